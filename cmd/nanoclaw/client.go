@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"os/user"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/linkerlin/nanoclaw.go/internal"
+)
+
+// runClientCommand 处理通过IPC访问运行中的 nanoclaw 的子命令，返回进程退出码
+func runClientCommand(cfg *internal.Config, cmd string, args []string) int {
+	client := internal.NewIPCClient(cfg.SocketPath())
+	defer client.Close()
+
+	var err error
+	switch cmd {
+	case "send":
+		err = clientSend(client, args)
+	case "groups":
+		err = clientGroups(client, args)
+	case "tasks":
+		err = clientTasks(client, args)
+	case "history":
+		err = clientHistory(client, args)
+	case "skills":
+		err = clientSkills(client, args)
+	case "status":
+		err = clientStatus(client, args)
+	}
+	if errors.Is(err, flag.ErrHelp) {
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", clientError(cfg, err))
+		return 1
+	}
+	return 0
+}
+
+// runAttach 运行连接到守护进程的TUI，退出时守护进程继续运行
+func runAttach(cfg *internal.Config) int {
+	client := internal.NewIPCClient(cfg.SocketPath())
+	defer client.Close()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// 本会话发送的消息以当前用户名署名，便于区分多个会话
+	sender := "You"
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		sender = u.Username
+	}
+	if err := internal.AttachTUI(ctx, client, cfg, sender); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", clientError(cfg, err))
+		return 1
+	}
+	return 0
+}
+
+// clientError 无法连接时给出提示
+func clientError(cfg *internal.Config, err error) error {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return fmt.Errorf("cannot connect to nanoclaw at %s (is `nanoclaw serve` running?)", cfg.SocketPath())
+	}
+	return err
+}
+
+// clientFlags 创建带 --json 选项的参数解析器
+func clientFlags(name string) (*flag.FlagSet, *bool) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	return fs, fs.Bool("json", false, "print the raw JSON result")
+}
+
+// printJSON 输出缩进的JSON结果
+func printJSON(v any) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// resolveChat 把群组目录或会话JID解析为会话JID
+func resolveChat(client *internal.IPCClient, group string) (internal.ChatJID, error) {
+	if strings.Contains(group, "@") {
+		return internal.ChatJID(group), nil
+	}
+	var groups []internal.GroupInfo
+	if err := client.Invoke("groups.list", nil, &groups); err != nil {
+		return "", err
+	}
+	for _, g := range groups {
+		if g.Folder == group {
+			return g.JID, nil
+		}
+	}
+	return "", fmt.Errorf("unknown group %s", group)
+}
+
+func clientSend(client *internal.IPCClient, args []string) error {
+	fs, asJSON := clientFlags("send")
+	wait := fs.Bool("wait", false, "wait for the bot's reply and print it")
+	timeout := fs.Duration("timeout", 2*time.Minute, "with --wait: how long to wait for the reply")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		return errors.New("send requires a group and a message (- reads stdin)")
+	}
+	text := strings.Join(fs.Args()[1:], " ")
+	if text == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		text = string(data)
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return errors.New("empty message")
+	}
+
+	// 通知回调需在首次调用前设置；Agent结束处理（thinking 变为 false）时还没有回复说明执行失败
+	replies := make(chan *internal.MessageInfo, 16)
+	if *wait {
+		started := false
+		client.SetNotify(func(method string, params json.RawMessage) {
+			var reply *internal.MessageInfo
+			switch method {
+			case internal.NotifyMessage:
+				var m internal.MessageInfo
+				if json.Unmarshal(params, &m) != nil || !m.IsBot {
+					return
+				}
+				reply = &m
+			case internal.NotifyThinking:
+				var t internal.ThinkingInfo
+				if json.Unmarshal(params, &t) != nil {
+					return
+				}
+				if t.Thinking || !started {
+					started = started || t.Thinking
+					return
+				}
+			default:
+				return
+			}
+			select {
+			case replies <- reply:
+			default:
+			}
+		})
+	}
+	chatJID, err := resolveChat(client, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *wait {
+		topics := []string{internal.NotifyMessage, internal.NotifyThinking}
+		if _, err := client.Subscribe(topics, []internal.ChatJID{chatJID}); err != nil {
+			return err
+		}
+	}
+	params := map[string]any{"chat_jid": chatJID, "content": text, "sender": "cli"}
+	if err := client.Invoke("messages.send", params, nil); err != nil {
+		return err
+	}
+	if !*wait {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	select {
+	case m := <-replies:
+		if m == nil {
+			return errors.New("the agent finished without a reply (see the nanoclaw log)")
+		}
+		if *asJSON {
+			return printJSON(m)
+		}
+		fmt.Println(m.Content)
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("no reply within %s (does the group require a trigger?)", *timeout)
+	}
+}
+
+func clientGroups(client *internal.IPCClient, args []string) error {
+	fs, asJSON := clientFlags("groups")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var groups []internal.GroupInfo
+	if err := client.Invoke("groups.list", nil, &groups); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(groups)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FOLDER\tJID\tNAME\tTRIGGER")
+	for _, g := range groups {
+		trigger := "-"
+		if g.RequiresTrigger {
+			trigger = g.TriggerPattern
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", g.Folder, g.JID, g.Name, trigger)
+	}
+	return w.Flush()
+}
+
+func clientTasks(client *internal.IPCClient, args []string) error {
+	fs, asJSON := clientFlags("tasks")
+	group := fs.String("group", "", "only tasks of this group folder")
+	status := fs.String("status", "", "only tasks with this status")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var tasks []internal.TaskInfo
+	params := map[string]string{"group_folder": *group, "status": *status}
+	if err := client.Invoke("tasks.list", params, &tasks); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(tasks)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tGROUP\tSTATUS\tSCHEDULE\tNEXT RUN\tPROMPT")
+	for _, t := range tasks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s %s\t%s\t%s\n",
+			t.ID, t.GroupFolder, t.Status, t.ScheduleType, t.ScheduleValue, formatLocal(t.NextRun), truncate(t.Prompt, 50))
+	}
+	return w.Flush()
+}
+
+func clientHistory(client *internal.IPCClient, args []string) error {
+	fs, asJSON := clientFlags("history")
+	limit := fs.Int("limit", 20, "number of messages")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("history requires a group")
+	}
+	chatJID, err := resolveChat(client, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var msgs []internal.MessageInfo
+	if err := client.Invoke("messages.list", map[string]any{"chat_jid": chatJID, "limit": *limit}, &msgs); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(msgs)
+	}
+	for _, m := range msgs {
+		name := m.SenderName
+		if name == "" {
+			name = m.Sender
+		}
+		fmt.Printf("[%s] %s: %s\n", m.Timestamp.Local().Format("2006-01-02 15:04:05"), name, m.Content)
+	}
+	return nil
+}
+
+func clientSkills(client *internal.IPCClient, args []string) error {
+	fs, asJSON := clientFlags("skills")
+	group := fs.String("group", "main", "group folder")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var skills []internal.SkillInfo
+	if err := client.Invoke("skills.list", map[string]string{"group_folder": *group}, &skills); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(skills)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSOURCE\tVERSION\tDESCRIPTION")
+	for _, s := range skills {
+		version := s.Version
+		if version == "" {
+			version = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Name, s.Source, version, truncate(s.Description, 60))
+	}
+	return w.Flush()
+}
+
+func clientStatus(client *internal.IPCClient, args []string) error {
+	fs, asJSON := clientFlags("status")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	var st internal.StatusInfo
+	if err := client.Invoke("status", nil, &st); err != nil {
+		return err
+	}
+	if *asJSON {
+		return printJSON(st)
+	}
+
+	fmt.Printf("name:      %s %s\npid:       %d\nstarted:   %s (up %s)\ngroups:    %d\nsubs:      %d\n",
+		st.Name, st.Version, st.PID, st.StartedAt.Local().Format("2006-01-02 15:04:05"),
+		time.Since(st.StartedAt).Round(time.Second), st.Groups, st.Subscriptions)
+	if len(st.Queue) == 0 {
+		fmt.Println("queue:     idle")
+		return nil
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "CHAT\tRUNNING\tPENDING")
+	for _, q := range st.Queue {
+		fmt.Fprintf(w, "%s\t%t\t%d\n", q.ChatJID, q.Running, q.Pending)
+	}
+	return w.Flush()
+}
@@ -2,20 +2,57 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"os/user"
+	"path/filepath"
 	"syscall"
+	"time"
+	_ "time/tzdata" // 任务时区在没有系统时区数据库的环境中也可用
 
-		"github.com/linkerlin/nanoclaw.go/internal"
+	"github.com/linkerlin/nanoclaw.go/internal"
+	"github.com/linkerlin/nanoclaw.go/skills"
 )
 
+const usage = `usage: nanoclaw [command] [args]
+
+commands:
+  tui                              run nanoclaw with the terminal UI (default)
+  serve                            run nanoclaw headless; other commands talk to it over the IPC socket
+  attach                           open a terminal UI connected to a running nanoclaw (Ctrl+C detaches)
+  send [--wait] <group> <text|->   send a message to a group as the user (- reads stdin);
+                                   --wait prints the bot's reply
+  groups                           list registered groups
+  tasks [--group G] [--status S]   list scheduled tasks
+  history [--limit N] <group>      show a group's recent messages
+  skills [--group G]               list skills available to a group
+  status                           show daemon status and the agent queue
+  skill <command>                  manage installed skills (nanoclaw skill for help)
+  task <command>                   manage scheduled tasks in the database (nanoclaw task for help)
+  token <command>                  manage HTTP API tokens (nanoclaw token for help)
+
+<group> is a group folder (e.g. main) or a chat JID (e.g. main@nanoclaw).
+Client commands accept --json to print the raw result.
+`
+
+// ipcDrainTimeout 退出时等待IPC请求完成的最长时间
+const ipcDrainTimeout = 30 * time.Second
+
 func main() {
 	// 初始化日志
 	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{
 		Level: slog.LevelInfo,
 	})))
 
+	// 沙箱辅助进程已在隔离环境中，不读取配置也不创建目录
+	if len(os.Args) > 1 && os.Args[1] == "sandbox-init" {
+		err := internal.SandboxInit(os.Args[2:])
+		slog.Error("sandbox", "err", err)
+		os.Exit(126)
+	}
+
 	// 加载配置
 	cfg := internal.LoadConfig()
 
@@ -23,55 +60,303 @@ func main() {
 	os.MkdirAll(cfg.App.DataDir, 0755)
 	os.MkdirAll(cfg.App.GroupsDir, 0755)
 
+	// 子命令
+	cmd, args := "tui", os.Args[1:]
+	if len(args) > 0 {
+		cmd, args = args[0], args[1:]
+	}
+	switch cmd {
+	case "serve":
+		os.Exit(runDaemon(cfg, false))
+	case "tui":
+		os.Exit(runDaemon(cfg, true))
+	case "attach":
+		os.Exit(runAttach(cfg))
+	case "send", "groups", "tasks", "history", "skills", "status":
+		os.Exit(runClientCommand(cfg, cmd, args))
+	case "skill":
+		os.Exit(runSkillCommand(cfg, args))
+	case "task":
+		os.Exit(runTaskCommand(cfg, args))
+	case "token":
+		os.Exit(runTokenCommand(cfg, args))
+	case "worker":
+		// Agent子进程，由主进程启动
+		if err := internal.RunWorker(cfg); err != nil {
+			slog.Error("agent worker", "err", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+}
+
+// runDaemon 运行服务（调度器、IPC控制接口），withTUI 时同时运行终端界面，返回进程退出码
+func runDaemon(cfg *internal.Config, withTUI bool) int {
+	// 同一数据目录只运行一个实例
+	removePID, err := internal.WritePIDFile(cfg.App.PIDFile)
+	if err != nil {
+		slog.Error("pid file (use nanoclaw attach to open a terminal UI on the running instance)", "err", err)
+		return 1
+	}
+	defer removePID()
+
 	// 打开数据库
 	db, err := internal.OpenDB(cfg.DBPath())
 	if err != nil {
 		slog.Error("open db", "err", err)
-		os.Exit(1)
+		return 1
 	}
 	defer db.Close()
 
 	// 初始化默认群组
 	initDefaultGroup(db)
 
+	// 加载技能：内置 < 全局 < 群组
+	registry := internal.NewSkillRegistry(db)
+	defer registry.Close()
+	registry.SetDataDir(filepath.Join(cfg.App.DataDir, "skilldata"))
+	if err := registry.LoadAll(skills.Builtin, cfg.App.SkillsDir, cfg.App.GroupsDir); err != nil {
+		slog.Error("load skills", "err", err)
+	}
+
 	// 初始化组件
 	queue := internal.NewGroupQueue(cfg.App.MaxConcurrent)
-	agent := internal.NewAgent(db)
+	// 以root运行时Agent在切换到 NANOCLAW_USER 的子进程中运行，主进程只保留Socket和数据库
+	var agent internal.AgentRunner
+	var worker *internal.AgentWorker
+	if internal.IsRoot() && cfg.App.User != "root" {
+		if _, err := user.Lookup(cfg.App.User); err != nil {
+			slog.Error("agent worker user (run make setup or set NANOCLAW_USER)", "user", cfg.App.User, "err", err)
+			return 1
+		}
+		exe, err := os.Executable()
+		if err != nil {
+			slog.Error("locate executable", "err", err)
+			return 1
+		}
+		worker = internal.NewAgentWorker(exe, "worker")
+		agent = worker
+	} else {
+		agent = internal.NewAgent(db)
+	}
 	scheduler := internal.NewScheduler(db, agent)
+	scheduler.SetQueue(queue, cfg.Scheduler.TaskPriority)
+	scheduler.SetPollInterval(time.Duration(cfg.Scheduler.PollInterval) * time.Second)
+	scheduler.SetTimeout(time.Duration(cfg.Scheduler.TaskTimeout) * time.Second)
+	scheduler.SetRetention(time.Duration(cfg.Scheduler.RunRetention)*24*time.Hour, cfg.Scheduler.RunKeep)
 	orch := internal.NewOrchestrator(db, queue, agent, cfg)
+	registry.SetHTTPAllowlist(cfg.App.HTTPAllowlist)
+	registry.SetAgent(agent)
+	registry.SetTaskNotifier(scheduler.Wake)
+	sandbox := newSandbox(cfg)
+	if sandbox != nil {
+		registry.SetSandbox(sandbox)
+	}
+	taskTools := internal.NewTaskTools(db)
+	taskTools.SetTaskNotifier(scheduler.Wake)
+	agent.RegisterTools(taskTools.Tools()...)
+	postBot := func(chatJID internal.ChatJID, content string) error {
+		_, err := orch.PostBotMessage(chatJID, content)
+		return err
+	}
+	registry.SetChatSender(postBot)
+	scheduler.SetDeliver(postBot)
+	scheduler.SetSkills(registry)
+	orch.SetSkills(registry)
+	orch.SetOnEvent(scheduler.HandleEvent)
+
+	// 运行时事件推送给IPC订阅者
+	hub := internal.NewHub()
+	orch.SetNotify(hub.Publish)
+	scheduler.SetNotify(hub.Publish)
 
 	// 创建TUI
-	tui := internal.NewTUI(db, queue, agent, cfg)
-	tui.SetOnSend(func(chatJID internal.ChatJID, content string) {
-		orch.HandleMessage(chatJID, "You", content)
-	})
+	var tui *internal.TUI
+	if withTUI {
+		tui = internal.NewTUI(db, queue, agent, cfg)
+		tui.SetOnSend(func(chatJID internal.ChatJID, content string) {
+			orch.HandleMessage(chatJID, "You", content)
+		})
+		tui.SetOnTasksChanged(scheduler.Wake)
 
-	// 设置TUI到编排器
-	orch.SetProgram(nil)  // 简化处理
+		// 设置TUI到编排器
+		orch.SetProgram(tui.Program())
+	}
 
-	// 上下文
+	// 上下文：ctx 在排空队列后取消；runCtx 收到退出信号时取消；force 在再次收到退出信号时取消，不再等待
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
+	runCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	forceCtx, force := context.WithCancel(context.Background())
+	defer force()
+	if worker != nil {
+		go worker.Supervise(ctx)
+	}
+
+	// 热重载技能和群组 CLAUDE.md，结果显示在状态栏（无TUI时记录日志）
+	os.MkdirAll(cfg.App.SkillsDir, 0755)
+	internal.HotReload(ctx, cfg, registry, agent, func(err error) {
+		switch {
+		case tui == nil && err != nil:
+			slog.Error("skill reload", "err", err)
+		case tui == nil:
+			slog.Info("skills reloaded")
+		case err != nil:
+			tui.Program().Send(internal.StatusMsg{Text: "skill reload: " + err.Error(), Error: true})
+		default:
+			tui.Program().Send(internal.StatusMsg{Text: "skills reloaded"})
+		}
+	})
 
-	// 启动调度器
+	// 启动调度器，退出时在排空队列前停止
 	scheduler.Start(ctx)
-	defer scheduler.Stop()
 
-	// 信号处理
+	// 控制接口，通过IPC和HTTP提供
+	api := internal.NewControlAPI(db, orch, queue)
+	api.SetSkills(registry)
+	api.SetHub(hub)
+	api.SetTaskNotifier(scheduler.Wake)
+
+	// 启动IPC控制接口；失败时TUI仍可使用，无TUI时退出
+	ipc, err := internal.ListenIPC(cfg.SocketPath())
+	if err != nil {
+		slog.Error("start ipc", "path", cfg.SocketPath(), "err", err)
+		if tui == nil {
+			return 1
+		}
+		ipc = nil
+	} else {
+		// 策略文件无效时只允许本用户访问
+		policy, err := internal.LoadIPCPolicy(cfg.App.IPCPolicy)
+		if err != nil {
+			slog.Error("load ipc policy", "path", cfg.App.IPCPolicy, "err", err)
+			policy = &internal.IPCPolicy{}
+		}
+		ipc.SetPolicy(policy)
+		api.Register(ipc)
+		go func() {
+			if err := ipc.Start(ctx); err != nil {
+				slog.Error("ipc", "err", err)
+			}
+		}()
+		// 排空队列后等待进行中的请求完成，超时后强制关闭连接
+		defer func() {
+			drainCtx, cancel := context.WithTimeout(forceCtx, ipcDrainTimeout)
+			defer cancel()
+			if err := ipc.Shutdown(drainCtx); err != nil {
+				slog.Warn("ipc shutdown", "err", err)
+			}
+		}()
+		slog.Info("ipc listening", "path", ipc.Path())
+	}
+
+	// 启动HTTP接口（设置了 NANOCLAW_HTTP_ADDR 时）；失败时的处理同IPC
+	if cfg.App.HTTPAddr != "" {
+		httpSrv, err := internal.ListenHTTP(cfg.App.HTTPAddr, internal.NewHTTPAPI(api), cfg.App.HTTPTLSCert, cfg.App.HTTPTLSKey)
+		if err != nil {
+			slog.Error("start http", "addr", cfg.App.HTTPAddr, "err", err)
+			if tui == nil {
+				return 1
+			}
+		} else {
+			go func() {
+				if err := httpSrv.Start(); err != nil {
+					slog.Error("http", "err", err)
+				}
+			}()
+			// 排空队列后结束事件流，等待进行中的请求完成
+			defer func() {
+				drainCtx, cancel := context.WithTimeout(forceCtx, ipcDrainTimeout)
+				defer cancel()
+				if err := httpSrv.Shutdown(drainCtx); err != nil {
+					slog.Warn("http shutdown", "err", err)
+				}
+			}()
+			slog.Info("http listening", "addr", httpSrv.Addr().String(), "tls", cfg.App.HTTPTLSCert != "")
+		}
+	}
+
+	// 重新加载配置文件：IPC策略、沙箱限制、技能和群组 CLAUDE.md；环境变量的修改需要重启
+	reload := func() {
+		sdNotify("RELOADING=1")
+		defer sdNotify("READY=1")
+		slog.Info("reloading configuration")
+		if ipc != nil {
+			// 策略文件无效时保留当前策略
+			if policy, err := internal.LoadIPCPolicy(cfg.App.IPCPolicy); err != nil {
+				slog.Error("load ipc policy, keeping the current policy", "path", cfg.App.IPCPolicy, "err", err)
+			} else {
+				ipc.SetPolicy(policy)
+			}
+		}
+		if sandbox != nil {
+			loadSandboxConfig(cfg, sandbox)
+		}
+		if err := registry.Reload(cfg.App.SkillsDir, cfg.App.GroupsDir); err != nil {
+			slog.Warn("skill reload", "err", err)
+		}
+		if groups, err := db.ListGroups(); err == nil {
+			for _, g := range groups {
+				agent.InvalidateMemory(g.Folder)
+			}
+		}
+	}
+
+	// 信号处理：SIGINT/SIGTERM 退出，再次收到时不再等待；SIGHUP 重新加载配置
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
 	go func() {
-		<-sigs
-		slog.Info("shutting down...")
-		cancel()
-		// 退出信号
+		for sig := range sigs {
+			switch {
+			case sig == syscall.SIGHUP:
+				reload()
+			case runCtx.Err() == nil:
+				slog.Info("shutting down...")
+				stop()
+			default:
+				slog.Warn("exiting without waiting for running jobs")
+				force()
+			}
+		}
 	}()
 
-	// 运行TUI
-	slog.Info("nanoclaw started", "name", cfg.App.Name)
-	if err := tui.Run(ctx); err != nil {
+	slog.Info("nanoclaw started", "name", cfg.App.Name, "tui", withTUI)
+	sdNotify("READY=1")
+	internal.SdWatchdog(ctx, func() error { return db.PingContext(ctx) })
+
+	code := 0
+	if tui == nil {
+		<-runCtx.Done()
+	} else if err := tui.Run(runCtx); err != nil {
 		slog.Error("tui error", "err", err)
-		os.Exit(1)
+		code = 1
+	}
+
+	// 退出：停止调度，等待排队和执行中的Agent调用、任务完成，之后关闭IPC连接
+	sdNotify("STOPPING=1")
+	scheduler.Stop()
+	drainCtx, cancelDrain := context.WithTimeout(forceCtx, time.Duration(cfg.App.ShutdownTimeout)*time.Second)
+	defer cancelDrain()
+	if status := queue.Status(); len(status) > 0 {
+		slog.Info("waiting for queued jobs", "groups", len(status), "timeout", cfg.App.ShutdownTimeout)
+	}
+	if err := queue.Drain(drainCtx); err != nil {
+		slog.Warn("queue not drained", "err", err, "queue", queue.Status())
+	}
+	return code
+}
+
+// sdNotify 向 systemd 发送状态，失败时只记录日志
+func sdNotify(state string) {
+	if err := internal.SdNotify(state); err != nil {
+		slog.Warn("sd_notify", "err", err)
 	}
 }
 
@@ -86,3 +371,29 @@ func initDefaultGroup(db *internal.DB) {
 	// 忽略错误（可能已存在）
 	db.SaveGroup(group)
 }
+
+// newSandbox 创建技能 exec.run 使用的沙箱，以root运行时沙箱中的命令使用 NANOCLAW_USER 的身份
+func newSandbox(cfg *internal.Config) *internal.Sandbox {
+	exe, err := os.Executable()
+	if err != nil {
+		slog.Error("locate executable, exec.run disabled", "err", err)
+		return nil
+	}
+	sandboxUser := ""
+	if internal.IsRoot() && cfg.App.User != "root" {
+		sandboxUser = cfg.App.User
+	}
+	sandbox := internal.NewSandbox(cfg.App.GroupsDir, sandboxUser, exe, "sandbox-init")
+	loadSandboxConfig(cfg, sandbox)
+	return sandbox
+}
+
+// loadSandboxConfig 读取沙箱限制配置，无效时保留当前限制（启动时为默认限制）
+func loadSandboxConfig(cfg *internal.Config, sandbox *internal.Sandbox) {
+	sandboxCfg, err := internal.LoadSandboxConfig(cfg.App.Sandbox)
+	if err != nil {
+		slog.Error("load sandbox config", "path", cfg.App.Sandbox, "err", err)
+		return
+	}
+	sandbox.SetConfig(sandboxCfg)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/linkerlin/nanoclaw.go/internal"
+	"github.com/linkerlin/nanoclaw.go/skills"
+)
+
+const skillUsage = `usage: nanoclaw skill <command> [args]
+
+commands:
+  install [--force] <dir|bundle.tar.gz>   validate and install a skill bundle
+  list                                    list available skills
+  info <name>                             show skill details
+  remove <name>                           uninstall a skill
+  enable <name> <group-folder>            enable a skill for a group
+  disable <name> <group-folder>           disable a skill for a group
+  test [--update] <dir>                   run golden transcript tests for a skill
+`
+
+// runSkillCommand 处理 nanoclaw skill 子命令，返回进程退出码
+func runSkillCommand(cfg *internal.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, skillUsage)
+		return 2
+	}
+	if args[0] == "test" {
+		return skillTest(args[1:])
+	}
+
+	db, err := internal.OpenDB(cfg.DBPath())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "open db:", err)
+		return 1
+	}
+	defer db.Close()
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "install":
+		err = skillInstall(cfg, db, args)
+	case "list":
+		err = skillList(cfg, db)
+	case "info":
+		err = skillInfo(cfg, db, args)
+	case "remove":
+		err = skillRemove(db, args)
+	case "enable", "disable":
+		err = skillSetEnabled(db, args, cmd == "enable")
+	default:
+		fmt.Fprint(os.Stderr, skillUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+func skillInstall(cfg *internal.Config, db *internal.DB, args []string) error {
+	fs := flag.NewFlagSet("skill install", flag.ContinueOnError)
+	force := fs.Bool("force", false, "allow downgrade or reinstall")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("install requires exactly one path")
+	}
+
+	inst, err := internal.InstallSkill(db, cfg.App.SkillsDir, fs.Arg(0), *force)
+	if errors.Is(err, internal.ErrSkillUpToDate) {
+		fmt.Printf("%s %s is already installed\n", inst.Name, inst.Version)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("installed %s %s -> %s\n", inst.Name, inst.Version, inst.Path)
+	return nil
+}
+
+// loadRegistry 加载内置和全局技能（不含群组私有技能）
+func loadRegistry(cfg *internal.Config, db *internal.DB) *internal.SkillRegistry {
+	registry := internal.NewSkillRegistry(db)
+	if err := registry.LoadAll(skills.Builtin, cfg.App.SkillsDir, cfg.App.GroupsDir); err != nil {
+		fmt.Fprintln(os.Stderr, "warning:", err)
+	}
+	return registry
+}
+
+func skillList(cfg *internal.Config, db *internal.DB) error {
+	registry := loadRegistry(cfg, db)
+	defer registry.Close()
+
+	installed, err := db.ListInstalledSkills()
+	if err != nil {
+		return err
+	}
+	versions := make(map[string]string)
+	for _, s := range installed {
+		versions[s.Name] = s.Version
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVERSION\tSOURCE\tDESCRIPTION")
+	for _, s := range registry.Skills("") {
+		version := s.Version
+		if v, ok := versions[s.Name]; ok {
+			version = v
+		}
+		if version == "" {
+			version = "-"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.Name, version, s.Source, s.Description)
+	}
+	return w.Flush()
+}
+
+func skillInfo(cfg *internal.Config, db *internal.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("info requires a skill name")
+	}
+	name := args[0]
+
+	registry := loadRegistry(cfg, db)
+	defer registry.Close()
+
+	skill, found := registry.Get(name)
+	inst, instErr := db.GetInstalledSkill(name)
+	if !found && instErr != nil {
+		return fmt.Errorf("skill not found: %s", name)
+	}
+
+	if found {
+		fmt.Printf("Name:         %s\n", skill.Name)
+		fmt.Printf("Description:  %s\n", skill.Description)
+		fmt.Printf("Version:      %s\n", skill.Version)
+		fmt.Printf("Source:       %s\n", skill.Source)
+		fmt.Printf("Path:         %s\n", skill.Path)
+		fmt.Printf("Capabilities: %s\n", strings.Join(skill.Capabilities, ", "))
+	}
+	if instErr == nil {
+		fmt.Printf("Installed:    %s from %s\n", inst.InstalledAt.Format("2006-01-02 15:04"), inst.Source)
+		fmt.Printf("Checksum:     %s\n", inst.Checksum)
+	}
+
+	states, err := db.SkillGroupStates(name)
+	if err != nil {
+		return err
+	}
+	folders := make([]string, 0, len(states))
+	for f := range states {
+		folders = append(folders, f)
+	}
+	sort.Strings(folders)
+	for _, f := range folders {
+		state := "enabled"
+		if !states[f] {
+			state = "disabled"
+		}
+		fmt.Printf("Group %s:   %s\n", f, state)
+	}
+	return nil
+}
+
+func skillRemove(db *internal.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("remove requires a skill name")
+	}
+	if err := internal.RemoveSkill(db, args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("removed %s\n", args[0])
+	return nil
+}
+
+func skillSetEnabled(db *internal.DB, args []string, enabled bool) error {
+	if len(args) != 2 {
+		return errors.New("requires a skill name and a group folder")
+	}
+	if err := db.SetSkillEnabled(args[0], args[1], enabled); err != nil {
+		return err
+	}
+	state := "enabled"
+	if !enabled {
+		state = "disabled"
+	}
+	fmt.Printf("%s %s for group %s\n", args[0], state, args[1])
+	return nil
+}
+
+// skillTest 运行技能目录 tests/ 下的用例并与 golden 记录比较
+func skillTest(args []string) int {
+	fs := flag.NewFlagSet("skill test", flag.ContinueOnError)
+	update := fs.Bool("update", false, "rewrite golden files with actual transcripts")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "error: test requires exactly one skill directory")
+		return 2
+	}
+
+	results, err := internal.RunSkillTests(fs.Arg(0), *update)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	failed := 0
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			failed++
+			fmt.Printf("ERROR %s: %v\n", r.Name, r.Err)
+		case r.Updated:
+			fmt.Printf("UPDATE %s\n", r.Name)
+		case r.Passed:
+			fmt.Printf("PASS %s\n", r.Name)
+		default:
+			failed++
+			fmt.Printf("FAIL %s\n%s", r.Name, r.Diff)
+		}
+	}
+	fmt.Printf("%d passed, %d failed\n", len(results)-failed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
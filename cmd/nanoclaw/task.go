@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/linkerlin/nanoclaw.go/internal"
+)
+
+const taskUsage = `usage: nanoclaw task <command> [args]
+
+commands:
+  list [--group G] [--status S]           list tasks
+  show <task-id>                          show a task's settings and state
+  pause <task-id>                         pause an active task
+  resume <task-id>                        resume a paused or failed task
+  run-now <task-id>                       run an active task at the next scheduler tick
+  edit [flags] <task-id>                  change a task (--prompt, --schedule-type, --schedule-value,
+                                          --tz, --silent, --retries, --retry-delay, --timeout, --misfire,
+                                          --debounce, --rate-limit)
+  delete <task-id>                        delete a task and its run history
+  steps <task-id> [file.json|-]           show a task's workflow steps, or replace them from a JSON
+                                          array ([] turns the task back into a single prompt)
+  runs [--limit N] [--page P] <task-id>   show a task's run history (newest first)
+  run <run-id>                            show the full output of one run
+  resume-run <run-id>                     re-run a failed workflow run from its failed steps
+`
+
+// runTaskCommand 处理 nanoclaw task 子命令，返回进程退出码
+func runTaskCommand(cfg *internal.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, taskUsage)
+		return 2
+	}
+
+	db, err := internal.OpenDB(cfg.DBPath())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "open db:", err)
+		return 1
+	}
+	defer db.Close()
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "list":
+		err = taskList(db, args)
+	case "show":
+		err = taskShow(db, args)
+	case "pause":
+		err = taskSetStatus(db, args, internal.TaskPaused)
+	case "resume":
+		err = taskSetStatus(db, args, internal.TaskActive)
+	case "run-now":
+		err = taskRunNow(db, args)
+	case "edit":
+		err = taskEdit(db, args)
+	case "delete":
+		err = taskDelete(db, args)
+	case "runs":
+		err = taskRuns(db, args)
+	case "run":
+		err = taskRun(db, args)
+	case "steps":
+		err = taskSteps(db, args)
+	case "resume-run":
+		err = taskResumeRun(db, args)
+	default:
+		fmt.Fprint(os.Stderr, taskUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+func taskList(db *internal.DB, args []string) error {
+	fs := flag.NewFlagSet("task list", flag.ContinueOnError)
+	group := fs.String("group", "", "only tasks of this group folder")
+	status := fs.String("status", "", "only tasks with this status")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	tasks, err := db.FindTasks(internal.TaskFilter{GroupFolder: *group, Status: *status})
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tGROUP\tSTATUS\tSCHEDULE\tNEXT RUN\tPROMPT")
+	for _, t := range tasks {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s %s\t%s\t%s\n",
+			t.ID, t.GroupFolder, t.Status, t.ScheduleType, t.ScheduleValue, formatLocal(t.NextRun), truncate(t.Prompt, 50))
+	}
+	return w.Flush()
+}
+
+// taskArg 读取唯一的任务ID参数并加载任务
+func taskArg(db *internal.DB, cmd string, args []string) (*internal.Task, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s requires a task id", cmd)
+	}
+	task, err := db.GetTask(args[0])
+	if err != nil {
+		return nil, fmt.Errorf("task %s: %w", args[0], err)
+	}
+	return task, nil
+}
+
+func taskShow(db *internal.DB, args []string) error {
+	t, err := taskArg(db, "show", args)
+	if err != nil {
+		return err
+	}
+	tz := t.Timezone
+	if tz == "" {
+		tz = "local"
+	}
+	misfire := t.Misfire
+	if misfire == "" {
+		misfire = internal.MisfireOnce
+	}
+	fmt.Printf("id:        %s\ngroup:     %s\nchat:      %s\nstatus:    %s\nschedule:  %s %s (%s)\n",
+		t.ID, t.GroupFolder, t.ChatJID, t.Status, t.ScheduleType, t.ScheduleValue, tz)
+	fmt.Printf("next run:  %s\nlast run:  %s\nsilent:    %t\nretries:   %d (attempt %d)\nmisfire:   %s\n",
+		formatLocal(t.NextRun), formatLocal(t.LastRun), t.Silent, t.RetryLimit, t.Attempt, misfire)
+	if t.RetryDelay > 0 {
+		fmt.Printf("delay:     %s\n", t.RetryDelay)
+	}
+	if t.Timeout > 0 {
+		fmt.Printf("timeout:   %s\n", t.Timeout)
+	}
+	if t.ScheduleType == "event" {
+		fmt.Printf("debounce:  %s\nrate:      %d/hour\n", t.Debounce, t.RateLimit)
+	}
+	fmt.Printf("\n%s\n", t.Prompt)
+	steps, err := db.GetTaskSteps(t.ID)
+	if err != nil {
+		return err
+	}
+	if len(steps) > 0 {
+		fmt.Printf("\nworkflow: %d steps (nanoclaw task steps %s)\n", len(steps), t.ID)
+	}
+	if t.LastResult != "" {
+		fmt.Printf("\nlast result:\n%s\n", t.LastResult)
+	}
+	return nil
+}
+
+func taskSetStatus(db *internal.DB, args []string, status string) error {
+	cmd := "pause"
+	if status == internal.TaskActive {
+		cmd = "resume"
+	}
+	t, err := taskArg(db, cmd, args)
+	if err != nil {
+		return err
+	}
+	if err := t.SetStatus(status, time.Now()); err != nil {
+		return err
+	}
+	if err := db.SaveTask(t); err != nil {
+		return err
+	}
+	fmt.Printf("task %s %s\n", t.ID, t.Status)
+	return nil
+}
+
+func taskRunNow(db *internal.DB, args []string) error {
+	t, err := taskArg(db, "run-now", args)
+	if err != nil {
+		return err
+	}
+	if err := db.TriggerTask(t.ID, time.Now()); err != nil {
+		return err
+	}
+	fmt.Printf("task %s triggered\n", t.ID)
+	return nil
+}
+
+func taskEdit(db *internal.DB, args []string) error {
+	fs := flag.NewFlagSet("task edit", flag.ContinueOnError)
+	prompt := fs.String("prompt", "", "task prompt")
+	scheduleType := fs.String("schedule-type", "", "once, interval, cron or event")
+	scheduleValue := fs.String("schedule-value", "", "schedule value")
+	tz := fs.String("tz", "", "IANA timezone (empty string for local)")
+	silent := fs.Bool("silent", false, "do not post results to the chat")
+	retries := fs.Int("retries", 0, "retries after a failed run")
+	retryDelay := fs.Duration("retry-delay", 0, "base delay between retries")
+	timeout := fs.Duration("timeout", 0, "run timeout")
+	misfire := fs.String("misfire", "", "once, all or skip")
+	debounce := fs.Duration("debounce", 0, "event tasks: quiet period before running")
+	rateLimit := fs.Int("rate-limit", 0, "event tasks: max runs per hour")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	t, err := taskArg(db, "edit", fs.Args())
+	if err != nil {
+		return err
+	}
+
+	// 只修改命令行中出现的选项
+	reschedule := false
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "prompt":
+			t.Prompt = *prompt
+		case "schedule-type":
+			t.ScheduleType, reschedule = *scheduleType, true
+		case "schedule-value":
+			t.ScheduleValue, reschedule = *scheduleValue, true
+		case "tz":
+			t.Timezone, reschedule = *tz, true
+		case "silent":
+			t.Silent = *silent
+		case "retries":
+			t.RetryLimit = *retries
+		case "retry-delay":
+			t.RetryDelay = *retryDelay
+		case "timeout":
+			t.Timeout = *timeout
+		case "misfire":
+			t.Misfire = *misfire
+		case "debounce":
+			t.Debounce = *debounce
+		case "rate-limit":
+			t.RateLimit = *rateLimit
+		}
+	})
+	if reschedule {
+		if err := t.Reschedule(time.Now()); err != nil {
+			return err
+		}
+	}
+	if err := db.SaveTask(t); err != nil {
+		return err
+	}
+	fmt.Printf("task %s updated, next run %s\n", t.ID, formatLocal(t.NextRun))
+	return nil
+}
+
+func taskDelete(db *internal.DB, args []string) error {
+	t, err := taskArg(db, "delete", args)
+	if err != nil {
+		return err
+	}
+	if err := db.DeleteTask(t.ID); err != nil {
+		return err
+	}
+	fmt.Printf("task %s deleted\n", t.ID)
+	return nil
+}
+
+func formatLocal(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04:05")
+}
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
+
+func taskRuns(db *internal.DB, args []string) error {
+	fs := flag.NewFlagSet("task runs", flag.ContinueOnError)
+	limit := fs.Int("limit", 20, "runs per page")
+	page := fs.Int("page", 1, "page number")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("runs requires a task id")
+	}
+	if *limit <= 0 || *page <= 0 {
+		return errors.New("--limit and --page must be positive")
+	}
+
+	id := fs.Arg(0)
+	if _, err := db.GetTask(id); err != nil {
+		return fmt.Errorf("task %s: %w", id, err)
+	}
+	total, err := db.CountTaskRuns(id)
+	if err != nil {
+		return err
+	}
+	runs, err := db.ListTaskRuns(id, *limit, (*page-1)**limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "RUN\tSTARTED\tDURATION\tSTATUS\tTOKENS\tOUTPUT")
+	for _, r := range runs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d/%d\t%s\n",
+			r.ID, r.StartedAt.Local().Format("2006-01-02 15:04:05"), r.Duration().Round(time.Millisecond),
+			r.Status, r.PromptTokens, r.CompletionTokens, r.Summary(60))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	pages := (total + *limit - 1) / *limit
+	fmt.Printf("page %d/%d, %d runs\n", *page, max(pages, 1), total)
+	return nil
+}
+
+// runIDArg 读取唯一的执行记录ID参数
+func runIDArg(cmd string, args []string) (int64, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("%s requires a run id", cmd)
+	}
+	var id int64
+	if _, err := fmt.Sscan(args[0], &id); err != nil {
+		return 0, fmt.Errorf("invalid run id %q", args[0])
+	}
+	return id, nil
+}
+
+func taskRun(db *internal.DB, args []string) error {
+	id, err := runIDArg("run", args)
+	if err != nil {
+		return err
+	}
+	r, err := db.GetTaskRun(id)
+	if err != nil {
+		return fmt.Errorf("run %d: %w", id, err)
+	}
+
+	fmt.Printf("task:     %s\nrun:      %d\nstarted:  %s\nduration: %s\nstatus:   %s\ntokens:   %d prompt, %d completion\n",
+		r.TaskID, r.ID, r.StartedAt.Local().Format(time.RFC3339), r.Duration().Round(time.Millisecond),
+		r.Status, r.PromptTokens, r.CompletionTokens)
+	if r.Error != "" {
+		fmt.Printf("error:    %s\n", r.Error)
+	}
+	if len(r.Steps) > 0 {
+		fmt.Println()
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "STEP\tSTATUS\tDURATION\tOUTPUT")
+		for _, st := range r.Steps {
+			text := st.Output
+			if st.Error != "" {
+				text = st.Error
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", st.Name, st.Status, st.EndedAt.Sub(st.StartedAt).Round(time.Millisecond), truncate(firstLine(text), 60))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("\n%s\n", r.Output)
+	return nil
+}
+
+func firstLine(s string) string {
+	if i := strings.IndexByte(s, '\n'); i >= 0 {
+		return s[:i]
+	}
+	return s
+}
+
+// taskSteps 显示任务的工作流步骤，或从JSON文件（- 为标准输入）替换
+func taskSteps(db *internal.DB, args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errors.New("steps requires a task id and an optional file")
+	}
+	t, err := taskArg(db, "steps", args[:1])
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		steps, err := db.GetTaskSteps(t.ID)
+		if err != nil {
+			return err
+		}
+		if len(steps) == 0 {
+			fmt.Printf("task %s has no workflow steps\n", t.ID)
+			return nil
+		}
+		out, err := json.MarshalIndent(steps, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	var data []byte
+	if args[1] == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(args[1])
+	}
+	if err != nil {
+		return err
+	}
+	var steps []internal.WorkflowStep
+	if err := json.Unmarshal(data, &steps); err != nil {
+		return fmt.Errorf("parse steps: %w", err)
+	}
+	if err := db.SaveTaskSteps(t.ID, steps); err != nil {
+		return err
+	}
+	fmt.Printf("task %s: %d workflow steps saved\n", t.ID, len(steps))
+	return nil
+}
+
+func taskResumeRun(db *internal.DB, args []string) error {
+	id, err := runIDArg("resume-run", args)
+	if err != nil {
+		return err
+	}
+	t, err := db.ResumeTaskRun(id, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("task %s will resume run %d at the next scheduler tick\n", t.ID, id)
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/linkerlin/nanoclaw.go/internal"
+)
+
+const tokenUsage = `usage: nanoclaw token <command> [args]
+
+commands:
+  create [--groups G,...] <name>   create a bearer token for the HTTP API and print it once;
+                                   --groups limits it to these group folders (default *)
+  list                             list tokens
+  revoke <name>                    delete a token
+`
+
+// runTokenCommand 处理 nanoclaw token 子命令，返回进程退出码
+func runTokenCommand(cfg *internal.Config, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, tokenUsage)
+		return 2
+	}
+
+	db, err := internal.OpenDB(cfg.DBPath())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "open db:", err)
+		return 1
+	}
+	defer db.Close()
+
+	cmd, args := args[0], args[1:]
+	switch cmd {
+	case "create":
+		err = tokenCreate(cfg, db, args)
+	case "list":
+		err = tokenList(db)
+	case "revoke":
+		err = tokenRevoke(db, args)
+	default:
+		fmt.Fprint(os.Stderr, tokenUsage)
+		return 2
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		return 1
+	}
+	return 0
+}
+
+func tokenCreate(cfg *internal.Config, db *internal.DB, args []string) error {
+	fs := flag.NewFlagSet("token create", flag.ContinueOnError)
+	groups := fs.String("groups", "*", "comma-separated group folders the token may access")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("create requires a token name")
+	}
+	t := &internal.APIToken{Name: fs.Arg(0), CreatedAt: time.Now()}
+	// 令牌名称是通过它发送的消息的发送者
+	if internal.IsReservedSender(cfg, t.Name) {
+		return fmt.Errorf("token name %s is reserved", t.Name)
+	}
+	for _, g := range strings.Split(*groups, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			t.Groups = append(t.Groups, g)
+		}
+	}
+	if len(t.Groups) == 0 {
+		return errors.New("--groups must name at least one group")
+	}
+	secret, hash, err := internal.NewAPIToken()
+	if err != nil {
+		return err
+	}
+	if err := db.CreateAPIToken(t, hash); err != nil {
+		return fmt.Errorf("token %s: %w", t.Name, err)
+	}
+	// 令牌只保存哈希，之后无法再次查看
+	fmt.Fprintf(os.Stderr, "token %s created; it is shown only once:\n", t.Name)
+	fmt.Println(secret)
+	return nil
+}
+
+func tokenList(db *internal.DB) error {
+	tokens, err := db.ListAPITokens()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tGROUPS\tCREATED\tLAST USED")
+	for _, t := range tokens {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.Name, strings.Join(t.Groups, ","),
+			t.CreatedAt.Local().Format("2006-01-02 15:04"), formatLocal(t.LastUsedAt))
+	}
+	return w.Flush()
+}
+
+func tokenRevoke(db *internal.DB, args []string) error {
+	if len(args) != 1 {
+		return errors.New("revoke requires a token name")
+	}
+	err := db.DeleteAPIToken(args[0])
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("unknown token %s", args[0])
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("token %s revoked\n", args[0])
+	return nil
+}
@@ -13,6 +13,7 @@ require (
 	github.com/sashabaranov/go-openai v1.36.1
 	github.com/yuin/gopher-lua v1.1.1
 	golang.org/x/sync v0.12.0
+	golang.org/x/sys v0.31.0
 	modernc.org/sqlite v1.34.5
 )
 
@@ -37,7 +38,6 @@ require (
 	github.com/rivo/uniseg v0.4.7 // indirect
 	github.com/sahilm/fuzzy v0.1.1 // indirect
 	github.com/xo/terminfo v0.0.0-20220910002029-abceb7e1c41e // indirect
-	golang.org/x/sys v0.31.0 // indirect
 	modernc.org/libc v1.55.3 // indirect
 	modernc.org/mathutil v1.6.0 // indirect
 	modernc.org/memory v1.8.0 // indirect
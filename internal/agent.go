@@ -2,17 +2,87 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/sashabaranov/go-openai"
 )
 
+// Runner 执行一次对话（Agent 实现；测试和技能测试工具使用脚本化替身）
+type Runner interface {
+	Run(ctx context.Context, groupFolder string, messages []Message) (string, error)
+}
+
+// Usage token用量
+type Usage struct {
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// UsageRunner 可报告token用量的 Runner
+type UsageRunner interface {
+	RunWithUsage(ctx context.Context, groupFolder string, messages []Message) (string, Usage, error)
+}
+
+// StreamRunner 可流式返回回复片段的 Runner
+type StreamRunner interface {
+	RunStreaming(ctx context.Context, groupFolder string, messages []Message, onDelta func(string)) (string, Usage, error)
+}
+
+// AgentRunner 完整的Agent接口，由 Agent 和在降权子进程中运行的 AgentWorker 实现
+type AgentRunner interface {
+	Runner
+	UsageRunner
+	StreamRunner
+	RegisterTools(tools ...AgentTool)
+	InvalidateMemory(groupFolder string)
+}
+
+// ToolContext 工具调用所在的会话
+type ToolContext struct {
+	GroupFolder string
+	ChatJID     ChatJID
+	Messages    []Message // 本次对话的历史消息
+}
+
+// newToolContext 返回对话的工具上下文，会话为最后一条消息所在的会话
+func newToolContext(groupFolder string, messages []Message) ToolContext {
+	tc := ToolContext{GroupFolder: groupFolder, Messages: messages}
+	if len(messages) > 0 {
+		tc.ChatJID = messages[len(messages)-1].ChatJID
+	}
+	return tc
+}
+
+// AgentTool LLM可以调用的工具
+type AgentTool struct {
+	Name        string
+	Description string
+	Parameters  any // JSON Schema
+	// Call 执行工具，args 为模型给出的JSON参数；返回的错误作为结果交给模型
+	Call func(ctx context.Context, tc ToolContext, args string) (string, error)
+}
+
+// maxToolRounds 单次对话中最多连续调用工具的轮数
+const maxToolRounds = 5
+
 // Agent LLM代理
 type Agent struct {
-	client *openai.Client
-	model  string
-	db     *DB
+	client    *openai.Client
+	model     string
+	db        *DB
+	groupsDir string
+	tools     []AgentTool
+
+	memMu  sync.RWMutex
+	memory map[string]string // 群组folder -> CLAUDE.md 内容缓存
 }
 
 // NewAgent 从环境变量创建Agent
@@ -27,16 +97,52 @@ func NewAgent(db *DB) *Agent {
 	config.BaseURL = cfg.LLM.BaseURL
 
 	return &Agent{
-		client: openai.NewClientWithConfig(config),
-		model:  cfg.LLM.Model,
-		db:     db,
+		client:    openai.NewClientWithConfig(config),
+		model:     cfg.LLM.Model,
+		db:        db,
+		groupsDir: cfg.App.GroupsDir,
+		memory:    make(map[string]string),
 	}
 }
 
-// Run 执行单次对话
-func (a *Agent) Run(ctx context.Context, groupFolder string, messages []Message) (string, error) {
-	// 转换消息格式
+// RegisterTools 注册可由LLM调用的工具（需在开始对话前调用）
+func (a *Agent) RegisterTools(tools ...AgentTool) {
+	a.tools = append(a.tools, tools...)
+}
+
+// InvalidateMemory 丢弃群组 CLAUDE.md 缓存，下次运行时重新读取
+func (a *Agent) InvalidateMemory(groupFolder string) {
+	a.memMu.Lock()
+	delete(a.memory, groupFolder)
+	a.memMu.Unlock()
+}
+
+// groupMemory 读取群组 CLAUDE.md（作为系统提示）
+func (a *Agent) groupMemory(groupFolder string) string {
+	a.memMu.RLock()
+	mem, ok := a.memory[groupFolder]
+	a.memMu.RUnlock()
+	if ok {
+		return mem
+	}
+
+	data, _ := os.ReadFile(filepath.Join(a.groupsDir, groupFolder, "CLAUDE.md"))
+	mem = string(data)
+	a.memMu.Lock()
+	a.memory[groupFolder] = mem
+	a.memMu.Unlock()
+	return mem
+}
+
+// buildMessages 转换消息格式，群组记忆作为系统消息置于开头
+func (a *Agent) buildMessages(groupFolder string, messages []Message) []openai.ChatCompletionMessage {
 	var msgs []openai.ChatCompletionMessage
+	if mem := a.groupMemory(groupFolder); mem != "" {
+		msgs = append(msgs, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: mem,
+		})
+	}
 	for _, m := range messages {
 		role := openai.ChatMessageRoleUser
 		if m.IsBotMessage {
@@ -47,37 +153,174 @@ func (a *Agent) Run(ctx context.Context, groupFolder string, messages []Message)
 			Content: m.Content,
 		})
 	}
+	return msgs
+}
+
+// Run 执行单次对话
+func (a *Agent) Run(ctx context.Context, groupFolder string, messages []Message) (string, error) {
+	resp, _, err := a.RunWithUsage(ctx, groupFolder, messages)
+	return resp, err
+}
 
-	// 调用API
-	resp, err := a.client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+// RunWithUsage 执行单次对话并返回token用量
+func (a *Agent) RunWithUsage(ctx context.Context, groupFolder string, messages []Message) (string, Usage, error) {
+	return a.run(ctx, groupFolder, messages, nil)
+}
+
+// RunStreaming 以流式请求执行单次对话，回复文本生成时依次传给 onDelta
+//
+// 工具调用同样支持；流式响应通常不包含token用量。
+func (a *Agent) RunStreaming(ctx context.Context, groupFolder string, messages []Message, onDelta func(string)) (string, Usage, error) {
+	return a.run(ctx, groupFolder, messages, onDelta)
+}
+
+func (a *Agent) run(ctx context.Context, groupFolder string, messages []Message, onDelta func(string)) (string, Usage, error) {
+	// 转换消息格式
+	req := openai.ChatCompletionRequest{
 		Model:    a.model,
-		Messages: msgs,
-	})
+		Messages: a.buildMessages(groupFolder, messages),
+		Tools:    a.toolDefinitions(),
+	}
+	tc := newToolContext(groupFolder, messages)
+
+	// 调用API，模型请求工具时执行工具并把结果发回，直到得到文本回复
+	var usage Usage
+	for round := 0; ; round++ {
+		msg, u, err := a.complete(ctx, req, onDelta)
+		usage.PromptTokens += u.PromptTokens
+		usage.CompletionTokens += u.CompletionTokens
+		if err != nil {
+			return "", usage, err
+		}
+		if len(msg.ToolCalls) == 0 {
+			return msg.Content, usage, nil
+		}
+		if round == maxToolRounds {
+			return "", usage, fmt.Errorf("llm error: more than %d rounds of tool calls", maxToolRounds)
+		}
+		req.Messages = append(req.Messages, msg)
+		for _, call := range msg.ToolCalls {
+			req.Messages = append(req.Messages, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				ToolCallID: call.ID,
+				Content:    a.callTool(ctx, tc, call),
+			})
+		}
+	}
+}
+
+// complete 请求一轮回复；onDelta 不为 nil 时使用流式请求并合并分片
+func (a *Agent) complete(ctx context.Context, req openai.ChatCompletionRequest, onDelta func(string)) (openai.ChatCompletionMessage, Usage, error) {
+	if onDelta == nil {
+		resp, err := a.client.CreateChatCompletion(ctx, req)
+		if err != nil {
+			return openai.ChatCompletionMessage{}, Usage{}, fmt.Errorf("llm error: %w", err)
+		}
+		usage := Usage{PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens}
+		if len(resp.Choices) == 0 {
+			return openai.ChatCompletionMessage{}, usage, fmt.Errorf("no response from LLM")
+		}
+		return resp.Choices[0].Message, usage, nil
+	}
+
+	req.Stream = true
+	req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	stream, err := a.client.CreateChatCompletionStream(ctx, req)
 	if err != nil {
-		return "", fmt.Errorf("llm error: %w", err)
+		return openai.ChatCompletionMessage{}, Usage{}, fmt.Errorf("llm stream error: %w", err)
+	}
+	defer stream.Close()
+
+	var (
+		usage   Usage
+		content strings.Builder
+		calls   []openai.ToolCall
+	)
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return openai.ChatCompletionMessage{}, usage, fmt.Errorf("llm stream error: %w", err)
+		}
+		if chunk.Usage != nil {
+			usage.PromptTokens += chunk.Usage.PromptTokens
+			usage.CompletionTokens += chunk.Usage.CompletionTokens
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+		delta := chunk.Choices[0].Delta
+		if delta.Content != "" {
+			content.WriteString(delta.Content)
+			onDelta(delta.Content)
+		}
+		// 工具调用按 Index 分片到达，名称和参数需要拼接
+		for _, tc := range delta.ToolCalls {
+			i := len(calls)
+			if tc.Index != nil {
+				i = *tc.Index
+			}
+			for len(calls) <= i {
+				calls = append(calls, openai.ToolCall{Type: openai.ToolTypeFunction})
+			}
+			if tc.ID != "" {
+				calls[i].ID = tc.ID
+			}
+			calls[i].Function.Name += tc.Function.Name
+			calls[i].Function.Arguments += tc.Function.Arguments
+		}
 	}
+	return openai.ChatCompletionMessage{
+		Role:      openai.ChatMessageRoleAssistant,
+		Content:   content.String(),
+		ToolCalls: calls,
+	}, usage, nil
+}
 
-	if len(resp.Choices) == 0 {
-		return "", fmt.Errorf("no response from LLM")
+func (a *Agent) toolDefinitions() []openai.Tool {
+	var defs []openai.Tool
+	for _, t := range a.tools {
+		defs = append(defs, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        t.Name,
+				Description: t.Description,
+				Parameters:  t.Parameters,
+			},
+		})
 	}
+	return defs
+}
 
-	return resp.Choices[0].Message.Content, nil
+// callTool 执行一次工具调用，错误以文本形式返回给模型
+func (a *Agent) callTool(ctx context.Context, tc ToolContext, call openai.ToolCall) string {
+	for _, t := range a.tools {
+		if t.Name != call.Function.Name {
+			continue
+		}
+		args := call.Function.Arguments
+		if args == "" {
+			args = "{}"
+		}
+		if !json.Valid([]byte(args)) {
+			return "error: arguments are not valid JSON"
+		}
+		out, err := t.Call(ctx, tc, args)
+		if err != nil {
+			slog.Warn("tool call", "tool", t.Name, "err", err)
+			return "error: " + err.Error()
+		}
+		return out
+	}
+	return "error: unknown tool " + call.Function.Name
 }
 
 // RunStream 流式执行
 func (a *Agent) RunStream(ctx context.Context, groupFolder string, messages []Message) (<-chan StreamEvent, error) {
 	// 转换消息格式
-	var msgs []openai.ChatCompletionMessage
-	for _, m := range messages {
-		role := openai.ChatMessageRoleUser
-		if m.IsBotMessage {
-			role = openai.ChatMessageRoleAssistant
-		}
-		msgs = append(msgs, openai.ChatCompletionMessage{
-			Role:    role,
-			Content: m.Content,
-		})
-	}
+	msgs := a.buildMessages(groupFolder, messages)
 
 	// 创建流
 	stream, err := a.client.CreateChatCompletionStream(ctx, openai.ChatCompletionRequest{
@@ -8,6 +8,9 @@ import (
 	"strconv"
 )
 
+// Version nanoclaw版本，技能包的 min_nanoclaw 与之比较
+const Version = "0.2.0"
+
 // Config 应用配置
 type Config struct {
 	App       AppConfig
@@ -20,6 +23,17 @@ type AppConfig struct {
 	Name            string
 	DataDir         string
 	GroupsDir       string
+	SkillsDir       string   // 全局用户技能目录
+	HTTPAllowlist   []string // 技能 http.request 允许访问的主机
+	SocketPath      string   // IPC控制接口的Unix Socket路径
+	IPCPolicy       string   // IPC访问策略文件
+	Sandbox         string   // 技能 exec.run 沙箱的限制配置文件
+	User            string   // 以root运行时Agent子进程切换到的用户，为 root 时不拆分进程
+	PIDFile         string   // 运行时写入PID的文件
+	ShutdownTimeout int      // 退出时等待队列中的Agent调用和任务完成的最长时间（秒）
+	HTTPAddr        string   // HTTP接口的监听地址，为空时不启动
+	HTTPTLSCert     string   // HTTP接口的TLS证书，与 HTTPTLSKey 同时设置时使用HTTPS
+	HTTPTLSKey      string   // HTTP接口的TLS私钥
 	TriggerPattern  *regexp.Regexp
 	MaxConcurrent   int64
 }
@@ -34,6 +48,10 @@ type LLMConfig struct {
 // SchedulerConfig 调度器配置
 type SchedulerConfig struct {
 	PollInterval int // 秒
+	TaskPriority int // 定时任务在 GroupQueue 中的优先级，交互消息为 PriorityInteractive
+	RunRetention int // 执行记录保留天数，0 表示不按时间清理
+	RunKeep      int // 每个任务最多保留的执行记录数，0 表示不限
+	TaskTimeout  int // 任务默认最长执行时间（秒），0 表示不限
 }
 
 // LoadConfig 从环境变量加载配置
@@ -52,9 +70,25 @@ func LoadConfig() *Config {
 		},
 		Scheduler: SchedulerConfig{
 			PollInterval: getEnvInt("NANOCLAW_SCHEDULER_INTERVAL", 60),
+			TaskPriority: getEnvInt("NANOCLAW_TASK_PRIORITY", PriorityScheduled),
+			RunRetention: getEnvInt("NANOCLAW_TASK_RUN_RETENTION_DAYS", 30),
+			RunKeep:      getEnvInt("NANOCLAW_TASK_RUN_KEEP", 100),
+			TaskTimeout:  getEnvInt("NANOCLAW_TASK_TIMEOUT", 300),
 		},
 	}
 
+	cfg.App.SkillsDir = getEnv("NANOCLAW_SKILLS_DIR", filepath.Join(cfg.App.DataDir, "skills"))
+	cfg.App.HTTPAllowlist = splitList(getEnv("NANOCLAW_HTTP_ALLOW", ""))
+	cfg.App.SocketPath = getEnv("NANOCLAW_SOCKET", defaultSocketPath(cfg.App.DataDir))
+	cfg.App.IPCPolicy = getEnv("NANOCLAW_IPC_POLICY", filepath.Join(cfg.App.DataDir, "ipc_policy.json"))
+	cfg.App.User = getEnv("NANOCLAW_USER", "nanoclaw")
+	cfg.App.Sandbox = getEnv("NANOCLAW_SANDBOX", filepath.Join(cfg.App.DataDir, "sandbox.json"))
+	cfg.App.PIDFile = getEnv("NANOCLAW_PID_FILE", filepath.Join(cfg.App.DataDir, "nanoclaw.pid"))
+	cfg.App.ShutdownTimeout = getEnvInt("NANOCLAW_SHUTDOWN_TIMEOUT", 60)
+	cfg.App.HTTPAddr = getEnv("NANOCLAW_HTTP_ADDR", "")
+	cfg.App.HTTPTLSCert = getEnv("NANOCLAW_HTTP_TLS_CERT", "")
+	cfg.App.HTTPTLSKey = getEnv("NANOCLAW_HTTP_TLS_KEY", "")
+
 	// 编译触发词正则
 	cfg.App.TriggerPattern = regexp.MustCompile(`(?i)^@` + regexp.QuoteMeta(cfg.App.Name) + `\b`)
 
@@ -66,9 +100,25 @@ func (c *Config) DBPath() string {
 	return filepath.Join(c.App.DataDir, "nanoclaw.db")
 }
 
+// GroupSkillsDir 返回群组私有技能目录
+func (c *Config) GroupSkillsDir(folder string) string {
+	return filepath.Join(c.App.GroupsDir, folder, "skills")
+}
+
 // SocketPath 返回Unix Socket路径
 func (c *Config) SocketPath() string {
-	return "/var/run/nanoclaw/nanoclaw.sock"
+	if c.App.SocketPath != "" {
+		return c.App.SocketPath
+	}
+	return defaultSocketPath(c.App.DataDir)
+}
+
+// defaultSocketPath 以root运行时使用 /var/run/nanoclaw，否则放在数据目录下
+func defaultSocketPath(dataDir string) string {
+	if IsRoot() || dataDir == "" {
+		return "/var/run/nanoclaw/nanoclaw.sock"
+	}
+	return filepath.Join(dataDir, "nanoclaw.sock")
 }
 
 func getEnv(key, fallback string) string {
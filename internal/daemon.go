@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SdNotify 向 systemd 发送状态通知（如 "READY=1"、"STOPPING=1"），多个状态以换行分隔
+//
+// 没有设置 NOTIFY_SOCKET（不是以 Type=notify 启动）时什么都不做。
+func SdNotify(state string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	// 以 @ 开头的抽象地址由 net 包转换
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("sd_notify: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("sd_notify: %w", err)
+	}
+	return nil
+}
+
+// SdWatchdogInterval systemd 要求的看门狗间隔（WATCHDOG_USEC），未启用或不是发给本进程时返回0
+func SdWatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// SdWatchdog 启用了看门狗时每半个间隔发送一次 WATCHDOG=1，直到 ctx 取消
+//
+// check 返回错误时跳过本次通知，持续失败由 systemd 按 WatchdogSec 重启进程。
+func SdWatchdog(ctx context.Context, check func() error) {
+	interval := SdWatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			if check != nil {
+				if err := check(); err != nil {
+					slog.Warn("watchdog check failed", "err", err)
+					continue
+				}
+			}
+			if err := SdNotify("WATCHDOG=1"); err != nil {
+				slog.Warn("watchdog", "err", err)
+			}
+		}
+	}()
+}
+
+// errPIDFileLocked PID文件被其他进程锁定
+var errPIDFileLocked = errors.New("pid file is locked")
+
+// WritePIDFile 写入当前进程的PID，返回退出时删除该文件的函数
+//
+// 进程运行期间持有文件的排他锁（unix 上为 flock），其他进程已持有锁时返回错误。
+// 锁随进程退出释放，残留的文件（进程已退出）被覆盖。
+func WritePIDFile(path string) (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockPIDFile(f); err != nil {
+			f.Close()
+			if errors.Is(err, errPIDFileLocked) {
+				data, _ := os.ReadFile(path)
+				return nil, fmt.Errorf("nanoclaw is already running (pid %s, %s)", strings.TrimSpace(string(data)), path)
+			}
+			return nil, err
+		}
+		// 加锁前文件可能已被退出的进程删除，锁住的是已不在路径上的旧文件时重新打开
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if cur, err := os.Stat(path); err != nil || !os.SameFile(fi, cur) {
+			f.Close()
+			continue
+		}
+
+		if err := f.Truncate(0); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+			f.Close()
+			return nil, err
+		}
+		return func() {
+			// 先删除再释放锁，等待中的进程不会锁住即将删除的文件后继续使用它
+			os.Remove(path)
+			f.Close()
+		}, nil
+	}
+}
@@ -0,0 +1,32 @@
+//go:build !unix
+
+package internal
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// processAlive 检查进程是否存在
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
+
+// lockPIDFile 不支持 flock 的平台上检查文件中记录的进程是否仍在运行
+func lockPIDFile(f *os.File) error {
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err == nil && pid != os.Getpid() && processAlive(pid) {
+		return errPIDFileLocked
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unixgram not supported")
+	}
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := SdNotify("READY=1"); err != nil {
+		t.Errorf("without NOTIFY_SOCKET: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+	if err := SdNotify("READY=1\nSTATUS=ok"); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1\nSTATUS=ok" {
+		t.Errorf("got %q, %v", buf[:n], err)
+	}
+}
+
+func TestSdWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"bad", "", 0},
+		{"2000000", "", 2 * time.Second},
+		{"2000000", strconv.Itoa(os.Getpid()), 2 * time.Second},
+		{"2000000", "1", 0},
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got := SdWatchdogInterval(); got != tt.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q: %s, want %s", tt.usec, tt.pid, got, tt.want)
+		}
+	}
+}
+
+func TestWritePIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run", "nanoclaw.pid")
+	remove, err := WritePIDFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); strings.TrimSpace(string(data)) != strconv.Itoa(os.Getpid()) {
+		t.Errorf("pid file = %q", data)
+	}
+	remove()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("pid file not removed: %v", err)
+	}
+
+	// 残留文件中的进程已退出时覆盖
+	os.WriteFile(path, []byte("999999999\n"), 0644)
+	remove, err = WritePIDFile(path)
+	if err != nil {
+		t.Fatalf("stale pid file: %v", err)
+	}
+	remove()
+
+	// 文件被运行中的实例锁定时拒绝
+	if runtime.GOOS != "windows" {
+		held, err := WritePIDFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := WritePIDFile(path); err == nil || !strings.Contains(err.Error(), "already running") {
+			t.Errorf("locked pid file: %v", err)
+		}
+		held()
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("pid file not removed: %v", err)
+		}
+	}
+}
+
+func TestWritePIDFile_Concurrent(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no flock")
+	}
+	path := filepath.Join(t.TempDir(), "nanoclaw.pid")
+	// 残留文件不影响结果：两个同时启动的实例只有一个能取得PID文件
+	os.WriteFile(path, []byte("999999999\n"), 0644)
+	for i := 0; i < 50; i++ {
+		var wg sync.WaitGroup
+		removes := make([]func(), 2)
+		for j := range removes {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				removes[j], _ = WritePIDFile(path)
+			}()
+		}
+		wg.Wait()
+		acquired := 0
+		for _, remove := range removes {
+			if remove != nil {
+				acquired++
+				remove()
+			}
+		}
+		if acquired != 1 {
+			t.Fatalf("round %d: %d acquisitions succeeded, want 1", i, acquired)
+		}
+	}
+}
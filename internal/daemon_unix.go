@@ -0,0 +1,18 @@
+//go:build unix
+
+package internal
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// lockPIDFile 以非阻塞方式对PID文件加排他锁，锁随文件关闭或进程退出释放
+func lockPIDFile(f *os.File) error {
+	err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return errPIDFileLocked
+	}
+	return err
+}
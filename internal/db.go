@@ -2,7 +2,9 @@ package internal
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -67,13 +69,148 @@ CREATE TABLE IF NOT EXISTS tasks (
     last_run TEXT,
     last_result TEXT,
     status TEXT DEFAULT 'active',
-    created_at TEXT
+    created_at TEXT,
+    silent INTEGER DEFAULT 0,
+    timezone TEXT DEFAULT '',
+    retry_limit INTEGER DEFAULT 0,
+    retry_delay INTEGER DEFAULT 0,
+    timeout INTEGER DEFAULT 0,
+    misfire TEXT DEFAULT '',
+    attempt INTEGER DEFAULT 0,
+    debounce INTEGER DEFAULT 0,
+    rate_limit INTEGER DEFAULT 0,
+    resume_from INTEGER DEFAULT 0
 );
 
 CREATE INDEX IF NOT EXISTS idx_tasks_next_run ON tasks(next_run) WHERE status = 'active';
+
+CREATE TABLE IF NOT EXISTS task_runs (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id TEXT NOT NULL,
+    started_at TEXT NOT NULL,
+    ended_at TEXT NOT NULL,
+    status TEXT NOT NULL,
+    error TEXT,
+    output TEXT,
+    prompt_tokens INTEGER DEFAULT 0,
+    completion_tokens INTEGER DEFAULT 0
+);
+
+CREATE INDEX IF NOT EXISTS idx_task_runs_task ON task_runs(task_id, started_at);
+
+CREATE TABLE IF NOT EXISTS task_steps (
+    task_id TEXT NOT NULL,
+    position INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    prompt TEXT,
+    skill TEXT,
+    args TEXT,
+    depends_on TEXT,
+    PRIMARY KEY (task_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS task_run_steps (
+    run_id INTEGER NOT NULL,
+    position INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    status TEXT NOT NULL,
+    started_at TEXT NOT NULL,
+    ended_at TEXT NOT NULL,
+    output TEXT,
+    error TEXT,
+    PRIMARY KEY (run_id, name)
+);
+
+CREATE TABLE IF NOT EXISTS kv (
+    group_folder TEXT NOT NULL,
+    key TEXT NOT NULL,
+    value TEXT,
+    updated_at TEXT,
+    PRIMARY KEY (group_folder, key)
+);
+
+CREATE TABLE IF NOT EXISTS installed_skills (
+    name TEXT PRIMARY KEY,
+    version TEXT NOT NULL,
+    source TEXT,
+    checksum TEXT,
+    path TEXT NOT NULL,
+    installed_at TEXT
+);
+
+CREATE TABLE IF NOT EXISTS skill_group_state (
+    name TEXT NOT NULL,
+    group_folder TEXT NOT NULL,
+    enabled INTEGER NOT NULL,
+    PRIMARY KEY (name, group_folder)
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens (
+    name TEXT PRIMARY KEY,
+    hash TEXT NOT NULL UNIQUE,
+    groups TEXT,
+    created_at TEXT,
+    last_used_at TEXT
+);
 `
-	_, err := db.Exec(schema)
-	return err
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+	return migrateColumns(db)
+}
+
+// columnMigrations 后续版本新增的列，旧数据库启动时自动补齐
+var columnMigrations = []struct {
+	table, column, def string
+}{
+	{"tasks", "silent", "INTEGER DEFAULT 0"},
+	{"tasks", "timezone", "TEXT DEFAULT ''"},
+	{"tasks", "retry_limit", "INTEGER DEFAULT 0"},
+	{"tasks", "retry_delay", "INTEGER DEFAULT 0"},
+	{"tasks", "timeout", "INTEGER DEFAULT 0"},
+	{"tasks", "misfire", "TEXT DEFAULT ''"},
+	{"tasks", "attempt", "INTEGER DEFAULT 0"},
+	{"tasks", "debounce", "INTEGER DEFAULT 0"},
+	{"tasks", "rate_limit", "INTEGER DEFAULT 0"},
+	{"tasks", "resume_from", "INTEGER DEFAULT 0"},
+}
+
+func migrateColumns(db *sql.DB) error {
+	for _, m := range columnMigrations {
+		exists, err := hasColumn(db, m.table, m.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf(`ALTER TABLE %s ADD COLUMN %s %s`, m.table, m.column, m.def)); err != nil {
+			return fmt.Errorf("add column %s.%s: %w", m.table, m.column, err)
+		}
+	}
+	return nil
+}
+
+func hasColumn(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf(`PRAGMA table_info(%s)`, table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			cid, notNull, pk int
+			name, typ        string
+			dflt             sql.NullString
+		)
+		if err := rows.Scan(&cid, &name, &typ, &notNull, &dflt, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
 }
 
 // SaveMessage 保存消息
@@ -88,11 +225,21 @@ func (d *DB) SaveMessage(m *Message) error {
 
 // GetMessages 获取消息
 func (d *DB) GetMessages(chatJID ChatJID, limit int) ([]Message, error) {
-	rows, err := d.Query(
-		`SELECT id, chat_jid, sender, sender_name, content, timestamp, is_bot 
-		 FROM messages WHERE chat_jid = ? ORDER BY timestamp DESC LIMIT ?`,
-		chatJID, limit,
-	)
+	return d.GetMessagesBefore(chatJID, "", limit)
+}
+
+// GetMessagesBefore 获取早于消息 before 的最近 limit 条消息（按时间顺序），before 为空时从最新开始；
+// 时间相同的消息按ID排序，分页结果不重复也不遗漏
+func (d *DB) GetMessagesBefore(chatJID ChatJID, before MessageID, limit int) ([]Message, error) {
+	query := `SELECT id, chat_jid, sender, sender_name, content, timestamp, is_bot 
+		 FROM messages WHERE chat_jid = ?`
+	args := []any{chatJID}
+	if before != "" {
+		query += ` AND (timestamp, id) < (SELECT timestamp, id FROM messages WHERE id = ? AND chat_jid = ?)`
+		args = append(args, before, chatJID)
+	}
+	query += ` ORDER BY timestamp DESC, id DESC LIMIT ?`
+	rows, err := d.Query(query, append(args, limit)...)
 	if err != nil {
 		return nil, err
 	}
@@ -135,6 +282,29 @@ func (d *DB) GetGroup(jid ChatJID) (*Group, error) {
 	return &g, nil
 }
 
+// ListGroups 获取所有群组（按名称排序）
+func (d *DB) ListGroups() ([]Group, error) {
+	rows, err := d.Query(`SELECT jid, name, folder, trigger_pattern, requires_trigger, added_at FROM groups ORDER BY name, jid`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []Group
+	for rows.Next() {
+		var g Group
+		var reqTrigger int
+		var addedAt string
+		if err := rows.Scan(&g.JID, &g.Name, &g.Folder, &g.TriggerPattern, &reqTrigger, &addedAt); err != nil {
+			return nil, err
+		}
+		g.RequiresTrigger = reqTrigger == 1
+		g.AddedAt, _ = time.Parse(time.RFC3339, addedAt)
+		groups = append(groups, g)
+	}
+	return groups, rows.Err()
+}
+
 // SaveGroup 保存群组
 func (d *DB) SaveGroup(g *Group) error {
 	_, err := d.Exec(
@@ -169,12 +339,16 @@ func (d *DB) SaveSession(s *Session) error {
 	return err
 }
 
+// taskColumns tasks表查询列，与 scanTasks 保持一致
+const taskColumns = `id, group_folder, chat_jid, prompt, schedule_type, schedule_value, next_run, last_run, last_result, status, created_at, silent, timezone, retry_limit, retry_delay, timeout, misfire, attempt, debounce, rate_limit, resume_from`
+
 // GetDueTasks 获取到期任务
+//
+// 使用 datetime() 比较，兼容早期版本以本地偏移存储的时间。
 func (d *DB) GetDueTasks(now time.Time) ([]Task, error) {
 	rows, err := d.Query(
-		`SELECT id, group_folder, chat_jid, prompt, schedule_type, schedule_value, next_run, last_run, last_result, status, created_at 
-		 FROM tasks WHERE status = 'active' AND next_run <= ?`,
-		now.Format(time.RFC3339),
+		`SELECT `+taskColumns+` FROM tasks WHERE status = 'active' AND datetime(next_run) <= datetime(?)`,
+		formatTime(now),
 	)
 	if err != nil {
 		return nil, err
@@ -184,44 +358,697 @@ func (d *DB) GetDueTasks(now time.Time) ([]Task, error) {
 	return scanTasks(rows)
 }
 
-// SaveTask 保存任务
+// NextDueTime 返回活动任务中最早的执行时间，没有时返回nil
+func (d *DB) NextDueTime() (*time.Time, error) {
+	var next sql.NullString
+	err := d.QueryRow(
+		`SELECT MIN(datetime(next_run)) FROM tasks WHERE status = 'active' AND next_run IS NOT NULL`,
+	).Scan(&next)
+	if err != nil || !next.Valid {
+		return nil, err
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05", next.String, time.UTC)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetTask 获取单个任务
+func (d *DB) GetTask(id string) (*Task, error) {
+	rows, err := d.Query(`SELECT `+taskColumns+` FROM tasks WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tasks, err := scanTasks(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(tasks) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &tasks[0], nil
+}
+
+// ListTasks 获取群组的全部任务（按创建时间排序）
+func (d *DB) ListTasks(groupFolder string) ([]Task, error) {
+	return d.FindTasks(TaskFilter{GroupFolder: groupFolder})
+}
+
+// TaskFilter 任务查询条件，零值字段不参与过滤
+type TaskFilter struct {
+	GroupFolder  string
+	ChatJID      ChatJID
+	Status       string
+	ScheduleType string
+	GroupFolders []string // 只查询这些群组（受访问限制的调用方），nil 表示不限制
+	Limit        int
+}
+
+// FindTasks 按条件查询任务（按创建时间排序）
+func (d *DB) FindTasks(f TaskFilter) ([]Task, error) {
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE 1 = 1`
+	var args []any
+	if f.GroupFolder != "" {
+		query += ` AND group_folder = ?`
+		args = append(args, f.GroupFolder)
+	}
+	if f.ChatJID != "" {
+		query += ` AND chat_jid = ?`
+		args = append(args, f.ChatJID)
+	}
+	if f.Status != "" {
+		query += ` AND status = ?`
+		args = append(args, f.Status)
+	}
+	if f.ScheduleType != "" {
+		query += ` AND schedule_type = ?`
+		args = append(args, f.ScheduleType)
+	}
+	if f.GroupFolders != nil {
+		// 空列表为 IN ()，SQLite 中不匹配任何行
+		query += ` AND group_folder IN (` + strings.TrimPrefix(strings.Repeat(", ?", len(f.GroupFolders)), ", ") + `)`
+		for _, folder := range f.GroupFolders {
+			args = append(args, folder)
+		}
+	}
+	query += ` ORDER BY created_at, id`
+	if f.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, f.Limit)
+	}
+
+	rows, err := d.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTasks(rows)
+}
+
+// DeleteTask 删除任务及其步骤和执行记录
+func (d *DB) DeleteTask(id string) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM tasks WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	for _, q := range []string{
+		`DELETE FROM task_run_steps WHERE run_id IN (SELECT id FROM task_runs WHERE task_id = ?)`,
+		`DELETE FROM task_runs WHERE task_id = ?`,
+		`DELETE FROM task_steps WHERE task_id = ?`,
+	} {
+		if _, err := tx.Exec(q, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// TriggerTask 将活动任务的下次执行时间提前到 at（立即执行）
+func (d *DB) TriggerTask(id string, at time.Time) error {
+	task, err := d.GetTask(id)
+	if err != nil {
+		return err
+	}
+	if task.Status != TaskActive {
+		return fmt.Errorf("task %s is %s", id, task.Status)
+	}
+	_, err = d.Exec(`UPDATE tasks SET next_run = ? WHERE id = ?`, formatTime(at), id)
+	return err
+}
+
+// UpdateTaskStatus 更新任务状态
+func (d *DB) UpdateTaskStatus(id, status string) error {
+	res, err := d.Exec(`UPDATE tasks SET status = ? WHERE id = ?`, status, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// SaveTask 保存任务（新建或更新定义与状态，不影响执行记录）
+//
+// 保存前校验调度表达式；活动任务未设置 NextRun 时，从 CreatedAt 起计算首次执行时间。
 func (d *DB) SaveTask(t *Task) error {
-	var nextRun *string
-	if t.NextRun != nil {
-		s := t.NextRun.Format(time.RFC3339)
-		nextRun = &s
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = time.Now()
 	}
-	_, err := d.Exec(
-		`INSERT OR REPLACE INTO tasks (id, group_folder, chat_jid, prompt, schedule_type, schedule_value, next_run, status, created_at) 
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		t.ID, t.GroupFolder, t.ChatJID, t.Prompt, t.ScheduleType, t.ScheduleValue, nextRun, t.Status, t.CreatedAt.Format(time.RFC3339),
+	if t.Status == "" {
+		t.Status = TaskActive
+	}
+	if err := t.ValidatePolicy(); err != nil {
+		return err
+	}
+	next, err := t.NextRunAfter(t.CreatedAt)
+	if err != nil {
+		return err
+	}
+	if t.NextRun == nil && t.Status == TaskActive {
+		t.NextRun = next
+	}
+
+	_, err = d.Exec(
+		`INSERT INTO tasks (id, group_folder, chat_jid, prompt, schedule_type, schedule_value, next_run, status, created_at, silent, timezone,
+		   retry_limit, retry_delay, timeout, misfire, debounce, rate_limit) 
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		 ON CONFLICT(id) DO UPDATE SET group_folder = excluded.group_folder, chat_jid = excluded.chat_jid,
+		   prompt = excluded.prompt, schedule_type = excluded.schedule_type, schedule_value = excluded.schedule_value,
+		   next_run = excluded.next_run, status = excluded.status, silent = excluded.silent, timezone = excluded.timezone,
+		   retry_limit = excluded.retry_limit, retry_delay = excluded.retry_delay, timeout = excluded.timeout, misfire = excluded.misfire,
+		   debounce = excluded.debounce, rate_limit = excluded.rate_limit`,
+		t.ID, t.GroupFolder, t.ChatJID, t.Prompt, t.ScheduleType, t.ScheduleValue, formatTimePtr(t.NextRun), t.Status, formatTime(t.CreatedAt), boolToInt(t.Silent), t.Timezone,
+		t.RetryLimit, int64(t.RetryDelay/time.Second), int64(t.Timeout/time.Second), t.Misfire,
+		int64(t.Debounce/time.Second), t.RateLimit,
 	)
 	return err
 }
 
 // UpdateTaskRun 更新任务执行结果
 func (d *DB) UpdateTaskRun(id string, result string, nextRun *time.Time) error {
-	var nr *string
-	if nextRun != nil {
-		s := nextRun.Format(time.RFC3339)
-		nr = &s
-	}
 	_, err := d.Exec(
 		`UPDATE tasks SET last_run = ?, last_result = ?, next_run = ? WHERE id = ?`,
-		time.Now().Format(time.RFC3339), result, nr, id,
+		formatTime(time.Now()), result, formatTimePtr(nextRun), id,
 	)
 	return err
 }
 
+// FinishTaskRun 记录一次执行：结果、执行时间，以及任务的新状态、下次执行时间、重试次数和工作流的恢复点
+//
+// 执行从活动状态开始；执行期间任务被暂停或修改了状态时保留当前的状态和下次执行时间，只记录结果。
+// 任务已被删除时返回 sql.ErrNoRows。
+func (d *DB) FinishTaskRun(t *Task, result string, ranAt time.Time) error {
+	res, err := d.Exec(
+		`UPDATE tasks SET
+		   status = CASE WHEN status = ? THEN ? ELSE status END,
+		   next_run = CASE WHEN status = ? THEN ? ELSE next_run END,
+		   last_run = ?, last_result = ?, attempt = ?, resume_from = ?
+		 WHERE id = ?`,
+		TaskActive, t.Status, TaskActive, formatTimePtr(t.NextRun),
+		formatTime(ranAt), result, t.Attempt, t.ResumeFrom, t.ID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// runTimeLayout 执行记录的时间格式：UTC、固定毫秒位数，保证字符串顺序即时间顺序
+const runTimeLayout = "2006-01-02T15:04:05.000Z"
+
+// taskRunColumns task_runs表查询列，与 scanTaskRuns 保持一致
+const taskRunColumns = `id, task_id, started_at, ended_at, status, error, output, prompt_tokens, completion_tokens`
+
+// SaveTaskRun 保存一次执行记录（包括工作流步骤的结果）并回填ID
+func (d *DB) SaveTaskRun(r *TaskRun) error {
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`INSERT INTO task_runs (task_id, started_at, ended_at, status, error, output, prompt_tokens, completion_tokens)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		r.TaskID, r.StartedAt.UTC().Format(runTimeLayout), r.EndedAt.UTC().Format(runTimeLayout),
+		r.Status, r.Error, r.Output, r.PromptTokens, r.CompletionTokens,
+	)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for i, st := range r.Steps {
+		if _, err := tx.Exec(
+			`INSERT INTO task_run_steps (run_id, position, name, status, started_at, ended_at, output, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			id, i, st.Name, st.Status, st.StartedAt.UTC().Format(runTimeLayout), st.EndedAt.UTC().Format(runTimeLayout), st.Output, st.Error,
+		); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	r.ID = id
+	return nil
+}
+
+// ListTaskRuns 分页获取任务的执行记录（最新的在前）
+func (d *DB) ListTaskRuns(taskID string, limit, offset int) ([]TaskRun, error) {
+	rows, err := d.Query(
+		`SELECT `+taskRunColumns+` FROM task_runs WHERE task_id = ? ORDER BY started_at DESC, id DESC LIMIT ? OFFSET ?`,
+		taskID, limit, offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanTaskRuns(rows)
+}
+
+// GetTaskRun 获取单条执行记录
+func (d *DB) GetTaskRun(id int64) (*TaskRun, error) {
+	rows, err := d.Query(`SELECT `+taskRunColumns+` FROM task_runs WHERE id = ?`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	runs, err := scanTaskRuns(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(runs) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	run := &runs[0]
+	run.Steps, err = d.getTaskRunSteps(id)
+	return run, err
+}
+
+func (d *DB) getTaskRunSteps(runID int64) ([]TaskRunStep, error) {
+	rows, err := d.Query(
+		`SELECT name, status, started_at, ended_at, output, error FROM task_run_steps WHERE run_id = ? ORDER BY position`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []TaskRunStep
+	for rows.Next() {
+		var st TaskRunStep
+		var started, ended string
+		var output, errText sql.NullString
+		if err := rows.Scan(&st.Name, &st.Status, &started, &ended, &output, &errText); err != nil {
+			return nil, err
+		}
+		st.StartedAt, _ = time.Parse(runTimeLayout, started)
+		st.EndedAt, _ = time.Parse(runTimeLayout, ended)
+		st.Output, st.Error = output.String, errText.String
+		steps = append(steps, st)
+	}
+	return steps, rows.Err()
+}
+
+// CountTaskRuns 返回任务的执行记录数
+func (d *DB) CountTaskRuns(taskID string) (int, error) {
+	var n int
+	err := d.QueryRow(`SELECT COUNT(*) FROM task_runs WHERE task_id = ?`, taskID).Scan(&n)
+	return n, err
+}
+
+// CountTaskRunsSince 统计任务在 since 之后开始的执行次数
+func (d *DB) CountTaskRunsSince(taskID string, since time.Time) (int, error) {
+	var n int
+	err := d.QueryRow(`SELECT COUNT(*) FROM task_runs WHERE task_id = ? AND started_at >= ?`,
+		taskID, since.UTC().Format(runTimeLayout)).Scan(&n)
+	return n, err
+}
+
+// PruneTaskRuns 删除早于 before 的记录，并且每个任务只保留最新的 keep 条
+//
+// before 为零值或 keep <= 0 时不按对应条件清理。返回删除的行数。
+func (d *DB) PruneTaskRuns(before time.Time, keep int) (int64, error) {
+	var total int64
+	if !before.IsZero() {
+		res, err := d.Exec(`DELETE FROM task_runs WHERE started_at < ?`, before.UTC().Format(runTimeLayout))
+		if err != nil {
+			return 0, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	if keep > 0 {
+		res, err := d.Exec(
+			`DELETE FROM task_runs WHERE id IN (
+			   SELECT id FROM (
+			     SELECT id, ROW_NUMBER() OVER (PARTITION BY task_id ORDER BY started_at DESC, id DESC) AS rn FROM task_runs
+			   ) WHERE rn > ?)`,
+			keep,
+		)
+		if err != nil {
+			return total, err
+		}
+		n, _ := res.RowsAffected()
+		total += n
+	}
+	if total > 0 {
+		if _, err := d.Exec(`DELETE FROM task_run_steps WHERE run_id NOT IN (SELECT id FROM task_runs)`); err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// SaveTaskSteps 替换任务的工作流步骤，steps 为空时任务恢复为单一提示
+//
+// 保存前校验步骤名、依赖关系和环。
+func (d *DB) SaveTaskSteps(taskID string, steps []WorkflowStep) error {
+	if _, err := OrderWorkflow(steps); err != nil {
+		return err
+	}
+	tx, err := d.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM tasks WHERE id = ?`, taskID).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(`DELETE FROM task_steps WHERE task_id = ?`, taskID); err != nil {
+		return err
+	}
+	for i, st := range steps {
+		args, _ := json.Marshal(st.Args)
+		deps, _ := json.Marshal(st.DependsOn)
+		if _, err := tx.Exec(
+			`INSERT INTO task_steps (task_id, position, name, prompt, skill, args, depends_on) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			taskID, i, st.Name, st.Prompt, st.Skill, string(args), string(deps),
+		); err != nil {
+			return err
+		}
+	}
+	// 步骤变化后旧的恢复点不再有效
+	if _, err := tx.Exec(`UPDATE tasks SET resume_from = 0 WHERE id = ?`, taskID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetTaskSteps 获取任务的工作流步骤（按定义顺序），普通任务返回空
+func (d *DB) GetTaskSteps(taskID string) ([]WorkflowStep, error) {
+	rows, err := d.Query(
+		`SELECT name, prompt, skill, args, depends_on FROM task_steps WHERE task_id = ? ORDER BY position`, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []WorkflowStep
+	for rows.Next() {
+		var st WorkflowStep
+		var prompt, skill, args, deps sql.NullString
+		if err := rows.Scan(&st.Name, &prompt, &skill, &args, &deps); err != nil {
+			return nil, err
+		}
+		st.Prompt, st.Skill = prompt.String, skill.String
+		if args.String != "" {
+			if err := json.Unmarshal([]byte(args.String), &st.Args); err != nil {
+				return nil, fmt.Errorf("step %s args: %w", st.Name, err)
+			}
+		}
+		if deps.String != "" {
+			if err := json.Unmarshal([]byte(deps.String), &st.DependsOn); err != nil {
+				return nil, fmt.Errorf("step %s depends_on: %w", st.Name, err)
+			}
+		}
+		steps = append(steps, st)
+	}
+	return steps, rows.Err()
+}
+
+// ResumeTaskRun 安排工作流任务在 at 从失败的执行记录 runID 继续：成功的步骤沿用其输出，其余步骤重新执行
+//
+// 已失败的任务重新激活；暂停或已完成的任务返回错误。
+func (d *DB) ResumeTaskRun(runID int64, at time.Time) (*Task, error) {
+	run, err := d.GetTaskRun(runID)
+	if err != nil {
+		return nil, err
+	}
+	if run.Status != TaskRunError || len(run.Steps) == 0 {
+		return nil, fmt.Errorf("run %d is not a failed workflow run", runID)
+	}
+	task, err := d.GetTask(run.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	if task.Status == TaskFailed {
+		if err := task.Transition(TaskActive); err != nil {
+			return nil, err
+		}
+	}
+	if task.Status != TaskActive {
+		return nil, fmt.Errorf("task %s is %s", task.ID, task.Status)
+	}
+	task.NextRun = &at
+	task.Attempt = 0
+	task.ResumeFrom = runID
+	_, err = d.Exec(`UPDATE tasks SET status = ?, next_run = ?, attempt = 0, resume_from = ? WHERE id = ?`,
+		task.Status, formatTime(at), runID, task.ID)
+	if err != nil {
+		return nil, err
+	}
+	return task, nil
+}
+
+// GetKV 读取群组键值
+func (d *DB) GetKV(groupFolder, key string) (string, bool, error) {
+	var v string
+	err := d.QueryRow(`SELECT value FROM kv WHERE group_folder = ? AND key = ?`, groupFolder, key).Scan(&v)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return v, true, nil
+}
+
+// SetKV 写入群组键值
+func (d *DB) SetKV(groupFolder, key, value string) error {
+	_, err := d.Exec(
+		`INSERT OR REPLACE INTO kv (group_folder, key, value, updated_at) VALUES (?, ?, ?, ?)`,
+		groupFolder, key, value, time.Now().Format(time.RFC3339),
+	)
+	return err
+}
+
+// DeleteKV 删除群组键值
+func (d *DB) DeleteKV(groupFolder, key string) error {
+	_, err := d.Exec(`DELETE FROM kv WHERE group_folder = ? AND key = ?`, groupFolder, key)
+	return err
+}
+
+// SaveInstalledSkill 记录已安装技能
+func (d *DB) SaveInstalledSkill(s *InstalledSkill) error {
+	_, err := d.Exec(
+		`INSERT OR REPLACE INTO installed_skills (name, version, source, checksum, path, installed_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		s.Name, s.Version, s.Source, s.Checksum, s.Path, s.InstalledAt.Format(time.RFC3339),
+	)
+	return err
+}
+
+// GetInstalledSkill 获取已安装技能记录
+func (d *DB) GetInstalledSkill(name string) (*InstalledSkill, error) {
+	var s InstalledSkill
+	var installedAt string
+	err := d.QueryRow(
+		`SELECT name, version, source, checksum, path, installed_at FROM installed_skills WHERE name = ?`, name,
+	).Scan(&s.Name, &s.Version, &s.Source, &s.Checksum, &s.Path, &installedAt)
+	if err != nil {
+		return nil, err
+	}
+	s.InstalledAt, _ = time.Parse(time.RFC3339, installedAt)
+	return &s, nil
+}
+
+// ListInstalledSkills 列出已安装技能
+func (d *DB) ListInstalledSkills() ([]InstalledSkill, error) {
+	rows, err := d.Query(`SELECT name, version, source, checksum, path, installed_at FROM installed_skills ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []InstalledSkill
+	for rows.Next() {
+		var s InstalledSkill
+		var installedAt string
+		if err := rows.Scan(&s.Name, &s.Version, &s.Source, &s.Checksum, &s.Path, &installedAt); err != nil {
+			return nil, err
+		}
+		s.InstalledAt, _ = time.Parse(time.RFC3339, installedAt)
+		list = append(list, s)
+	}
+	return list, rows.Err()
+}
+
+// DeleteInstalledSkill 删除已安装技能记录及其群组启用状态
+func (d *DB) DeleteInstalledSkill(name string) error {
+	if _, err := d.Exec(`DELETE FROM skill_group_state WHERE name = ?`, name); err != nil {
+		return err
+	}
+	_, err := d.Exec(`DELETE FROM installed_skills WHERE name = ?`, name)
+	return err
+}
+
+// SetSkillEnabled 设置技能在群组中是否启用
+func (d *DB) SetSkillEnabled(name, groupFolder string, enabled bool) error {
+	_, err := d.Exec(
+		`INSERT OR REPLACE INTO skill_group_state (name, group_folder, enabled) VALUES (?, ?, ?)`,
+		name, groupFolder, boolToInt(enabled),
+	)
+	return err
+}
+
+// DisabledSkills 返回群组中被禁用的技能
+func (d *DB) DisabledSkills(groupFolder string) (map[string]bool, error) {
+	rows, err := d.Query(`SELECT name FROM skill_group_state WHERE group_folder = ? AND enabled = 0`, groupFolder)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	disabled := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		disabled[name] = true
+	}
+	return disabled, rows.Err()
+}
+
+// SkillGroupStates 返回技能在各群组中的启用状态（仅含显式设置过的群组）
+func (d *DB) SkillGroupStates(name string) (map[string]bool, error) {
+	rows, err := d.Query(`SELECT group_folder, enabled FROM skill_group_state WHERE name = ? ORDER BY group_folder`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := make(map[string]bool)
+	for rows.Next() {
+		var folder string
+		var enabled int
+		if err := rows.Scan(&folder, &enabled); err != nil {
+			return nil, err
+		}
+		states[folder] = enabled == 1
+	}
+	return states, rows.Err()
+}
+
+// CreateAPIToken 保存HTTP接口令牌，只保存令牌的哈希；名称已存在时返回错误
+func (d *DB) CreateAPIToken(t *APIToken, hash string) error {
+	groups, err := json.Marshal(t.Groups)
+	if err != nil {
+		return err
+	}
+	_, err = d.Exec(`INSERT INTO api_tokens (name, hash, groups, created_at) VALUES (?, ?, ?, ?)`,
+		t.Name, hash, string(groups), t.CreatedAt.Format(time.RFC3339))
+	return err
+}
+
+// GetAPITokenByHash 按哈希查找令牌，不存在时返回 sql.ErrNoRows
+func (d *DB) GetAPITokenByHash(hash string) (*APIToken, error) {
+	rows, err := d.Query(`SELECT name, groups, created_at, last_used_at FROM api_tokens WHERE hash = ?`, hash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens, err := scanAPITokens(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	return &tokens[0], nil
+}
+
+// ListAPITokens 列出全部令牌（按名称排序）
+func (d *DB) ListAPITokens() ([]APIToken, error) {
+	rows, err := d.Query(`SELECT name, groups, created_at, last_used_at FROM api_tokens ORDER BY name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanAPITokens(rows)
+}
+
+// DeleteAPIToken 撤销令牌，不存在时返回 sql.ErrNoRows
+func (d *DB) DeleteAPIToken(name string) error {
+	res, err := d.Exec(`DELETE FROM api_tokens WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// TouchAPIToken 记录令牌的最后使用时间
+func (d *DB) TouchAPIToken(name string, at time.Time) error {
+	_, err := d.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE name = ?`, at.Format(time.RFC3339), name)
+	return err
+}
+
+func scanAPITokens(rows *sql.Rows) ([]APIToken, error) {
+	var tokens []APIToken
+	for rows.Next() {
+		var t APIToken
+		var groups, createdAt string
+		var lastUsed sql.NullString
+		if err := rows.Scan(&t.Name, &groups, &createdAt, &lastUsed); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(groups), &t.Groups); err != nil {
+			return nil, fmt.Errorf("token %s: groups: %w", t.Name, err)
+		}
+		t.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		if lastUsed.Valid {
+			used, _ := time.Parse(time.RFC3339, lastUsed.String)
+			t.LastUsedAt = &used
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
 func scanTasks(rows *sql.Rows) ([]Task, error) {
 	var tasks []Task
 	for rows.Next() {
 		var t Task
 		var nextRun, lastRun, lastResult *string
 		var createdAt string
-		if err := rows.Scan(&t.ID, &t.GroupFolder, &t.ChatJID, &t.Prompt, &t.ScheduleType, &t.ScheduleValue, &nextRun, &lastRun, &lastResult, &t.Status, &createdAt); err != nil {
+		var silent int
+		var retryDelay, timeout, debounce int64
+		if err := rows.Scan(&t.ID, &t.GroupFolder, &t.ChatJID, &t.Prompt, &t.ScheduleType, &t.ScheduleValue, &nextRun, &lastRun, &lastResult, &t.Status, &createdAt, &silent, &t.Timezone,
+			&t.RetryLimit, &retryDelay, &timeout, &t.Misfire, &t.Attempt, &debounce, &t.RateLimit, &t.ResumeFrom); err != nil {
 			return nil, err
 		}
+		t.Silent = silent == 1
+		t.RetryDelay = time.Duration(retryDelay) * time.Second
+		t.Timeout = time.Duration(timeout) * time.Second
+		t.Debounce = time.Duration(debounce) * time.Second
 		if lastResult != nil {
 			t.LastResult = *lastResult
 		}
@@ -239,6 +1066,36 @@ func scanTasks(rows *sql.Rows) ([]Task, error) {
 	return tasks, rows.Err()
 }
 
+func scanTaskRuns(rows *sql.Rows) ([]TaskRun, error) {
+	var runs []TaskRun
+	for rows.Next() {
+		var r TaskRun
+		var started, ended string
+		var errText, output sql.NullString
+		if err := rows.Scan(&r.ID, &r.TaskID, &started, &ended, &r.Status, &errText, &output, &r.PromptTokens, &r.CompletionTokens); err != nil {
+			return nil, err
+		}
+		r.StartedAt, _ = time.Parse(runTimeLayout, started)
+		r.EndedAt, _ = time.Parse(runTimeLayout, ended)
+		r.Error, r.Output = errText.String, output.String
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// formatTime 任务时间统一以UTC存储，保证按字符串比较与时间顺序一致
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+func formatTimePtr(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := formatTime(*t)
+	return &s
+}
+
 func boolToInt(b bool) int {
 	if b {
 		return 1
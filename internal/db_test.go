@@ -1,6 +1,9 @@
 package internal
 
 import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -201,3 +204,317 @@ func TestDB_GetDueTasks(t *testing.T) {
 		t.Error("Expected to find due task")
 	}
 }
+
+func TestDB_ListTasksAndUpdateStatus(t *testing.T) {
+	db := TestTempDB(t)
+
+	now := time.Now()
+	for i, folder := range []string{"main", "main", "other"} {
+		task := &Task{
+			ID:           "list-task-" + string(rune('0'+i)),
+			GroupFolder:  folder,
+			ChatJID:      ChatJID(folder + "@nanoclaw"),
+			Prompt:       "p",
+			ScheduleType: "once",
+			Status:       "active",
+			CreatedAt:    now.Add(time.Duration(i) * time.Second),
+		}
+		if err := db.SaveTask(task); err != nil {
+			t.Fatalf("SaveTask failed: %v", err)
+		}
+	}
+
+	tasks, err := db.ListTasks("main")
+	if err != nil {
+		t.Fatalf("ListTasks failed: %v", err)
+	}
+	if len(tasks) != 2 || tasks[0].ID != "list-task-0" {
+		t.Fatalf("ListTasks = %+v", tasks)
+	}
+
+	if err := db.UpdateTaskStatus("list-task-1", "paused"); err != nil {
+		t.Fatalf("UpdateTaskStatus failed: %v", err)
+	}
+	got, err := db.GetTask("list-task-1")
+	if err != nil {
+		t.Fatalf("GetTask failed: %v", err)
+	}
+	if got.Status != "paused" {
+		t.Errorf("Status = %q, want paused", got.Status)
+	}
+
+	if err := db.UpdateTaskStatus("missing", "paused"); err == nil {
+		t.Error("Expected error for missing task")
+	}
+	if _, err := db.GetTask("missing"); err == nil {
+		t.Error("Expected error for missing task")
+	}
+}
+
+func TestDB_KV(t *testing.T) {
+	db := TestTempDB(t)
+
+	if _, ok, err := db.GetKV("main", "k"); err != nil || ok {
+		t.Fatalf("GetKV on empty = (%v, %v)", ok, err)
+	}
+	if err := db.SetKV("main", "k", "v1"); err != nil {
+		t.Fatalf("SetKV failed: %v", err)
+	}
+	if err := db.SetKV("main", "k", "v2"); err != nil {
+		t.Fatalf("SetKV failed: %v", err)
+	}
+	if v, ok, _ := db.GetKV("main", "k"); !ok || v != "v2" {
+		t.Errorf("GetKV = (%q, %v), want (v2, true)", v, ok)
+	}
+	if _, ok, _ := db.GetKV("other", "k"); ok {
+		t.Error("kv should be isolated per group")
+	}
+	if err := db.DeleteKV("main", "k"); err != nil {
+		t.Fatalf("DeleteKV failed: %v", err)
+	}
+	if _, ok, _ := db.GetKV("main", "k"); ok {
+		t.Error("Expected key deleted")
+	}
+}
+
+func TestOpenDB_MigratesOldTasksTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	raw, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = raw.Exec(`CREATE TABLE tasks (id TEXT PRIMARY KEY, group_folder TEXT NOT NULL, chat_jid TEXT NOT NULL,
+		prompt TEXT NOT NULL, schedule_type TEXT NOT NULL, schedule_value TEXT NOT NULL, next_run TEXT,
+		last_run TEXT, last_result TEXT, status TEXT DEFAULT 'active', created_at TEXT);
+		INSERT INTO tasks (id, group_folder, chat_jid, prompt, schedule_type, schedule_value, status, created_at)
+		VALUES ('old', 'main', 'main@nanoclaw', 'p', 'once', '', 'active', '2024-01-01T00:00:00Z');`)
+	raw.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatalf("OpenDB: %v", err)
+	}
+	defer db.Close()
+	task, err := db.GetTask("old")
+	if err != nil {
+		t.Fatalf("GetTask: %v", err)
+	}
+	if task.Silent {
+		t.Error("migrated task should not be silent")
+	}
+}
+
+func TestDB_SaveTaskValidation(t *testing.T) {
+	created := time.Date(2024, 1, 1, 8, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		typ      string
+		value    string
+		wantErr  bool
+		wantNext time.Time
+	}{
+		{"once immediate", "once", "", false, created},
+		{"once at", "once", "2024-02-01T00:00:00Z", false, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"interval", "interval", "90m", false, created.Add(90 * time.Minute)},
+		{"cron", "cron", "0 9 * * *", false, time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)},
+		{"bad once", "once", "tomorrow", true, time.Time{}},
+		{"bad interval", "interval", "often", true, time.Time{}},
+		{"negative interval", "interval", "-1h", true, time.Time{}},
+		{"bad cron", "cron", "0 25 * * *", true, time.Time{}},
+		{"unknown type", "weekly", "mon", true, time.Time{}},
+	}
+
+	db := TestTempDB(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := &Task{ID: tt.name, GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "p",
+				ScheduleType: tt.typ, ScheduleValue: tt.value, Timezone: "UTC", CreatedAt: created}
+			err := db.SaveTask(task)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SaveTask err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if _, err := db.GetTask(tt.name); err == nil {
+					t.Error("invalid task should not be saved")
+				}
+				return
+			}
+			got, err := db.GetTask(tt.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != TaskActive || got.NextRun == nil || !got.NextRun.Equal(tt.wantNext) {
+				t.Errorf("status = %s, next_run = %v, want active %v", got.Status, got.NextRun, tt.wantNext)
+			}
+		})
+	}
+}
+
+func TestDB_SaveTaskKeepsRunHistory(t *testing.T) {
+	db := TestTempDB(t)
+	task := &Task{ID: "keep", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "p", ScheduleType: "interval", ScheduleValue: "1h"}
+	if err := db.SaveTask(task); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.FinishTaskRun(task, "ok", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	task.Status = TaskPaused
+	if err := db.SaveTask(task); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := db.GetTask("keep")
+	if got.Status != TaskPaused || got.LastResult != "ok" || got.LastRun == nil {
+		t.Errorf("got status=%s last_result=%q last_run=%v", got.Status, got.LastResult, got.LastRun)
+	}
+}
+
+func TestDB_DueTasksAcrossOffsets(t *testing.T) {
+	db := TestTempDB(t)
+	// 早期版本以本地偏移存储：09:30+08:00 即 01:30Z
+	_, err := db.Exec(`INSERT INTO tasks (id, group_folder, chat_jid, prompt, schedule_type, schedule_value, next_run, status, created_at)
+		VALUES ('legacy', 'main', 'main@nanoclaw', 'p', 'interval', '1h', '2024-01-01T09:30:00+08:00', 'active', '2024-01-01T00:00:00Z')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	due, err := db.GetDueTasks(time.Date(2024, 1, 1, 1, 0, 0, 0, time.UTC))
+	if err != nil || len(due) != 0 {
+		t.Fatalf("due at 01:00Z = %d, err %v; want 0", len(due), err)
+	}
+	due, _ = db.GetDueTasks(time.Date(2024, 1, 1, 1, 30, 0, 0, time.UTC))
+	if len(due) != 1 {
+		t.Fatalf("due at 01:30Z = %d, want 1", len(due))
+	}
+
+	next, err := db.NextDueTime()
+	if err != nil || next == nil || !next.Equal(time.Date(2024, 1, 1, 1, 30, 0, 0, time.UTC)) {
+		t.Errorf("NextDueTime = %v, %v", next, err)
+	}
+}
+
+func TestDB_TaskRuns(t *testing.T) {
+	db := TestTempDB(t)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		for _, id := range []string{"a", "b"} {
+			r := &TaskRun{TaskID: id, StartedAt: base.Add(time.Duration(i) * time.Hour),
+				EndedAt: base.Add(time.Duration(i)*time.Hour + 1500*time.Millisecond), Status: TaskRunSuccess,
+				Output: fmt.Sprintf("%s-%d", id, i), PromptTokens: 10, CompletionTokens: i}
+			if err := db.SaveTaskRun(r); err != nil {
+				t.Fatal(err)
+			}
+			if r.ID == 0 {
+				t.Fatal("SaveTaskRun did not set ID")
+			}
+		}
+	}
+
+	runs, err := db.ListTaskRuns("a", 2, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 || runs[0].Output != "a-3" || runs[1].Output != "a-2" {
+		t.Fatalf("page = %+v", runs)
+	}
+	if d := runs[0].Duration(); d != 1500*time.Millisecond {
+		t.Errorf("Duration = %v", d)
+	}
+	got, err := db.GetTaskRun(runs[0].ID)
+	if err != nil || got.CompletionTokens != 3 || got.TaskID != "a" {
+		t.Errorf("GetTaskRun = %+v, %v", got, err)
+	}
+	if _, err := db.GetTaskRun(9999); err != sql.ErrNoRows {
+		t.Errorf("missing run err = %v", err)
+	}
+
+	// 按时间清理 i=0，再按条数每个任务保留3条
+	n, err := db.PruneTaskRuns(base.Add(30*time.Minute), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("pruned %d, want 4", n)
+	}
+	for _, id := range []string{"a", "b"} {
+		if c, _ := db.CountTaskRuns(id); c != 3 {
+			t.Errorf("%s runs = %d, want 3", id, c)
+		}
+	}
+	runs, _ = db.ListTaskRuns("b", 10, 0)
+	if runs[len(runs)-1].Output != "b-2" {
+		t.Errorf("oldest kept = %q, want b-2", runs[len(runs)-1].Output)
+	}
+}
+
+func TestDB_FindDeleteTriggerTasks(t *testing.T) {
+	db := TestTempDB(t)
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, g := range []string{"main", "main", "other"} {
+		task := &Task{ID: fmt.Sprintf("t%d", i), GroupFolder: g, ChatJID: ChatJID(g + "@nanoclaw"), Prompt: "p",
+			ScheduleType: "interval", ScheduleValue: "1h", CreatedAt: created.Add(time.Duration(i) * time.Minute)}
+		if err := db.SaveTask(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.UpdateTaskStatus("t1", TaskPaused); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		filter TaskFilter
+		want   []string
+	}{
+		{"all", TaskFilter{}, []string{"t0", "t1", "t2"}},
+		{"group", TaskFilter{GroupFolder: "main"}, []string{"t0", "t1"}},
+		{"status", TaskFilter{Status: TaskActive}, []string{"t0", "t2"}},
+		{"chat", TaskFilter{ChatJID: "other@nanoclaw"}, []string{"t2"}},
+		{"limit", TaskFilter{Limit: 1}, []string{"t0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks, err := db.FindTasks(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var ids []string
+			for _, task := range tasks {
+				ids = append(ids, task.ID)
+			}
+			if fmt.Sprint(ids) != fmt.Sprint(tt.want) {
+				t.Errorf("ids = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err := db.TriggerTask("t0", now); err != nil {
+		t.Fatal(err)
+	}
+	if task, _ := db.GetTask("t0"); task.NextRun == nil || !task.NextRun.Equal(now) {
+		t.Errorf("triggered NextRun = %v", task.NextRun)
+	}
+	if err := db.TriggerTask("t1", now); err == nil {
+		t.Error("triggering a paused task should fail")
+	}
+
+	if err := db.SaveTaskRun(&TaskRun{TaskID: "t0", StartedAt: now, EndedAt: now, Status: TaskRunSuccess}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.DeleteTask("t0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetTask("t0"); err != sql.ErrNoRows {
+		t.Errorf("deleted task err = %v", err)
+	}
+	if n, _ := db.CountTaskRuns("t0"); n != 0 {
+		t.Errorf("runs after delete = %d", n)
+	}
+	if err := db.DeleteTask("t0"); err != sql.ErrNoRows {
+		t.Errorf("second delete err = %v", err)
+	}
+}
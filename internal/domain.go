@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
 	"time"
@@ -58,23 +59,149 @@ type Task struct {
 	GroupFolder   string
 	ChatJID       ChatJID
 	Prompt        string
-	ScheduleType  string // cron/interval/once
+	ScheduleType  string // cron/interval/once/event
 	ScheduleValue string
+	Timezone      string // IANA时区，cron 和不带偏移的 once 时间按此解释；为空时使用服务器时区
 	NextRun       *time.Time
 	LastRun       *time.Time
 	LastResult    string
-	Status        string // active/paused/completed
+	Status        string // active/paused/completed/failed
 	CreatedAt     time.Time
+	Silent        bool // 为true时执行结果只记录，不发送到会话（失败仍会通知）
+
+	RetryLimit int           // 失败后最多重试次数
+	RetryDelay time.Duration // 首次重试延迟，之后每次翻倍；0 使用默认值
+	Timeout    time.Duration // 单次执行最长时间；0 使用调度器默认值
+	Misfire    string        // 错过执行时间时的处理，见 Misfire* 常量；为空等同 MisfireOnce
+	Attempt    int           // 当前连续失败次数，成功或放弃重试后清零
+	ResumeFrom int64         // 工作流任务：下次执行从该执行记录中失败的步骤继续，0 表示从头执行
+
+	Debounce  time.Duration // event 任务：最后一次匹配后等待的时间，期间的事件合并为一次执行
+	RateLimit int           // event 任务：每小时最多执行次数，0 表示不限
+}
+
+// 错过执行时间（如进程停止期间）的处理策略
+const (
+	MisfireOnce = "once" // 补执行一次，然后从当前时间继续调度
+	MisfireAll  = "all"  // 依次补执行每个错过的时间点
+	MisfireSkip = "skip" // 跳过错过的执行，等待下一个时间点
+)
+
+// 任务状态
+const (
+	TaskActive    = "active"
+	TaskPaused    = "paused"
+	TaskCompleted = "completed" // once 任务执行成功
+	TaskFailed    = "failed"    // once 任务执行失败或调度无法继续
+)
+
+// taskTransitions 允许的状态转换
+var taskTransitions = map[string][]string{
+	TaskActive: {TaskPaused, TaskCompleted, TaskFailed},
+	TaskPaused: {TaskActive},
+	TaskFailed: {TaskActive},
+}
+
+// Transition 将任务切换到新状态，不允许的转换返回错误
+func (t *Task) Transition(to string) error {
+	for _, s := range taskTransitions[t.Status] {
+		if s == to {
+			t.Status = to
+			return nil
+		}
+	}
+	return fmt.Errorf("task %s: cannot change status from %s to %s", t.ID, t.Status, to)
+}
+
+// SetStatus 切换状态；恢复为活动时，没有下次执行时间的任务从 now 起重新计算
+func (t *Task) SetStatus(to string, now time.Time) error {
+	if err := t.Transition(to); err != nil {
+		return err
+	}
+	if to == TaskActive && t.NextRun == nil {
+		return t.Reschedule(now)
+	}
+	return nil
+}
+
+// Reschedule 调度设置变化后，从 now 起重新计算活动或暂停任务的下次执行时间
+func (t *Task) Reschedule(now time.Time) error {
+	next, err := t.NextRunAfter(now)
+	if err != nil {
+		return err
+	}
+	if t.Status == TaskActive || t.Status == TaskPaused {
+		t.NextRun = next
+	}
+	return nil
+}
+
+// ValidatePolicy 校验重试、超时和错过执行策略
+func (t *Task) ValidatePolicy() error {
+	switch t.Misfire {
+	case "", MisfireOnce, MisfireAll, MisfireSkip:
+	default:
+		return fmt.Errorf("invalid misfire policy %q: want once, all or skip", t.Misfire)
+	}
+	if t.RetryLimit < 0 {
+		return fmt.Errorf("invalid retry limit %d", t.RetryLimit)
+	}
+	if t.RetryDelay < 0 || t.Timeout < 0 {
+		return fmt.Errorf("retry delay and timeout must not be negative")
+	}
+	if t.Debounce < 0 || t.RateLimit < 0 {
+		return fmt.Errorf("debounce and rate limit must not be negative")
+	}
+	return nil
 }
 
 // IsDue 检查任务是否到期
 func (t *Task) IsDue(now time.Time) bool {
-	if t.NextRun == nil || t.Status != "active" {
+	if t.NextRun == nil || t.Status != TaskActive {
 		return false
 	}
 	return !now.Before(*t.NextRun)
 }
 
+// 任务执行结果
+const (
+	TaskRunSuccess = "success"
+	TaskRunError   = "error"
+	TaskRunSkipped = "skipped" // 按 MisfireSkip 跳过
+)
+
+// TaskRun 任务的一次执行记录
+type TaskRun struct {
+	ID               int64
+	TaskID           string
+	StartedAt        time.Time
+	EndedAt          time.Time
+	Status           string // success/error/skipped
+	Error            string
+	Output           string
+	PromptTokens     int
+	CompletionTokens int
+	Steps            []TaskRunStep // 工作流任务各步骤的结果，按执行顺序
+}
+
+// Duration 执行耗时
+func (r *TaskRun) Duration() time.Duration {
+	return r.EndedAt.Sub(r.StartedAt)
+}
+
+// Summary 输出（失败时为错误）的首行，超过 n 个字符时截断
+func (r *TaskRun) Summary(n int) string {
+	text := r.Output
+	if r.Status == TaskRunError {
+		text = r.Error
+	}
+	text, _, _ = strings.Cut(strings.TrimSpace(text), "\n")
+	if runes := []rune(text); len(runes) > n {
+		text = string(runes[:n]) + "…"
+	}
+	return text
+}
+
 // StreamEvent 流式响应事件
 type StreamEvent struct {
 	Content string
@@ -145,3 +145,77 @@ func TestTask_IsDue(t *testing.T) {
 		})
 	}
 }
+
+func TestTask_Transition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		ok       bool
+	}{
+		{TaskActive, TaskPaused, true},
+		{TaskActive, TaskCompleted, true},
+		{TaskActive, TaskFailed, true},
+		{TaskPaused, TaskActive, true},
+		{TaskFailed, TaskActive, true},
+		{TaskPaused, TaskCompleted, false},
+		{TaskCompleted, TaskActive, false},
+		{TaskActive, TaskActive, false},
+		{TaskActive, "bogus", false},
+	}
+
+	for _, tt := range tests {
+		task := &Task{ID: "t", Status: tt.from}
+		err := task.Transition(tt.to)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s -> %s: err = %v, want ok=%v", tt.from, tt.to, err, tt.ok)
+		}
+		want := tt.from
+		if tt.ok {
+			want = tt.to
+		}
+		if task.Status != want {
+			t.Errorf("%s -> %s: status = %s, want %s", tt.from, tt.to, task.Status, want)
+		}
+	}
+}
+
+func TestTaskRun_Summary(t *testing.T) {
+	tests := []struct {
+		run  TaskRun
+		n    int
+		want string
+	}{
+		{TaskRun{Status: TaskRunSuccess, Output: "  first line\nsecond"}, 20, "first line"},
+		{TaskRun{Status: TaskRunError, Output: "partial", Error: "llm error: timeout"}, 20, "llm error: timeout"},
+		{TaskRun{Status: TaskRunSuccess, Output: "日报已生成完毕"}, 4, "日报已生…"},
+	}
+	for _, tt := range tests {
+		if got := tt.run.Summary(tt.n); got != tt.want {
+			t.Errorf("Summary(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestTask_SetStatus(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	task := &Task{ID: "t", ScheduleType: "interval", ScheduleValue: "1h", Status: TaskFailed}
+	if err := task.SetStatus(TaskActive, now); err != nil {
+		t.Fatal(err)
+	}
+	if task.NextRun == nil || !task.NextRun.Equal(now.Add(time.Hour)) {
+		t.Errorf("NextRun = %v, want %v", task.NextRun, now.Add(time.Hour))
+	}
+
+	if err := task.SetStatus(TaskPaused, now); err != nil {
+		t.Fatal(err)
+	}
+	task.ScheduleValue = "2h"
+	if err := task.Reschedule(now); err != nil {
+		t.Fatal(err)
+	}
+	if !task.NextRun.Equal(now.Add(2 * time.Hour)) {
+		t.Errorf("rescheduled NextRun = %v", task.NextRun)
+	}
+	if err := task.SetStatus(TaskCompleted, now); err == nil {
+		t.Error("paused -> completed should fail")
+	}
+}
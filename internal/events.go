@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 事件类型
+const (
+	EventMessage = "message" // 会话中收到用户消息
+	EventJoin    = "join"    // 新成员加入会话（由渠道上报）
+)
+
+// Event 可触发 event 任务的会话事件
+type Event struct {
+	Kind    string
+	ChatJID ChatJID
+	Sender  string
+	Content string // 消息内容；join 事件为空
+	Time    time.Time
+}
+
+// String 事件的单行描述，附加到任务提示中
+func (e Event) String() string {
+	ts := e.Time.Format("15:04:05")
+	if e.Kind == EventJoin {
+		return fmt.Sprintf("[%s] %s joined", ts, e.Sender)
+	}
+	return fmt.Sprintf("[%s] %s: %s", ts, e.Sender, e.Content)
+}
+
+// EventTrigger event 任务的触发条件，由 ScheduleValue 解析得到
+//
+//	/pattern/ 或 re:pattern   消息内容匹配正则表达式
+//	keyword:a,b               消息包含任一关键词（不区分大小写）
+//	join                      新成员加入
+type EventTrigger struct {
+	Kind     string
+	Pattern  *regexp.Regexp
+	Keywords []string
+}
+
+// ParseEventTrigger 解析 event 任务的触发条件
+func ParseEventTrigger(value string) (*EventTrigger, error) {
+	switch {
+	case value == "join":
+		return &EventTrigger{Kind: EventJoin}, nil
+	case strings.HasPrefix(value, "keyword:"):
+		var words []string
+		for _, w := range strings.Split(strings.TrimPrefix(value, "keyword:"), ",") {
+			if w = strings.ToLower(strings.TrimSpace(w)); w != "" {
+				words = append(words, w)
+			}
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("invalid event %q: no keywords", value)
+		}
+		return &EventTrigger{Kind: EventMessage, Keywords: words}, nil
+	}
+
+	expr, ok := strings.CutPrefix(value, "re:")
+	if !ok {
+		if len(value) < 3 || value[0] != '/' || value[len(value)-1] != '/' {
+			return nil, fmt.Errorf("invalid event %q: want /regex/, re:regex, keyword:a,b or join", value)
+		}
+		expr = value[1 : len(value)-1]
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid event %q: %w", value, err)
+	}
+	return &EventTrigger{Kind: EventMessage, Pattern: re}, nil
+}
+
+// Match 检查事件是否满足触发条件
+func (t *EventTrigger) Match(ev Event) bool {
+	if ev.Kind != t.Kind {
+		return false
+	}
+	if t.Pattern != nil {
+		return t.Pattern.MatchString(ev.Content)
+	}
+	content := strings.ToLower(ev.Content)
+	for _, w := range t.Keywords {
+		if strings.Contains(content, w) {
+			return true
+		}
+	}
+	return len(t.Keywords) == 0
+}
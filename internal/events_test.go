@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestEventTrigger(t *testing.T) {
+	msg := func(content string) Event { return Event{Kind: EventMessage, Content: content} }
+	join := Event{Kind: EventJoin, Sender: "bob"}
+
+	tests := []struct {
+		value string
+		ev    Event
+		want  bool
+	}{
+		{"/^deploy \\w+$/", msg("deploy api"), true},
+		{"/^deploy \\w+$/", msg("please deploy api"), false},
+		{"re:(?i)error", msg("Build ERROR in ci"), true},
+		{"keyword:help, 帮助", msg("Can someone HELP me?"), true},
+		{"keyword:help, 帮助", msg("需要帮助"), true},
+		{"keyword:help", msg("hello"), false},
+		{"keyword:help", join, false},
+		{"join", join, true},
+		{"join", msg("join"), false},
+	}
+	for _, tt := range tests {
+		trigger, err := ParseEventTrigger(tt.value)
+		if err != nil {
+			t.Fatalf("ParseEventTrigger(%q): %v", tt.value, err)
+		}
+		if got := trigger.Match(tt.ev); got != tt.want {
+			t.Errorf("%q.Match(%+v) = %v, want %v", tt.value, tt.ev, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"", "help", "keyword:", "keyword: , ", "/(/", "re:[", "//"} {
+		if _, err := ParseEventTrigger(value); err == nil || !strings.Contains(err.Error(), "invalid event") {
+			t.Errorf("ParseEventTrigger(%q) err = %v", value, err)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIToken HTTP接口的访问令牌
+//
+// 令牌明文只在创建时显示一次，数据库中只保存其 SHA-256 哈希。
+// Groups 为可访问的群组目录名，支持 "*" 和前缀（同 IPCRule.Groups）。
+type APIToken struct {
+	Name       string
+	Groups     []string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// apiTokenPrefix 令牌明文的前缀，便于在配置和日志中识别
+const apiTokenPrefix = "ncl_"
+
+// NewAPIToken 生成随机令牌，返回明文和保存到数据库的哈希
+func NewAPIToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return token, HashAPIToken(token), nil
+}
+
+// HashAPIToken 计算令牌的哈希
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// AllowGroup 令牌能否访问群组
+func (t *APIToken) AllowGroup(folder string) bool {
+	return t != nil && matchAny(t.Groups, folder)
+}
+
+type apiTokenKey struct{}
+
+// TokenFromContext 返回当前HTTP请求使用的令牌，不在HTTP请求中时返回 nil
+func TokenFromContext(ctx context.Context) *APIToken {
+	t, _ := ctx.Value(apiTokenKey{}).(*APIToken)
+	return t
+}
+
+// HTTP接口的限制
+const (
+	httpMaxBody        = 1 << 20          // 请求体最大字节数
+	httpWriteTimeout   = 10 * time.Second // 写入一个SSE事件的时间，超时（客户端不读取）时结束事件流
+	httpHeartbeat      = 30 * time.Second // SSE事件流没有事件时发送注释行的间隔，避免代理关闭空闲连接
+	tokenTouchInterval = time.Minute      // 令牌最后使用时间的更新间隔
+)
+
+// errHTTPNotFound 路径中的群组不存在
+var errHTTPNotFound = errors.New("not found")
+
+// HTTPAPI 通过HTTP提供的REST接口和SSE事件流，与IPC共用 ControlAPI 的方法和群组授权
+//
+//	GET  /groups                    已注册的群组
+//	GET  /groups/{group}/messages   会话的消息（按时间顺序），limit、before 分页
+//	POST /groups/{group}/messages   {content} 以用户消息的形式发送，发送者由服务端设为令牌名称
+//	GET  /tasks                     任务列表，参数同 tasks.list
+//	GET  /tasks/{id}/runs           任务的执行记录（最新在前），limit
+//	GET  /skills                    群组可用的技能，group_folder 默认 main
+//	GET  /status                    服务状态（StatusInfo）
+//	GET  /events                    SSE事件流，topics 和 group（可重复）过滤，事件名即通知主题
+//
+// {group} 为会话JID或群组目录名。请求需携带 "Authorization: Bearer <token>"，
+// /events 也接受 access_token 查询参数（浏览器的 EventSource 不能设置请求头）。
+type HTTPAPI struct {
+	api       *ControlAPI
+	db        *DB
+	mux       *http.ServeMux
+	heartbeat time.Duration
+}
+
+// NewHTTPAPI 创建HTTP接口，订阅通知使用 api 的通知中心
+func NewHTTPAPI(api *ControlAPI) *HTTPAPI {
+	h := &HTTPAPI{api: api, db: api.db, mux: http.NewServeMux(), heartbeat: httpHeartbeat}
+	h.mux.HandleFunc("GET /groups", h.listGroups)
+	h.mux.HandleFunc("GET /groups/{group}/messages", h.listMessages)
+	h.mux.HandleFunc("POST /groups/{group}/messages", h.sendMessage)
+	h.mux.HandleFunc("GET /tasks", h.listTasks)
+	h.mux.HandleFunc("GET /tasks/{id}/runs", h.listTaskRuns)
+	h.mux.HandleFunc("GET /skills", h.listSkills)
+	h.mux.HandleFunc("GET /status", h.status)
+	h.mux.HandleFunc("GET /events", h.events)
+	return h
+}
+
+// ServeHTTP 验证令牌后分发请求
+func (h *HTTPAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token, err := h.authenticate(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="nanoclaw"`)
+		writeHTTPError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	h.mux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiTokenKey{}, token)))
+}
+
+// authenticate 查找请求携带的令牌，并按 tokenTouchInterval 记录使用时间
+func (h *HTTPAPI) authenticate(r *http.Request) (*APIToken, error) {
+	// 认证方案不区分大小写
+	scheme, secret, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		secret = ""
+	}
+	if secret == "" && r.URL.Path == "/events" {
+		secret = r.URL.Query().Get("access_token")
+	}
+	if secret == "" {
+		return nil, errors.New("missing bearer token")
+	}
+	t, err := h.db.GetAPITokenByHash(HashAPIToken(strings.TrimSpace(secret)))
+	if errors.Is(err, sql.ErrNoRows) {
+		slog.Warn("http invalid token", "remote", r.RemoteAddr)
+		return nil, errors.New("invalid token")
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if t.LastUsedAt == nil || now.Sub(*t.LastUsedAt) >= tokenTouchInterval {
+		if err := h.db.TouchAPIToken(t.Name, now); err != nil {
+			slog.Warn("http touch token", "token", t.Name, "err", err)
+		}
+	}
+	return t, nil
+}
+
+// call 以 params 调用 ControlAPI 方法并写入结果
+func (h *HTTPAPI) call(w http.ResponseWriter, r *http.Request, method RPCHandler, params any, status int) {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		writeHTTPError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	result, err := method(r.Context(), raw)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	writeJSON(w, status, result)
+}
+
+// group 解析群组（会话JID或目录名）并检查令牌的访问权限
+func (h *HTTPAPI) group(ctx context.Context, ref string) (*Group, error) {
+	var g *Group
+	if strings.Contains(ref, "@") {
+		found, err := h.db.GetGroup(ChatJID(ref))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errHTTPNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+		g = found
+	} else {
+		groups, err := h.db.ListGroups()
+		if err != nil {
+			return nil, err
+		}
+		for i := range groups {
+			if groups[i].Folder == ref {
+				g = &groups[i]
+				break
+			}
+		}
+		if g == nil {
+			return nil, errHTTPNotFound
+		}
+	}
+	if err := authorizeGroup(ctx, g.Folder); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// MessagePage GET /groups/{group}/messages 的结果，Before 非空时用作下一页（更早的消息）的 before 参数
+type MessagePage struct {
+	Messages []MessageInfo `json:"messages"`
+	Before   MessageID     `json:"before,omitempty"`
+}
+
+func (h *HTTPAPI) listGroups(w http.ResponseWriter, r *http.Request) {
+	h.call(w, r, h.api.listGroups, nil, http.StatusOK)
+}
+
+func (h *HTTPAPI) listMessages(w http.ResponseWriter, r *http.Request) {
+	g, err := h.group(r.Context(), r.PathValue("group"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	q := r.URL.Query()
+	limit, err := queryInt(q.Get("limit"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	if limit == 0 {
+		limit = defaultMessageLimit
+	}
+	params, _ := json.Marshal(map[string]any{"chat_jid": g.JID, "limit": limit, "before": q.Get("before")})
+	result, err := h.api.listMessages(r.Context(), params)
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	page := MessagePage{Messages: result.([]MessageInfo)}
+	if len(page.Messages) == limit {
+		page.Before = page.Messages[0].ID
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (h *HTTPAPI) sendMessage(w http.ResponseWriter, r *http.Request) {
+	g, err := h.group(r.Context(), r.PathValue("group"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	var body struct {
+		Content string `json:"content"`
+	}
+	data, err := readBody(w, r)
+	if err == nil {
+		err = BindParams(data, &body)
+	}
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	// 发送者固定为令牌名称
+	params := map[string]any{"chat_jid": g.JID, "content": body.Content}
+	h.call(w, r, h.api.sendMessage, params, http.StatusAccepted)
+}
+
+func (h *HTTPAPI) listTasks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	limit, err := queryInt(q.Get("limit"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	params := map[string]any{"group_folder": q.Get("group_folder"), "chat_jid": q.Get("chat_jid"),
+		"status": q.Get("status"), "schedule_type": q.Get("schedule_type"), "limit": limit}
+	h.call(w, r, h.api.listTasks, params, http.StatusOK)
+}
+
+func (h *HTTPAPI) listTaskRuns(w http.ResponseWriter, r *http.Request) {
+	limit, err := queryInt(r.URL.Query().Get("limit"))
+	if err != nil {
+		writeAPIError(w, err)
+		return
+	}
+	h.call(w, r, h.api.listTaskRuns, map[string]any{"task_id": r.PathValue("id"), "limit": limit}, http.StatusOK)
+}
+
+func (h *HTTPAPI) listSkills(w http.ResponseWriter, r *http.Request) {
+	h.call(w, r, h.api.listSkills, map[string]string{"group_folder": r.URL.Query().Get("group_folder")}, http.StatusOK)
+}
+
+func (h *HTTPAPI) status(w http.ResponseWriter, r *http.Request) {
+	h.call(w, r, h.api.status, nil, http.StatusOK)
+}
+
+// events 以 Server-Sent Events 推送通知，直到客户端断开或服务器关闭
+//
+// 事件的 event 为通知主题，data 为JSON（同IPC通知的参数）；客户端读取过慢时收到 notify.lagged。
+func (h *HTTPAPI) events(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	q := r.URL.Query()
+	var topics []string
+	for _, list := range q["topics"] {
+		for _, t := range splitList(list) {
+			if !notifyTopics[t] {
+				writeAPIError(w, InvalidParams("unknown topic %s", t))
+				return
+			}
+			topics = append(topics, t)
+		}
+	}
+	var chats []ChatJID
+	for _, ref := range q["group"] {
+		g, err := h.group(ctx, ref)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		chats = append(chats, g.JID)
+	}
+	if len(chats) == 0 && restricted(ctx) {
+		// 受限的令牌只能订阅允许访问的群组
+		allowed, err := h.api.allowedChats(ctx, nil)
+		if err != nil {
+			writeAPIError(w, err)
+			return
+		}
+		chats = allowed
+	}
+	if h.api.hub == nil {
+		writeHTTPError(w, http.StatusServiceUnavailable, "notifications are not available")
+		return
+	}
+
+	sub := h.api.hub.Subscribe(topics, chats, 0)
+	defer sub.Close()
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	// send 写入一段数据并立即发送，失败（包括超时）时结束事件流
+	send := func(data []byte) bool {
+		rc.SetWriteDeadline(time.Now().Add(httpWriteTimeout))
+		if _, err := w.Write(data); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+	if !send([]byte(": connected\n\n")) {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-heartbeat.C:
+			if !send([]byte(": ping\n\n")) {
+				return
+			}
+		case n, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(n.Data)
+			if err != nil {
+				slog.Warn("http event", "topic", n.Topic, "err", err)
+				continue
+			}
+			if !send(fmt.Appendf(nil, "event: %s\ndata: %s\n\n", n.Topic, data)) {
+				return
+			}
+		}
+	}
+}
+
+// readBody 读取请求体，超过 httpMaxBody 时返回错误
+func readBody(w http.ResponseWriter, r *http.Request) (json.RawMessage, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(http.MaxBytesReader(w, r.Body, httpMaxBody)); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, InvalidParams("request body exceeds %d bytes", tooLarge.Limit)
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// queryInt 解析整数查询参数，为空时返回 0
+func queryInt(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, InvalidParams("invalid number %q", s)
+	}
+	return n, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeHTTPError 写入 {"error": message}
+func writeHTTPError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// writeAPIError 把 ControlAPI 的错误转换为HTTP状态码
+func writeAPIError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var rpcErr *RPCError
+	switch {
+	case errors.Is(err, errHTTPNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, ErrQueueClosed):
+		status = http.StatusServiceUnavailable
+	case errors.As(err, &rpcErr):
+		switch rpcErr.Code {
+		case RPCInvalidParams, RPCInvalidRequest:
+			status = http.StatusBadRequest
+		case RPCUnauthorized:
+			status = http.StatusForbidden
+		}
+		writeHTTPError(w, status, rpcErr.Message)
+		return
+	}
+	if status == http.StatusInternalServerError {
+		slog.Error("http", "err", err)
+	}
+	writeHTTPError(w, status, err.Error())
+}
+
+// HTTPServer HTTP接口的服务器
+type HTTPServer struct {
+	srv          *http.Server
+	ln           net.Listener
+	closeStreams context.CancelFunc
+}
+
+// ListenHTTP 在 addr 监听；certFile 和 keyFile 都不为空时使用HTTPS
+func ListenHTTP(addr string, handler http.Handler, certFile, keyFile string) (*HTTPServer, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("both a TLS certificate and a key are required")
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			ln.Close()
+			return nil, err
+		}
+		ln = tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	}
+	// 事件流是长连接，Shutdown 时通过 BaseContext 结束，否则会一直等待
+	streams, closeStreams := context.WithCancel(context.Background())
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+		BaseContext:       func(net.Listener) context.Context { return streams },
+	}
+	srv.RegisterOnShutdown(closeStreams)
+	return &HTTPServer{srv: srv, ln: ln, closeStreams: closeStreams}, nil
+}
+
+// Addr 返回监听地址
+func (s *HTTPServer) Addr() net.Addr {
+	return s.ln.Addr()
+}
+
+// Start 处理请求，直到 Shutdown 时返回 nil
+func (s *HTTPServer) Start() error {
+	if err := s.srv.Serve(s.ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown 停止接受连接，结束事件流并等待进行中的请求完成；ctx 到期时强制关闭连接
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	err := s.srv.Shutdown(ctx)
+	if err != nil {
+		s.srv.Close()
+	}
+	s.closeStreams()
+	return err
+}
@@ -0,0 +1,228 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestHTTPAPI 启动带两个群组（main、team）的HTTP接口，返回服务器地址、令牌（全部群组、仅 team）和通知中心
+func newTestHTTPAPI(t *testing.T) (*httptest.Server, *DB, *Hub, map[string]string) {
+	t.Helper()
+	db := TestTempDB(t)
+	queue := NewGroupQueue(1)
+	orch := NewOrchestrator(db, queue, nil, TestConfig(t))
+	hub := NewHub()
+	orch.SetNotify(hub.Publish)
+	for _, g := range []*Group{
+		{JID: "main@nanoclaw", Name: "Main", Folder: "main", AddedAt: time.Now()},
+		{JID: "team@nanoclaw", Name: "Team", Folder: "team", AddedAt: time.Now()},
+	} {
+		if err := db.SaveGroup(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tokens := map[string]string{}
+	for name, groups := range map[string][]string{"admin": {"*"}, "team": {"team"}} {
+		secret, hash, err := NewAPIToken()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.CreateAPIToken(&APIToken{Name: name, Groups: groups, CreatedAt: time.Now()}, hash); err != nil {
+			t.Fatal(err)
+		}
+		tokens[name] = secret
+	}
+
+	api := NewControlAPI(db, orch, queue)
+	api.SetHub(hub)
+	srv := httptest.NewServer(NewHTTPAPI(api))
+	t.Cleanup(srv.Close)
+	return srv, db, hub, tokens
+}
+
+// doHTTP 发送请求并把JSON响应解码到 out（可为 nil），返回状态码
+func doHTTP(t *testing.T, method, url, token, body string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decode: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestHTTPAPI_Auth(t *testing.T) {
+	srv, db, _, tokens := newTestHTTPAPI(t)
+
+	for _, token := range []string{"", "ncl_wrong"} {
+		var body map[string]string
+		if code := doHTTP(t, "GET", srv.URL+"/groups", token, "", &body); code != http.StatusUnauthorized || body["error"] == "" {
+			t.Errorf("token %q: status %d, body %v", token, code, body)
+		}
+	}
+	if code := doHTTP(t, "GET", srv.URL+"/groups", tokens["admin"], "", nil); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	list, err := db.ListAPITokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Name != "admin" || list[0].LastUsedAt == nil || list[1].LastUsedAt != nil {
+		t.Errorf("tokens = %+v", list)
+	}
+
+	// 撤销后不能再使用
+	if err := db.DeleteAPIToken("admin"); err != nil {
+		t.Fatal(err)
+	}
+	if code := doHTTP(t, "GET", srv.URL+"/groups", tokens["admin"], "", nil); code != http.StatusUnauthorized {
+		t.Errorf("revoked token: status %d", code)
+	}
+	if err := db.DeleteAPIToken("admin"); err == nil {
+		t.Error("deleting a missing token should fail")
+	}
+}
+
+func TestHTTPAPI_Resources(t *testing.T) {
+	srv, db, _, tokens := newTestHTTPAPI(t)
+	admin, team := tokens["admin"], tokens["team"]
+	base := time.Now().Add(-time.Hour)
+	for i := range 5 {
+		db.SaveMessage(&Message{ID: MessageID(fmt.Sprintf("m%d", i)), ChatJID: "main@nanoclaw", Sender: "alice",
+			Content: fmt.Sprintf("msg %d", i), Timestamp: base.Add(time.Duration(i/2) * time.Second)})
+	}
+	db.SaveTask(&Task{ID: "t1", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "report", ScheduleType: "interval", ScheduleValue: "1h"})
+	db.SaveTask(&Task{ID: "t2", GroupFolder: "team", ChatJID: "team@nanoclaw", Prompt: "standup", ScheduleType: "interval", ScheduleValue: "1h"})
+
+	var groups []GroupInfo
+	if code := doHTTP(t, "GET", srv.URL+"/groups", team, "", &groups); code != http.StatusOK || len(groups) != 1 || groups[0].Folder != "team" {
+		t.Errorf("groups for team token = %d %+v", code, groups)
+	}
+
+	// 分页：时间相同的消息按ID排序，不重复不遗漏
+	var got []string
+	before := ""
+	for range 3 {
+		var page MessagePage
+		url := srv.URL + "/groups/main/messages?limit=2&before=" + before
+		if code := doHTTP(t, "GET", url, admin, "", &page); code != http.StatusOK {
+			t.Fatalf("messages: status %d", code)
+		}
+		var ids []string
+		for _, m := range page.Messages {
+			ids = append(ids, string(m.ID))
+		}
+		got = append(ids, got...)
+		before = string(page.Before)
+		if before == "" {
+			break
+		}
+	}
+	if strings.Join(got, ",") != "m0,m1,m2,m3,m4" {
+		t.Errorf("paged messages = %v", got)
+	}
+
+	var ok map[string]bool
+	if code := doHTTP(t, "POST", srv.URL+"/groups/team@nanoclaw/messages", team, `{"content":"hello"}`, &ok); code != http.StatusAccepted || !ok["ok"] {
+		t.Errorf("send = %d %v", code, ok)
+	}
+	if msgs, _ := db.GetMessages("team@nanoclaw", 1); len(msgs) != 1 || msgs[0].Content != "hello" || msgs[0].Sender != "team" {
+		t.Errorf("sent message = %+v", msgs)
+	}
+
+	var tasks []TaskInfo
+	if code := doHTTP(t, "GET", srv.URL+"/tasks", team, "", &tasks); code != http.StatusOK || len(tasks) != 1 || tasks[0].ID != "t2" {
+		t.Errorf("tasks for team token = %d %+v", code, tasks)
+	}
+	var runs []TaskRunInfo
+	if code := doHTTP(t, "GET", srv.URL+"/tasks/t2/runs", team, "", &runs); code != http.StatusOK || runs == nil {
+		t.Errorf("runs = %d %+v", code, runs)
+	}
+
+	for _, tt := range []struct {
+		method, path, token, body string
+		want                      int
+	}{
+		{"GET", "/groups/main/messages", team, "", http.StatusForbidden},
+		{"POST", "/groups/main/messages", team, `{"content":"x"}`, http.StatusForbidden},
+		{"GET", "/tasks/t1/runs", team, "", http.StatusForbidden},
+		{"GET", "/groups/nobody/messages", admin, "", http.StatusNotFound},
+		{"GET", "/groups/nobody@nanoclaw/messages", admin, "", http.StatusNotFound},
+		{"GET", "/groups/main/messages?limit=x", admin, "", http.StatusBadRequest},
+		{"GET", "/groups/main/messages?limit=10000", admin, "", http.StatusBadRequest},
+		{"POST", "/groups/main/messages", admin, `{"content":""}`, http.StatusBadRequest},
+		{"POST", "/groups/main/messages", admin, `{"text":"x"}`, http.StatusBadRequest},
+		{"POST", "/groups/main/messages", admin, `{"content":"x","sender":"System"}`, http.StatusBadRequest},
+		{"GET", "/skills", admin, "", http.StatusInternalServerError}, // 未设置技能注册表
+		{"GET", "/nothing", admin, "", http.StatusNotFound},
+		{"DELETE", "/groups", admin, "", http.StatusMethodNotAllowed},
+	} {
+		if code := doHTTP(t, tt.method, srv.URL+tt.path, tt.token, tt.body, nil); code != tt.want {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, code, tt.want)
+		}
+	}
+}
+
+func TestHTTPAPI_Events(t *testing.T) {
+	srv, _, hub, tokens := newTestHTTPAPI(t)
+
+	// 只能访问 team 的令牌收不到 main 的通知；EventSource 通过查询参数传递令牌
+	resp, err := http.Get(srv.URL + "/events?topics=message,agent.delta&access_token=" + tokens["team"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("status %d, content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	r := bufio.NewReader(resp.Body)
+	if line, _ := r.ReadString('\n'); line != ": connected\n" {
+		t.Fatalf("first line = %q", line)
+	}
+	for hub.Subscribers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	hub.Publish(Notification{Topic: NotifyDelta, ChatJID: "main@nanoclaw", Data: DeltaInfo{ChatJID: "main@nanoclaw", Delta: "secret"}})
+	hub.Publish(Notification{Topic: NotifyThinking, ChatJID: "team@nanoclaw", Data: ThinkingInfo{ChatJID: "team@nanoclaw"}})
+	hub.Publish(Notification{Topic: NotifyDelta, ChatJID: "team@nanoclaw", Data: DeltaInfo{ChatJID: "team@nanoclaw", Delta: "hi"}})
+
+	var event []string
+	for len(event) < 2 {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line == "\n" {
+			continue
+		}
+		event = append(event, strings.TrimSuffix(line, "\n"))
+	}
+	want := []string{"event: agent.delta", `data: {"chat_jid":"team@nanoclaw","delta":"hi"}`}
+	if event[0] != want[0] || event[1] != want[1] {
+		t.Errorf("event = %q, want %q", event, want)
+	}
+
+	for _, path := range []string{"/events?topics=bogus", "/events?group=main"} {
+		if code := doHTTP(t, "GET", srv.URL+path, tokens["team"], "", nil); code == http.StatusOK {
+			t.Errorf("%s: status %d", path, code)
+		}
+	}
+}
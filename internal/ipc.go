@@ -2,41 +2,145 @@ package internal
 
 import (
 	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 )
 
-// IPCServer Unix Socket服务器
+// IPCServer Unix Socket服务器，使用 JSON-RPC 2.0 协议
+//
+// 每个连接可以依次发送多个请求（每行一个JSON对象或批量数组），按顺序得到响应；
+// 没有 id 的通知不返回响应。
 type IPCServer struct {
 	socketPath string
 	listener   net.Listener
-	handler    func(AgentRequest) AgentResponse
+	owner      uint32 // 服务进程的用户，不受访问策略限制
+	mu         sync.RWMutex
+	methods    map[string]RPCHandler
+	policy     *IPCPolicy
+	concurrent int // 每个连接同时处理的请求数，<= 1 时按顺序处理
+	limits     IPCLimits
+
+	connMu   sync.Mutex
+	conns    map[*RPCConn]struct{}
+	connWG   sync.WaitGroup
+	draining bool
+}
+
+// IPCLimits 连接的限制，0 表示不限制
+type IPCLimits struct {
+	MaxMessageSize int           // 单条请求（一行）的最大字节数，超过时回复错误并关闭连接
+	MaxConns       int           // 同时打开的连接数，超过时拒绝新连接
+	IdleTimeout    time.Duration // 没有进行中的请求和订阅时等待下一条请求的时间，超时后关闭连接
+	WriteTimeout   time.Duration // 写入一条响应或通知的时间，超时后关闭连接
 }
 
-// AgentRequest Agent请求
+// DefaultIPCLimits ListenIPC 创建的服务器使用的限制；NewConnServer 不设限制
+var DefaultIPCLimits = IPCLimits{
+	MaxMessageSize: 4 << 20,
+	MaxConns:       128,
+	IdleTimeout:    5 * time.Minute,
+	WriteTimeout:   10 * time.Second,
+}
+
+const (
+	ipcMinAcceptDelay = 5 * time.Millisecond // Accept 暂时失败（如文件描述符耗尽）后的等待时间，之后每次加倍
+	ipcMaxAcceptDelay = time.Second
+)
+
+var (
+	errMessageTooLarge = errors.New("message too large")
+	errIdle            = errors.New("idle timeout")
+	errDraining        = errors.New("server shutting down")
+)
+
+// AgentRequest Agent请求（agent.run 方法的参数）
 type AgentRequest struct {
 	GroupFolder string    `json:"group_folder"`
 	Messages    []Message `json:"messages"`
+	Stream      string    `json:"stream,omitempty"`  // 不为空时以该ID推送回复片段
+	Request     string    `json:"request,omitempty"` // 父进程分配的请求ID，子进程调用工具时带上
 }
 
-// AgentResponse Agent响应
+// AgentResponse Agent响应（agent.run 方法的结果）
 type AgentResponse struct {
 	Content string `json:"content"`
 	Error   string `json:"error,omitempty"`
+	Usage   *Usage `json:"usage,omitempty"`
+}
+
+// RPCHandler JSON-RPC方法的处理函数，params 为原始参数（未提供时为空）
+//
+// 返回 *RPCError 时原样作为错误响应，其他错误使用 RPCServerError。
+type RPCHandler func(ctx context.Context, params json.RawMessage) (any, error)
+
+// JSON-RPC 2.0 错误码
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+	RPCServerError    = -32000 // 方法执行失败
+	RPCUnauthorized   = -32001 // 访问策略拒绝
+)
+
+// RPCError JSON-RPC错误对象
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    any    `json:"data,omitempty"`
 }
 
-// NewIPCServer 创建IPC服务器
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// InvalidParams 返回参数错误
+func InvalidParams(format string, args ...any) *RPCError {
+	return &RPCError{Code: RPCInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// RPCRequest JSON-RPC请求；ID 为空表示通知
+type RPCRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// RPCResponse JSON-RPC响应
+type RPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// NewIPCServer 在 socketDir 下创建 nanoclaw.sock 并监听
 func NewIPCServer(socketDir string) (*IPCServer, error) {
+	return ListenIPC(filepath.Join(socketDir, "nanoclaw.sock"))
+}
+
+// ListenIPC 在 socketPath 创建Unix Socket并监听，所在目录不存在时自动创建
+func ListenIPC(socketPath string) (*IPCServer, error) {
 	// 创建目录
-	if err := os.MkdirAll(socketDir, 0755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0755); err != nil {
 		return nil, fmt.Errorf("mkdir: %w", err)
 	}
 
-	socketPath := filepath.Join(socketDir, "nanoclaw.sock")
-
 	// 删除已存在的socket
 	os.Remove(socketPath)
 
@@ -55,92 +159,738 @@ func NewIPCServer(socketDir string) (*IPCServer, error) {
 	return &IPCServer{
 		socketPath: socketPath,
 		listener:   listener,
+		owner:      uint32(os.Getuid()),
+		methods:    make(map[string]RPCHandler),
+		limits:     DefaultIPCLimits,
 	}, nil
 }
 
-// SetHandler 设置请求处理器
+// NewConnServer 创建不监听Socket的服务器，由调用方通过 ServeConn 提供连接
+func NewConnServer() *IPCServer {
+	return &IPCServer{owner: uint32(os.Getuid()), methods: make(map[string]RPCHandler)}
+}
+
+// Path 返回socket路径
+func (s *IPCServer) Path() string {
+	return s.socketPath
+}
+
+// Handle 注册方法，同名方法覆盖之前的注册
+func (s *IPCServer) Handle(method string, h RPCHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.methods[method] = h
+}
+
+// SetConcurrency 允许每个连接同时处理最多 n 个请求（需在处理连接前设置）
+//
+// 并发处理时响应不再按请求顺序返回，客户端按 id 匹配；批量请求仍按顺序处理。
+func (s *IPCServer) SetConcurrency(n int) {
+	s.concurrent = n
+}
+
+// SetLimits 设置连接的限制（需在处理连接前设置）
+func (s *IPCServer) SetLimits(l IPCLimits) {
+	s.limits = l
+}
+
+// SetPolicy 设置访问策略，对之后的请求生效；nil 表示不限制
+func (s *IPCServer) SetPolicy(p *IPCPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.policy = p
+}
+
+func (s *IPCServer) accessPolicy() (*IPCPolicy, uint32) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.policy, s.owner
+}
+
+// SetHandler 设置 agent.run 方法的处理器
 func (s *IPCServer) SetHandler(h func(AgentRequest) AgentResponse) {
-	s.handler = h
+	s.Handle("agent.run", func(_ context.Context, params json.RawMessage) (any, error) {
+		var req AgentRequest
+		if err := BindParams(params, &req); err != nil {
+			return nil, err
+		}
+		return h(req), nil
+	})
 }
 
-// Start 启动服务器
-func (s *IPCServer) Start() error {
+// Start 接受连接，直到 ctx 取消或服务器停止时返回 nil；Accept 出现不可恢复的错误时返回该错误
+//
+// ctx 取消只停止接受新连接，已有连接由 Shutdown 或 Stop 关闭。
+func (s *IPCServer) Start(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() { s.listener.Close() })
+	defer stop()
+
+	var delay time.Duration
 	for {
 		conn, err := s.listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) || ctx.Err() != nil {
+				return nil
+			}
+			if !temporaryAcceptError(err) {
+				return fmt.Errorf("accept: %w", err)
+			}
+			delay = min(max(delay*2, ipcMinAcceptDelay), ipcMaxAcceptDelay)
+			slog.Warn("ipc accept", "err", err, "retry_in", delay)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return nil
+			}
 			continue
 		}
-		go s.handleConn(conn)
+		delay = 0
+		go s.ServeConn(conn)
 	}
 }
 
-// Stop 停止服务器
+// temporaryAcceptError Accept 的错误是否可以稍后重试
+func temporaryAcceptError(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	for _, errno := range []syscall.Errno{syscall.EMFILE, syscall.ENFILE, syscall.ENOBUFS, syscall.ENOMEM, syscall.ECONNABORTED, syscall.EINTR} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stop 立即停止服务器：关闭监听和全部连接，进行中的请求的 ctx 被取消
 func (s *IPCServer) Stop() error {
-	return s.listener.Close()
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	s.connMu.Lock()
+	s.draining = true
+	conns := make([]*RPCConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.connMu.Unlock()
+	for _, c := range conns {
+		c.close()
+	}
+	return err
 }
 
-func (s *IPCServer) handleConn(conn net.Conn) {
-	defer conn.Close()
+// Shutdown 平滑停止服务器：不再接受连接和新请求，等待进行中的请求完成并发出响应后关闭连接
+//
+// ctx 到期时仍未完成的连接被强制关闭（同 Stop），返回 ctx 的错误。
+func (s *IPCServer) Shutdown(ctx context.Context) error {
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.connMu.Lock()
+	s.draining = true
+	conns := make([]*RPCConn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.connMu.Unlock()
+	// 唤醒阻塞在读取上的连接，它们发现服务器正在停止后不再读取请求
+	for _, c := range conns {
+		c.stopReading()
+	}
 
-	reader := bufio.NewReader(conn)
-	decoder := json.NewDecoder(reader)
-	encoder := json.NewEncoder(conn)
+	done := make(chan struct{})
+	go func() {
+		s.connWG.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.Stop()
+		return ctx.Err()
+	}
+}
+
+// addConn 登记连接，服务器正在停止或连接数已满时返回错误
+func (s *IPCServer) addConn(c *RPCConn) error {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if s.draining {
+		return errDraining
+	}
+	if s.limits.MaxConns > 0 && len(s.conns) >= s.limits.MaxConns {
+		return fmt.Errorf("too many connections (limit %d)", s.limits.MaxConns)
+	}
+	if s.conns == nil {
+		s.conns = make(map[*RPCConn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	s.connWG.Add(1)
+	return nil
+}
+
+func (s *IPCServer) removeConn(c *RPCConn) {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	if _, ok := s.conns[c]; ok {
+		delete(s.conns, c)
+		s.connWG.Done()
+	}
+}
+
+func (s *IPCServer) isDraining() bool {
+	s.connMu.Lock()
+	defer s.connMu.Unlock()
+	return s.draining
+}
+
+// RPCConn 服务端的一个连接，处理函数通过 ConnFromContext 获取，用于推送通知
+type RPCConn struct {
+	conn    net.Conn
+	server  *IPCServer
+	peer    *PeerCred // 无法获取时为 nil，设置了策略时拒绝全部请求
+	wmu     sync.Mutex
+	encoder *json.Encoder
+	ctx     context.Context // 处理函数的 ctx，连接关闭时取消
+	cancel  context.CancelFunc
+	active  atomic.Int32 // 进行中的请求和订阅数，不为 0 时不因空闲关闭
+
+	mu      sync.Mutex
+	closed  bool
+	onClose []func()
+}
+
+type rpcConnKey struct{}
+
+// ConnFromContext 返回处理当前请求的连接，不在IPC请求中时返回 nil
+func ConnFromContext(ctx context.Context) *RPCConn {
+	c, _ := ctx.Value(rpcConnKey{}).(*RPCConn)
+	return c
+}
+
+// Peer 返回对端进程的凭据，无法获取时返回 nil
+func (c *RPCConn) Peer() *PeerCred {
+	return c.peer
+}
+
+// Notify 向客户端推送通知（没有 id 的请求）
+func (c *RPCConn) Notify(method string, params any) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return c.write(RPCRequest{JSONRPC: "2.0", Method: method, Params: raw})
+}
+
+// write 写入一条消息，失败（包括超时）时关闭连接
+func (c *RPCConn) write(v any) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if t := c.server.limits.WriteTimeout; t > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(t))
+	}
+	err := c.encoder.Encode(v)
+	if err != nil {
+		c.conn.Close()
+	}
+	return err
+}
+
+// hold 标记连接上有需要保持的状态（如订阅），返回的函数撤销标记
+func (c *RPCConn) hold() func() {
+	c.active.Add(1)
+	var once sync.Once
+	return func() { once.Do(func() { c.active.Add(-1) }) }
+}
+
+// OnClose 注册连接关闭时执行的清理函数；连接已关闭时立即执行
+func (c *RPCConn) OnClose(fn func()) {
+	c.mu.Lock()
+	if !c.closed {
+		c.onClose = append(c.onClose, fn)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	fn()
+}
+
+func (c *RPCConn) close() {
+	c.mu.Lock()
+	c.closed = true
+	fns := c.onClose
+	c.onClose = nil
+	c.mu.Unlock()
+	c.conn.Close()
+	c.cancel()
+	for _, fn := range fns {
+		fn()
+	}
+}
+
+// armRead 在读取下一条请求前设置超时；服务器正在停止时返回 errDraining
+func (c *RPCConn) armRead() error {
+	// 与 stopReading 互斥，避免覆盖它设置的超时
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.server.isDraining() {
+		return errDraining
+	}
+	if t := c.server.limits.IdleTimeout; t > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(t))
+	}
+	return nil
+}
+
+// stopReading 让阻塞中的读取立即返回
+func (c *RPCConn) stopReading() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conn.SetReadDeadline(time.Now())
+}
+
+// readMessage 读取一行请求（含换行符）
+func (c *RPCConn) readMessage(r *bufio.Reader) ([]byte, error) {
+	limit := c.server.limits.MaxMessageSize
+	var msg []byte
+	for {
+		if err := c.armRead(); err != nil {
+			return nil, err
+		}
+		chunk, err := r.ReadSlice('\n')
+		msg = append(msg, chunk...)
+		if limit > 0 && len(bytes.TrimRight(msg, "\r\n")) > limit {
+			return nil, errMessageTooLarge
+		}
+		switch {
+		case err == nil:
+			return msg, nil
+		case errors.Is(err, bufio.ErrBufferFull):
+		case errors.Is(err, os.ErrDeadlineExceeded):
+			// 等待进行中的请求或订阅时不算空闲，继续读取（已读到的部分保留）
+			if c.active.Load() == 0 && !c.server.isDraining() {
+				return nil, errIdle
+			}
+		case errors.Is(err, io.EOF) && len(bytes.TrimSpace(msg)) > 0:
+			// 最后一条请求没有换行
+			return msg, nil
+		default:
+			return nil, err
+		}
+	}
+}
 
-	var req AgentRequest
-	if err := decoder.Decode(&req); err != nil {
-		encoder.Encode(AgentResponse{Error: err.Error()})
+// ServeConn 在已建立的连接上处理请求直到连接关闭
+//
+// 请求以换行分隔；无法解析的请求、超过 MaxMessageSize 的请求得到错误响应后连接被关闭。
+func (s *IPCServer) ServeConn(conn net.Conn) {
+	rc := &RPCConn{conn: conn, server: s, encoder: json.NewEncoder(conn)}
+	rc.ctx, rc.cancel = context.WithCancel(context.WithValue(context.Background(), rpcConnKey{}, rc))
+	if err := s.addConn(rc); err != nil {
+		rc.write(RPCResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &RPCError{Code: RPCServerError, Message: err.Error()}})
+		rc.close()
 		return
 	}
+	defer s.removeConn(rc)
+	defer rc.close()
+	peer, err := peerCredentials(conn)
+	if err != nil {
+		slog.Debug("ipc peer credentials", "err", err)
+	}
+	rc.peer = peer
 
-	if s.handler != nil {
-		resp := s.handler(req)
-		encoder.Encode(resp)
-	} else {
-		encoder.Encode(AgentResponse{Error: "no handler"})
+	reader := bufio.NewReader(conn)
+	ctx := rc.ctx
+
+	// 并发处理的请求在连接关闭前完成
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	var sem chan struct{}
+	if s.concurrent > 1 {
+		sem = make(chan struct{}, s.concurrent)
+	}
+
+	for {
+		raw, err := rc.readMessage(reader)
+		switch {
+		case errors.Is(err, errMessageTooLarge):
+			rc.write(RPCResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: &RPCError{Code: RPCInvalidRequest, Message: fmt.Sprintf("message too large (limit %d bytes)", s.limits.MaxMessageSize)}})
+			return
+		case errors.Is(err, errIdle):
+			slog.Debug("ipc connection idle, closing")
+			return
+		case err != nil:
+			return
+		}
+		trimmed := bytes.TrimSpace(raw)
+		if len(trimmed) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(trimmed, new(json.RawMessage)); err != nil {
+			rc.write(RPCResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: &RPCError{Code: RPCParseError, Message: "parse error: " + err.Error()}})
+			return
+		}
+
+		var reply any
+		isBatch := trimmed[0] == '['
+		if sem != nil && !isBatch {
+			sem <- struct{}{}
+			wg.Add(1)
+			release := rc.hold()
+			go func() {
+				defer func() { release(); <-sem; wg.Done() }()
+				if resp := s.handleRequest(ctx, trimmed); resp != nil {
+					rc.write(resp)
+				}
+			}()
+			continue
+		}
+		if isBatch {
+			reply = s.handleBatch(ctx, trimmed)
+		} else if resp := s.handleRequest(ctx, trimmed); resp != nil {
+			reply = resp
+		}
+		if reply == nil {
+			continue
+		}
+		if err := rc.write(reply); err != nil {
+			return
+		}
 	}
 }
 
+// handleBatch 处理批量请求，全部为通知时返回 nil
+func (s *IPCServer) handleBatch(ctx context.Context, raw json.RawMessage) any {
+	var batch []json.RawMessage
+	if err := json.Unmarshal(raw, &batch); err != nil || len(batch) == 0 {
+		return RPCResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &RPCError{Code: RPCInvalidRequest, Message: "invalid batch"}}
+	}
+	var responses []*RPCResponse
+	for _, item := range batch {
+		if resp := s.handleRequest(ctx, item); resp != nil {
+			responses = append(responses, resp)
+		}
+	}
+	if len(responses) == 0 {
+		return nil
+	}
+	return responses
+}
+
+// handleRequest 处理单个请求，通知返回 nil
+func (s *IPCServer) handleRequest(ctx context.Context, raw json.RawMessage) *RPCResponse {
+	var req RPCRequest
+	if err := json.Unmarshal(raw, &req); err != nil || req.JSONRPC != "2.0" || req.Method == "" {
+		return &RPCResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &RPCError{Code: RPCInvalidRequest, Message: "invalid request"}}
+	}
+
+	s.mu.RLock()
+	h, ok := s.methods[req.Method]
+	s.mu.RUnlock()
+
+	var result any
+	var err error
+	if rc := ConnFromContext(ctx); rc != nil {
+		// 先检查策略，被拒绝的对端无法探测方法是否存在
+		err = rc.authorizeMethod(req.Method)
+	}
+	switch {
+	case err != nil:
+	case ok:
+		result, err = s.call(ctx, h, req)
+	default:
+		err = &RPCError{Code: RPCMethodNotFound, Message: "method not found: " + req.Method}
+	}
+	if req.ID == nil {
+		if err != nil {
+			slog.Warn("ipc notification failed", "method", req.Method, "err", err)
+		}
+		return nil
+	}
+
+	resp := &RPCResponse{JSONRPC: "2.0", ID: req.ID}
+	if err == nil {
+		if resp.Result, err = json.Marshal(result); err != nil {
+			err = &RPCError{Code: RPCInternalError, Message: "encode result: " + err.Error()}
+		}
+	}
+	if err != nil {
+		var rpcErr *RPCError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &RPCError{Code: RPCServerError, Message: err.Error()}
+		}
+		resp.Result, resp.Error = nil, rpcErr
+	}
+	return resp
+}
+
+// call 执行处理函数，panic 转为内部错误
+func (s *IPCServer) call(ctx context.Context, h RPCHandler, req RPCRequest) (result any, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Error("ipc handler panic", "method", req.Method, "panic", r)
+			err = &RPCError{Code: RPCInternalError, Message: fmt.Sprintf("internal error: %v", r)}
+		}
+	}()
+	return h(ctx, req.Params)
+}
+
+// BindParams 将请求参数解码到 v，未知字段和类型错误返回参数错误；未提供参数时保持 v 不变
+func BindParams(params json.RawMessage, v any) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(params))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return InvalidParams("invalid params: %v", err)
+	}
+	return nil
+}
+
 // IPCClient Unix Socket客户端
+//
+// 首次调用时建立连接，之后的调用复用同一连接（可并发调用）；连接出错后下次调用重新连接。
+// 服务端推送的通知交给 SetNotify 设置的回调。
 type IPCClient struct {
-	socketPath string
+	socketPath   string
+	dial         func() (net.Conn, error)
+	onNotify     func(method string, params json.RawMessage)
+	onDisconnect func(error)
+
+	mu     sync.Mutex
+	conn   *clientConn
+	nextID int64
+}
+
+// clientConn 客户端连接，读取协程按ID把响应交给等待中的调用
+type clientConn struct {
+	conn    net.Conn
+	wmu     sync.Mutex
+	encoder *json.Encoder
+
+	mu      sync.Mutex
+	pending map[string]chan *RPCResponse
+	err     error
+	done    chan struct{}
+}
+
+// rpcMessage 客户端读取的消息：响应或服务端通知
+type rpcMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  *RPCError       `json:"error"`
 }
 
 // NewIPCClient 创建IPC客户端
 func NewIPCClient(socketPath string) *IPCClient {
-	return &IPCClient{socketPath: socketPath}
+	return &IPCClient{socketPath: socketPath, dial: func() (net.Conn, error) {
+		return net.Dial("unix", socketPath)
+	}}
 }
 
-// Call 调用Agent
+// NewIPCClientConn 在已建立的连接上创建客户端，连接断开后不再重连
+func NewIPCClientConn(conn net.Conn) *IPCClient {
+	used := false
+	return &IPCClient{dial: func() (net.Conn, error) {
+		// 在 c.mu 下调用
+		if used {
+			return nil, net.ErrClosed
+		}
+		used = true
+		return conn, nil
+	}}
+}
+
+// SetNotify 设置通知回调（需在首次调用前设置）
+//
+// 回调在读取协程中按到达顺序执行，不能在其中同步调用 Invoke。
+func (c *IPCClient) SetNotify(fn func(method string, params json.RawMessage)) {
+	c.onNotify = fn
+}
+
+// SetOnDisconnect 设置连接意外断开时的回调（需在首次调用前设置），Close 关闭连接时不调用
+//
+// 连接上的订阅随之失效，下次调用会重新连接，需要时重新订阅。
+func (c *IPCClient) SetOnDisconnect(fn func(error)) {
+	c.onDisconnect = fn
+}
+
+// Call 调用Agent（agent.run 方法）
 func (c *IPCClient) Call(req AgentRequest) (AgentResponse, error) {
-	conn, err := net.Dial("unix", c.socketPath)
+	var resp AgentResponse
+	err := c.Invoke("agent.run", req, &resp)
+	return resp, err
+}
+
+// Subscribe 订阅推送通知（主题见 Notify* 常量，会话为空表示全部），返回订阅ID
+func (c *IPCClient) Subscribe(topics []string, chats []ChatJID) (string, error) {
+	var result struct {
+		Subscription string `json:"subscription"`
+	}
+	err := c.Invoke("subscribe", map[string]any{"topics": topics, "chat_jids": chats}, &result)
+	return result.Subscription, err
+}
+
+// Invoke 调用方法并将结果解码到 result（可为 nil）；服务端返回的错误为 *RPCError
+func (c *IPCClient) Invoke(method string, params, result any) error {
+	return c.InvokeContext(context.Background(), method, params, result)
+}
+
+// InvokeContext 与 Invoke 相同，ctx 取消时不再等待响应
+func (c *IPCClient) InvokeContext(ctx context.Context, method string, params, result any) error {
+	req := RPCRequest{JSONRPC: "2.0", Method: method}
+	if params != nil {
+		raw, err := json.Marshal(params)
+		if err != nil {
+			return err
+		}
+		req.Params = raw
+	}
+
+	cc, id, err := c.connect()
+	if err != nil {
+		return err
+	}
+	req.ID = json.RawMessage(id)
+	ch := make(chan *RPCResponse, 1)
+	cc.mu.Lock()
+	if cc.err != nil {
+		cc.mu.Unlock()
+		return cc.err
+	}
+	cc.pending[id] = ch
+	cc.mu.Unlock()
+	defer func() {
+		cc.mu.Lock()
+		delete(cc.pending, id)
+		cc.mu.Unlock()
+	}()
+
+	cc.wmu.Lock()
+	err = cc.encoder.Encode(req)
+	cc.wmu.Unlock()
 	if err != nil {
-		return AgentResponse{}, fmt.Errorf("dial: %w", err)
+		cc.fail(err)
+		return err
 	}
-	defer conn.Close()
 
-	encoder := json.NewEncoder(conn)
-	decoder := json.NewDecoder(conn)
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result != nil {
+			return json.Unmarshal(resp.Result, result)
+		}
+		return nil
+	case <-cc.done:
+		return cc.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	if err := encoder.Encode(req); err != nil {
-		return AgentResponse{}, err
+// connect 返回可用的连接和新的请求ID，没有连接或连接已断开时重新连接
+func (c *IPCClient) connect() (*clientConn, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil {
+		select {
+		case <-c.conn.done:
+			c.conn = nil
+		default:
+		}
 	}
+	if c.conn == nil {
+		conn, err := c.dial()
+		if err != nil {
+			return nil, "", fmt.Errorf("dial: %w", err)
+		}
+		c.conn = &clientConn{
+			conn:    conn,
+			encoder: json.NewEncoder(conn),
+			pending: make(map[string]chan *RPCResponse),
+			done:    make(chan struct{}),
+		}
+		go c.conn.readLoop(c.onNotify, c.onDisconnect)
+	}
+	c.nextID++
+	return c.conn, strconv.FormatInt(c.nextID, 10), nil
+}
 
-	var resp AgentResponse
-	if err := decoder.Decode(&resp); err != nil {
-		return AgentResponse{}, err
+func (cc *clientConn) readLoop(onNotify func(string, json.RawMessage), onDisconnect func(error)) {
+	defer func() {
+		cc.mu.Lock()
+		err := cc.err
+		cc.mu.Unlock()
+		if onDisconnect != nil && !errors.Is(err, net.ErrClosed) {
+			onDisconnect(err)
+		}
+	}()
+	decoder := json.NewDecoder(bufio.NewReader(cc.conn))
+	for {
+		var msg rpcMessage
+		if err := decoder.Decode(&msg); err != nil {
+			cc.fail(err)
+			return
+		}
+		switch {
+		case msg.Method != "" && msg.ID == nil:
+			if onNotify != nil {
+				onNotify(msg.Method, msg.Params)
+			}
+		case string(msg.ID) == "null" && msg.Error != nil:
+			// 服务端无法解析请求，随后会关闭连接
+			cc.fail(msg.Error)
+			return
+		default:
+			cc.mu.Lock()
+			ch := cc.pending[string(msg.ID)]
+			cc.mu.Unlock()
+			if ch != nil {
+				ch <- &RPCResponse{JSONRPC: "2.0", ID: msg.ID, Result: msg.Result, Error: msg.Error}
+			}
+		}
 	}
+}
 
-	return resp, nil
+// fail 记录错误并关闭连接，等待中的调用返回该错误
+func (cc *clientConn) fail(err error) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.err != nil {
+		return
+	}
+	cc.err = err
+	cc.conn.Close()
+	close(cc.done)
+}
+
+// Close 关闭连接
+func (c *IPCClient) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		c.conn.fail(net.ErrClosed)
+		c.conn = nil
+	}
+	return nil
 }
 
 // IsRoot 检查是否以root运行
 func IsRoot() bool {
 	return os.Getuid() == 0
 }
-
-// DropPrivileges 切换到nanoclaw用户（简化版，实际使用setuid）
-func DropPrivileges() error {
-	// 注意：实际实现需要使用syscall.Setuid
-	// 这里仅作演示
-	return nil
-}
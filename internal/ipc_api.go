@@ -0,0 +1,648 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ControlAPI 通过IPC提供的控制方法
+//
+//	messages.send  {chat_jid, content[, sender]}          以用户消息的形式发送到会话（与TUI输入相同的处理）；
+//	                                                      sender 只对服务进程自身的用户有效，不能是 System 或助手名称
+//	messages.list  {chat_jid[, limit][, before]}          会话最近的消息（按时间顺序），before 为消息ID时返回更早的消息
+//	groups.list                                           已注册的群组
+//	tasks.list     {group_folder, chat_jid, status, schedule_type, limit}（均可选）
+//	tasks.pause    {task_id}                              暂停活动任务，返回修改后的任务
+//	tasks.resume   {task_id}                              恢复暂停或失败的任务，返回修改后的任务
+//	tasks.trigger  {task_id}                              活动任务在下一次调度时立即执行
+//	tasks.delete   {task_id}                              删除任务及其执行记录
+//	tasks.runs     {task_id[, limit]}                     任务的执行记录（最新在前）
+//	skills.list    {group_folder}                         群组可用的技能
+//	skills.run     {name[, group_folder][, chat_jid][, args]}  执行技能并返回输出
+//	queue.status                                          排队或正在执行的群组
+//	status                                                服务状态（StatusInfo）
+//	subscribe      {topics, chat_jids}（均可选）          在当前连接上接收推送通知，返回 {subscription}
+//	unsubscribe    {subscription}                         取消订阅；连接关闭时自动取消
+type ControlAPI struct {
+	db      *DB
+	orch    *Orchestrator
+	queue   *GroupQueue
+	skills  *SkillRegistry
+	hub     *Hub
+	start   time.Time
+	onTasks func() // 任务被修改后通知调度器
+
+	mu     sync.Mutex
+	subs   map[string]apiSubscription
+	nextID int
+}
+
+// apiSubscription 客户端的订阅及其所在连接
+type apiSubscription struct {
+	sub     *Subscription
+	conn    *RPCConn
+	release func() // 撤销连接的保持标记，有订阅的连接不因空闲被关闭
+}
+
+// notifyTopics 可订阅的主题
+var notifyTopics = map[string]bool{NotifyMessage: true, NotifyDelta: true, NotifyThinking: true, NotifyTaskRun: true}
+
+// 消息列表默认和最大条数
+const (
+	defaultMessageLimit = 50
+	maxMessageLimit     = 500
+)
+
+// NewControlAPI 创建控制接口
+func NewControlAPI(db *DB, orch *Orchestrator, queue *GroupQueue) *ControlAPI {
+	return &ControlAPI{db: db, orch: orch, queue: queue, start: time.Now(), subs: make(map[string]apiSubscription)}
+}
+
+// SetHub 设置通知中心，未设置时 subscribe 返回错误
+func (a *ControlAPI) SetHub(h *Hub) {
+	a.hub = h
+}
+
+// SetSkills 设置技能注册表，未设置时 skills.* 方法返回错误
+func (a *ControlAPI) SetSkills(sr *SkillRegistry) {
+	a.skills = sr
+}
+
+// SetTaskNotifier 设置任务被 tasks.* 方法修改后的回调（通常为 Scheduler.Wake）
+func (a *ControlAPI) SetTaskNotifier(fn func()) {
+	a.onTasks = fn
+}
+
+// Register 在服务器上注册全部方法
+func (a *ControlAPI) Register(s *IPCServer) {
+	s.Handle("messages.send", a.sendMessage)
+	s.Handle("messages.list", a.listMessages)
+	s.Handle("groups.list", a.listGroups)
+	s.Handle("tasks.list", a.listTasks)
+	s.Handle("tasks.pause", func(ctx context.Context, params json.RawMessage) (any, error) {
+		return a.setTaskStatus(ctx, params, TaskPaused)
+	})
+	s.Handle("tasks.resume", func(ctx context.Context, params json.RawMessage) (any, error) {
+		return a.setTaskStatus(ctx, params, TaskActive)
+	})
+	s.Handle("tasks.trigger", a.triggerTask)
+	s.Handle("tasks.delete", a.deleteTask)
+	s.Handle("tasks.runs", a.listTaskRuns)
+	s.Handle("skills.list", a.listSkills)
+	s.Handle("skills.run", a.runSkill)
+	s.Handle("queue.status", a.queueStatus)
+	s.Handle("status", a.status)
+	s.Handle("subscribe", a.subscribe)
+	s.Handle("unsubscribe", a.unsubscribe)
+}
+
+// GroupInfo 群组的JSON表示
+type GroupInfo struct {
+	JID             ChatJID   `json:"jid"`
+	Name            string    `json:"name"`
+	Folder          string    `json:"folder"`
+	TriggerPattern  string    `json:"trigger_pattern"`
+	RequiresTrigger bool      `json:"requires_trigger"`
+	AddedAt         time.Time `json:"added_at"`
+}
+
+// MessageInfo 消息的JSON表示
+type MessageInfo struct {
+	ID         MessageID `json:"id"`
+	ChatJID    ChatJID   `json:"chat_jid"`
+	Sender     string    `json:"sender"`
+	SenderName string    `json:"sender_name"`
+	Content    string    `json:"content"`
+	Timestamp  time.Time `json:"timestamp"`
+	IsBot      bool      `json:"is_bot"`
+}
+
+// TaskInfo 任务的JSON表示
+type TaskInfo struct {
+	ID            string     `json:"id"`
+	GroupFolder   string     `json:"group_folder"`
+	ChatJID       ChatJID    `json:"chat_jid"`
+	Prompt        string     `json:"prompt"`
+	ScheduleType  string     `json:"schedule_type"`
+	ScheduleValue string     `json:"schedule_value"`
+	Timezone      string     `json:"timezone,omitempty"`
+	Status        string     `json:"status"`
+	NextRun       *time.Time `json:"next_run,omitempty"`
+	LastRun       *time.Time `json:"last_run,omitempty"`
+	LastResult    string     `json:"last_result,omitempty"`
+	Silent        bool       `json:"silent"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// SkillInfo 技能的JSON表示
+type SkillInfo struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Version     string `json:"version,omitempty"`
+	Source      string `json:"source"`
+}
+
+// StatusInfo status 方法的结果，Groups 和 Queue 只包含对端可访问的群组
+type StatusInfo struct {
+	Name          string        `json:"name"`
+	Version       string        `json:"version"`
+	PID           int           `json:"pid"`
+	StartedAt     time.Time     `json:"started_at"`
+	Groups        int           `json:"groups"`
+	Queue         []QueueStatus `json:"queue"`
+	Subscriptions int           `json:"subscriptions"`
+}
+
+// NewGroupInfo 转换为JSON表示
+func NewGroupInfo(g Group) GroupInfo {
+	return GroupInfo{JID: g.JID, Name: g.Name, Folder: g.Folder, TriggerPattern: g.TriggerPattern,
+		RequiresTrigger: g.RequiresTrigger, AddedAt: g.AddedAt}
+}
+
+// NewMessageInfo 转换为JSON表示
+func NewMessageInfo(m Message) MessageInfo {
+	return MessageInfo{ID: m.ID, ChatJID: m.ChatJID, Sender: m.Sender, SenderName: m.SenderName,
+		Content: m.Content, Timestamp: m.Timestamp, IsBot: m.IsBotMessage}
+}
+
+// NewTaskInfo 转换为JSON表示
+func NewTaskInfo(t Task) TaskInfo {
+	return TaskInfo{ID: t.ID, GroupFolder: t.GroupFolder, ChatJID: t.ChatJID, Prompt: t.Prompt,
+		ScheduleType: t.ScheduleType, ScheduleValue: t.ScheduleValue, Timezone: t.Timezone, Status: t.Status,
+		NextRun: t.NextRun, LastRun: t.LastRun, LastResult: t.LastResult, Silent: t.Silent, CreatedAt: t.CreatedAt}
+}
+
+// group 获取已注册的群组，不存在时返回参数错误，访问策略不允许时返回拒绝错误
+func (a *ControlAPI) group(ctx context.Context, jid ChatJID) (*Group, error) {
+	if jid == "" {
+		return nil, InvalidParams("chat_jid is required")
+	}
+	g, err := a.db.GetGroup(jid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, InvalidParams("unknown chat %s", jid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeGroup(ctx, g.Folder); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// chatAllowed 当前请求能否访问会话所属的群组，用于过滤列表结果
+func (a *ControlAPI) chatAllowed(ctx context.Context, jid ChatJID) bool {
+	if !restricted(ctx) {
+		return true
+	}
+	g, err := a.db.GetGroup(jid)
+	return err == nil && groupAllowed(ctx, g.Folder)
+}
+
+// SystemSender 调度器注入任务提示时使用的发送者
+const SystemSender = "System"
+
+// IsReservedSender 名称是否为调度器或助手自身使用的发送者（不区分大小写），外部发送的消息不能使用
+func IsReservedSender(cfg *Config, name string) bool {
+	name = strings.TrimSpace(name)
+	return strings.EqualFold(name, SystemSender) || strings.EqualFold(name, cfg.App.Name)
+}
+
+func (a *ControlAPI) sendMessage(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		ChatJID ChatJID `json:"chat_jid"`
+		Content string  `json:"content"`
+		Sender  string  `json:"sender"`
+	}
+	if err := BindParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Content == "" {
+		return nil, InvalidParams("content is required")
+	}
+	if _, err := a.group(ctx, p.ChatJID); err != nil {
+		return nil, err
+	}
+	// 排空队列期间消息不会再被处理
+	if a.queue.Closed() {
+		return nil, ErrQueueClosed
+	}
+	// 发送者由服务端确定，外部消息不能冒充调度器或助手（例如满足 confirm_task 对用户回复的检查）
+	sender := requestSender(ctx, p.Sender)
+	if IsReservedSender(a.orch.cfg, sender) {
+		return nil, InvalidParams("sender %q is reserved", sender)
+	}
+	a.orch.HandleMessage(p.ChatJID, sender, p.Content)
+	return map[string]bool{"ok": true}, nil
+}
+
+func (a *ControlAPI) listMessages(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		ChatJID ChatJID   `json:"chat_jid"`
+		Limit   int       `json:"limit"`
+		Before  MessageID `json:"before"`
+	}
+	if err := BindParams(params, &p); err != nil {
+		return nil, err
+	}
+	if _, err := a.group(ctx, p.ChatJID); err != nil {
+		return nil, err
+	}
+	if p.Limit < 0 || p.Limit > maxMessageLimit {
+		return nil, InvalidParams("limit must be between 1 and %d", maxMessageLimit)
+	}
+	if p.Limit == 0 {
+		p.Limit = defaultMessageLimit
+	}
+	msgs, err := a.db.GetMessagesBefore(p.ChatJID, p.Before, p.Limit)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]MessageInfo, len(msgs))
+	for i, m := range msgs {
+		result[i] = NewMessageInfo(m)
+	}
+	return result, nil
+}
+
+func (a *ControlAPI) listGroups(ctx context.Context, _ json.RawMessage) (any, error) {
+	return a.allowedGroups(ctx)
+}
+
+// allowedGroups 当前请求可访问的群组
+func (a *ControlAPI) allowedGroups(ctx context.Context) ([]GroupInfo, error) {
+	groups, err := a.db.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+	result := []GroupInfo{}
+	for _, g := range groups {
+		if groupAllowed(ctx, g.Folder) {
+			result = append(result, NewGroupInfo(g))
+		}
+	}
+	return result, nil
+}
+
+func (a *ControlAPI) listTasks(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		GroupFolder  string  `json:"group_folder"`
+		ChatJID      ChatJID `json:"chat_jid"`
+		Status       string  `json:"status"`
+		ScheduleType string  `json:"schedule_type"`
+		Limit        int     `json:"limit"`
+	}
+	if err := BindParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Limit < 0 {
+		return nil, InvalidParams("limit must not be negative")
+	}
+	f := TaskFilter{GroupFolder: p.GroupFolder, ChatJID: p.ChatJID, Status: p.Status,
+		ScheduleType: p.ScheduleType, Limit: p.Limit}
+	// 受限制的调用方只查询可访问的群组，在SQL中过滤后再应用 limit
+	if restricted(ctx) {
+		groups, err := a.allowedGroups(ctx)
+		if err != nil {
+			return nil, err
+		}
+		f.GroupFolders = []string{}
+		for _, g := range groups {
+			f.GroupFolders = append(f.GroupFolders, g.Folder)
+		}
+	}
+	tasks, err := a.db.FindTasks(f)
+	if err != nil {
+		return nil, err
+	}
+	result := []TaskInfo{}
+	for _, t := range tasks {
+		result = append(result, NewTaskInfo(t))
+	}
+	return result, nil
+}
+
+// task 获取任务并检查所属群组的访问权限
+func (a *ControlAPI) task(ctx context.Context, id string) (*Task, error) {
+	if id == "" {
+		return nil, InvalidParams("task_id is required")
+	}
+	t, err := a.db.GetTask(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, InvalidParams("unknown task %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeGroup(ctx, t.GroupFolder); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// taskID 解析只有 task_id 的参数
+func taskID(params json.RawMessage) (string, error) {
+	var p struct {
+		TaskID string `json:"task_id"`
+	}
+	err := BindParams(params, &p)
+	return p.TaskID, err
+}
+
+// tasksChanged 通知调度器重新计算下次唤醒时间
+func (a *ControlAPI) tasksChanged() {
+	if a.onTasks != nil {
+		a.onTasks()
+	}
+}
+
+func (a *ControlAPI) setTaskStatus(ctx context.Context, params json.RawMessage, status string) (any, error) {
+	id, err := taskID(params)
+	if err != nil {
+		return nil, err
+	}
+	t, err := a.task(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.SetStatus(status, time.Now()); err != nil {
+		return nil, InvalidParams("%v", err)
+	}
+	if err := a.db.SaveTask(t); err != nil {
+		return nil, err
+	}
+	a.tasksChanged()
+	return NewTaskInfo(*t), nil
+}
+
+func (a *ControlAPI) triggerTask(ctx context.Context, params json.RawMessage) (any, error) {
+	id, err := taskID(params)
+	if err != nil {
+		return nil, err
+	}
+	t, err := a.task(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if t.Status != TaskActive {
+		return nil, InvalidParams("task %s is %s", t.ID, t.Status)
+	}
+	if err := a.db.TriggerTask(t.ID, time.Now()); err != nil {
+		return nil, err
+	}
+	a.tasksChanged()
+	return map[string]bool{"ok": true}, nil
+}
+
+func (a *ControlAPI) deleteTask(ctx context.Context, params json.RawMessage) (any, error) {
+	id, err := taskID(params)
+	if err != nil {
+		return nil, err
+	}
+	t, err := a.task(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.db.DeleteTask(t.ID); err != nil {
+		return nil, err
+	}
+	a.tasksChanged()
+	return map[string]bool{"ok": true}, nil
+}
+
+func (a *ControlAPI) listTaskRuns(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		TaskID string `json:"task_id"`
+		Limit  int    `json:"limit"`
+	}
+	if err := BindParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Limit < 0 || p.Limit > maxMessageLimit {
+		return nil, InvalidParams("limit must be between 1 and %d", maxMessageLimit)
+	}
+	if p.Limit == 0 {
+		p.Limit = defaultMessageLimit
+	}
+	t, err := a.task(ctx, p.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	runs, err := a.db.ListTaskRuns(t.ID, p.Limit, 0)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]TaskRunInfo, len(runs))
+	for i, r := range runs {
+		result[i] = NewTaskRunInfo(*t, r)
+	}
+	return result, nil
+}
+
+func (a *ControlAPI) listSkills(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		GroupFolder string `json:"group_folder"`
+	}
+	if err := BindParams(params, &p); err != nil {
+		return nil, err
+	}
+	if a.skills == nil {
+		return nil, errors.New("skills are not available")
+	}
+	if p.GroupFolder == "" {
+		p.GroupFolder = "main"
+	}
+	if err := authorizeGroup(ctx, p.GroupFolder); err != nil {
+		return nil, err
+	}
+	skills := a.skills.Skills(p.GroupFolder)
+	result := make([]SkillInfo, len(skills))
+	for i, s := range skills {
+		result[i] = SkillInfo{Name: s.Name, Description: s.Description, Version: s.Version, Source: s.Source.String()}
+	}
+	return result, nil
+}
+
+func (a *ControlAPI) runSkill(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		Name        string   `json:"name"`
+		GroupFolder string   `json:"group_folder"`
+		ChatJID     ChatJID  `json:"chat_jid"`
+		Args        []string `json:"args"`
+	}
+	if err := BindParams(params, &p); err != nil {
+		return nil, err
+	}
+	if p.Name == "" {
+		return nil, InvalidParams("name is required")
+	}
+	if a.skills == nil {
+		return nil, errors.New("skills are not available")
+	}
+	// 给出会话时使用其所属群组
+	if p.ChatJID != "" {
+		g, err := a.group(ctx, p.ChatJID)
+		if err != nil {
+			return nil, err
+		}
+		if p.GroupFolder == "" {
+			p.GroupFolder = g.Folder
+		}
+	}
+	if p.GroupFolder == "" {
+		p.GroupFolder = "main"
+	}
+	if err := authorizeGroup(ctx, p.GroupFolder); err != nil {
+		return nil, err
+	}
+	if _, ok := a.skills.Lookup(p.GroupFolder, p.Name); !ok {
+		return nil, InvalidParams("unknown skill %s", p.Name)
+	}
+	out, err := a.skills.Run(ctx, p.Name, SkillContext{GroupFolder: p.GroupFolder, ChatJID: p.ChatJID, Argv: p.Args})
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{"output": out}, nil
+}
+
+func (a *ControlAPI) queueStatus(ctx context.Context, _ json.RawMessage) (any, error) {
+	return map[string]any{"groups": a.allowedQueue(ctx)}, nil
+}
+
+// allowedQueue 当前请求可访问的群组的队列状态
+func (a *ControlAPI) allowedQueue(ctx context.Context) []QueueStatus {
+	status := []QueueStatus{}
+	for _, st := range a.queue.Status() {
+		if a.chatAllowed(ctx, st.ChatJID) {
+			status = append(status, st)
+		}
+	}
+	return status
+}
+
+func (a *ControlAPI) status(ctx context.Context, _ json.RawMessage) (any, error) {
+	groups, err := a.allowedGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	subs := len(a.subs)
+	a.mu.Unlock()
+	return StatusInfo{
+		Name:          a.orch.cfg.App.Name,
+		Version:       Version,
+		PID:           os.Getpid(),
+		StartedAt:     a.start,
+		Groups:        len(groups),
+		Queue:         a.allowedQueue(ctx),
+		Subscriptions: subs,
+	}, nil
+}
+
+func (a *ControlAPI) subscribe(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		Topics   []string  `json:"topics"`
+		ChatJIDs []ChatJID `json:"chat_jids"`
+	}
+	if err := BindParams(params, &p); err != nil {
+		return nil, err
+	}
+	for _, t := range p.Topics {
+		if !notifyTopics[t] {
+			return nil, InvalidParams("unknown topic %s", t)
+		}
+	}
+	conn := ConnFromContext(ctx)
+	if a.hub == nil || conn == nil {
+		return nil, errors.New("notifications are not available")
+	}
+	if restricted(ctx) {
+		// 受策略限制的对端只能订阅允许访问的群组
+		chats, err := a.allowedChats(ctx, p.ChatJIDs)
+		if err != nil {
+			return nil, err
+		}
+		p.ChatJIDs = chats
+	}
+
+	sub := a.hub.Subscribe(p.Topics, p.ChatJIDs, 0)
+	a.mu.Lock()
+	a.nextID++
+	id := fmt.Sprintf("sub-%d", a.nextID)
+	a.subs[id] = apiSubscription{sub: sub, conn: conn, release: conn.hold()}
+	a.mu.Unlock()
+	conn.OnClose(func() { a.closeSubscription(id) })
+
+	// 写入阻塞（客户端读取过慢）时通知积压在订阅缓冲区，满后由 Hub 丢弃并计数
+	go func() {
+		for n := range sub.C() {
+			if err := conn.Notify(n.Topic, n.Data); err != nil {
+				slog.Debug("ipc notify", "subscription", id, "err", err)
+				a.closeSubscription(id)
+			}
+		}
+	}()
+	return map[string]string{"subscription": id}, nil
+}
+
+// allowedChats 检查请求的会话均可访问；未指定时返回全部可访问的会话
+func (a *ControlAPI) allowedChats(ctx context.Context, chats []ChatJID) ([]ChatJID, error) {
+	if len(chats) > 0 {
+		for _, jid := range chats {
+			if _, err := a.group(ctx, jid); err != nil {
+				return nil, err
+			}
+		}
+		return chats, nil
+	}
+	groups, err := a.db.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		if groupAllowed(ctx, g.Folder) {
+			chats = append(chats, g.JID)
+		}
+	}
+	if len(chats) == 0 {
+		return nil, &RPCError{Code: RPCUnauthorized, Message: "permission denied: no accessible groups"}
+	}
+	return chats, nil
+}
+
+func (a *ControlAPI) unsubscribe(ctx context.Context, params json.RawMessage) (any, error) {
+	var p struct {
+		Subscription string `json:"subscription"`
+	}
+	if err := BindParams(params, &p); err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	s, ok := a.subs[p.Subscription]
+	a.mu.Unlock()
+	// 只能取消当前连接上的订阅
+	if !ok || s.conn != ConnFromContext(ctx) {
+		return nil, InvalidParams("unknown subscription %s", p.Subscription)
+	}
+	a.closeSubscription(p.Subscription)
+	return map[string]bool{"ok": true}, nil
+}
+
+func (a *ControlAPI) closeSubscription(id string) {
+	a.mu.Lock()
+	s, ok := a.subs[id]
+	delete(a.subs, id)
+	a.mu.Unlock()
+	if ok {
+		s.sub.Close()
+		s.release()
+	}
+}
@@ -0,0 +1,288 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestControlAPI(t *testing.T) {
+	db := TestTempDB(t)
+	cfg := TestConfig(t)
+	queue := NewGroupQueue(2)
+	orch := NewOrchestrator(db, queue, nil, cfg)
+	chatJID := ChatJID("main@nanoclaw")
+	if err := db.SaveGroup(&Group{JID: chatJID, Name: "Main", Folder: "main", AddedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveTask(&Task{ID: "t1", GroupFolder: "main", ChatJID: chatJID, Prompt: "report",
+		ScheduleType: "interval", ScheduleValue: "1h"}); err != nil {
+		t.Fatal(err)
+	}
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+	registry.Register(&Skill{Name: "echo", Description: "echo args", LuaScript: `return GROUP_FOLDER .. ":" .. table.concat(arg, "|")`})
+
+	server := startTestIPC(t)
+	api := NewControlAPI(db, orch, queue)
+	api.SetSkills(registry)
+	api.Register(server)
+	client := NewIPCClient(server.Path())
+	defer client.Close()
+
+	var groups []GroupInfo
+	if err := client.Invoke("groups.list", nil, &groups); err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].JID != chatJID || groups[0].Folder != "main" {
+		t.Errorf("groups = %+v", groups)
+	}
+
+	var ok map[string]bool
+	if err := client.Invoke("messages.send", map[string]any{"chat_jid": chatJID, "content": "hello from a script"}, &ok); err != nil || !ok["ok"] {
+		t.Fatalf("send = %v, %v", ok, err)
+	}
+	var msgs []MessageInfo
+	if err := client.Invoke("messages.list", map[string]any{"chat_jid": chatJID, "limit": 10}, &msgs); err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Content != "hello from a script" || msgs[0].Sender != "ipc" {
+		t.Errorf("messages = %+v", msgs)
+	}
+	// 服务进程自身的用户可以指定发送者
+	if err := client.Invoke("messages.send", map[string]any{"chat_jid": chatJID, "content": "named", "sender": "alice"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 时间戳精确到秒，两条消息的先后不确定
+	if all, _ := db.GetMessages(chatJID, 10); !slices.ContainsFunc(all, func(m Message) bool { return m.Content == "named" && m.Sender == "alice" }) {
+		t.Errorf("named sender = %+v", all)
+	}
+
+	var tasks []TaskInfo
+	if err := client.Invoke("tasks.list", map[string]any{"group_folder": "main", "status": "active"}, &tasks); err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].ID != "t1" || tasks[0].NextRun == nil {
+		t.Errorf("tasks = %+v", tasks)
+	}
+
+	var skills []SkillInfo
+	if err := client.Invoke("skills.list", map[string]any{"group_folder": "main"}, &skills); err != nil {
+		t.Fatal(err)
+	}
+	if len(skills) != 1 || skills[0].Name != "echo" {
+		t.Errorf("skills = %+v", skills)
+	}
+	var out map[string]string
+	if err := client.Invoke("skills.run", map[string]any{"name": "echo", "chat_jid": chatJID, "args": []string{"a", "b"}}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out["output"] != "main:a|b" {
+		t.Errorf("skill output = %q", out["output"])
+	}
+
+	var status struct {
+		Groups []QueueStatus `json:"groups"`
+	}
+	if err := client.Invoke("queue.status", nil, &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Groups == nil {
+		t.Error("queue.status should return an empty list, not null")
+	}
+
+	var info StatusInfo
+	if err := client.Invoke("status", nil, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.PID != os.Getpid() || info.Version != Version || info.Groups != 1 || info.Queue == nil || info.StartedAt.IsZero() {
+		t.Errorf("status = %+v", info)
+	}
+
+	// 参数错误
+	for _, tt := range []struct {
+		method string
+		params map[string]any
+	}{
+		{"messages.send", map[string]any{"chat_jid": chatJID}},
+		{"messages.send", map[string]any{"chat_jid": "nobody@nanoclaw", "content": "x"}},
+		{"messages.send", map[string]any{"chat_jid": chatJID, "content": "x", "sender": "System"}},
+		{"messages.send", map[string]any{"chat_jid": chatJID, "content": "x", "sender": " system "}},
+		{"messages.send", map[string]any{"chat_jid": chatJID, "content": "x", "sender": cfg.App.Name}},
+		{"messages.list", map[string]any{}},
+		{"messages.list", map[string]any{"chat_jid": chatJID, "limit": 10000}},
+		{"tasks.list", map[string]any{"group": "main"}},
+		{"skills.run", map[string]any{"name": "missing"}},
+	} {
+		var rpcErr *RPCError
+		if err := client.Invoke(tt.method, tt.params, nil); !errors.As(err, &rpcErr) || rpcErr.Code != RPCInvalidParams {
+			t.Errorf("%s %v: err = %v, want invalid params", tt.method, tt.params, err)
+		}
+	}
+
+	// 退出排空队列时拒绝新消息
+	if err := queue.Drain(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	var rpcErr *RPCError
+	err := client.Invoke("messages.send", map[string]any{"chat_jid": chatJID, "content": "late"}, nil)
+	if !errors.As(err, &rpcErr) || rpcErr.Code != RPCServerError || rpcErr.Message != ErrQueueClosed.Error() {
+		t.Errorf("send while draining: err = %v", err)
+	}
+}
+
+func TestControlAPI_Subscribe(t *testing.T) {
+	db := TestTempDB(t)
+	cfg := TestConfig(t)
+	queue := NewGroupQueue(2)
+	orch := NewOrchestrator(db, queue, nil, cfg)
+	hub := NewHub()
+	orch.SetNotify(hub.Publish)
+	for _, g := range []*Group{
+		{JID: "main@nanoclaw", Name: "Main", Folder: "main", AddedAt: time.Now()},
+		{JID: "other@nanoclaw", Name: "Other", Folder: "other", AddedAt: time.Now()},
+	} {
+		if err := db.SaveGroup(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	server := startTestIPC(t)
+	api := NewControlAPI(db, orch, queue)
+	api.SetHub(hub)
+	api.Register(server)
+	client := NewIPCClient(server.Path())
+	defer client.Close()
+	got := make(chan MessageInfo, 4)
+	client.SetNotify(func(method string, params json.RawMessage) {
+		var m MessageInfo
+		if method == NotifyMessage && json.Unmarshal(params, &m) == nil {
+			got <- m
+		}
+	})
+
+	id, err := client.Subscribe([]string{NotifyMessage}, []ChatJID{"main@nanoclaw"})
+	if err != nil || id == "" {
+		t.Fatalf("subscribe = %q, %v", id, err)
+	}
+	for _, chat := range []ChatJID{"other@nanoclaw", "main@nanoclaw"} {
+		if err := client.Invoke("messages.send", map[string]any{"chat_jid": chat, "content": "hi " + string(chat)}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case m := <-got:
+		if m.ChatJID != "main@nanoclaw" || m.Content != "hi main@nanoclaw" {
+			t.Errorf("notification = %+v", m)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no notification")
+	}
+
+	// 其他连接不能取消该订阅
+	other := NewIPCClient(server.Path())
+	defer other.Close()
+	var rpcErr *RPCError
+	if err := other.Invoke("unsubscribe", map[string]string{"subscription": id}, nil); !errors.As(err, &rpcErr) {
+		t.Errorf("unsubscribe from another connection: err = %v", err)
+	}
+	if _, err := client.Subscribe([]string{"bogus"}, nil); !errors.As(err, &rpcErr) || rpcErr.Code != RPCInvalidParams {
+		t.Errorf("unknown topic: err = %v", err)
+	}
+	if err := client.Invoke("unsubscribe", map[string]string{"subscription": id}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if hub.Subscribers() != 0 {
+		t.Errorf("subscribers after unsubscribe = %d", hub.Subscribers())
+	}
+
+	// 连接关闭时自动取消订阅
+	if _, err := other.Subscribe(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.Subscribers() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hub.Subscribers() != 0 {
+		t.Errorf("subscription survived connection close")
+	}
+	select {
+	case m := <-got:
+		t.Errorf("unexpected notification %+v", m)
+	default:
+	}
+}
+
+func TestControlAPI_Tasks(t *testing.T) {
+	db := TestTempDB(t)
+	queue := NewGroupQueue(1)
+	orch := NewOrchestrator(db, queue, nil, TestConfig(t))
+	task := &Task{ID: "t1", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "report",
+		ScheduleType: "interval", ScheduleValue: "1h"}
+	if err := db.SaveTask(task); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := db.SaveTaskRun(&TaskRun{TaskID: "t1", StartedAt: now.Add(-time.Second), EndedAt: now, Status: TaskRunSuccess, Output: "done"}); err != nil {
+		t.Fatal(err)
+	}
+
+	server := startTestIPC(t)
+	api := NewControlAPI(db, orch, queue)
+	changed := 0
+	api.SetTaskNotifier(func() { changed++ })
+	api.Register(server)
+	client := NewIPCClient(server.Path())
+	defer client.Close()
+
+	var info TaskInfo
+	if err := client.Invoke("tasks.pause", map[string]string{"task_id": "t1"}, &info); err != nil || info.Status != TaskPaused {
+		t.Fatalf("pause = %+v, %v", info, err)
+	}
+	if got, _ := db.GetTask("t1"); got.Status != TaskPaused {
+		t.Errorf("status in db = %s", got.Status)
+	}
+	// 暂停的任务不能立即执行
+	var rpcErr *RPCError
+	if err := client.Invoke("tasks.trigger", map[string]string{"task_id": "t1"}, nil); !errors.As(err, &rpcErr) || rpcErr.Code != RPCInvalidParams {
+		t.Errorf("trigger paused task: %v", err)
+	}
+	if err := client.Invoke("tasks.resume", map[string]string{"task_id": "t1"}, &info); err != nil || info.Status != TaskActive {
+		t.Fatalf("resume = %+v, %v", info, err)
+	}
+	if err := client.Invoke("tasks.trigger", map[string]string{"task_id": "t1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetTask("t1"); got.NextRun == nil || got.NextRun.After(time.Now()) {
+		t.Errorf("next run after trigger = %v", got.NextRun)
+	}
+
+	var runs []TaskRunInfo
+	if err := client.Invoke("tasks.runs", map[string]any{"task_id": "t1", "limit": 5}, &runs); err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 || runs[0].Output != "done" || runs[0].ChatJID != "main@nanoclaw" || runs[0].RunID == 0 {
+		t.Errorf("runs = %+v", runs)
+	}
+
+	if err := client.Invoke("tasks.delete", map[string]string{"task_id": "t1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetTask("t1"); err == nil {
+		t.Error("task not deleted")
+	}
+	if changed != 4 {
+		t.Errorf("scheduler notified %d times, want 4", changed)
+	}
+
+	for _, method := range []string{"tasks.pause", "tasks.delete", "tasks.runs"} {
+		if err := client.Invoke(method, map[string]string{"task_id": "t1"}, nil); !errors.As(err, &rpcErr) || rpcErr.Code != RPCInvalidParams {
+			t.Errorf("%s unknown task: %v", method, err)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+)
+
+// PeerCred IPC连接对端进程的凭据（SO_PEERCRED），GID 为对端的主组
+type PeerCred struct {
+	UID uint32
+	GID uint32
+	PID int32
+}
+
+// IPCPolicy IPC访问策略
+//
+// 服务进程自身的用户不受限制；其他对端按顺序匹配第一条 uid（或 gid）相同的规则，
+// 没有匹配的规则时拒绝全部请求。策略文件为JSON：
+//
+//	{"rules": [
+//	  {"uid": 0, "methods": ["*"], "groups": ["*"]},
+//	  {"uid": 1001, "methods": ["messages.*", "groups.list"], "groups": ["main"]},
+//	  {"gid": 1002, "methods": ["queue.status"]}
+//	]}
+type IPCPolicy struct {
+	Rules []IPCRule `json:"rules"`
+}
+
+// IPCRule 一条策略规则
+//
+// Methods 和 Groups 支持 "*"（全部）和 "messages.*" 形式的前缀；
+// Groups 为空时不能访问任何群组，只能调用与群组无关的方法。
+type IPCRule struct {
+	UID     *uint32  `json:"uid,omitempty"`
+	GID     *uint32  `json:"gid,omitempty"`
+	Methods []string `json:"methods"`
+	Groups  []string `json:"groups,omitempty"`
+}
+
+// LoadIPCPolicy 读取策略文件，文件不存在时返回空策略（只允许服务进程自身的用户）
+func LoadIPCPolicy(path string) (*IPCPolicy, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &IPCPolicy{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var p IPCPolicy
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &p, nil
+}
+
+// Validate 检查每条规则只按 uid 或 gid 之一匹配，并且至少允许一个方法
+func (p *IPCPolicy) Validate() error {
+	for i, r := range p.Rules {
+		if (r.UID == nil) == (r.GID == nil) {
+			return fmt.Errorf("rule %d: exactly one of uid and gid is required", i+1)
+		}
+		if len(r.Methods) == 0 {
+			return fmt.Errorf("rule %d: methods is required", i+1)
+		}
+	}
+	return nil
+}
+
+// Rule 返回匹配对端的第一条规则，没有时返回 nil
+func (p *IPCPolicy) Rule(peer *PeerCred) *IPCRule {
+	if p == nil || peer == nil {
+		return nil
+	}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if (r.UID != nil && *r.UID == peer.UID) || (r.GID != nil && *r.GID == peer.GID) {
+			return r
+		}
+	}
+	return nil
+}
+
+// AllowMethod 规则是否允许调用方法
+func (r *IPCRule) AllowMethod(method string) bool {
+	return r != nil && matchAny(r.Methods, method)
+}
+
+// AllowGroup 规则是否允许访问群组
+func (r *IPCRule) AllowGroup(folder string) bool {
+	return r != nil && matchAny(r.Groups, folder)
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if p == "*" || p == s || (strings.HasSuffix(p, ".*") && strings.HasPrefix(s, p[:len(p)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+// access 返回连接适用的规则；unrestricted 表示未设置策略或对端为服务进程自身的用户
+func (c *RPCConn) access() (rule *IPCRule, unrestricted bool) {
+	policy, owner := c.server.accessPolicy()
+	if policy == nil || (c.peer != nil && c.peer.UID == owner) {
+		return nil, true
+	}
+	return policy.Rule(c.peer), false
+}
+
+// authorizeMethod 检查连接能否调用方法，拒绝时记录日志
+func (c *RPCConn) authorizeMethod(method string) error {
+	if rule, all := c.access(); all || rule.AllowMethod(method) {
+		return nil
+	}
+	c.logDenied("method", method)
+	return &RPCError{Code: RPCUnauthorized, Message: "permission denied: " + method}
+}
+
+// allowGroup 连接能否访问群组（不记录日志，用于过滤列表）
+func (c *RPCConn) allowGroup(folder string) bool {
+	rule, all := c.access()
+	return all || rule.AllowGroup(folder)
+}
+
+func (c *RPCConn) logDenied(kind, name string) {
+	attrs := []any{kind, name}
+	if c.peer != nil {
+		attrs = append(attrs, "uid", c.peer.UID, "gid", c.peer.GID, "pid", c.peer.PID)
+	} else {
+		attrs = append(attrs, "peer", "unknown")
+	}
+	slog.Warn("ipc access denied", attrs...)
+}
+
+// authorizeGroup 检查当前请求能否访问群组，拒绝时记录日志；HTTP请求按令牌授权，不在IPC或HTTP请求中时总是允许
+func authorizeGroup(ctx context.Context, folder string) error {
+	if groupAllowed(ctx, folder) {
+		return nil
+	}
+	if t := TokenFromContext(ctx); t != nil {
+		slog.Warn("http access denied", "group", folder, "token", t.Name)
+	} else {
+		ConnFromContext(ctx).logDenied("group", folder)
+	}
+	return &RPCError{Code: RPCUnauthorized, Message: "permission denied: group " + folder}
+}
+
+// groupAllowed 当前请求能否访问群组，用于过滤列表结果
+func groupAllowed(ctx context.Context, folder string) bool {
+	if t := TokenFromContext(ctx); t != nil {
+		return t.AllowGroup(folder)
+	}
+	c := ConnFromContext(ctx)
+	return c == nil || c.allowGroup(folder)
+}
+
+// requestSender 当前请求发送消息时的发送者：HTTP请求为令牌名称，受访问策略限制的IPC对端为 uid:<uid>，
+// 只有不受限制的调用方（服务进程自身的用户，如 nanoclaw attach）可以用 requested 指定名称
+func requestSender(ctx context.Context, requested string) string {
+	if t := TokenFromContext(ctx); t != nil {
+		return t.Name
+	}
+	if c := ConnFromContext(ctx); c != nil {
+		if _, all := c.access(); !all {
+			if c.peer != nil {
+				return fmt.Sprintf("uid:%d", c.peer.UID)
+			}
+			return "ipc"
+		}
+	}
+	if requested == "" {
+		return "ipc"
+	}
+	return requested
+}
+
+// restricted 当前请求是否受访问策略或令牌限制
+func restricted(ctx context.Context) bool {
+	if t := TokenFromContext(ctx); t != nil {
+		return !slices.Contains(t.Groups, "*")
+	}
+	if c := ConnFromContext(ctx); c != nil {
+		_, all := c.access()
+		return !all
+	}
+	return false
+}
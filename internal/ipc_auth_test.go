@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadIPCPolicy(t *testing.T) {
+	dir := t.TempDir()
+	p, err := LoadIPCPolicy(filepath.Join(dir, "missing.json"))
+	if err != nil || p == nil || len(p.Rules) != 0 {
+		t.Fatalf("missing file: %+v, %v", p, err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"valid", `{"rules":[{"uid":1000,"methods":["*"],"groups":["*"]},{"gid":50,"methods":["queue.status"]}]}`, ""},
+		{"no principal", `{"rules":[{"methods":["*"]}]}`, "exactly one of uid and gid"},
+		{"both principals", `{"rules":[{"uid":1,"gid":1,"methods":["*"]}]}`, "exactly one of uid and gid"},
+		{"no methods", `{"rules":[{"uid":1}]}`, "methods is required"},
+		{"unknown field", `{"rules":[{"user":1,"methods":["*"]}]}`, "unknown field"},
+		{"bad json", `{"rules":`, "parse"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name+".json")
+		os.WriteFile(path, []byte(tt.content), 0644)
+		_, err := LoadIPCPolicy(path)
+		if tt.wantErr == "" && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestIPCPolicy_Rule(t *testing.T) {
+	uid, gid := uint32(1001), uint32(50)
+	p := &IPCPolicy{Rules: []IPCRule{
+		{UID: &uid, Methods: []string{"messages.*", "groups.list"}, Groups: []string{"main"}},
+		{GID: &gid, Methods: []string{"*"}, Groups: []string{"*"}},
+	}}
+	tests := []struct {
+		peer          *PeerCred
+		method, group string
+		wantMethod    bool
+		wantGroup     bool
+	}{
+		{&PeerCred{UID: 1001, GID: 50}, "messages.send", "main", true, true},
+		{&PeerCred{UID: 1001, GID: 50}, "messages", "other", false, false}, // 第一条匹配的规则生效
+		{&PeerCred{UID: 1001}, "groups.list", "main", true, true},
+		{&PeerCred{UID: 1001}, "tasks.list", "main", false, true},
+		{&PeerCred{UID: 2000, GID: 50}, "tasks.list", "other", true, true},
+		{&PeerCred{UID: 2000, GID: 60}, "groups.list", "main", false, false},
+		{nil, "groups.list", "main", false, false},
+	}
+	for _, tt := range tests {
+		r := p.Rule(tt.peer)
+		if got := r.AllowMethod(tt.method); got != tt.wantMethod {
+			t.Errorf("%+v %s: AllowMethod = %v", tt.peer, tt.method, got)
+		}
+		if got := r.AllowGroup(tt.group); got != tt.wantGroup {
+			t.Errorf("%+v %s: AllowGroup = %v", tt.peer, tt.group, got)
+		}
+	}
+}
@@ -1,8 +1,16 @@
 package internal
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -54,7 +62,7 @@ func TestIPCServerXSkipFail_ClientServer(t *testing.T) {
 	})
 	
 	// 在后台启动服务器
-	go server.Start()
+	go server.Start(context.Background())
 	
 	// 等待服务器启动
 	time.Sleep(100 * time.Millisecond)
@@ -119,7 +127,7 @@ func TestIPCServerXSkipFail_MultipleClients(t *testing.T) {
 	})
 	
 	// 在后台启动服务器
-	go server.Start()
+	go server.Start(context.Background())
 	time.Sleep(100 * time.Millisecond)
 	
 	// 多个客户端并发请求
@@ -159,3 +167,327 @@ func TestIsRoot(t *testing.T) {
 	result := IsRoot()
 	t.Logf("IsRoot() = %v", result)
 }
+
+// startTestIPC 启动测试用服务器并返回socket路径
+func startTestIPC(t *testing.T) *IPCServer {
+	t.Helper()
+	server, err := NewIPCServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Stop() })
+	go server.Start(context.Background())
+	return server
+}
+
+func TestIPCServer_JSONRPC(t *testing.T) {
+	server := startTestIPC(t)
+	server.Handle("math.add", func(_ context.Context, params json.RawMessage) (any, error) {
+		var p struct{ A, B int }
+		if err := BindParams(params, &p); err != nil {
+			return nil, err
+		}
+		return p.A + p.B, nil
+	})
+	server.Handle("fail", func(context.Context, json.RawMessage) (any, error) {
+		return nil, errors.New("boom")
+	})
+	var notified atomic.Int32
+	server.Handle("notify", func(context.Context, json.RawMessage) (any, error) {
+		notified.Add(1)
+		return nil, nil
+	})
+
+	conn, err := net.Dial("unix", server.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	// 同一连接上依次发送多个请求
+	tests := []struct {
+		name string
+		req  string
+		want string // 响应中应包含的片段
+	}{
+		{"result", `{"jsonrpc":"2.0","id":1,"method":"math.add","params":{"a":2,"b":3}}`, `"id":1,"result":5`},
+		{"string id", `{"jsonrpc":"2.0","id":"x","method":"math.add","params":{"a":1}}`, `"id":"x","result":1`},
+		{"method not found", `{"jsonrpc":"2.0","id":2,"method":"nope"}`, `"code":-32601`},
+		{"invalid params", `{"jsonrpc":"2.0","id":3,"method":"math.add","params":{"a":"x"}}`, `"code":-32602`},
+		{"unknown field", `{"jsonrpc":"2.0","id":4,"method":"math.add","params":{"c":1}}`, `"code":-32602`},
+		{"handler error", `{"jsonrpc":"2.0","id":5,"method":"fail"}`, `"code":-32000,"message":"boom"`},
+		{"missing version", `{"id":6,"method":"math.add"}`, `"id":null,"error":{"code":-32600`},
+		{"batch", `[{"jsonrpc":"2.0","id":7,"method":"math.add","params":{"a":1,"b":1}},{"jsonrpc":"2.0","method":"notify"},{"jsonrpc":"2.0","id":8,"method":"nope"}]`,
+			`[{"jsonrpc":"2.0","id":7,"result":2},{"jsonrpc":"2.0","id":8,"error":{"code":-32601`},
+		{"empty batch", `[]`, `"code":-32600`},
+	}
+	for _, tt := range tests {
+		if _, err := conn.Write([]byte(tt.req + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !strings.Contains(line, tt.want) {
+			t.Errorf("%s: response %s, want %s", tt.name, strings.TrimSpace(line), tt.want)
+		}
+	}
+
+	// 通知没有响应，紧随其后的请求得到自己的响应
+	conn.Write([]byte(`{"jsonrpc":"2.0","method":"notify"}` + "\n" + `{"jsonrpc":"2.0","id":9,"method":"math.add"}` + "\n"))
+	line, _ := reader.ReadString('\n')
+	if !strings.Contains(line, `"id":9,"result":0`) {
+		t.Errorf("after notification: %s", line)
+	}
+	if n := notified.Load(); n != 2 {
+		t.Errorf("notifications handled = %d, want 2", n)
+	}
+
+	// 解析错误后关闭连接
+	conn.Write([]byte("{not json}\n"))
+	line, _ = reader.ReadString('\n')
+	if !strings.Contains(line, `"code":-32700`) {
+		t.Errorf("parse error response: %s", line)
+	}
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Error("connection should be closed after a parse error")
+	}
+}
+
+func TestIPCClient_Invoke(t *testing.T) {
+	server := startTestIPC(t)
+	server.Handle("echo", func(_ context.Context, params json.RawMessage) (any, error) {
+		var p map[string]string
+		if err := BindParams(params, &p); err != nil {
+			return nil, err
+		}
+		return p, nil
+	})
+
+	client := NewIPCClient(server.Path())
+	defer client.Close()
+	for i := 0; i < 3; i++ {
+		var got map[string]string
+		if err := client.Invoke("echo", map[string]string{"n": strconv.Itoa(i)}, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got["n"] != strconv.Itoa(i) {
+			t.Errorf("echo = %v", got)
+		}
+	}
+
+	var rpcErr *RPCError
+	if err := client.Invoke("missing", nil, nil); !errors.As(err, &rpcErr) || rpcErr.Code != RPCMethodNotFound {
+		t.Errorf("missing method err = %v", err)
+	}
+	// 错误响应不影响连接复用
+	if err := client.Invoke("echo", nil, nil); err != nil {
+		t.Errorf("after error: %v", err)
+	}
+}
+
+func TestIPCServer_StartReturns(t *testing.T) {
+	// 停止服务器或取消 ctx 后 Start 返回，不再循环
+	for _, viaCtx := range []bool{false, true} {
+		server, err := NewIPCServer(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- server.Start(ctx) }()
+		if viaCtx {
+			cancel()
+		} else {
+			server.Stop()
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Start = %v", err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Start did not return (ctx=%v)", viaCtx)
+		}
+		cancel()
+		server.Stop()
+	}
+}
+
+// startLimitedIPC 启动使用给定限制的服务器，slow 方法阻塞到 release 关闭
+func startLimitedIPC(t *testing.T, limits IPCLimits) (server *IPCServer, release chan struct{}) {
+	t.Helper()
+	server, err := NewIPCServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.SetLimits(limits)
+	server.SetConcurrency(4)
+	release = make(chan struct{})
+	server.Handle("slow", func(ctx context.Context, _ json.RawMessage) (any, error) {
+		select {
+		case <-release:
+			return "done", nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+	server.Handle("ping", func(context.Context, json.RawMessage) (any, error) { return "pong", nil })
+	t.Cleanup(func() { server.Stop() })
+	go server.Start(context.Background())
+	return server, release
+}
+
+func TestIPCServer_Limits(t *testing.T) {
+	server, release := startLimitedIPC(t, IPCLimits{MaxMessageSize: 64, MaxConns: 2, IdleTimeout: 100 * time.Millisecond})
+	defer close(release)
+	dial := func() (net.Conn, *bufio.Reader) {
+		conn, err := net.Dial("unix", server.Path())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn, bufio.NewReader(conn)
+	}
+	closed := func(r *bufio.Reader) bool {
+		_, err := r.ReadString('\n')
+		return err != nil
+	}
+
+	// 超过大小限制：回复错误并关闭
+	conn, r := dial()
+	conn.Write([]byte(`{"jsonrpc":"2.0","id":1,"method":"ping","params":"` + strings.Repeat("x", 100) + `"}` + "\n"))
+	if line, _ := r.ReadString('\n'); !strings.Contains(line, "message too large") {
+		t.Errorf("oversized request: %s", line)
+	}
+	if !closed(r) {
+		t.Error("connection should be closed after an oversized request")
+	}
+
+	// 空闲连接被关闭，等待中的请求不算空闲
+	idle, idleR := dial()
+	busy, busyR := dial()
+	busy.Write([]byte(`{"jsonrpc":"2.0","id":1,"method":"slow"}` + "\n"))
+	start := time.Now()
+	if !closed(idleR) {
+		t.Error("idle connection should be closed")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("idle close took %s", d)
+	}
+	idle.Close()
+
+	// 连接数已满（busy 和下面的 c2）时拒绝新连接
+	time.Sleep(300 * time.Millisecond)
+	c2, r2 := dial()
+	c2.Write([]byte(`{"jsonrpc":"2.0","id":1,"method":"slow"}` + "\n"))
+	time.Sleep(50 * time.Millisecond)
+	_, r3 := dial()
+	if line, _ := r3.ReadString('\n'); !strings.Contains(line, "too many connections") {
+		t.Errorf("over connection limit: %s", line)
+	}
+
+	release <- struct{}{}
+	release <- struct{}{}
+	for _, r := range []*bufio.Reader{busyR, r2} {
+		if line, _ := r.ReadString('\n'); !strings.Contains(line, `"result":"done"`) {
+			t.Errorf("slow request on busy connection: %s", line)
+		}
+	}
+}
+
+func TestIPCServer_Shutdown(t *testing.T) {
+	server, release := startLimitedIPC(t, DefaultIPCLimits)
+	client := NewIPCClient(server.Path())
+	defer client.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		var got string
+		err := client.Invoke("slow", nil, &got)
+		if err == nil && got != "done" {
+			err = errors.New("result " + got)
+		}
+		result <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	shutdown := make(chan error, 1)
+	go func() { shutdown <- server.Shutdown(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown returned before the request finished: %v", err)
+	default:
+	}
+	// 停止期间不再接受连接
+	if _, err := net.Dial("unix", server.Path()); err == nil {
+		t.Error("dial succeeded during shutdown")
+	}
+
+	close(release)
+	if err := <-result; err != nil {
+		t.Errorf("in-flight request: %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown = %v", err)
+	}
+}
+
+func TestIPCServer_ShutdownTimeout(t *testing.T) {
+	server, _ := startLimitedIPC(t, DefaultIPCLimits)
+	client := NewIPCClient(server.Path())
+	defer client.Close()
+	result := make(chan error, 1)
+	go func() { result <- client.Invoke("slow", nil, nil) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// 到期后强制关闭，处理函数的 ctx 被取消
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v", err)
+	}
+	if err := <-result; err == nil {
+		t.Error("request should fail after forced shutdown")
+	}
+}
+
+func TestIPCClient_OnDisconnect(t *testing.T) {
+	server := startTestIPC(t)
+	server.Handle("ping", func(context.Context, json.RawMessage) (any, error) { return "pong", nil })
+
+	client := NewIPCClient(server.Path())
+	disconnected := make(chan error, 1)
+	client.SetOnDisconnect(func(err error) { disconnected <- err })
+	if err := client.Invoke("ping", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 服务端关闭连接
+	server.Stop()
+	select {
+	case err := <-disconnected:
+		if err == nil {
+			t.Error("disconnect error is nil")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnDisconnect not called")
+	}
+
+	// 主动关闭不回调
+	server2 := startTestIPC(t)
+	server2.Handle("ping", func(context.Context, json.RawMessage) (any, error) { return "pong", nil })
+	client = NewIPCClient(server2.Path())
+	client.SetOnDisconnect(func(err error) { disconnected <- err })
+	if err := client.Invoke("ping", nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	client.Close()
+	select {
+	case err := <-disconnected:
+		t.Errorf("OnDisconnect called after Close: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
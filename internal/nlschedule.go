@@ -0,0 +1,692 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Schedule 结构化调度，对应 Task 的 ScheduleType 和 ScheduleValue
+type Schedule struct {
+	Type  string
+	Value string
+}
+
+// ParseSchedule 将常见的中英文时间描述解析为调度，按 now 所在时区计算
+//
+// 英文示例："every 5 minutes"、"every weekday at 9am"、"every mon, wed at 18:30"、
+// "every month on the 1st at 9"、"in 2 hours"、"tomorrow at 9"、"next friday 5pm"；
+// 中文示例："每5分钟"、"每个工作日早上9点"、"每周一三五下午3点半"、"每月1号9点"、
+// "半小时后"、"明天上午10点"、"下周一9点"、"3月5号晚上8点"。
+// 给出日期但没有时刻时默认为 09:00；一次性时间转换为带偏移的RFC3339。
+func ParseSchedule(text string, now time.Time) (Schedule, error) {
+	s := strings.ToLower(strings.Join(strings.Fields(text), " "))
+	s = strings.TrimRight(s, "。.!！?？")
+	var (
+		sched Schedule
+		ok    bool
+	)
+	if strings.IndexFunc(s, func(r rune) bool { return unicode.Is(unicode.Han, r) }) >= 0 {
+		sched, ok = parseZHSchedule(s, now)
+	} else {
+		sched, ok = parseENSchedule(s, now)
+	}
+	if !ok {
+		return Schedule{}, fmt.Errorf("unrecognized schedule %q", text)
+	}
+	return sched, nil
+}
+
+// clock 一天中的时刻
+type clock struct{ h, m int }
+
+var defaultClock = clock{9, 0}
+
+func cronAt(c clock, dom, dow string) Schedule {
+	return Schedule{Type: "cron", Value: fmt.Sprintf("%d %d %s * %s", c.m, c.h, dom, dow)}
+}
+
+func onceAt(t time.Time) Schedule {
+	return Schedule{Type: "once", Value: t.Format(time.RFC3339)}
+}
+
+// intervalOf 以最简形式表示间隔，如 90m 而不是 1h30m0s
+func intervalOf(d time.Duration) Schedule {
+	switch {
+	case d%time.Hour == 0:
+		return Schedule{Type: "interval", Value: fmt.Sprintf("%dh", d/time.Hour)}
+	case d%time.Minute == 0:
+		return Schedule{Type: "interval", Value: fmt.Sprintf("%dm", d/time.Minute)}
+	}
+	return Schedule{Type: "interval", Value: fmt.Sprintf("%ds", d/time.Second)}
+}
+
+// dateAt 返回 day 当天的 c 时刻；c.h 为24表示当天结束时的午夜，即第二天0点
+func dateAt(day time.Time, c clock) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), c.h, c.m, 0, 0, day.Location())
+}
+
+// nextClock 返回 now 之后最近的 c 时刻（今天或明天）
+func nextClock(now time.Time, c clock) time.Time {
+	t := dateAt(now, c)
+	if !t.After(now) {
+		t = dateAt(now.AddDate(0, 0, 1), c)
+	}
+	return t
+}
+
+// nextCronDay 将cron的日期和星期字段顺延一天，用于"晚上12点"这类落在第二天0点的周期任务；
+// 每月31号顺延后无法表示，返回 false
+func nextCronDay(dom, dow string) (string, string, bool) {
+	if dom != "*" {
+		d, err := strconv.Atoi(dom)
+		if err != nil || d >= 31 {
+			return "", "", false
+		}
+		dom = strconv.Itoa(d + 1)
+	}
+	if dow == "*" {
+		return dom, dow, true
+	}
+	var days []int
+	for _, part := range strings.Split(dow, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		lo, err1 := strconv.Atoi(from)
+		hi, err2 := lo, error(nil)
+		if isRange {
+			hi, err2 = strconv.Atoi(to)
+		}
+		if err1 != nil || err2 != nil {
+			return "", "", false
+		}
+		for d := lo; d <= hi; d++ {
+			days = append(days, (d+1)%7)
+		}
+	}
+	return dom, dowList(days), true
+}
+
+// nextWeekday 返回 now 之后最近的星期 wd 的 c 时刻
+func nextWeekday(now time.Time, wd time.Weekday, c clock) time.Time {
+	t := dateAt(now.AddDate(0, 0, (int(wd)-int(now.Weekday())+7)%7), c)
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 7)
+	}
+	return t
+}
+
+// dowList 将星期集合格式化为cron的星期字段
+func dowList(days []int) string {
+	slices.Sort(days)
+	days = slices.Compact(days)
+	parts := make([]string, len(days))
+	for i, d := range days {
+		parts[i] = strconv.Itoa(d)
+	}
+	return strings.Join(parts, ",")
+}
+
+// --- English ---
+
+var (
+	enClockExpr = `(noon|midnight|\d{1,2}(?:[:.]\d{2})?\s*(?:am|pm|a\.m\.|p\.m\.)?)`
+	enUnitExpr  = `(seconds?|secs?|minutes?|mins?|hours?|hrs?|days?|weeks?)`
+
+	reENIn       = regexp.MustCompile(`^in\s+(\d+|an?|one|half an?|half)\s*` + enUnitExpr + `$`)
+	reENAt       = regexp.MustCompile(`^(.*?)\s*\bat\s+` + enClockExpr + `$`)
+	reENTail     = regexp.MustCompile(`^(.*?)\s*\b` + enClockExpr + `$`)
+	reENClock    = regexp.MustCompile(`^(\d{1,2})(?:[:.](\d{2}))?\s*(am|pm|a\.m\.|p\.m\.)?$`)
+	reENEvery    = regexp.MustCompile(`^(?:every|each)\s+(.+)$`)
+	reENInterval = regexp.MustCompile(`^(\d+\s*|an?\s+|one\s+|other\s+)?` + enUnitExpr + `$`)
+	reENMonthly  = regexp.MustCompile(`^month(?:\s+on)?(?:\s+the)?\s+(\d{1,2})(?:st|nd|rd|th)?$`)
+	reENDate     = regexp.MustCompile(`^(?:on\s+)?(\d{4}-\d{1,2}-\d{1,2})$`)
+	reENDaySep   = regexp.MustCompile(`\s*(?:,|&|/|\band\b)\s*|\s+`)
+)
+
+// enAliases 改写为 "every ..." 形式的前缀
+var enAliases = [][2]string{
+	{"daily", "every day"},
+	{"hourly", "every hour"},
+	{"weekly on ", "every "},
+	{"monthly", "every month"},
+	{"on weekdays", "every weekday"},
+	{"weekdays", "every weekday"},
+	{"on weekends", "every weekend"},
+	{"weekends", "every weekend"},
+}
+
+var enWeekdays = map[string]time.Weekday{
+	"sunday": time.Sunday, "sun": time.Sunday,
+	"monday": time.Monday, "mon": time.Monday,
+	"tuesday": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday,
+	"wednesday": time.Wednesday, "wed": time.Wednesday,
+	"thursday": time.Thursday, "thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday,
+	"friday": time.Friday, "fri": time.Friday,
+	"saturday": time.Saturday, "sat": time.Saturday,
+}
+
+// enPeriods 没有给出时刻时各时段的默认时刻
+var enPeriods = map[string]clock{
+	"morning": {9, 0}, "afternoon": {15, 0}, "evening": {18, 0}, "night": {21, 0},
+}
+
+func parseENSchedule(s string, now time.Time) (Schedule, bool) {
+	for _, a := range enAliases {
+		if strings.HasPrefix(s, a[0]) {
+			s = a[1] + s[len(a[0]):]
+			break
+		}
+	}
+
+	if m := reENIn.FindStringSubmatch(s); m != nil {
+		d, ok := enDuration(m[1], m[2])
+		if !ok {
+			return Schedule{}, false
+		}
+		return onceAt(now.Add(d).Truncate(time.Second)), true
+	}
+
+	// 先尝试拆出末尾的时刻，失败时把整句作为日期部分
+	for _, split := range []bool{true, false} {
+		body, c, hasClock := s, defaultClock, false
+		if split {
+			m := reENAt.FindStringSubmatch(s)
+			if m == nil {
+				m = reENTail.FindStringSubmatch(s)
+			}
+			if m == nil {
+				continue
+			}
+			var ok bool
+			if c, ok = parseENClock(m[2]); !ok {
+				continue
+			}
+			body, hasClock = m[1], true
+		}
+		if m := reENEvery.FindStringSubmatch(body); m != nil {
+			if sched, ok := parseENRecurring(m[1], c, hasClock); ok {
+				return sched, true
+			}
+			continue
+		}
+		if t, ok := parseENDay(body, now, c, hasClock); ok {
+			return onceAt(t), true
+		}
+	}
+	return Schedule{}, false
+}
+
+func parseENClock(s string) (clock, bool) {
+	switch s {
+	case "noon":
+		return clock{12, 0}, true
+	case "midnight":
+		return clock{0, 0}, true
+	}
+	m := reENClock.FindStringSubmatch(s)
+	if m == nil {
+		return clock{}, false
+	}
+	h, _ := strconv.Atoi(m[1])
+	min := 0
+	if m[2] != "" {
+		min, _ = strconv.Atoi(m[2])
+	}
+	switch strings.ReplaceAll(m[3], ".", "") {
+	case "am":
+		if h < 1 || h > 12 {
+			return clock{}, false
+		}
+		h %= 12
+	case "pm":
+		if h < 1 || h > 12 {
+			return clock{}, false
+		}
+		h = h%12 + 12
+	}
+	if h > 23 || min > 59 {
+		return clock{}, false
+	}
+	return clock{h, min}, true
+}
+
+func enDuration(count, unit string) (time.Duration, bool) {
+	var d time.Duration
+	switch strings.TrimSuffix(unit, "s") {
+	case "second", "sec":
+		d = time.Second
+	case "minute", "min":
+		d = time.Minute
+	case "hour", "hr":
+		d = time.Hour
+	case "day":
+		d = 24 * time.Hour
+	case "week":
+		d = 7 * 24 * time.Hour
+	default:
+		return 0, false
+	}
+	count = strings.TrimSpace(count)
+	switch count {
+	case "", "a", "an", "one":
+		return d, true
+	case "other":
+		return 2 * d, true
+	case "half", "half a", "half an":
+		return d / 2, true
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return time.Duration(n) * d, true
+}
+
+// parseENRecurring 解析 every 之后的部分
+func parseENRecurring(body string, c clock, hasClock bool) (Schedule, bool) {
+	if m := reENInterval.FindStringSubmatch(body); m != nil {
+		d, ok := enDuration(m[1], m[2])
+		if !ok {
+			return Schedule{}, false
+		}
+		if !hasClock {
+			return intervalOf(d), true
+		}
+		if d == 24*time.Hour {
+			return cronAt(c, "*", "*"), true
+		}
+		return Schedule{}, false
+	}
+	if m := reENMonthly.FindStringSubmatch(body); m != nil {
+		dom, _ := strconv.Atoi(m[1])
+		if dom < 1 || dom > 31 {
+			return Schedule{}, false
+		}
+		return cronAt(c, strconv.Itoa(dom), "*"), true
+	}
+	if p, ok := enPeriods[body]; ok {
+		if !hasClock {
+			c = p
+		}
+		return cronAt(c, "*", "*"), true
+	}
+	switch body {
+	case "weekday", "weekdays", "workday", "workdays", "business day", "business days":
+		return cronAt(c, "*", "1-5"), true
+	case "weekend", "weekends", "weekend day":
+		return cronAt(c, "*", "0,6"), true
+	}
+
+	var days []int
+	for _, name := range reENDaySep.Split(body, -1) {
+		if name == "" {
+			continue
+		}
+		wd, ok := enWeekdays[strings.TrimSuffix(name, "s")]
+		if !ok {
+			if wd, ok = enWeekdays[name]; !ok {
+				return Schedule{}, false
+			}
+		}
+		days = append(days, int(wd))
+	}
+	if len(days) == 0 {
+		return Schedule{}, false
+	}
+	return cronAt(c, "*", dowList(days)), true
+}
+
+// parseENDay 解析一次性日期：today/tonight/tomorrow/星期名/YYYY-MM-DD，或只有时刻
+func parseENDay(body string, now time.Time, c clock, hasClock bool) (time.Time, bool) {
+	switch body {
+	case "":
+		if !hasClock {
+			return time.Time{}, false
+		}
+		return nextClock(now, c), true
+	case "today":
+		return dateAt(now, c), hasClock
+	case "tonight":
+		switch {
+		case !hasClock:
+			c = clock{20, 0}
+		case c.h == 0 || c.h == 12:
+			c.h = 24 // 午夜，即明天0点
+		case c.h < 12:
+			c.h += 12
+		}
+		return dateAt(now, c), true
+	case "tomorrow":
+		return dateAt(now.AddDate(0, 0, 1), c), true
+	case "day after tomorrow", "the day after tomorrow":
+		return dateAt(now.AddDate(0, 0, 2), c), true
+	}
+	if m := reENDate.FindStringSubmatch(body); m != nil {
+		day, err := time.ParseInLocation("2006-1-2", m[1], now.Location())
+		if err != nil {
+			return time.Time{}, false
+		}
+		return dateAt(day, c), true
+	}
+	for _, prefix := range []string{"on ", "next ", "this "} {
+		body = strings.TrimPrefix(body, prefix)
+	}
+	if wd, ok := enWeekdays[body]; ok {
+		return nextWeekday(now, wd, c), true
+	}
+	return time.Time{}, false
+}
+
+// --- 中文 ---
+
+const (
+	zhNumExpr = `([\d零〇一二两三四五六七八九十]+)`
+	zhDayExpr = `[一二三四五六日天1-7]`
+	zhWeek    = `(?:周|星期|礼拜)`
+	zhUnit    = `(秒钟?|分钟?|小时|钟头|天|日|周|星期|礼拜)`
+)
+
+var (
+	reZHEvery     = regexp.MustCompile(`^每(?:隔)?` + zhNumExpr + `?个?(半)?个?` + zhUnit + `$`)
+	reZHIn        = regexp.MustCompile(`^过?` + zhNumExpr + `?个?(半)?个?` + zhUnit + `(?:后|以后|之后)$`)
+	reZHDaily     = regexp.MustCompile(`^(?:每天|每日|天天)(.*)$`)
+	reZHWorkday   = regexp.MustCompile(`^(?:每个?|每逢)?工作日(?:每天)?(.*)$`)
+	reZHWeekend   = regexp.MustCompile(`^(?:每个?|每逢)周末(.*)$`)
+	reZHWeekRange = regexp.MustCompile(`^(?:每个?)?` + zhWeek + `(` + zhDayExpr + `)(?:到|至|-)` + zhWeek + `?(` + zhDayExpr + `)(?:每天)?(.*)$`)
+	reZHWeekly    = regexp.MustCompile(`^每个?` + zhWeek + `((?:` + zhDayExpr + `[、,和及与]?)+)(.*)$`)
+	reZHMonthly   = regexp.MustCompile(`^每个?月` + zhNumExpr + `(?:号|日)(.*)$`)
+	reZHRelDay    = regexp.MustCompile(`^(今天|今早|今晚|明天|明早|明晚|后天|大后天)(.*)$`)
+	reZHWeekday   = regexp.MustCompile(`^(下下|下个?|这个?|本)?` + zhWeek + `(` + zhDayExpr + `)(.*)$`)
+	reZHDate      = regexp.MustCompile(`^(?:(\d{4})年)?` + zhNumExpr + `月` + zhNumExpr + `(?:号|日)(.*)$`)
+	reZHISODate   = regexp.MustCompile(`^(\d{4}-\d{1,2}-\d{1,2})(.*)$`)
+	reZHClock     = regexp.MustCompile(`^(凌晨|早上|早晨|清晨|上午|中午|下午|傍晚|晚上|夜里|半夜)?(?:(\d{1,2})(?:点钟?|时|:)(?:(\d{1,2})分?|(半)|(1|3)刻)?)?$`)
+	reZHNumeral   = regexp.MustCompile(`[零〇一二两三四五六七八九十]+`)
+)
+
+var zhDigits = map[rune]int{'零': 0, '〇': 0, '一': 1, '二': 2, '两': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+
+// zhPeriods 时段的默认时刻
+var zhPeriods = map[string]clock{
+	"凌晨": {5, 0}, "早上": {9, 0}, "早晨": {9, 0}, "清晨": {7, 0}, "上午": {9, 0}, "中午": {12, 0},
+	"下午": {15, 0}, "傍晚": {18, 0}, "晚上": {20, 0}, "夜里": {22, 0}, "半夜": {0, 0},
+}
+
+// zhNumerals 将中文数字转换为阿拉伯数字："十五"→"15"，"二十三"→"23"，"零五"→"05"
+func zhNumerals(s string) string {
+	return reZHNumeral.ReplaceAllStringFunc(s, func(run string) string {
+		rs := []rune(run)
+		i := slices.Index(rs, '十')
+		if i < 0 {
+			var sb strings.Builder
+			for _, r := range rs {
+				sb.WriteString(strconv.Itoa(zhDigits[r]))
+			}
+			return sb.String()
+		}
+		tens, ones := 1, 0
+		if i > 0 {
+			tens = zhDigits[rs[i-1]]
+		}
+		if i+1 < len(rs) {
+			ones = zhDigits[rs[i+1]]
+		}
+		return strconv.Itoa(tens*10 + ones)
+	})
+}
+
+func zhAtoi(s string) (int, bool) {
+	n, err := strconv.Atoi(zhNumerals(s))
+	return n, err == nil
+}
+
+func zhWeekday(r rune) int {
+	switch r {
+	case '日', '天', '7':
+		return 0
+	case '1', '2', '3', '4', '5', '6':
+		return int(r - '0')
+	}
+	return zhDigits[r]
+}
+
+func zhDuration(count, half, unit string) (time.Duration, bool) {
+	var d time.Duration
+	switch strings.TrimSuffix(unit, "钟") {
+	case "秒":
+		d = time.Second
+	case "分":
+		d = time.Minute
+	case "小时", "钟头":
+		d = time.Hour
+	case "天", "日":
+		d = 24 * time.Hour
+	case "周", "星期", "礼拜":
+		d = 7 * 24 * time.Hour
+	default:
+		return 0, false
+	}
+	n := 1
+	if half != "" {
+		n = 0 // "半小时" 而不是 "一个半小时"
+	}
+	if count != "" {
+		var ok bool
+		if n, ok = zhAtoi(count); !ok || n <= 0 {
+			return 0, false
+		}
+	}
+	total := time.Duration(n) * d
+	if half != "" {
+		total += d / 2
+	}
+	return total, true
+}
+
+// parseZHClock 解析时刻部分，period 为日期中隐含的时段（如"今晚"）
+func parseZHClock(s, period string) (clock, bool) {
+	m := reZHClock.FindStringSubmatch(zhNumerals(strings.TrimSpace(s)))
+	if m == nil {
+		return clock{}, false
+	}
+	if m[1] != "" {
+		period = m[1]
+	}
+	if m[2] == "" {
+		if c, ok := zhPeriods[period]; ok {
+			return c, true
+		}
+		return defaultClock, true
+	}
+	h, _ := strconv.Atoi(m[2])
+	min := 0
+	switch {
+	case m[3] != "":
+		min, _ = strconv.Atoi(m[3])
+	case m[4] != "":
+		min = 30
+	case m[5] != "":
+		q, _ := strconv.Atoi(m[5])
+		min = q * 15
+	}
+	if h > 23 || min > 59 {
+		return clock{}, false
+	}
+	switch period {
+	case "下午", "傍晚":
+		if h < 12 {
+			h += 12
+		}
+	case "晚上", "夜里":
+		// 晚上12点是当天结束时的午夜，记为24点，由调用方顺延到第二天0点
+		if h <= 12 {
+			h += 12
+		}
+	case "中午":
+		if h < 11 {
+			h += 12
+		}
+	case "凌晨", "半夜":
+		if h == 12 {
+			h = 0
+		}
+	}
+	return clock{h, min}, true
+}
+
+// zhCompact 去掉空格，只保留两个数字之间的空格（"每周1,3,5 9点"）
+func zhCompact(s string) string {
+	rs := []rune(strings.NewReplacer("：", ":", "，", ",").Replace(s))
+	var sb strings.Builder
+	for i, r := range rs {
+		if r == ' ' && (i == 0 || i == len(rs)-1 || !unicode.IsDigit(rs[i-1]) || !unicode.IsDigit(rs[i+1])) {
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func parseZHSchedule(s string, now time.Time) (Schedule, bool) {
+	s = zhCompact(s)
+
+	if m := reZHEvery.FindStringSubmatch(s); m != nil {
+		d, ok := zhDuration(m[1], m[2], m[3])
+		return intervalOf(d), ok
+	}
+	if m := reZHIn.FindStringSubmatch(s); m != nil {
+		d, ok := zhDuration(m[1], m[2], m[3])
+		return onceAt(now.Add(d).Truncate(time.Second)), ok
+	}
+
+	// 周期任务
+	recurring := func(rest, dom, dow string) (Schedule, bool) {
+		c, ok := parseZHClock(rest, "")
+		if ok && c.h == 24 {
+			c.h = 0
+			if dom, dow, ok = nextCronDay(dom, dow); !ok {
+				return Schedule{}, false
+			}
+		}
+		return cronAt(c, dom, dow), ok
+	}
+	if m := reZHDaily.FindStringSubmatch(s); m != nil {
+		return recurring(m[1], "*", "*")
+	}
+	if m := reZHWorkday.FindStringSubmatch(s); m != nil {
+		return recurring(m[1], "*", "1-5")
+	}
+	if m := reZHWeekend.FindStringSubmatch(s); m != nil {
+		return recurring(m[1], "*", "0,6")
+	}
+	if m := reZHWeekRange.FindStringSubmatch(s); m != nil {
+		from, to := zhWeekday([]rune(m[1])[0]), zhWeekday([]rune(m[2])[0])
+		if from == 0 || to == 0 || from >= to {
+			return Schedule{}, false
+		}
+		return recurring(m[3], "*", fmt.Sprintf("%d-%d", from, to))
+	}
+	if m := reZHWeekly.FindStringSubmatch(s); m != nil {
+		days, rest := []rune(m[1]), m[2]
+		// "每周一三点"、"每周一10点"：最后一个字其实是小时
+		last := days[len(days)-1]
+		if len(days) > 1 && (strings.HasPrefix(rest, "点") || strings.HasPrefix(rest, "时") || strings.HasPrefix(rest, ":") ||
+			unicode.IsDigit(last) && rest != "" && unicode.IsDigit([]rune(rest)[0])) {
+			rest = string(days[len(days)-1]) + rest
+			days = days[:len(days)-1]
+		}
+		var dow []int
+		for _, r := range days {
+			if strings.ContainsRune("、,和及与", r) {
+				continue
+			}
+			dow = append(dow, zhWeekday(r))
+		}
+		return recurring(rest, "*", dowList(dow))
+	}
+	if m := reZHMonthly.FindStringSubmatch(s); m != nil {
+		dom, ok := zhAtoi(m[1])
+		if !ok || dom < 1 || dom > 31 {
+			return Schedule{}, false
+		}
+		return recurring(m[2], strconv.Itoa(dom), "*")
+	}
+
+	// 一次性任务
+	once := func(day time.Time, rest, period string) (Schedule, bool) {
+		c, ok := parseZHClock(rest, period)
+		return onceAt(dateAt(day, c)), ok
+	}
+	if m := reZHRelDay.FindStringSubmatch(s); m != nil {
+		switch m[1] {
+		case "今天":
+			return once(now, m[2], "")
+		case "今早":
+			return once(now, m[2], "早上")
+		case "今晚":
+			return once(now, m[2], "晚上")
+		case "明天":
+			return once(now.AddDate(0, 0, 1), m[2], "")
+		case "明早":
+			return once(now.AddDate(0, 0, 1), m[2], "早上")
+		case "明晚":
+			return once(now.AddDate(0, 0, 1), m[2], "晚上")
+		case "后天":
+			return once(now.AddDate(0, 0, 2), m[2], "")
+		case "大后天":
+			return once(now.AddDate(0, 0, 3), m[2], "")
+		}
+	}
+	if m := reZHWeekday.FindStringSubmatch(s); m != nil {
+		c, ok := parseZHClock(m[3], "")
+		if !ok {
+			return Schedule{}, false
+		}
+		wd := zhWeekday([]rune(m[2])[0])
+		if !strings.HasPrefix(m[1], "下") {
+			return onceAt(nextWeekday(now, time.Weekday(wd), c)), true
+		}
+		// 下周X：从本周一算起加一周（下下周再加一周）
+		monday := now.AddDate(0, 0, -((int(now.Weekday()) + 6) % 7))
+		offset := (wd + 6) % 7
+		weeks := 7
+		if m[1] == "下下" {
+			weeks = 14
+		}
+		return onceAt(dateAt(monday.AddDate(0, 0, weeks+offset), c)), true
+	}
+	if m := reZHDate.FindStringSubmatch(s); m != nil {
+		month, ok1 := zhAtoi(m[2])
+		day, ok2 := zhAtoi(m[3])
+		c, ok3 := parseZHClock(m[4], "")
+		if !ok1 || !ok2 || !ok3 || month < 1 || month > 12 || day < 1 || day > 31 {
+			return Schedule{}, false
+		}
+		year := now.Year()
+		if m[1] != "" {
+			year, _ = strconv.Atoi(m[1])
+		}
+		t := time.Date(year, time.Month(month), day, c.h, c.m, 0, 0, now.Location())
+		if m[1] == "" && !t.After(now) {
+			t = t.AddDate(1, 0, 0)
+		}
+		return onceAt(t), true
+	}
+	if m := reZHISODate.FindStringSubmatch(s); m != nil {
+		day, err := time.ParseInLocation("2006-1-2", m[1], now.Location())
+		if err != nil {
+			return Schedule{}, false
+		}
+		return once(day, m[2], "")
+	}
+
+	// 只有时刻：今天或明天最近的一次
+	if s == "" {
+		return Schedule{}, false
+	}
+	c, ok := parseZHClock(s, "")
+	return onceAt(nextClock(now, c)), ok
+}
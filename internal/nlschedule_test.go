@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	loc, _ := time.LoadLocation("Asia/Shanghai")
+	now := time.Date(2024, 1, 3, 10, 0, 0, 0, loc) // 星期三
+
+	tests := []struct {
+		text      string
+		wantType  string
+		wantValue string
+	}{
+		// English, recurring
+		{"every 5 minutes", "interval", "5m"},
+		{"every hour", "interval", "1h"},
+		{"hourly", "interval", "1h"},
+		{"every 90 seconds", "interval", "90s"},
+		{"every other day", "interval", "48h"},
+		{"every day at 9", "cron", "0 9 * * *"},
+		{"daily at 6:30pm", "cron", "30 18 * * *"},
+		{"every morning", "cron", "0 9 * * *"},
+		{"every evening at 7pm", "cron", "0 19 * * *"},
+		{"every weekday at 9am", "cron", "0 9 * * 1-5"},
+		{"weekdays at 09:15", "cron", "15 9 * * 1-5"},
+		{"every weekend at noon", "cron", "0 12 * * 0,6"},
+		{"every Mon, Wed and Fri at 18:30", "cron", "30 18 * * 1,3,5"},
+		{"every tuesday", "cron", "0 9 * * 2"},
+		{"every month on the 1st at 9", "cron", "0 9 1 * *"},
+		{"monthly on the 15th", "cron", "0 9 15 * *"},
+		{"every month on the 15", "cron", "0 9 15 * *"},
+		// English, once
+		{"in 10 minutes", "once", "2024-01-03T10:10:00+08:00"},
+		{"in an hour", "once", "2024-01-03T11:00:00+08:00"},
+		{"in half an hour", "once", "2024-01-03T10:30:00+08:00"},
+		{"in 2 days", "once", "2024-01-05T10:00:00+08:00"},
+		{"tomorrow at 9", "once", "2024-01-04T09:00:00+08:00"},
+		{"tomorrow", "once", "2024-01-04T09:00:00+08:00"},
+		{"today at 5pm", "once", "2024-01-03T17:00:00+08:00"},
+		{"tonight at 8", "once", "2024-01-03T20:00:00+08:00"},
+		{"tonight at 12", "once", "2024-01-04T00:00:00+08:00"},
+		{"tonight at midnight", "once", "2024-01-04T00:00:00+08:00"},
+		{"tonight at 12:30am", "once", "2024-01-04T00:30:00+08:00"},
+		{"at 9am", "once", "2024-01-04T09:00:00+08:00"},
+		{"at 11:30", "once", "2024-01-03T11:30:00+08:00"},
+		{"next friday 5pm", "once", "2024-01-05T17:00:00+08:00"},
+		{"on wednesday at 9", "once", "2024-01-10T09:00:00+08:00"},
+		{"on 2024-06-01 at 18:00", "once", "2024-06-01T18:00:00+08:00"},
+		{"2024-06-01 18:00.", "once", "2024-06-01T18:00:00+08:00"},
+		// 中文，周期
+		{"每5分钟", "interval", "5m"},
+		{"每隔两小时", "interval", "2h"},
+		{"每半小时", "interval", "30m"},
+		{"每小时", "interval", "1h"},
+		{"每天9点", "cron", "0 9 * * *"},
+		{"每天早上九点半", "cron", "30 9 * * *"},
+		{"每天晚上8点", "cron", "0 20 * * *"},
+		{"每天 21:45", "cron", "45 21 * * *"},
+		{"每天中午", "cron", "0 12 * * *"},
+		{"每个工作日早上9点", "cron", "0 9 * * 1-5"},
+		{"工作日每天十点一刻", "cron", "15 10 * * 1-5"},
+		{"周一到周五下午6点", "cron", "0 18 * * 1-5"},
+		{"每周末上午10点", "cron", "0 10 * * 0,6"},
+		{"每周一三五下午3点半", "cron", "30 15 * * 1,3,5"},
+		{"每周一三点", "cron", "0 3 * * 1"},
+		{"每周一10点", "cron", "0 10 * * 1"},
+		{"每星期日晚上", "cron", "0 20 * * 0"},
+		{"每天晚上12点", "cron", "0 0 * * *"},
+		{"每天夜里12点半", "cron", "30 0 * * *"},
+		{"工作日晚上12点", "cron", "0 0 * * 2,3,4,5,6"},
+		{"每周末晚上12点", "cron", "0 0 * * 0,1"},
+		{"每周五晚上12点", "cron", "0 0 * * 6"},
+		{"每周六夜里12点", "cron", "0 0 * * 0"},
+		{"每月1号晚上12点", "cron", "0 0 2 * *"},
+		{"每天中午12点", "cron", "0 12 * * *"},
+		{"每天下午12点", "cron", "0 12 * * *"},
+		{"每天凌晨12点", "cron", "0 0 * * *"},
+		{"每天半夜12点", "cron", "0 0 * * *"},
+		{"每月1号9点", "cron", "0 9 1 * *"},
+		{"每个月十五日下午两点", "cron", "0 14 15 * *"},
+		// 中文，一次
+		{"10分钟后", "once", "2024-01-03T10:10:00+08:00"},
+		{"半小时后", "once", "2024-01-03T10:30:00+08:00"},
+		{"一个半小时以后", "once", "2024-01-03T11:30:00+08:00"},
+		{"明天上午10点", "once", "2024-01-04T10:00:00+08:00"},
+		{"今晚8点", "once", "2024-01-03T20:00:00+08:00"},
+		{"今晚12点", "once", "2024-01-04T00:00:00+08:00"},
+		{"明晚12点", "once", "2024-01-05T00:00:00+08:00"},
+		{"今天晚上12点", "once", "2024-01-04T00:00:00+08:00"},
+		{"今天夜里12点", "once", "2024-01-04T00:00:00+08:00"},
+		{"今天下午12点", "once", "2024-01-03T12:00:00+08:00"},
+		{"今天傍晚12点", "once", "2024-01-03T12:00:00+08:00"},
+		{"明天中午12点", "once", "2024-01-04T12:00:00+08:00"},
+		{"明天凌晨12点", "once", "2024-01-04T00:00:00+08:00"},
+		{"晚上12点", "once", "2024-01-04T00:00:00+08:00"},
+		{"夜里12点", "once", "2024-01-04T00:00:00+08:00"},
+		{"周五晚上12点", "once", "2024-01-06T00:00:00+08:00"},
+		{"3月5号晚上12点", "once", "2024-03-06T00:00:00+08:00"},
+		{"2024-06-01晚上12点", "once", "2024-06-02T00:00:00+08:00"},
+		{"明早", "once", "2024-01-04T09:00:00+08:00"},
+		{"后天下午3点20分", "once", "2024-01-05T15:20:00+08:00"},
+		{"周五下午5点", "once", "2024-01-05T17:00:00+08:00"},
+		{"下周一9点", "once", "2024-01-08T09:00:00+08:00"},
+		{"下周三9点", "once", "2024-01-10T09:00:00+08:00"},
+		{"3月5号晚上8点", "once", "2024-03-05T20:00:00+08:00"},
+		{"1月2日9点", "once", "2025-01-02T09:00:00+08:00"},
+		{"2024年6月1日18:00", "once", "2024-06-01T18:00:00+08:00"},
+		{"下午3点", "once", "2024-01-03T15:00:00+08:00"},
+		{"9点", "once", "2024-01-04T09:00:00+08:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got, err := ParseSchedule(tt.text, now)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q) error: %v", tt.text, err)
+			}
+			if got.Type != tt.wantType || got.Value != tt.wantValue {
+				t.Errorf("ParseSchedule(%q) = %s %q, want %s %q", tt.text, got.Type, got.Value, tt.wantType, tt.wantValue)
+			}
+			if _, err := NextRunTime(got.Type, got.Value, "Asia/Shanghai", now); err != nil {
+				t.Errorf("result does not validate: %v", err)
+			}
+		})
+	}
+}
+
+func TestParseSchedule_Invalid(t *testing.T) {
+	now := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+	for _, text := range []string{"", "whenever", "every blue moon", "at 25:00", "in 0 minutes", "today", "每逢佳节", "每月40号", "周五到周一9点", "晚上24点", "每月31号晚上12点"} {
+		if got, err := ParseSchedule(text, now); err == nil {
+			t.Errorf("ParseSchedule(%q) = %+v, want error", text, got)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package internal
+
+import (
+	"sync"
+	"time"
+)
+
+// 推送通知的主题，同时作为 JSON-RPC 通知的方法名
+const (
+	NotifyMessage  = "message"        // 会话中保存了新消息，数据为 MessageInfo
+	NotifyDelta    = "agent.delta"    // Agent回复的流式片段，数据为 DeltaInfo
+	NotifyThinking = "agent.thinking" // Agent开始或结束处理，数据为 ThinkingInfo
+	NotifyTaskRun  = "task.completed" // 定时任务执行结束，数据为 TaskRunInfo
+	NotifyLagged   = "notify.lagged"  // 订阅者处理过慢，之前有通知被丢弃，数据为 LaggedInfo
+)
+
+// defaultNotifyBuffer 每个订阅者缓冲的通知数
+const defaultNotifyBuffer = 256
+
+// Notification 推送给订阅者的运行时事件
+type Notification struct {
+	Topic   string
+	ChatJID ChatJID
+	Data    any
+}
+
+// DeltaInfo Agent回复的流式片段
+type DeltaInfo struct {
+	ChatJID ChatJID `json:"chat_jid"`
+	Delta   string  `json:"delta"`
+}
+
+// ThinkingInfo Agent处理状态
+type ThinkingInfo struct {
+	ChatJID  ChatJID `json:"chat_jid"`
+	Thinking bool    `json:"thinking"`
+}
+
+// TaskRunInfo 定时任务的一次执行结果
+type TaskRunInfo struct {
+	TaskID      string    `json:"task_id"`
+	RunID       int64     `json:"run_id"`
+	GroupFolder string    `json:"group_folder"`
+	ChatJID     ChatJID   `json:"chat_jid"`
+	Status      string    `json:"status"`
+	Output      string    `json:"output,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	StartedAt   time.Time `json:"started_at"`
+	EndedAt     time.Time `json:"ended_at"`
+}
+
+// NewTaskRunInfo 转换为JSON表示
+func NewTaskRunInfo(t Task, r TaskRun) TaskRunInfo {
+	return TaskRunInfo{TaskID: t.ID, RunID: r.ID, GroupFolder: t.GroupFolder, ChatJID: t.ChatJID,
+		Status: r.Status, Output: r.Output, Error: r.Error, StartedAt: r.StartedAt, EndedAt: r.EndedAt}
+}
+
+// LaggedInfo 被丢弃的通知数
+type LaggedInfo struct {
+	Dropped int `json:"dropped"`
+}
+
+// Hub 将通知分发给订阅者
+//
+// Publish 从不阻塞：订阅者的缓冲区满时丢弃通知并计数，
+// 缓冲区有空位后先收到一条 NotifyLagged 通知，再继续接收新的通知。
+type Hub struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscription 一个订阅，从 C 读取通知，Close 后 C 被关闭
+type Subscription struct {
+	hub     *Hub
+	topics  map[string]bool
+	chats   map[ChatJID]bool
+	ch      chan Notification
+	dropped int // 由 hub.mu 保护
+	closed  bool
+}
+
+// NewHub 创建通知中心
+func NewHub() *Hub {
+	return &Hub{subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe 订阅给定主题和会话的通知，为空表示全部；buffer <= 0 时使用默认大小
+func (h *Hub) Subscribe(topics []string, chats []ChatJID, buffer int) *Subscription {
+	if buffer <= 0 {
+		buffer = defaultNotifyBuffer
+	}
+	s := &Subscription{hub: h, ch: make(chan Notification, buffer)}
+	if len(topics) > 0 {
+		s.topics = make(map[string]bool, len(topics))
+		for _, t := range topics {
+			s.topics[t] = true
+		}
+	}
+	if len(chats) > 0 {
+		s.chats = make(map[ChatJID]bool, len(chats))
+		for _, c := range chats {
+			s.chats[c] = true
+		}
+	}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// Publish 把通知分发给匹配的订阅者
+func (h *Hub) Publish(n Notification) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.matches(n) {
+			s.deliver(n)
+		}
+	}
+}
+
+// Subscribers 返回当前订阅数
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+func (s *Subscription) matches(n Notification) bool {
+	if s.topics != nil && !s.topics[n.Topic] {
+		return false
+	}
+	return s.chats == nil || s.chats[n.ChatJID]
+}
+
+// deliver 非阻塞发送，调用方需持有 hub.mu
+func (s *Subscription) deliver(n Notification) {
+	if s.dropped > 0 {
+		select {
+		case s.ch <- Notification{Topic: NotifyLagged, Data: LaggedInfo{Dropped: s.dropped}}:
+			s.dropped = 0
+		default:
+			s.dropped++
+			return
+		}
+	}
+	select {
+	case s.ch <- n:
+	default:
+		s.dropped++
+	}
+}
+
+// C 返回通知通道
+func (s *Subscription) C() <-chan Notification {
+	return s.ch
+}
+
+// Close 取消订阅，可重复调用
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	delete(s.hub.subs, s)
+	close(s.ch)
+}
@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestHub_Filter(t *testing.T) {
+	hub := NewHub()
+	all := hub.Subscribe(nil, nil, 0)
+	msgs := hub.Subscribe([]string{NotifyMessage}, []ChatJID{"a@nanoclaw"}, 0)
+
+	hub.Publish(Notification{Topic: NotifyMessage, ChatJID: "a@nanoclaw", Data: 1})
+	hub.Publish(Notification{Topic: NotifyMessage, ChatJID: "b@nanoclaw", Data: 2})
+	hub.Publish(Notification{Topic: NotifyThinking, ChatJID: "a@nanoclaw", Data: 3})
+
+	if got := len(all.C()); got != 3 {
+		t.Errorf("unfiltered subscriber got %d notifications, want 3", got)
+	}
+	if got := len(msgs.C()); got != 1 {
+		t.Fatalf("filtered subscriber got %d notifications, want 1", got)
+	}
+	if n := <-msgs.C(); n.Data != 1 {
+		t.Errorf("filtered notification = %+v", n)
+	}
+
+	// Close 可重复调用，关闭后 C 在读完缓冲后结束
+	all.Close()
+	all.Close()
+	for range all.C() {
+	}
+	if hub.Subscribers() != 1 {
+		t.Errorf("subscribers = %d, want 1", hub.Subscribers())
+	}
+	hub.Publish(Notification{Topic: NotifyMessage, ChatJID: "a@nanoclaw"})
+}
+
+func TestHub_Lagged(t *testing.T) {
+	hub := NewHub()
+	sub := hub.Subscribe(nil, nil, 2)
+	defer sub.Close()
+	for i := 0; i < 5; i++ {
+		hub.Publish(Notification{Topic: NotifyDelta, Data: i})
+	}
+	// 缓冲区满，后三条被丢弃
+	if n := <-sub.C(); n.Data != 0 {
+		t.Fatalf("first = %+v", n)
+	}
+	<-sub.C()
+
+	hub.Publish(Notification{Topic: NotifyDelta, Data: 5})
+	if n := <-sub.C(); n.Topic != NotifyLagged || n.Data.(LaggedInfo).Dropped != 3 {
+		t.Errorf("want lagged notice for 3 dropped, got %+v", n)
+	}
+	if n := <-sub.C(); n.Data != 5 {
+		t.Errorf("after lagged = %+v", n)
+	}
+}
+
+func TestAgent_RunStreaming(t *testing.T) {
+	db := TestTempDB(t)
+	tools := NewTaskTools(db)
+	agent, reqs := fakeLLM(t, []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{
+			{ID: "c1", Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: "schedule_task", Arguments: `{"prompt":"standup","when":"every day at 9am"}`}},
+		}},
+		{Role: openai.ChatMessageRoleAssistant, Content: "Daily at 9, OK?"},
+	})
+	agent.RegisterTools(tools.Tools()...)
+
+	var deltas []string
+	msgs := []Message{{ID: "m1", ChatJID: "main@nanoclaw", Content: "@Andy remind me about standup every day at 9"}}
+	resp, usage, err := agent.RunStreaming(context.Background(), "main", msgs, func(d string) { deltas = append(deltas, d) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != "Daily at 9, OK?" || strings.Join(deltas, "") != resp || len(deltas) != len(resp) {
+		t.Errorf("resp = %q, deltas = %q", resp, deltas)
+	}
+	if usage.PromptTokens != 20 || usage.CompletionTokens != 4 {
+		t.Errorf("usage = %+v", usage)
+	}
+	if len(*reqs) != 2 || !(*reqs)[0].Stream {
+		t.Fatalf("requests = %d, stream = %v", len(*reqs), (*reqs)[0].Stream)
+	}
+	// 分片到达的工具调用被拼接完整后执行
+	second := (*reqs)[1].Messages
+	call := second[len(second)-2]
+	if len(call.ToolCalls) != 1 || call.ToolCalls[0].Function.Arguments != `{"prompt":"standup","when":"every day at 9am"}` {
+		t.Errorf("assembled tool call = %+v", call.ToolCalls)
+	}
+	if got := second[len(second)-1]; got.ToolCallID != "c1" {
+		t.Errorf("tool result = %+v", got)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"strings"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea/v2"
@@ -14,14 +15,17 @@ import (
 type Orchestrator struct {
 	db       *DB
 	queue    *GroupQueue
-	agent    *Agent
+	agent    Runner
 	cfg      *Config
 	program  *tea.Program
 	onReply  func(ChatJID, string)
+	skills   *SkillRegistry
+	onEvent  func(Event)
+	notify   func(Notification)
 }
 
 // NewOrchestrator 创建编排器
-func NewOrchestrator(db *DB, queue *GroupQueue, agent *Agent, cfg *Config) *Orchestrator {
+func NewOrchestrator(db *DB, queue *GroupQueue, agent Runner, cfg *Config) *Orchestrator {
 	return &Orchestrator{
 		db:    db,
 		queue: queue,
@@ -40,6 +44,46 @@ func (o *Orchestrator) SetOnReply(fn func(ChatJID, string)) {
 	o.onReply = fn
 }
 
+// SetSkills 设置技能注册表，"/名称 参数" 形式的消息将作为技能命令执行
+func (o *Orchestrator) SetSkills(sr *SkillRegistry) {
+	o.skills = sr
+}
+
+// SetOnEvent 设置会话事件回调（通常为 Scheduler.HandleEvent）
+func (o *Orchestrator) SetOnEvent(fn func(Event)) {
+	o.onEvent = fn
+}
+
+// SetNotify 设置推送通知回调（通常为 Hub.Publish），设置后Agent以流式请求运行并推送回复片段
+func (o *Orchestrator) SetNotify(fn func(Notification)) {
+	o.notify = fn
+}
+
+func (o *Orchestrator) publish(topic string, chatJID ChatJID, data any) {
+	if o.notify != nil {
+		o.notify(Notification{Topic: topic, ChatJID: chatJID, Data: data})
+	}
+}
+
+// setThinking 向TUI和订阅者发送思考状态
+func (o *Orchestrator) setThinking(chatJID ChatJID, thinking bool) {
+	if o.program != nil {
+		o.program.Send(ThinkingMsg{ChatJID: chatJID, Thinking: thinking})
+	}
+	o.publish(NotifyThinking, chatJID, ThinkingInfo{ChatJID: chatJID, Thinking: thinking})
+}
+
+// HandleJoin 处理渠道上报的新成员加入
+func (o *Orchestrator) HandleJoin(chatJID ChatJID, member string) {
+	o.emit(Event{Kind: EventJoin, ChatJID: chatJID, Sender: member, Time: time.Now()})
+}
+
+func (o *Orchestrator) emit(ev Event) {
+	if o.onEvent != nil {
+		o.onEvent(ev)
+	}
+}
+
 // HandleMessage 处理用户消息
 func (o *Orchestrator) HandleMessage(chatJID ChatJID, sender, content string) {
 	// 保存消息
@@ -60,6 +104,13 @@ func (o *Orchestrator) HandleMessage(chatJID ChatJID, sender, content string) {
 	if o.program != nil {
 		o.program.Send(TUIMsg{ChatJID: chatJID, Message: *msg})
 	}
+	o.publish(NotifyMessage, chatJID, NewMessageInfo(*msg))
+
+	// 技能命令
+	if o.enqueueCommand(chatJID, content) {
+		return
+	}
+	o.emit(Event{Kind: EventMessage, ChatJID: chatJID, Sender: sender, Content: content, Time: msg.Timestamp})
 
 	// 检查触发词
 	if o.cfg.App.TriggerPattern.MatchString(content) {
@@ -70,27 +121,19 @@ func (o *Orchestrator) HandleMessage(chatJID ChatJID, sender, content string) {
 // enqueueAgent 将Agent任务加入队列
 func (o *Orchestrator) enqueueAgent(ctx context.Context, chatJID ChatJID) {
 	// 发送思考中状态
-	if o.program != nil {
-		o.program.Send(ThinkingMsg{ChatJID: chatJID, Thinking: true})
-	}
+	o.setThinking(chatJID, true)
 
 	if err := o.queue.Enqueue(ctx, chatJID, func() {
 		o.runAgent(chatJID)
 	}); err != nil {
 		slog.Error("enqueue agent", "err", err)
-		if o.program != nil {
-			o.program.Send(ThinkingMsg{ChatJID: chatJID, Thinking: false})
-		}
+		o.setThinking(chatJID, false)
 	}
 }
 
 // runAgent 运行Agent
 func (o *Orchestrator) runAgent(chatJID ChatJID) {
-	defer func() {
-		if o.program != nil {
-			o.program.Send(ThinkingMsg{ChatJID: chatJID, Thinking: false})
-		}
-	}()
+	defer o.setThinking(chatJID, false)
 
 	// 获取历史消息
 	messages, err := o.db.GetMessages(chatJID, 20)
@@ -100,45 +143,150 @@ func (o *Orchestrator) runAgent(chatJID ChatJID) {
 		return
 	}
 
-	// 获取群组信息
+	// 调用Agent
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	var resp string
+	if sr, ok := o.agent.(StreamRunner); ok && o.notify != nil {
+		resp, _, err = sr.RunStreaming(ctx, o.groupFolder(chatJID), messages, func(delta string) {
+			o.publish(NotifyDelta, chatJID, DeltaInfo{ChatJID: chatJID, Delta: delta})
+		})
+	} else {
+		resp, err = o.agent.Run(ctx, o.groupFolder(chatJID), messages)
+	}
+	if err != nil {
+		slog.Error("agent run", "err", err)
+		o.sendReply(chatJID, fmt.Sprintf("Error: %v", err))
+		return
+	}
+
+	// 保存并投递回复
+	if _, err := o.PostBotMessage(chatJID, resp); err != nil {
+		slog.Error("save bot message", "err", err)
+	}
+}
+
+// groupFolder 获取会话所属群组，未注册的会话使用默认群组
+func (o *Orchestrator) groupFolder(chatJID ChatJID) string {
 	group, err := o.db.GetGroup(chatJID)
 	if err != nil {
-		// 使用默认群组
-		group = &Group{Folder: "main"}
+		return "main"
+	}
+	return group.Folder
+}
+
+// enqueueCommand 若消息是已注册技能的命令则排队执行，返回是否已处理
+func (o *Orchestrator) enqueueCommand(chatJID ChatJID, content string) bool {
+	if o.skills == nil || !strings.HasPrefix(content, "/") {
+		return false
+	}
+	argv, err := splitArgs(content[1:])
+	if err != nil || len(argv) == 0 {
+		return false
+	}
+	folder := o.groupFolder(chatJID)
+	name := argv[0]
+	if _, ok := o.skills.Lookup(folder, name); !ok {
+		return false
 	}
 
-	// 调用Agent
+	sc := SkillContext{GroupFolder: folder, ChatJID: chatJID, Argv: argv[1:]}
+	if err := o.queue.EnqueuePriority(context.Background(), chatJID, PriorityInteractive, func() {
+		o.runCommand(name, sc)
+	}); err != nil {
+		slog.Error("enqueue command", "skill", name, "err", err)
+	}
+	return true
+}
+
+// runCommand 执行技能命令并把结果回复到会话
+func (o *Orchestrator) runCommand(name string, sc SkillContext) {
 	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
 	defer cancel()
 
-	resp, err := o.agent.Run(ctx, group.Folder, messages)
+	out, err := o.skills.Run(ctx, name, sc)
 	if err != nil {
-		slog.Error("agent run", "err", err)
-		o.sendReply(chatJID, fmt.Sprintf("Error: %v", err))
+		slog.Error("skill command", "skill", name, "err", err)
+		out = fmt.Sprintf("Error: %v", err)
+	}
+	if out == "" {
 		return
 	}
+	if _, err := o.PostBotMessage(sc.ChatJID, out); err != nil {
+		slog.Error("save bot message", "err", err)
+	}
+}
+
+// splitArgs 按空白拆分命令参数，支持单双引号和反斜杠转义
+func splitArgs(s string) ([]string, error) {
+	var (
+		args    []string
+		cur     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range s {
+		switch {
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inArg = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		default:
+			cur.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if escaped {
+		cur.WriteRune('\\')
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
 
-	// 保存回复
+// PostBotMessage 以机器人身份向会话发送消息：保存、推送到TUI和订阅者并触发回复回调
+func (o *Orchestrator) PostBotMessage(chatJID ChatJID, content string) (*Message, error) {
 	botMsg := &Message{
 		ID:           MessageID(uuid.New().String()),
 		ChatJID:      chatJID,
 		Sender:       o.cfg.App.Name,
 		SenderName:   o.cfg.App.Name,
-		Content:      resp,
+		Content:      content,
 		Timestamp:    time.Now(),
 		IsBotMessage: true,
 	}
-	if err := o.db.SaveMessage(botMsg); err != nil {
-		slog.Error("save bot message", "err", err)
-	}
+	err := o.db.SaveMessage(botMsg)
 
 	// 发送给TUI
 	if o.program != nil {
 		o.program.Send(TUIMsg{ChatJID: chatJID, Message: *botMsg})
 	}
+	o.publish(NotifyMessage, chatJID, NewMessageInfo(*botMsg))
 
 	// 回调
-	o.sendReply(chatJID, resp)
+	o.sendReply(chatJID, content)
+	return botMsg, err
 }
 
 func (o *Orchestrator) sendReply(chatJID ChatJID, content string) {
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -185,3 +186,84 @@ func TestOrchestratorXSkip_ConcurrentMessages(t *testing.T) {
 		t.Errorf("Expected 1 message, got %d", len(msgs))
 	}
 }
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{`task list`, []string{"task", "list"}, false},
+		{`  task   create "daily report" cron "0 9 * * *" `, []string{"task", "create", "daily report", "cron", "0 9 * * *"}, false},
+		{`echo 'it''s' a\ b ""`, []string{"echo", "its", "a b", ""}, false},
+		{`say "a \"quoted\" word"`, []string{"say", `a "quoted" word`}, false},
+		{`bad "open`, nil, true},
+	}
+	for _, tt := range tests {
+		got, err := splitArgs(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("splitArgs(%q) err = %v", tt.in, err)
+			continue
+		}
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", tt.want) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestOrchestrator_SkillCommand(t *testing.T) {
+	db := TestTempDB(t)
+	cfg := TestConfig(t)
+	queue := NewGroupQueue(2)
+	orch := NewOrchestrator(db, queue, nil, cfg)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+	registry.Register(&Skill{Name: "echo", LuaScript: `return GROUP_FOLDER .. ":" .. table.concat(arg, "|")`})
+	orch.SetSkills(registry)
+
+	replies := make(chan string, 1)
+	orch.SetOnReply(func(_ ChatJID, content string) { replies <- content })
+
+	chatJID := ChatJID("main@nanoclaw")
+	orch.HandleMessage(chatJID, "User", `/echo one "two three"`)
+	select {
+	case got := <-replies:
+		if got != "main:one|two three" {
+			t.Errorf("reply = %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no reply to command")
+	}
+
+	// 未注册的命令按普通消息处理
+	orch.HandleMessage(chatJID, "User", "/nope")
+	select {
+	case got := <-replies:
+		t.Errorf("unexpected reply %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+	msgs, _ := db.GetMessages(chatJID, 10)
+	if len(msgs) != 3 {
+		t.Errorf("messages = %d, want 3", len(msgs))
+	}
+}
+
+func TestOrchestrator_Events(t *testing.T) {
+	db := TestTempDB(t)
+	orch := NewOrchestrator(db, NewGroupQueue(1), nil, TestConfig(t))
+	var events []Event
+	orch.SetOnEvent(func(ev Event) { events = append(events, ev) })
+
+	orch.HandleMessage("main@nanoclaw", "alice", "any help here?")
+	orch.HandleJoin("main@nanoclaw", "bob")
+
+	if len(events) != 2 {
+		t.Fatalf("events = %+v", events)
+	}
+	if ev := events[0]; ev.Kind != EventMessage || ev.Sender != "alice" || ev.Content != "any help here?" || ev.Time.IsZero() {
+		t.Errorf("message event = %+v", ev)
+	}
+	if ev := events[1]; ev.Kind != EventJoin || ev.Sender != "bob" || ev.ChatJID != "main@nanoclaw" {
+		t.Errorf("join event = %+v", ev)
+	}
+}
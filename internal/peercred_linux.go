@@ -0,0 +1,32 @@
+//go:build linux
+
+package internal
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// peerCredentials 通过 SO_PEERCRED 获取Unix Socket对端进程的凭据
+func peerCredentials(conn net.Conn) (*PeerCred, error) {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return nil, errors.New("not a unix socket")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return nil, err
+	}
+	if credErr != nil {
+		return nil, credErr
+	}
+	return &PeerCred{UID: cred.Uid, GID: cred.Gid, PID: cred.Pid}, nil
+}
@@ -0,0 +1,187 @@
+//go:build linux
+
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// connPair 返回一对相连的Unix Socket连接
+func connPair(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := func(fd int) net.Conn {
+		f := os.NewFile(uintptr(fd), "socketpair")
+		defer f.Close()
+		c, err := net.FileConn(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	return conn(fds[0]), conn(fds[1])
+}
+
+func TestPeerCredentials(t *testing.T) {
+	a, _ := connPair(t)
+	peer, err := peerCredentials(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.UID != uint32(os.Getuid()) || peer.GID != uint32(os.Getgid()) || peer.PID != int32(os.Getpid()) {
+		t.Errorf("peer = %+v", peer)
+	}
+}
+
+// rawRPC 在连接上逐个发送请求并读取响应
+type rawRPC struct {
+	t   *testing.T
+	enc *json.Encoder
+	dec *json.Decoder
+}
+
+func (r rawRPC) call(method string, params any) RPCResponse {
+	r.t.Helper()
+	raw, _ := json.Marshal(params)
+	if err := r.enc.Encode(RPCRequest{JSONRPC: "2.0", ID: json.RawMessage("1"), Method: method, Params: raw}); err != nil {
+		r.t.Fatal(err)
+	}
+	var resp RPCResponse
+	if err := r.dec.Decode(&resp); err != nil {
+		r.t.Fatal(err)
+	}
+	return resp
+}
+
+func TestIPCServer_PeerPolicy(t *testing.T) {
+	db := TestTempDB(t)
+	queue := NewGroupQueue(2)
+	orch := NewOrchestrator(db, queue, nil, TestConfig(t))
+	for _, g := range []*Group{
+		{JID: "main@nanoclaw", Name: "Main", Folder: "main", AddedAt: time.Now()},
+		{JID: "other@nanoclaw", Name: "Other", Folder: "other", AddedAt: time.Now()},
+	} {
+		if err := db.SaveGroup(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// serve 在 socketpair 上启动服务；owner 与测试进程不同时，测试进程按普通对端受策略限制
+	serve := func(owner uint32, policy *IPCPolicy) rawRPC {
+		s := &IPCServer{methods: make(map[string]RPCHandler), owner: owner}
+		NewControlAPI(db, orch, queue).Register(s)
+		s.SetPolicy(policy)
+		server, client := connPair(t)
+		go s.ServeConn(server)
+		return rawRPC{t: t, enc: json.NewEncoder(client), dec: json.NewDecoder(client)}
+	}
+	me := uint32(os.Getuid())
+	other := me + 1
+	gid := uint32(os.Getgid()) + 1
+	policy := &IPCPolicy{Rules: []IPCRule{
+		{UID: &me, Methods: []string{"messages.*", "groups.list"}, Groups: []string{"main"}},
+	}}
+
+	denied := func(resp RPCResponse) bool { return resp.Error != nil && resp.Error.Code == RPCUnauthorized }
+
+	peer := serve(other, policy)
+	if resp := peer.call("messages.list", map[string]any{"chat_jid": "main@nanoclaw"}); resp.Error != nil {
+		t.Errorf("allowed group: %+v", resp.Error)
+	}
+	if resp := peer.call("messages.send", map[string]any{"chat_jid": "other@nanoclaw", "content": "x"}); !denied(resp) {
+		t.Errorf("denied group: %+v", resp)
+	}
+	// 受限制的对端不能指定发送者
+	if resp := peer.call("messages.send", map[string]any{"chat_jid": "main@nanoclaw", "content": "x", "sender": "System"}); resp.Error != nil {
+		t.Errorf("send: %+v", resp.Error)
+	}
+	if msgs, _ := db.GetMessages("main@nanoclaw", 1); len(msgs) != 1 || msgs[0].Sender != fmt.Sprintf("uid:%d", me) {
+		t.Errorf("sender = %+v", msgs)
+	}
+	for _, method := range []string{"tasks.list", "queue.status", "no.such.method"} {
+		if resp := peer.call(method, nil); !denied(resp) {
+			t.Errorf("%s: %+v", method, resp)
+		}
+	}
+	var groups []GroupInfo
+	resp := peer.call("groups.list", nil)
+	if err := json.Unmarshal(resp.Result, &groups); err != nil || len(groups) != 1 || groups[0].Folder != "main" {
+		t.Errorf("groups.list filtered = %s, %+v", resp.Result, resp.Error)
+	}
+
+	// 策略中没有匹配规则的对端拒绝全部请求
+	stranger := serve(other, &IPCPolicy{Rules: []IPCRule{{GID: &gid, Methods: []string{"*"}, Groups: []string{"*"}}}})
+	if resp := stranger.call("groups.list", nil); !denied(resp) {
+		t.Errorf("stranger: %+v", resp)
+	}
+
+	// 服务进程自身的用户不受策略限制
+	owner := serve(me, policy)
+	if resp := owner.call("tasks.list", nil); resp.Error != nil {
+		t.Errorf("owner: %+v", resp.Error)
+	}
+}
+
+func TestIPCServer_PeerTaskListLimit(t *testing.T) {
+	db := TestTempDB(t)
+	queue := NewGroupQueue(2)
+	orch := NewOrchestrator(db, queue, nil, TestConfig(t))
+	for _, g := range []*Group{
+		{JID: "main@nanoclaw", Name: "Main", Folder: "main", AddedAt: time.Now()},
+		{JID: "other@nanoclaw", Name: "Other", Folder: "other", AddedAt: time.Now()},
+	} {
+		if err := db.SaveGroup(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 较早创建的任务都属于对端无权访问的群组
+	base := time.Now().Add(-time.Hour)
+	for i, folder := range []string{"other", "other", "other", "main", "main"} {
+		task := &Task{ID: fmt.Sprintf("t%d", i), GroupFolder: folder, ChatJID: ChatJID(folder + "@nanoclaw"), Prompt: "p",
+			ScheduleType: "once", Status: TaskActive, CreatedAt: base.Add(time.Duration(i) * time.Minute)}
+		if err := db.SaveTask(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := &IPCServer{methods: make(map[string]RPCHandler), owner: uint32(os.Getuid()) + 1}
+	NewControlAPI(db, orch, queue).Register(s)
+	me := uint32(os.Getuid())
+	s.SetPolicy(&IPCPolicy{Rules: []IPCRule{{UID: &me, Methods: []string{"tasks.list"}, Groups: []string{"main"}}}})
+	server, client := connPair(t)
+	go s.ServeConn(server)
+	peer := rawRPC{t: t, enc: json.NewEncoder(client), dec: json.NewDecoder(client)}
+
+	for _, tt := range []struct {
+		limit int
+		want  string
+	}{
+		{0, "t3,t4"},
+		{1, "t3"},
+		{2, "t3,t4"},
+	} {
+		resp := peer.call("tasks.list", map[string]any{"limit": tt.limit})
+		var tasks []TaskInfo
+		if err := json.Unmarshal(resp.Result, &tasks); err != nil {
+			t.Fatalf("limit %d: %s, %+v", tt.limit, resp.Result, resp.Error)
+		}
+		var ids []string
+		for _, task := range tasks {
+			ids = append(ids, task.ID)
+		}
+		if got := strings.Join(ids, ","); got != tt.want {
+			t.Errorf("limit %d: tasks = %q, want %q", tt.limit, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package internal
+
+import (
+	"errors"
+	"net"
+)
+
+func peerCredentials(conn net.Conn) (*PeerCred, error) {
+	return nil, errors.New("peer credentials not supported on this platform")
+}
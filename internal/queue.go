@@ -2,86 +2,181 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"sort"
 	"sync"
 
 	"golang.org/x/sync/semaphore"
 )
 
+// 任务优先级，数值越大越先执行
+const (
+	PriorityScheduled   = 0  // 定时任务默认优先级
+	PriorityInteractive = 10 // 聊天触发的交互请求
+)
+
+// ErrQueueClosed 队列正在排空，不再接受新任务
+var ErrQueueClosed = errors.New("queue closed: nanoclaw is shutting down")
+
+// queuedJob 排队中的任务
+type queuedJob struct {
+	ctx      context.Context
+	run      func()
+	priority int
+	seq      uint64
+}
+
 // GroupQueue 使用Google官方semaphore实现加权并发控制
+//
+// 同一群组的任务串行执行；等待中的任务按优先级、再按入队顺序调度。
 type GroupQueue struct {
-	sem      *semaphore.Weighted
-	mu       sync.Mutex
-	queues   map[ChatJID][]func()
-	running  map[ChatJID]bool
+	sem     *semaphore.Weighted
+	mu      sync.Mutex
+	queues  map[ChatJID][]queuedJob
+	running map[ChatJID]bool
+	seq     uint64
+	closed  bool
+	idle    chan struct{} // Drain 之后队列排空时关闭
 }
 
 // NewGroupQueue 创建队列
 func NewGroupQueue(maxConcurrent int64) *GroupQueue {
 	return &GroupQueue{
 		sem:     semaphore.NewWeighted(maxConcurrent),
-		queues:  make(map[ChatJID][]func()),
+		queues:  make(map[ChatJID][]queuedJob),
 		running: make(map[ChatJID]bool),
 	}
 }
 
-// Enqueue 将任务加入队列
+// Enqueue 以交互优先级将任务加入队列
 func (q *GroupQueue) Enqueue(ctx context.Context, chatJID ChatJID, job func()) error {
+	return q.EnqueuePriority(ctx, chatJID, PriorityInteractive, job)
+}
+
+// EnqueuePriority 以指定优先级将任务加入队列，不会阻塞调用方
+//
+// ctx 已取消时返回错误；任务开始前 ctx 被取消则直接丢弃。
+func (q *GroupQueue) EnqueuePriority(ctx context.Context, chatJID ChatJID, priority int, job func()) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	q.mu.Lock()
-	q.queues[chatJID] = append(q.queues[chatJID], job)
+	if q.closed {
+		q.mu.Unlock()
+		return ErrQueueClosed
+	}
+	q.seq++
+	j := queuedJob{ctx: ctx, run: job, priority: priority, seq: q.seq}
+	jobs := q.queues[chatJID]
+	// 插入到第一个优先级更低的任务之前，同优先级保持FIFO
+	i := len(jobs)
+	for i > 0 && jobs[i-1].priority < priority {
+		i--
+	}
+	jobs = append(jobs, queuedJob{})
+	copy(jobs[i+1:], jobs[i:])
+	jobs[i] = j
+	q.queues[chatJID] = jobs
 	q.mu.Unlock()
 
-	return q.tryDispatch(ctx)
+	q.dispatch()
+	return nil
 }
 
-// tryDispatch 尝试分发任务
-func (q *GroupQueue) tryDispatch(ctx context.Context) error {
-	q.mu.Lock()
+// dispatch 在有空闲许可时持续分发任务
+func (q *GroupQueue) dispatch() {
+	for q.sem.TryAcquire(1) {
+		q.mu.Lock()
+		target, ok := q.next()
+		if !ok {
+			q.mu.Unlock()
+			q.sem.Release(1)
+			return
+		}
+		job := q.queues[target][0]
+		q.queues[target] = q.queues[target][1:]
+		if len(q.queues[target]) == 0 {
+			delete(q.queues, target)
+		}
+		q.running[target] = true
+		q.mu.Unlock()
 
-	// 找一个有待处理任务且未在运行的群组
+		go q.run(target, job)
+	}
+}
+
+// next 选出队首任务优先级最高（同级时最早入队）且未在运行的群组，调用方需持有锁
+func (q *GroupQueue) next() (ChatJID, bool) {
 	var target ChatJID
+	var best *queuedJob
 	for jid, jobs := range q.queues {
-		if len(jobs) > 0 && !q.running[jid] {
-			target = jid
-			break
+		if len(jobs) == 0 || q.running[jid] {
+			continue
+		}
+		head := &jobs[0]
+		if best == nil || head.priority > best.priority ||
+			(head.priority == best.priority && head.seq < best.seq) {
+			target, best = jid, head
 		}
 	}
+	return target, best != nil
+}
 
-	if target == "" {
+func (q *GroupQueue) run(chatJID ChatJID, job queuedJob) {
+	defer func() {
+		q.sem.Release(1)
+		q.mu.Lock()
+		delete(q.running, chatJID)
+		q.checkIdle()
 		q.mu.Unlock()
-		return nil
+		// 尝试分发下一个
+		q.dispatch()
+	}()
+	if job.ctx.Err() != nil {
+		return
 	}
+	job.run()
+}
 
-	// 取出任务
-	job := q.queues[target][0]
-	q.queues[target] = q.queues[target][1:]
-	if len(q.queues[target]) == 0 {
-		delete(q.queues, target)
+// Drain 停止接受新任务（之后的 Enqueue 返回 ErrQueueClosed），等待已排队和执行中的任务全部完成
+//
+// ctx 到期时返回 ctx.Err()，未完成的任务继续执行。
+func (q *GroupQueue) Drain(ctx context.Context) error {
+	q.mu.Lock()
+	q.closed = true
+	if q.idle == nil {
+		q.idle = make(chan struct{})
+		q.checkIdle()
 	}
-	q.running[target] = true
+	idle := q.idle
 	q.mu.Unlock()
 
-	// 获取semaphore许可
-	if err := q.sem.Acquire(ctx, 1); err != nil {
-		q.mu.Lock()
-		q.running[target] = false
-		q.mu.Unlock()
-		return err
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
+}
 
-	// 异步执行
-	go func() {
-		defer func() {
-			q.sem.Release(1)
-			q.mu.Lock()
-			q.running[target] = false
-			q.mu.Unlock()
-			// 尝试分发下一个
-			q.tryDispatch(ctx)
-		}()
-		job()
-	}()
+// Closed 队列是否已停止接受新任务
+func (q *GroupQueue) Closed() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
 
-	return nil
+// checkIdle 排空中的队列没有任务时通知 Drain，调用方需持有锁
+func (q *GroupQueue) checkIdle() {
+	if q.idle == nil || len(q.queues) > 0 || len(q.running) > 0 {
+		return
+	}
+	select {
+	case <-q.idle:
+	default:
+		close(q.idle)
+	}
 }
 
 // PendingCount 返回待处理任务数
@@ -97,3 +192,29 @@ func (q *GroupQueue) IsRunning(chatJID ChatJID) bool {
 	defer q.mu.Unlock()
 	return q.running[chatJID]
 }
+
+// QueueStatus 群组的排队情况
+type QueueStatus struct {
+	ChatJID ChatJID `json:"chat_jid"`
+	Pending int     `json:"pending"`
+	Running bool    `json:"running"`
+}
+
+// Status 返回有任务排队或正在执行的群组（按 ChatJID 排序）
+func (q *GroupQueue) Status() []QueueStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	seen := make(map[ChatJID]bool)
+	var status []QueueStatus
+	for jid := range q.queues {
+		seen[jid] = true
+	}
+	for jid := range q.running {
+		seen[jid] = true
+	}
+	for jid := range seen {
+		status = append(status, QueueStatus{ChatJID: jid, Pending: len(q.queues[jid]), Running: q.running[jid]})
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].ChatJID < status[j].ChatJID })
+	return status
+}
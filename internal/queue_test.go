@@ -202,3 +202,127 @@ func TestGroupQueue_PendingCount(t *testing.T) {
 		t.Error("Expected 0 pending after all tasks completed")
 	}
 }
+
+func TestGroupQueue_PriorityOrdering(t *testing.T) {
+	queue := NewGroupQueue(1)
+	ctx := context.Background()
+
+	var order []string
+	var mu sync.Mutex
+	record := func(id string) func() {
+		return func() {
+			mu.Lock()
+			order = append(order, id)
+			mu.Unlock()
+		}
+	}
+
+	// 占住唯一的许可，让后续任务排队
+	release := make(chan struct{})
+	queue.Enqueue(ctx, "blocker@nanoclaw", func() { <-release })
+
+	queue.EnqueuePriority(ctx, "a@nanoclaw", PriorityScheduled, record("task-a"))
+	queue.EnqueuePriority(ctx, "b@nanoclaw", PriorityScheduled, record("task-b"))
+	queue.Enqueue(ctx, "c@nanoclaw", record("chat-c"))
+	queue.EnqueuePriority(ctx, "c@nanoclaw", PriorityScheduled, record("task-c"))
+	queue.Enqueue(ctx, "c@nanoclaw", record("chat-c2"))
+
+	if got := queue.PendingCount("c@nanoclaw"); got != 3 {
+		t.Fatalf("PendingCount = %d, want 3", got)
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := len(order)
+		mu.Unlock()
+		if n == 5 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	want := []string{"chat-c", "chat-c2", "task-a", "task-b", "task-c"}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(order) != len(want) {
+		t.Fatalf("order = %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("order = %v, want %v", order, want)
+		}
+	}
+}
+
+func TestGroupQueue_SkipsCancelledJob(t *testing.T) {
+	queue := NewGroupQueue(1)
+	release := make(chan struct{})
+	queue.Enqueue(context.Background(), "a@nanoclaw", func() { <-release })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan struct{}, 1)
+	if err := queue.Enqueue(ctx, "b@nanoclaw", func() { ran <- struct{}{} }); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+	cancel()
+	close(release)
+
+	done := make(chan struct{})
+	queue.Enqueue(context.Background(), "c@nanoclaw", func() { close(done) })
+	<-done
+	select {
+	case <-ran:
+		t.Error("cancelled job should not run")
+	default:
+	}
+}
+
+func TestGroupQueue_Status(t *testing.T) {
+	queue := NewGroupQueue(1)
+	release := make(chan struct{})
+	started := make(chan struct{})
+	queue.Enqueue(t.Context(), "a", func() { close(started); <-release })
+	<-started
+	queue.Enqueue(t.Context(), "a", func() {})
+	queue.Enqueue(t.Context(), "b", func() {})
+
+	status := queue.Status()
+	want := []QueueStatus{{ChatJID: "a", Pending: 1, Running: true}, {ChatJID: "b", Pending: 1}}
+	if len(status) != 2 || status[0] != want[0] || status[1] != want[1] {
+		t.Errorf("status = %+v, want %+v", status, want)
+	}
+	close(release)
+}
+
+func TestGroupQueue_Drain(t *testing.T) {
+	queue := NewGroupQueue(1)
+	release := make(chan struct{})
+	var ran atomic.Int32
+	queue.Enqueue(t.Context(), "a", func() { <-release; ran.Add(1) })
+	queue.Enqueue(t.Context(), "b", func() { ran.Add(1) })
+
+	// 超时时任务仍在执行
+	ctx, cancel := context.WithTimeout(t.Context(), 20*time.Millisecond)
+	defer cancel()
+	if err := queue.Drain(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Drain = %v, want deadline exceeded", err)
+	}
+	if err := queue.Enqueue(t.Context(), "c", func() { ran.Add(1) }); err != ErrQueueClosed {
+		t.Errorf("Enqueue after Drain = %v", err)
+	}
+
+	// 已排队的任务执行完后返回
+	close(release)
+	if err := queue.Drain(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if n := ran.Load(); n != 2 || !queue.Closed() {
+		t.Errorf("ran %d jobs, closed = %t", n, queue.Closed())
+	}
+	// 空队列立即返回
+	if err := NewGroupQueue(1).Drain(t.Context()); err != nil {
+		t.Error(err)
+	}
+}
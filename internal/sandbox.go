@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sandboxMaxOutput 沙箱命令 stdout、stderr 各自保留的最大字节数
+const sandboxMaxOutput = 1 << 20
+
+// SandboxLimits 沙箱中命令的资源限制，0 表示不限
+type SandboxLimits struct {
+	Network    bool   `json:"network"`      // 允许访问网络
+	CPUSeconds int    `json:"cpu_seconds"`  // CPU时间（RLIMIT_CPU）
+	MemoryMB   int    `json:"memory_mb"`    // 地址空间（RLIMIT_AS）
+	FileSizeMB int    `json:"file_size_mb"` // 写入的单个文件大小（RLIMIT_FSIZE）
+	Timeout    string `json:"timeout"`      // 墙钟时间（Go时长字符串）
+}
+
+// DefaultSandboxLimits 没有配置文件时的限制
+var DefaultSandboxLimits = SandboxLimits{CPUSeconds: 10, MemoryMB: 512, FileSizeMB: 64, Timeout: "30s"}
+
+// SandboxConfig 沙箱配置，群组的设置覆盖默认值中给出的字段
+//
+//	{"default": {"network": false, "cpu_seconds": 10, "memory_mb": 512, "timeout": "30s"},
+//	 "groups": {"main": {"network": true, "timeout": "2m"}}}
+type SandboxConfig struct {
+	Default SandboxLimits
+	Groups  map[string]SandboxLimits
+}
+
+// LoadSandboxConfig 读取沙箱配置文件，文件不存在时使用 DefaultSandboxLimits
+//
+// 配置文件位于数据目录而不是群组目录，沙箱中的命令无法修改自己的限制。
+func LoadSandboxConfig(path string) (*SandboxConfig, error) {
+	cfg := &SandboxConfig{Default: DefaultSandboxLimits, Groups: map[string]SandboxLimits{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var file struct {
+		Default json.RawMessage            `json:"default"`
+		Groups  map[string]json.RawMessage `json:"groups"`
+	}
+	if err := decodeStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if file.Default != nil {
+		if err := decodeStrict(file.Default, &cfg.Default); err != nil {
+			return nil, fmt.Errorf("%s: default: %w", path, err)
+		}
+	}
+	if err := cfg.Default.validate(); err != nil {
+		return nil, fmt.Errorf("%s: default: %w", path, err)
+	}
+	for folder, raw := range file.Groups {
+		limits := cfg.Default
+		if err := decodeStrict(raw, &limits); err != nil {
+			return nil, fmt.Errorf("%s: group %s: %w", path, folder, err)
+		}
+		if err := limits.validate(); err != nil {
+			return nil, fmt.Errorf("%s: group %s: %w", path, folder, err)
+		}
+		cfg.Groups[folder] = limits
+	}
+	return cfg, nil
+}
+
+// Limits 返回群组适用的限制
+func (c *SandboxConfig) Limits(folder string) SandboxLimits {
+	if l, ok := c.Groups[folder]; ok {
+		return l
+	}
+	return c.Default
+}
+
+func (l SandboxLimits) validate() error {
+	if l.CPUSeconds < 0 || l.MemoryMB < 0 || l.FileSizeMB < 0 {
+		return errors.New("limits must not be negative")
+	}
+	if _, err := l.timeout(); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (l SandboxLimits) timeout() (time.Duration, error) {
+	if l.Timeout == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(l.Timeout)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid timeout %q", l.Timeout)
+	}
+	return d, nil
+}
+
+func decodeStrict(data []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// sandboxSpec 传给 sandbox-init 的参数
+type sandboxSpec struct {
+	Dir        string        `json:"dir"`            // 群组目录（宿主路径）
+	Namespaces bool          `json:"namespaces"`     // 是否已在新的命名空间中
+	User       string        `json:"user,omitempty"` // 不使用命名空间且以root运行时切换到的用户
+	Limits     SandboxLimits `json:"limits"`
+}
+
+// SandboxResult 沙箱命令的执行结果
+type SandboxResult struct {
+	Status int // 退出码，被信号结束时为 -1
+	Stdout string
+	Stderr string
+}
+
+// Sandbox 在隔离环境中执行技能的外部命令
+//
+// 命令由 sandbox-init 辅助进程启动：在新的 user/mount/pid/ipc/uts（不允许网络时还有 net）
+// 命名空间中只挂载群组目录（/group，可写）和只读的系统目录，设置资源限制、Landlock
+// 规则（内核支持时）和 seccomp 过滤后 exec 目标命令。无法创建用户命名空间时降级为
+// 只使用资源限制、Landlock 和 seccomp（禁止 AF_UNIX 以外的 socket），并记录一次警告。
+type Sandbox struct {
+	path      string
+	args      []string
+	env       []string // 追加到命令环境
+	groupsDir string
+	user      string // 以root运行时沙箱进程使用的用户，为空时使用当前用户
+
+	mu           sync.Mutex
+	config       *SandboxConfig
+	noNamespaces bool // 创建命名空间失败，已降级
+}
+
+// NewSandbox 创建以 path args... 启动 sandbox-init 的沙箱
+func NewSandbox(groupsDir, user, path string, args ...string) *Sandbox {
+	return &Sandbox{path: path, args: args, groupsDir: groupsDir, user: user,
+		config: &SandboxConfig{Default: DefaultSandboxLimits}}
+}
+
+// SetConfig 替换沙箱配置，对之后的命令生效
+func (s *Sandbox) SetConfig(c *SandboxConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.config = c
+}
+
+// Run 在群组的沙箱中用 /bin/sh -c 执行命令，stdin 作为标准输入
+//
+// 命令以非零状态退出不是错误；超时、无法启动时返回错误。
+func (s *Sandbox) Run(ctx context.Context, groupFolder, command, stdin string) (*SandboxResult, error) {
+	if !validFolder(groupFolder) {
+		return nil, fmt.Errorf("invalid group folder %q", groupFolder)
+	}
+	dir := filepath.Join(s.groupsDir, groupFolder)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	limits := s.config.Limits(groupFolder)
+	namespaces := !s.noNamespaces
+	s.mu.Unlock()
+
+	spec := sandboxSpec{Dir: dir, Namespaces: namespaces, Limits: limits}
+	res, err := s.run(ctx, spec, command, stdin)
+	if namespaces && errors.Is(err, errNamespacesUnavailable) {
+		s.mu.Lock()
+		if !s.noNamespaces {
+			s.noNamespaces = true
+			slog.Warn("sandbox: user namespaces unavailable, isolating with rlimits, landlock and seccomp only", "err", err)
+		}
+		s.mu.Unlock()
+		spec.Namespaces = false
+		res, err = s.run(ctx, spec, command, stdin)
+	}
+	return res, err
+}
+
+func (s *Sandbox) run(ctx context.Context, spec sandboxSpec, command, stdin string) (*SandboxResult, error) {
+	timeout, _ := spec.Limits.timeout()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if !spec.Namespaces && IsRoot() {
+		spec.User = s.user
+	}
+	raw, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+	args := append(append(append([]string(nil), s.args...), string(raw)), "/bin/sh", "-c", command)
+	cmd := exec.CommandContext(ctx, s.path, args...)
+	cmd.Env = append([]string{"PATH=/usr/local/bin:/usr/bin:/bin", "LANG=C.UTF-8"}, s.env...)
+	cmd.Stdin = bytes.NewReader([]byte(stdin))
+	var stdout, stderr limitedBuffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := s.configure(cmd, spec); err != nil {
+		return nil, err
+	}
+
+	if err := cmd.Start(); err != nil {
+		if spec.Namespaces && namespaceError(err) {
+			return nil, fmt.Errorf("%w: %v", errNamespacesUnavailable, err)
+		}
+		return nil, fmt.Errorf("start sandbox: %w", err)
+	}
+	err = cmd.Wait()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("sandbox: command timed out after %s", timeout)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("sandbox: %w", err)
+	}
+	return &SandboxResult{Status: cmd.ProcessState.ExitCode(), Stdout: stdout.String(), Stderr: stderr.String()}, nil
+}
+
+// validFolder 群组目录名不能为空或包含路径分隔符，避免挂载群组目录之外的路径
+func validFolder(folder string) bool {
+	return folder != "" && folder != "." && folder != ".." && !strings.ContainsAny(folder, `/\`)
+}
+
+// errNamespacesUnavailable 无法创建命名空间，Run 降级后重试
+var errNamespacesUnavailable = errors.New("namespaces unavailable")
+
+// limitedBuffer 只保留前 sandboxMaxOutput 字节的输出
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := sandboxMaxOutput - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "\n[output truncated]"
+	}
+	return b.buf.String()
+}
@@ -0,0 +1,340 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// sandboxSystemDirs 只读挂载到沙箱中的系统目录，不存在的跳过
+var sandboxSystemDirs = []string{"/usr", "/bin", "/sbin", "/lib", "/lib32", "/lib64", "/libx32", "/etc"}
+
+// sandboxDevices 沙箱 /dev 中可用的设备
+var sandboxDevices = []string{"/dev/null", "/dev/zero", "/dev/random", "/dev/urandom"}
+
+// configure 设置 sandbox-init 的命名空间和进程组
+func (s *Sandbox) configure(cmd *exec.Cmd, spec sandboxSpec) error {
+	attr := &syscall.SysProcAttr{Setpgid: true, Pdeathsig: syscall.SIGKILL}
+	if spec.Namespaces {
+		uid, gid := os.Getuid(), os.Getgid()
+		if IsRoot() && s.user != "" {
+			u, err := user.Lookup(s.user)
+			if err != nil {
+				return fmt.Errorf("lookup user %s: %w", s.user, err)
+			}
+			uid, _ = strconv.Atoi(u.Uid)
+			gid, _ = strconv.Atoi(u.Gid)
+		}
+		attr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID |
+			syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+		if !spec.Limits.Network {
+			attr.Cloneflags |= syscall.CLONE_NEWNET
+		}
+		// 沙箱中的root映射为宿主的普通用户，只在自己的命名空间中有特权
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: uid, Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: gid, Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+	}
+	cmd.SysProcAttr = attr
+	// 结束整个进程组，命令派生的子进程不会在超时后继续运行
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = workerStopTimeout
+	return nil
+}
+
+// namespaceError 启动失败是否因为无法创建命名空间（未开启或限制了非特权用户命名空间）
+func namespaceError(err error) bool {
+	for _, errno := range []syscall.Errno{syscall.EPERM, syscall.EACCES, syscall.EINVAL, syscall.ENOSPC, syscall.ENOSYS} {
+		if errors.Is(err, errno) {
+			return true
+		}
+	}
+	return false
+}
+
+// SandboxInit 沙箱辅助进程（nanoclaw sandbox-init <spec> <command...>），成功时不返回
+//
+// 在 Sandbox 创建的命名空间中搭建根文件系统，然后依次设置资源限制、no_new_privs、
+// Landlock 和 seccomp，最后 exec 命令。
+func SandboxInit(args []string) error {
+	if len(args) < 2 {
+		return errors.New("usage: sandbox-init <spec> <command> [args...]")
+	}
+	var spec sandboxSpec
+	if err := json.Unmarshal([]byte(args[0]), &spec); err != nil {
+		return fmt.Errorf("parse sandbox spec: %w", err)
+	}
+	// 以下设置只作用于当前线程，exec 也要在同一线程上进行
+	runtime.LockOSThread()
+
+	dir, tmp := spec.Dir, spec.Dir
+	if spec.Namespaces {
+		if err := setupSandboxRoot(spec.Dir); err != nil {
+			return err
+		}
+		dir, tmp = "/group", "/tmp"
+	} else if spec.User != "" {
+		if err := DropPrivileges(spec.User); err != nil {
+			return err
+		}
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	os.Setenv("HOME", dir)
+	os.Setenv("TMPDIR", tmp)
+
+	if err := setSandboxLimits(spec.Limits); err != nil {
+		return err
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("no_new_privs: %w", err)
+	}
+	if err := landlockRestrict(spec, dir); err != nil {
+		return err
+	}
+	// 已在独立的网络命名空间中时不需要限制 socket
+	if err := installSeccomp(!spec.Namespaces && !spec.Limits.Network); err != nil {
+		return err
+	}
+
+	path, err := exec.LookPath(args[1])
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, args[1:], os.Environ())
+}
+
+// setupSandboxRoot 在新的 mount 命名空间中构造只含系统目录、群组目录、/tmp 和 /dev 的根目录并切换过去
+func setupSandboxRoot(groupDir string) error {
+	// 群组目录可能位于随后被覆盖的 /tmp 下，先持有它的 fd
+	group, err := os.OpenFile(groupDir, unix.O_PATH|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return err
+	}
+	defer group.Close()
+
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("make mounts private: %w", err)
+	}
+	root := os.TempDir()
+	if err := unix.Mount("tmpfs", root, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=755,size=1m"); err != nil {
+		return fmt.Errorf("mount root: %w", err)
+	}
+
+	for _, d := range sandboxSystemDirs {
+		fi, err := os.Lstat(d)
+		if err != nil {
+			continue
+		}
+		target := filepath.Join(root, d)
+		if fi.Mode()&os.ModeSymlink != 0 {
+			// 如 /bin -> usr/bin
+			link, err := os.Readlink(d)
+			if err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := bindMount(d, target, true); err != nil {
+			return err
+		}
+	}
+	if err := bindMount(fmt.Sprintf("/proc/self/fd/%d", group.Fd()), filepath.Join(root, "group"), false); err != nil {
+		return err
+	}
+	if err := mountTmpfs(filepath.Join(root, "tmp"), "mode=1777,size=64m"); err != nil {
+		return err
+	}
+	if err := mountTmpfs(filepath.Join(root, "dev"), "mode=755,size=64k"); err != nil {
+		return err
+	}
+	for _, d := range sandboxDevices {
+		target := filepath.Join(root, d)
+		if err := os.WriteFile(target, nil, 0644); err != nil {
+			return err
+		}
+		if err := unix.Mount(d, target, "", unix.MS_BIND, ""); err != nil {
+			return fmt.Errorf("mount %s: %w", d, err)
+		}
+	}
+	// 新的 pid 命名空间只能看到自己的进程；挂载失败（如宿主 /proc 被部分遮盖）不影响命令执行
+	if err := os.Mkdir(filepath.Join(root, "proc"), 0555); err != nil {
+		return err
+	}
+	unix.Mount("proc", filepath.Join(root, "proc"), "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+
+	old := filepath.Join(root, ".oldroot")
+	if err := os.Mkdir(old, 0700); err != nil {
+		return err
+	}
+	if err := unix.PivotRoot(root, old); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+	if err := unix.Unmount("/.oldroot", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("unmount old root: %w", err)
+	}
+	if err := os.Remove("/.oldroot"); err != nil {
+		return err
+	}
+	if err := unix.Mount("", "/", "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("remount root read-only: %w", err)
+	}
+	return nil
+}
+
+// bindMount 把 src 挂载到 target，readonly 时重新挂载为只读
+func bindMount(src, target string, readonly bool) error {
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	if err := unix.Mount(src, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind %s: %w", src, err)
+	}
+	if !readonly {
+		return nil
+	}
+	// 用户命名空间中重新挂载时必须保留原挂载点锁定的 nosuid/nodev/noexec 等标志
+	var st unix.Statfs_t
+	if err := unix.Statfs(target, &st); err != nil {
+		return err
+	}
+	flags := uintptr(st.Flags) & (unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC | unix.MS_NOATIME | unix.MS_NODIRATIME)
+	if st.Flags&unix.ST_RELATIME != 0 {
+		flags |= unix.MS_RELATIME
+	}
+	if err := unix.Mount("", target, "", unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY|flags, ""); err != nil {
+		return fmt.Errorf("remount %s read-only: %w", src, err)
+	}
+	return nil
+}
+
+func mountTmpfs(target, options string) error {
+	if err := os.Mkdir(target, 0755); err != nil {
+		return err
+	}
+	if err := unix.Mount("tmpfs", target, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, options); err != nil {
+		return fmt.Errorf("mount %s: %w", target, err)
+	}
+	return nil
+}
+
+// setSandboxLimits 设置 CPU 时间、地址空间和文件大小限制，0 表示不限
+func setSandboxLimits(l SandboxLimits) error {
+	limits := []struct {
+		resource int
+		value    uint64
+	}{
+		{unix.RLIMIT_CPU, uint64(l.CPUSeconds)},
+		{unix.RLIMIT_AS, uint64(l.MemoryMB) << 20},
+		{unix.RLIMIT_FSIZE, uint64(l.FileSizeMB) << 20},
+	}
+	for _, lim := range limits {
+		if lim.value == 0 {
+			continue
+		}
+		if err := unix.Setrlimit(lim.resource, &unix.Rlimit{Cur: lim.value, Max: lim.value}); err != nil {
+			return fmt.Errorf("setrlimit %d: %w", lim.resource, err)
+		}
+	}
+	return nil
+}
+
+// landlockRestrict 限制命令只能写群组目录（和命名空间中私有的 /tmp），只能读系统目录
+//
+// 内核不支持 Landlock 时跳过，命名空间和 seccomp 仍然生效。
+func landlockRestrict(spec sandboxSpec, dir string) error {
+	abi := landlockABI()
+	if abi <= 0 {
+		return nil
+	}
+	// 各版本ABI支持的文件系统权限
+	handled := uint64(unix.LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1)
+	if abi >= 2 {
+		handled |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		handled |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	if abi >= 5 {
+		handled |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	const read = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr.Access_fs), 0)
+	if errno != 0 {
+		return fmt.Errorf("landlock: create ruleset: %w", errno)
+	}
+	defer unix.Close(int(fd))
+
+	rules := map[string]uint64{dir: handled}
+	for _, d := range sandboxDevices {
+		rules[d] = handled
+	}
+	if spec.Namespaces {
+		// 根目录已只包含允许的内容
+		rules["/"] = read
+		rules["/tmp"] = handled
+	} else {
+		for _, d := range sandboxSystemDirs {
+			rules[d] = read
+		}
+	}
+	for path, access := range rules {
+		if err := landlockAddRule(int(fd), path, access); err != nil {
+			return err
+		}
+	}
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0, 0); errno != 0 {
+		return fmt.Errorf("landlock: restrict self: %w", errno)
+	}
+	return nil
+}
+
+// landlockABI 返回内核支持的 Landlock ABI 版本，不支持时为 0
+func landlockABI() int {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0
+	}
+	return int(abi)
+}
+
+// landlockAddRule 允许访问 path 之下的文件，不存在的路径跳过
+func landlockAddRule(ruleset int, path string, access uint64) error {
+	f, err := os.OpenFile(path, unix.O_PATH, 0)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	if fi, err := f.Stat(); err == nil && !fi.IsDir() {
+		// 文件只能设置适用于文件的权限
+		access &= unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE | unix.LANDLOCK_ACCESS_FS_READ_FILE |
+			unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	attr := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(f.Fd())}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset), unix.LANDLOCK_RULE_PATH_BENEATH,
+		uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("landlock: add rule %s: %w", path, errno)
+	}
+	return nil
+}
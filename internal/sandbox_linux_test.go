@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSandboxInitProcess 不是测试：newTestSandbox 让测试二进制作为 sandbox-init 运行
+func TestSandboxInitProcess(t *testing.T) {
+	if os.Getenv("NANOCLAW_TEST_SANDBOX") == "" {
+		t.Skip("helper process for TestSandbox")
+	}
+	for i, a := range os.Args {
+		if a == "--" {
+			err := SandboxInit(os.Args[i+1:])
+			t.Fatal(err)
+		}
+	}
+	t.Fatal("missing sandbox-init arguments")
+}
+
+// newTestSandbox 返回以测试二进制为 sandbox-init 的沙箱，群组目录位于临时目录
+func newTestSandbox(t *testing.T, limits SandboxLimits) (*Sandbox, string) {
+	t.Helper()
+	groups := t.TempDir()
+	s := NewSandbox(groups, "", os.Args[0], "-test.run=^TestSandboxInitProcess$", "--")
+	s.env = []string{"NANOCLAW_TEST_SANDBOX=1"}
+	s.SetConfig(&SandboxConfig{Default: limits})
+	return s, filepath.Join(groups, "main")
+}
+
+// requireNamespaces 无法创建用户命名空间时跳过
+func requireNamespaces(t *testing.T, s *Sandbox) {
+	t.Helper()
+	res, err := s.Run(context.Background(), "main", "true", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != 0 {
+		t.Fatalf("true exited %d: %s", res.Status, res.Stderr)
+	}
+	if s.noNamespaces {
+		t.Skip("user namespaces unavailable")
+	}
+}
+
+func TestSandbox_Namespaces(t *testing.T) {
+	s, dir := newTestSandbox(t, SandboxLimits{Timeout: "10s"})
+	requireNamespaces(t, s)
+	secret := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(secret, []byte("secret"), 0644)
+
+	res, err := s.Run(context.Background(), "main",
+		`pwd; cat; echo out > out.txt; cat `+secret+`; ls /proc/net/dev >/dev/null && tail -n +3 /proc/net/dev | cut -d: -f1; echo $$`, "from stdin\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Fields(res.Stdout)
+	if len(lines) < 3 || lines[0] != "/group" || lines[1] != "from" || lines[2] != "stdin" {
+		t.Fatalf("stdout = %q, stderr = %q", res.Stdout, res.Stderr)
+	}
+	// 写入群组目录对宿主可见
+	if data, _ := os.ReadFile(filepath.Join(dir, "out.txt")); string(data) != "out\n" {
+		t.Errorf("out.txt = %q", data)
+	}
+	// 群组目录之外的文件不可见
+	if strings.Contains(res.Stdout, "secret") || !strings.Contains(res.Stderr, secret) {
+		t.Errorf("secret readable: stdout = %q, stderr = %q", res.Stdout, res.Stderr)
+	}
+	// 独立的网络和pid命名空间：只有 lo，shell 是 1 号进程
+	if rest := strings.Join(lines[3:], " "); rest != "lo 1" {
+		t.Errorf("net devices and pid = %q", rest)
+	}
+	if res.Status != 0 {
+		t.Errorf("status = %d", res.Status)
+	}
+
+	res, err = s.Run(context.Background(), "main", "touch /usr/x; unshare --user true || echo denied; exit 3", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status != 3 || !strings.Contains(res.Stdout, "denied") || !strings.Contains(res.Stderr, "/usr/x") {
+		t.Errorf("status = %d, stdout = %q, stderr = %q", res.Status, res.Stdout, res.Stderr)
+	}
+}
+
+func TestSandbox_Limits(t *testing.T) {
+	s, _ := newTestSandbox(t, SandboxLimits{Timeout: "300ms", CPUSeconds: 1})
+	requireNamespaces(t, s)
+
+	start := time.Now()
+	_, err := s.Run(context.Background(), "main", "sleep 5 & wait", "")
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("err = %v, want timeout", err)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Errorf("timeout took %s", d)
+	}
+
+	// CPU时间用完后被 SIGXCPU/SIGKILL 结束
+	s.SetConfig(&SandboxConfig{Default: SandboxLimits{Timeout: "10s", CPUSeconds: 1}})
+	res, err := s.Run(context.Background(), "main", "while :; do :; done", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Status == 0 {
+		t.Errorf("busy loop exited normally")
+	}
+}
+
+func TestSandbox_Fallback(t *testing.T) {
+	s, dir := newTestSandbox(t, SandboxLimits{Timeout: "10s"})
+	s.noNamespaces = true
+	secret := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(secret, []byte("secret"), 0644)
+
+	res, err := s.Run(context.Background(), "main", `pwd; echo ok > f; cat `+secret+`; unshare --user true || echo denied`, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(res.Stdout, dir+"\n") || !strings.Contains(res.Stdout, "denied") {
+		t.Errorf("stdout = %q, stderr = %q", res.Stdout, res.Stderr)
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "f")); string(data) != "ok\n" {
+		t.Errorf("f = %q", data)
+	}
+	// 没有 Landlock 时只能依赖文件权限，不检查
+	if landlockABI() > 0 && strings.Contains(res.Stdout, "secret") {
+		t.Errorf("secret readable without namespaces: %q", res.Stdout)
+	}
+}
+
+func TestSkill_Exec(t *testing.T) {
+	db := TestTempDB(t)
+	sr := NewSkillRegistry(db)
+	defer sr.Close()
+	s, dir := newTestSandbox(t, SandboxLimits{Timeout: "10s"})
+	sr.SetSandbox(s)
+
+	script := `
+		local r, err = exec.run("cat; echo err >&2; exit 2", {stdin = "hello"})
+		if not r then return err end
+		return r.status .. "|" .. r.stdout .. "|" .. r.stderr`
+	sr.Register(&Skill{Name: "sh", LuaScript: script, Source: SourceGlobal, Capabilities: []string{CapExec}})
+	sr.Register(&Skill{Name: "nocap", LuaScript: `return tostring(exec)`, Source: SourceGlobal})
+
+	out, err := sr.Run(context.Background(), "sh", SkillContext{GroupFolder: "main"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "2|hello|err\n" {
+		t.Errorf("out = %q", out)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("group dir not created: %v", err)
+	}
+	if out, _ := sr.Run(context.Background(), "nocap", SkillContext{GroupFolder: "main"}); out != "nil" {
+		t.Errorf("exec without capability = %q", out)
+	}
+}
@@ -0,0 +1,23 @@
+//go:build !linux
+
+package internal
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errSandboxUnsupported = errors.New("sandbox not supported on this platform")
+
+func (s *Sandbox) configure(cmd *exec.Cmd, spec sandboxSpec) error {
+	return errSandboxUnsupported
+}
+
+func namespaceError(err error) bool {
+	return false
+}
+
+// SandboxInit 沙箱辅助进程，只支持Linux
+func SandboxInit(args []string) error {
+	return errSandboxUnsupported
+}
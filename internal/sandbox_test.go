@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSandboxConfig(t *testing.T) {
+	dir := t.TempDir()
+	c, err := LoadSandboxConfig(filepath.Join(dir, "missing.json"))
+	if err != nil || c.Limits("main") != DefaultSandboxLimits {
+		t.Fatalf("missing file: %+v, %v", c, err)
+	}
+
+	path := filepath.Join(dir, "sandbox.json")
+	os.WriteFile(path, []byte(`{"default":{"cpu_seconds":5},"groups":{"main":{"network":true,"timeout":"2m"}}}`), 0644)
+	c, err = LoadSandboxConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 未给出的字段沿用默认值，群组设置覆盖默认设置
+	if got := c.Limits("other"); got.CPUSeconds != 5 || got.MemoryMB != 512 || got.Network || got.Timeout != "30s" {
+		t.Errorf("default limits = %+v", got)
+	}
+	if got := c.Limits("main"); got.CPUSeconds != 5 || !got.Network || got.Timeout != "2m" {
+		t.Errorf("main limits = %+v", got)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"unknown field", `{"default":{"cpu":1}}`, "unknown field"},
+		{"bad timeout", `{"groups":{"main":{"timeout":"soon"}}}`, "invalid timeout"},
+		{"negative", `{"default":{"memory_mb":-1}}`, "must not be negative"},
+		{"bad json", `{"default":`, "parse"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name+".json")
+		os.WriteFile(path, []byte(tt.content), 0644)
+		if _, err := LoadSandboxConfig(path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: err = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSandbox_InvalidFolder(t *testing.T) {
+	s := NewSandbox(t.TempDir(), "", "false")
+	for _, folder := range []string{"", "..", "a/b", `a\b`} {
+		if _, err := s.Run(nil, folder, "true", ""); err == nil {
+			t.Errorf("folder %q accepted", folder)
+		}
+	}
+}
@@ -2,118 +2,604 @@ package internal
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"log/slog"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/robfig/cron/v3"
 )
 
+const (
+	defaultRetryDelay = time.Minute
+	maxRetryDelay     = time.Hour
+	// misfireGrace 晚于计划时间超过该值（且超过轮询间隔）才视为错过执行
+	misfireGrace = time.Minute
+	// maxPendingEvents 每个 event 任务在两次执行之间保留的事件数
+	maxPendingEvents = 20
+)
+
 // Scheduler 定时任务调度器
 type Scheduler struct {
-	db     *DB
-	agent  *Agent
-	cron   *cron.Cron
-	ticker *time.Ticker
-	stop   chan struct{}
+	db      *DB
+	agent   Runner
+	stop    chan struct{}
+	wake    chan struct{}
+	deliver func(chatJID ChatJID, content string) error
+	now     func() time.Time
+
+	// pollInterval 最长休眠时间，用于发现其他进程新建的任务
+	pollInterval time.Duration
+	timeout      time.Duration // 任务未设置超时时的默认值，0 表示不限
+	retention    time.Duration // 执行记录保留时长，0 表示不限
+	keepRuns     int           // 每个任务保留的执行记录数，0 表示不限
+
+	queue    *GroupQueue
+	priority int
+	skills   *SkillRegistry // 工作流的技能步骤使用
+	notify   func(Notification)
+
+	mu       sync.Mutex
+	inflight map[string]bool    // 已入队或正在执行的任务，避免重复调度
+	events   map[string][]Event // event 任务等待执行时累积的事件
 }
 
 // NewScheduler 创建调度器
-func NewScheduler(db *DB, agent *Agent) *Scheduler {
+func NewScheduler(db *DB, agent Runner) *Scheduler {
 	return &Scheduler{
-		db:    db,
-		agent: agent,
-		cron:  cron.New(),
-		stop:  make(chan struct{}),
+		db:       db,
+		agent:    agent,
+		stop:     make(chan struct{}),
+		wake:     make(chan struct{}, 1),
+		now:      time.Now,
+		inflight: make(map[string]bool),
+		events:   make(map[string][]Event),
+
+		pollInterval: time.Minute,
 	}
 }
 
+// SetPollInterval 设置最长休眠时间
+func (s *Scheduler) SetPollInterval(d time.Duration) {
+	if d > 0 {
+		s.pollInterval = d
+	}
+}
+
+// SetClock 替换时间源（测试用）
+func (s *Scheduler) SetClock(now func() time.Time) {
+	s.now = now
+}
+
+// SetTimeout 设置任务的默认最长执行时间
+func (s *Scheduler) SetTimeout(d time.Duration) {
+	s.timeout = d
+}
+
+// SetRetention 设置执行记录的保留策略
+func (s *Scheduler) SetRetention(maxAge time.Duration, keep int) {
+	s.retention = maxAge
+	s.keepRuns = keep
+}
+
+// SetQueue 通过 GroupQueue 执行任务，与聊天请求共享并发限制和群组串行
+func (s *Scheduler) SetQueue(queue *GroupQueue, priority int) {
+	s.queue = queue
+	s.priority = priority
+}
+
+// SetSkills 设置工作流技能步骤使用的技能注册表
+func (s *Scheduler) SetSkills(sr *SkillRegistry) {
+	s.skills = sr
+}
+
+// SetNotify 设置推送通知回调（通常为 Hub.Publish），每次执行结束后发送 NotifyTaskRun
+func (s *Scheduler) SetNotify(fn func(Notification)) {
+	s.notify = fn
+}
+
+// SetDeliver 设置任务结果的投递回调（通常为 Orchestrator.PostBotMessage）
+func (s *Scheduler) SetDeliver(fn func(chatJID ChatJID, content string) error) {
+	s.deliver = fn
+}
+
 // Start 启动调度器
 func (s *Scheduler) Start(ctx context.Context) {
-	s.cron.Start()
-	s.ticker = time.NewTicker(60 * time.Second)
-
 	go s.loop(ctx)
 }
 
 // Stop 停止调度器
 func (s *Scheduler) Stop() {
-	s.cron.Stop()
-	if s.ticker != nil {
-		s.ticker.Stop()
-	}
 	close(s.stop)
 }
 
+// Wake 任务发生变化时唤醒调度循环重新计算休眠时间
+func (s *Scheduler) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// HandleEvent 检查会话中的 event 任务，匹配的任务在防抖时间后执行
+//
+// 只处理用户消息和成员加入；每次匹配都把执行时间推迟到 Debounce 之后，
+// 期间的事件合并为一次执行。最近一小时的执行次数达到 RateLimit 时忽略事件。
+func (s *Scheduler) HandleEvent(ev Event) {
+	now := s.now()
+	if ev.Time.IsZero() {
+		ev.Time = now
+	}
+	tasks, err := s.db.FindTasks(TaskFilter{ChatJID: ev.ChatJID, Status: TaskActive, ScheduleType: "event"})
+	if err != nil {
+		slog.Error("find event tasks", "err", err)
+		return
+	}
+
+	triggered := false
+	for _, task := range tasks {
+		trigger, err := ParseEventTrigger(task.ScheduleValue)
+		if err != nil || !trigger.Match(ev) || task.Attempt > 0 {
+			continue
+		}
+		if task.RateLimit > 0 {
+			n, err := s.db.CountTaskRunsSince(task.ID, now.Add(-time.Hour))
+			if err != nil || n >= task.RateLimit {
+				slog.Info("event task rate limited", "id", task.ID, "runs", n, "err", err)
+				continue
+			}
+		}
+
+		s.mu.Lock()
+		pending := append(s.events[task.ID], ev)
+		s.events[task.ID] = pending[max(len(pending)-maxPendingEvents, 0):]
+		s.mu.Unlock()
+
+		if err := s.db.TriggerTask(task.ID, now.Add(task.Debounce)); err != nil {
+			slog.Error("trigger event task", "id", task.ID, "err", err)
+			continue
+		}
+		triggered = true
+	}
+	if triggered {
+		s.Wake()
+	}
+}
+
+// takeEvents 取出任务累积的事件
+func (s *Scheduler) takeEvents(id string) []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	evs := s.events[id]
+	delete(s.events, id)
+	return evs
+}
+
+// loop 休眠到最早的 next_run，到期后分发任务
 func (s *Scheduler) loop(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		case <-s.stop:
 			return
-		case <-s.ticker.C:
+		case <-s.wake:
+		case <-timer.C:
 			s.checkTasks(ctx)
 		}
+		timer.Reset(s.sleepDuration())
+	}
+}
+
+// sleepDuration 距离下一个任务到期的时间，限制在 [1s, pollInterval]
+//
+// 下限避免已到期但仍在执行的任务造成空转。
+func (s *Scheduler) sleepDuration() time.Duration {
+	next, err := s.db.NextDueTime()
+	if err != nil {
+		slog.Error("next due time", "err", err)
+		return s.pollInterval
 	}
+	if next == nil {
+		return s.pollInterval
+	}
+	return min(max(next.Sub(s.now()), time.Second), s.pollInterval)
 }
 
 func (s *Scheduler) checkTasks(ctx context.Context) {
-	tasks, err := s.db.GetDueTasks(time.Now())
+	tasks, err := s.db.GetDueTasks(s.now())
 	if err != nil {
 		slog.Error("get due tasks", "err", err)
 		return
 	}
 
 	for _, task := range tasks {
-		go s.runTask(ctx, task)
+		s.dispatch(ctx, task)
 	}
 }
 
+// dispatch 将任务交给队列（未设置队列时直接执行）
+func (s *Scheduler) dispatch(ctx context.Context, task Task) {
+	s.mu.Lock()
+	if s.inflight[task.ID] {
+		s.mu.Unlock()
+		return
+	}
+	s.inflight[task.ID] = true
+	s.mu.Unlock()
+
+	if s.skipMisfire(task) {
+		s.done(task.ID)
+		return
+	}
+
+	run := func() {
+		defer s.done(task.ID)
+		s.runTask(ctx, task)
+	}
+	if s.queue == nil {
+		go run()
+		return
+	}
+	if err := s.queue.EnqueuePriority(ctx, task.ChatJID, s.priority, run); err != nil {
+		slog.Error("enqueue task", "id", task.ID, "err", err)
+		s.done(task.ID)
+	}
+}
+
+func (s *Scheduler) done(id string) {
+	s.mu.Lock()
+	delete(s.inflight, id)
+	s.mu.Unlock()
+}
+
+// misfired 计划时间已过去超过容忍时间（通常因为进程停止）
+func (s *Scheduler) misfired(task Task) bool {
+	return task.NextRun != nil && s.now().Sub(*task.NextRun) > max(misfireGrace, s.pollInterval)
+}
+
+// skipMisfire 按 MisfireSkip 跳过错过的执行并安排下一次，返回是否已跳过
+//
+// 等待重试的任务不会被跳过。
+func (s *Scheduler) skipMisfire(task Task) bool {
+	if task.Misfire != MisfireSkip || task.Attempt > 0 || !s.misfired(task) {
+		return false
+	}
+	now := s.now()
+	reason := fmt.Sprintf("skipped: missed scheduled time %s", task.NextRun.Format(time.RFC3339))
+	slog.Info("skip missed task", "id", task.ID, "scheduled", task.NextRun)
+	s.record(task, &TaskRun{TaskID: task.ID, StartedAt: now, EndedAt: now, Status: TaskRunSkipped, Error: reason})
+	if err := s.advance(&task, TaskFailed, now); err != nil {
+		reason = fmt.Sprintf("%s; %v", reason, err)
+	}
+	s.finish(task, reason)
+	return true
+}
+
 func (s *Scheduler) runTask(ctx context.Context, task Task) {
-	slog.Info("running task", "id", task.ID, "group", task.GroupFolder)
+	// 排队期间任务可能已被暂停或删除
+	current, err := s.db.GetTask(task.ID)
+	if err != nil || current.Status != TaskActive {
+		slog.Info("skip task", "id", task.ID, "err", err)
+		return
+	}
+	task = *current
+	slog.Info("running task", "id", task.ID, "group", task.GroupFolder, "attempt", task.Attempt)
+
+	// 限制单次执行时间
+	runCtx := ctx
+	timeout := task.Timeout
+	if timeout == 0 {
+		timeout = s.timeout
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	run := &TaskRun{TaskID: task.ID, StartedAt: s.now()}
+	resp, usage, err := s.execute(runCtx, task, run)
+	if err != nil && ctx.Err() == nil && runCtx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s: %w", timeout, err)
+	}
+	run.EndedAt = s.now()
+	run.PromptTokens, run.CompletionTokens = usage.PromptTokens, usage.CompletionTokens
+	run.Output = resp
+	run.Status = TaskRunSuccess
+	if err != nil {
+		run.Status, run.Error = TaskRunError, err.Error()
+	}
+	s.record(task, run)
+	if err != nil {
+		if ctx.Err() != nil {
+			// 进程退出导致中断：保持原计划，重启后按错过策略处理
+			slog.Info("task interrupted", "id", task.ID)
+			return
+		}
+		if len(run.Steps) > 0 {
+			// 重试时从失败的步骤继续
+			task.ResumeFrom = run.ID
+		}
+		s.fail(task, err)
+		return
+	}
+
+	// 保存结果
+	task.Attempt = 0
+	task.ResumeFrom = 0
+	from := s.now()
+	if task.Misfire == MisfireAll && task.NextRun != nil && current.Attempt == 0 {
+		// 从计划时间而不是当前时间推算，错过的时间点会依次到期
+		from = *task.NextRun
+	}
+	if err := s.advance(&task, TaskCompleted, from); err != nil {
+		s.finish(task, "error: "+err.Error())
+		s.post(task, fmt.Sprintf("Error: scheduled task %s stopped: %v", task.ID, err))
+		return
+	}
+	s.finish(task, resp)
+	if !task.Silent {
+		s.post(task, resp)
+	}
+	slog.Info("task completed", "id", task.ID, "status", task.Status)
+}
 
+// execute 执行任务：有工作流步骤时按步骤执行，否则直接执行任务提示
+func (s *Scheduler) execute(ctx context.Context, task Task, run *TaskRun) (string, Usage, error) {
+	events := s.takeEvents(task.ID)
+	steps, err := s.db.GetTaskSteps(task.ID)
+	if err != nil {
+		return "", Usage{}, fmt.Errorf("get steps: %w", err)
+	}
+	if len(steps) == 0 {
+		return s.invoke(ctx, task, events)
+	}
+	var resume *TaskRun
+	if task.ResumeFrom > 0 {
+		if resume, err = s.db.GetTaskRun(task.ResumeFrom); err != nil {
+			slog.Warn("resume run not found, restarting workflow", "id", task.ID, "run", task.ResumeFrom, "err", err)
+			resume = nil
+		}
+	}
+	return s.runWorkflow(ctx, task, steps, events, resume, run)
+}
+
+// invoke 以最近的会话消息为上下文执行任务提示，触发执行的事件附加在提示之后
+func (s *Scheduler) invoke(ctx context.Context, task Task, events []Event) (string, Usage, error) {
 	// 获取历史消息作为上下文
 	messages, err := s.db.GetMessages(task.ChatJID, 10)
 	if err != nil {
-		slog.Error("get messages", "err", err)
-		s.db.UpdateTaskRun(task.ID, "error: "+err.Error(), nil)
-		return
+		return "", Usage{}, fmt.Errorf("get messages: %w", err)
 	}
 
 	// 添加任务提示
+	prompt := task.Prompt
+	if len(events) > 0 {
+		var sb strings.Builder
+		sb.WriteString(prompt)
+		sb.WriteString("\n\nTriggered by:")
+		for _, ev := range events {
+			sb.WriteString("\n- " + ev.String())
+		}
+		prompt = sb.String()
+	}
 	messages = append(messages, Message{
 		ID:        MessageID("task-" + task.ID),
 		ChatJID:   task.ChatJID,
-		Sender:    "System",
-		Content:   task.Prompt,
-		Timestamp: time.Now(),
+		Sender:    SystemSender,
+		Content:   prompt,
+		Timestamp: s.now(),
 	})
 
 	// 调用Agent
+	if ur, ok := s.agent.(UsageRunner); ok {
+		return ur.RunWithUsage(ctx, task.GroupFolder, messages)
+	}
 	resp, err := s.agent.Run(ctx, task.GroupFolder, messages)
+	return resp, Usage{}, err
+}
+
+// record 保存执行记录、通知订阅者并按保留策略清理旧记录
+func (s *Scheduler) record(task Task, run *TaskRun) {
+	if err := s.db.SaveTaskRun(run); err != nil {
+		slog.Error("save task run", "id", run.TaskID, "err", err)
+		return
+	}
+	if s.notify != nil {
+		s.notify(Notification{Topic: NotifyTaskRun, ChatJID: task.ChatJID, Data: NewTaskRunInfo(task, *run)})
+	}
+	var before time.Time
+	if s.retention > 0 {
+		before = s.now().Add(-s.retention)
+	}
+	if _, err := s.db.PruneTaskRuns(before, s.keepRuns); err != nil {
+		slog.Error("prune task runs", "err", err)
+	}
+}
+
+// advance 计算执行后的状态和下次执行时间
+//
+// once 任务转为 onceStatus；周期任务保持活动并计算 from 之后的下次时间，调度表达式无法解析时转为 failed。
+// event 任务等待下一个事件，执行期间又有事件到达时在防抖时间后再次执行。
+func (s *Scheduler) advance(task *Task, onceStatus string, from time.Time) error {
+	task.NextRun = nil
+	if task.ScheduleType == "once" {
+		return task.Transition(onceStatus)
+	}
+	next, err := task.NextRunAfter(from)
 	if err != nil {
-		slog.Error("agent run", "err", err)
-		s.db.UpdateTaskRun(task.ID, "error: "+err.Error(), nil)
+		task.Transition(TaskFailed)
+		return err
+	}
+	task.NextRun = next
+	if task.ScheduleType == "event" {
+		s.mu.Lock()
+		pending := len(s.events[task.ID]) > 0
+		s.mu.Unlock()
+		if pending {
+			next := s.now().Add(task.Debounce)
+			task.NextRun = &next
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) finish(task Task, result string) {
+	err := s.db.FinishTaskRun(&task, result, s.now())
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		slog.Info("task deleted while running", "id", task.ID)
+	case err != nil:
+		slog.Error("update task run", "id", task.ID, "err", err)
+	}
+}
+
+// retryDelay 第 attempt 次重试前的等待时间（指数退避，上限 maxRetryDelay）
+func (s *Scheduler) retryDelay(task Task) time.Duration {
+	delay := task.RetryDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	for i := 1; i < task.Attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxRetryDelay)
+}
+
+// fail 处理失败：还有重试次数时按退避安排重试，否则记录失败并向会话发送错误通知
+//
+// 放弃重试后 once 任务转为 failed，周期任务仍按计划进行下一次执行；静默任务同样通知。
+func (s *Scheduler) fail(task Task, err error) {
+	if task.Attempt < task.RetryLimit {
+		task.Attempt++
+		next := s.now().Add(s.retryDelay(task))
+		task.NextRun = &next
+		slog.Warn("task failed, retrying", "id", task.ID, "attempt", task.Attempt, "next", next, "err", err)
+		s.finish(task, fmt.Sprintf("error (retry %d/%d at %s): %v", task.Attempt, task.RetryLimit, next.Format(time.RFC3339), err))
 		return
 	}
 
-	// 保存结果
-	var nextRun *time.Time
-	if task.ScheduleType == "interval" {
-		d, _ := time.ParseDuration(task.ScheduleValue)
-		if d > 0 {
-			nr := time.Now().Add(d)
-			nextRun = &nr
+	slog.Error("task failed", "id", task.ID, "err", err)
+	if task.RetryLimit > 0 {
+		err = fmt.Errorf("after %d attempts: %w", task.RetryLimit+1, err)
+	}
+	task.Attempt = 0
+	task.ResumeFrom = 0
+	if advErr := s.advance(&task, TaskFailed, s.now()); advErr != nil {
+		err = fmt.Errorf("%w; %v", err, advErr)
+	}
+	s.finish(task, "error: "+err.Error())
+	s.post(task, fmt.Sprintf("Error: scheduled task %s failed: %v", task.ID, err))
+}
+
+func (s *Scheduler) post(task Task, content string) {
+	if s.deliver == nil {
+		return
+	}
+	if err := s.deliver(task.ChatJID, content); err != nil {
+		slog.Error("deliver task result", "id", task.ID, "err", err)
+	}
+}
+
+// cronParser 支持可选的秒字段、@every/@daily 等描述符以及 CRON_TZ= 前缀
+var cronParser = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// onceLayouts once 任务不带时区偏移时接受的格式，按任务时区解释
+var onceLayouts = []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+// LoadTimezone 解析任务时区，空字符串表示服务器本地时区
+func LoadTimezone(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// NextRunTime 计算 from 之后的下次执行时间
+//
+// once 的值为RFC3339时间（为空表示立即执行；不带偏移时按 timezone 解释），
+// interval 为 time.ParseDuration 格式，cron 为5字段或带秒的6字段表达式，
+// 按 timezone 计算（表达式中的 CRON_TZ= 前缀优先）。
+// event 任务由事件触发，只校验触发条件，返回nil。
+func NextRunTime(scheduleType, value, timezone string, from time.Time) (*time.Time, error) {
+	loc, err := LoadTimezone(timezone)
+	if err != nil {
+		return nil, err
+	}
+	switch scheduleType {
+	case "once":
+		if value == "" {
+			return &from, nil
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		for _, layout := range onceLayouts {
+			if err == nil {
+				break
+			}
+			t, err = time.ParseInLocation(layout, value, loc)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid once time %q: want RFC3339 or YYYY-MM-DD HH:MM", value)
+		}
+		return &t, nil
+	case "interval":
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval %q: %w", value, err)
 		}
-	} else if task.ScheduleType == "cron" {
-		// 解析cron表达式计算下次执行时间
-		parser := cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
-		if sched, err := parser.Parse(task.ScheduleValue); err == nil {
-			nr := sched.Next(time.Now())
-			nextRun = &nr
+		if d <= 0 {
+			return nil, fmt.Errorf("invalid interval %q: must be positive", value)
 		}
+		t := from.Add(d)
+		return &t, nil
+	case "cron":
+		sched, err := cronParser.Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron %q: %w", value, err)
+		}
+		t := sched.Next(from.In(loc))
+		if t.IsZero() {
+			return nil, fmt.Errorf("cron %q never fires", value)
+		}
+		return &t, nil
+	case "event":
+		_, err := ParseEventTrigger(value)
+		return nil, err
 	}
+	return nil, fmt.Errorf("unknown schedule type: %q", scheduleType)
+}
+
+// NextRunAfter 按任务的调度和时区计算 from 之后的下次执行时间
+func (t *Task) NextRunAfter(from time.Time) (*time.Time, error) {
+	return NextRunTime(t.ScheduleType, t.ScheduleValue, t.Timezone, from)
+}
 
-	s.db.UpdateTaskRun(task.ID, resp, nextRun)
-	slog.Info("task completed", "id", task.ID)
+// UpcomingRuns 计算 from 之后最多 n 次执行时间（once 只有一次，event 没有）
+func (t *Task) UpcomingRuns(from time.Time, n int) ([]time.Time, error) {
+	var runs []time.Time
+	for len(runs) < n {
+		next, err := t.NextRunAfter(from)
+		if err != nil {
+			return nil, err
+		}
+		if next == nil {
+			break
+		}
+		runs = append(runs, *next)
+		if t.ScheduleType == "once" {
+			break
+		}
+		from = *next
+	}
+	return runs, nil
 }
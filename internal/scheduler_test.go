@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"strings"
 	"testing"
 	"time"
 )
@@ -164,3 +165,656 @@ func TestSchedulerXSkipLLM_FutureTask(t *testing.T) {
 
 	time.Sleep(2 * time.Second)
 }
+
+func TestScheduler_RunTaskDelivery(t *testing.T) {
+	tests := []struct {
+		name     string
+		silent   bool
+		replies  []string
+		wantPost string // 为空表示不应发送
+		wantLast string
+	}{
+		{"posts result", false, []string{"daily report"}, "daily report", "daily report"},
+		{"silent keeps result", true, []string{"daily report"}, "", "daily report"},
+		{"failure notifies", false, nil, "Error: scheduled task t1 failed", "error: "},
+		{"silent failure notifies", true, nil, "Error: scheduled task t1 failed", "error: "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := TestTempDB(t)
+			past := time.Now().Add(-time.Minute)
+			task := &Task{ID: "t1", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "report",
+				ScheduleType: "interval", ScheduleValue: "1h", NextRun: &past, Status: "active",
+				CreatedAt: past, Silent: tt.silent}
+			if err := db.SaveTask(task); err != nil {
+				t.Fatal(err)
+			}
+
+			scheduler := NewScheduler(db, &ScriptedRunner{Replies: tt.replies})
+			var posts []string
+			scheduler.SetDeliver(func(chatJID ChatJID, content string) error {
+				if chatJID != task.ChatJID {
+					t.Errorf("delivered to %s", chatJID)
+				}
+				posts = append(posts, content)
+				return nil
+			})
+			scheduler.runTask(context.Background(), *task)
+
+			switch {
+			case tt.wantPost == "" && len(posts) != 0:
+				t.Errorf("expected no posts, got %q", posts)
+			case tt.wantPost != "" && (len(posts) != 1 || !strings.HasPrefix(posts[0], tt.wantPost)):
+				t.Errorf("posts = %q, want prefix %q", posts, tt.wantPost)
+			}
+			got, err := db.GetTask("t1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(got.LastResult, tt.wantLast) {
+				t.Errorf("last_result = %q, want prefix %q", got.LastResult, tt.wantLast)
+			}
+			if got.Silent != tt.silent {
+				t.Errorf("silent = %v, want %v", got.Silent, tt.silent)
+			}
+		})
+	}
+}
+
+func TestScheduler_DispatchThroughQueue(t *testing.T) {
+	db := TestTempDB(t)
+	queue := NewGroupQueue(1)
+	runner := &ScriptedRunner{Replies: []string{"done"}}
+	scheduler := NewScheduler(db, runner)
+	scheduler.SetQueue(queue, PriorityScheduled)
+
+	past := time.Now().Add(-time.Minute)
+	task := Task{ID: "q1", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "report",
+		ScheduleType: "once", NextRun: &past, Status: "active", CreatedAt: past}
+	if err := db.SaveTask(&task); err != nil {
+		t.Fatal(err)
+	}
+
+	// 同一群组的交互请求正在执行时，任务只能排队
+	release := make(chan struct{})
+	queue.Enqueue(context.Background(), task.ChatJID, func() { <-release })
+
+	scheduler.dispatch(context.Background(), task)
+	scheduler.dispatch(context.Background(), task) // 重复调度应被忽略
+	if got := queue.PendingCount(task.ChatJID); got != 1 {
+		t.Fatalf("PendingCount = %d, want 1", got)
+	}
+	close(release)
+
+	deadline := time.Now().Add(time.Second)
+	for queue.IsRunning(task.ChatJID) || queue.PendingCount(task.ChatJID) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("task did not run")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	got, err := db.GetTask("q1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.LastResult != "done" || len(runner.Prompts) != 1 {
+		t.Errorf("last_result = %q, prompts = %v", got.LastResult, runner.Prompts)
+	}
+}
+
+func TestScheduler_Lifecycle(t *testing.T) {
+	start := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		typ       string
+		value     string
+		replies   []string
+		wantState string
+		wantNext  *time.Time
+		wantPost  string
+	}{
+		{"once success completes", "once", "", []string{"ok"}, TaskCompleted, nil, "ok"},
+		{"once failure fails", "once", "", nil, TaskFailed, nil, "Error: scheduled task"},
+		{"interval success reschedules", "interval", "30m", []string{"ok"}, TaskActive, ptrTime(start.Add(30 * time.Minute)), "ok"},
+		{"interval failure reschedules", "interval", "30m", nil, TaskActive, ptrTime(start.Add(30 * time.Minute)), "Error: scheduled task"},
+		{"cron success reschedules", "cron", "0 10 * * *", []string{"ok"}, TaskActive, ptrTime(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)), "ok"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := TestTempDB(t)
+			task := &Task{ID: "life", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "p",
+				ScheduleType: tt.typ, ScheduleValue: tt.value, CreatedAt: start.Add(-time.Hour)}
+			if err := db.SaveTask(task); err != nil {
+				t.Fatal(err)
+			}
+
+			scheduler := NewScheduler(db, &ScriptedRunner{Replies: tt.replies})
+			scheduler.SetClock(func() time.Time { return start })
+			var posts []string
+			scheduler.SetDeliver(func(_ ChatJID, content string) error {
+				posts = append(posts, content)
+				return nil
+			})
+			scheduler.runTask(context.Background(), *task)
+
+			got, err := db.GetTask("life")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tt.wantState {
+				t.Errorf("status = %s, want %s", got.Status, tt.wantState)
+			}
+			if (got.NextRun == nil) != (tt.wantNext == nil) || (got.NextRun != nil && !got.NextRun.Equal(*tt.wantNext)) {
+				t.Errorf("next_run = %v, want %v", got.NextRun, tt.wantNext)
+			}
+			if got.LastRun == nil || !got.LastRun.Equal(start) {
+				t.Errorf("last_run = %v, want %v", got.LastRun, start)
+			}
+			if len(posts) != 1 || !strings.HasPrefix(posts[0], tt.wantPost) {
+				t.Errorf("posts = %q, want prefix %q", posts, tt.wantPost)
+			}
+
+			// 完成或失败的任务不再被视为到期
+			due, _ := db.GetDueTasks(start.Add(24 * time.Hour))
+			if (len(due) > 0) != (tt.wantState == TaskActive) {
+				t.Errorf("due tasks = %d for status %s", len(due), got.Status)
+			}
+		})
+	}
+}
+
+func TestScheduler_SkipsPausedBeforeRun(t *testing.T) {
+	db := TestTempDB(t)
+	task := &Task{ID: "p1", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "p", ScheduleType: "once"}
+	if err := db.SaveTask(task); err != nil {
+		t.Fatal(err)
+	}
+	// 入队后被暂停
+	if err := db.UpdateTaskStatus("p1", TaskPaused); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := &ScriptedRunner{Replies: []string{"ok"}}
+	NewScheduler(db, runner).runTask(context.Background(), *task)
+	if len(runner.Prompts) != 0 {
+		t.Errorf("paused task ran: %v", runner.Prompts)
+	}
+}
+
+func ptrTime(t time.Time) *time.Time { return &t }
+
+func TestNextRunTime(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) // 08:00 Asia/Shanghai
+
+	tests := []struct {
+		name    string
+		typ     string
+		value   string
+		tz      string
+		want    time.Time
+		wantErr bool
+	}{
+		{"cron in task timezone", "cron", "0 9 * * *", "Asia/Shanghai", time.Date(2024, 1, 1, 9, 0, 0, 0, shanghai), false},
+		{"cron in UTC", "cron", "0 9 * * *", "UTC", time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC), false},
+		{"CRON_TZ prefix wins", "cron", "CRON_TZ=Asia/Shanghai 0 9 * * *", "UTC", time.Date(2024, 1, 1, 9, 0, 0, 0, shanghai), false},
+		{"seconds field", "cron", "*/15 * * * * *", "UTC", from.Add(15 * time.Second), false},
+		{"descriptor", "cron", "@every 90s", "", from.Add(90 * time.Second), false},
+		{"once without offset", "once", "2024-01-02 09:00", "Asia/Shanghai", time.Date(2024, 1, 2, 9, 0, 0, 0, shanghai), false},
+		{"once RFC3339 ignores tz", "once", "2024-01-02T09:00:00Z", "Asia/Shanghai", time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC), false},
+		{"interval", "interval", "45s", "Asia/Shanghai", from.Add(45 * time.Second), false},
+		{"bad timezone", "cron", "0 9 * * *", "Mars/Olympus", time.Time{}, true},
+		{"seven fields", "cron", "0 0 9 * * * *", "UTC", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NextRunTime(tt.typ, tt.value, tt.tz, from)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScheduler_SleepDuration(t *testing.T) {
+	db := TestTempDB(t)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	scheduler := NewScheduler(db, &ScriptedRunner{})
+	scheduler.SetClock(func() time.Time { return now })
+	scheduler.SetPollInterval(time.Minute)
+
+	if d := scheduler.sleepDuration(); d != time.Minute {
+		t.Errorf("no tasks: sleep = %v, want 1m", d)
+	}
+
+	save := func(id, value string) {
+		task := &Task{ID: id, GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "p",
+			ScheduleType: "cron", ScheduleValue: value, Timezone: "UTC", CreatedAt: now}
+		if err := db.SaveTask(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save("hourly", "0 * * * *")
+	if d := scheduler.sleepDuration(); d != time.Minute {
+		t.Errorf("far task: sleep = %v, want capped at 1m", d)
+	}
+	save("fast", "*/20 * * * * *")
+	if d := scheduler.sleepDuration(); d != 20*time.Second {
+		t.Errorf("seconds cron: sleep = %v, want 20s", d)
+	}
+
+	// 已到期（执行中）的任务不应导致空转
+	scheduler.SetClock(func() time.Time { return now.Add(time.Hour) })
+	if d := scheduler.sleepDuration(); d != time.Second {
+		t.Errorf("overdue: sleep = %v, want 1s", d)
+	}
+}
+
+func TestScheduler_WakeRunsNewTask(t *testing.T) {
+	db := TestTempDB(t)
+	runner := &ScriptedRunner{Replies: []string{"ok"}}
+	scheduler := NewScheduler(db, runner)
+	scheduler.SetPollInterval(time.Hour)
+	done := make(chan struct{})
+	scheduler.SetDeliver(func(ChatJID, string) error {
+		close(done)
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	scheduler.Start(ctx)
+	defer scheduler.Stop()
+
+	// 启动后空闲休眠一小时，新建任务后唤醒
+	time.Sleep(50 * time.Millisecond)
+	task := &Task{ID: "wake", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "p", ScheduleType: "once"}
+	if err := db.SaveTask(task); err != nil {
+		t.Fatal(err)
+	}
+	scheduler.Wake()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("task did not run after Wake")
+	}
+}
+
+// usageRunner 带token用量的脚本化Runner
+type usageRunner struct {
+	ScriptedRunner
+	usage Usage
+}
+
+func (r *usageRunner) RunWithUsage(ctx context.Context, groupFolder string, messages []Message) (string, Usage, error) {
+	resp, err := r.Run(ctx, groupFolder, messages)
+	return resp, r.usage, err
+}
+
+func TestScheduler_RecordsRuns(t *testing.T) {
+	db := TestTempDB(t)
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	task := &Task{ID: "hist", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "p",
+		ScheduleType: "interval", ScheduleValue: "1m", CreatedAt: now.Add(-time.Hour)}
+	if err := db.SaveTask(task); err != nil {
+		t.Fatal(err)
+	}
+
+	runner := &usageRunner{ScriptedRunner: ScriptedRunner{Replies: []string{"one", "two"}}, usage: Usage{PromptTokens: 12, CompletionTokens: 3}}
+	scheduler := NewScheduler(db, runner)
+	clock := now
+	scheduler.SetClock(func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	})
+	scheduler.SetRetention(0, 2)
+
+	for i := 0; i < 3; i++ {
+		scheduler.runTask(context.Background(), *task)
+	}
+
+	runs, err := db.ListTaskRuns("hist", 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("runs = %d, want 2 after retention", len(runs))
+	}
+	// 第三次脚本回复耗尽，记录为失败
+	if runs[0].Status != TaskRunError || runs[0].Error == "" {
+		t.Errorf("latest run = %+v, want error", runs[0])
+	}
+	if runs[1].Status != TaskRunSuccess || runs[1].Output != "two" || runs[1].PromptTokens != 12 || runs[1].CompletionTokens != 3 {
+		t.Errorf("second run = %+v", runs[1])
+	}
+	if runs[1].Duration() <= 0 {
+		t.Errorf("duration = %v, want > 0", runs[1].Duration())
+	}
+}
+
+// runnerFunc 函数形式的Runner
+type runnerFunc func(ctx context.Context, groupFolder string, messages []Message) (string, error)
+
+func (f runnerFunc) Run(ctx context.Context, groupFolder string, messages []Message) (string, error) {
+	return f(ctx, groupFolder, messages)
+}
+
+func TestScheduler_RetryBackoff(t *testing.T) {
+	db := TestTempDB(t)
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	task := &Task{ID: "retry", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "p", ScheduleType: "once",
+		RetryLimit: 3, RetryDelay: 10 * time.Second, CreatedAt: now}
+	if err := db.SaveTask(task); err != nil {
+		t.Fatal(err)
+	}
+
+	scheduler := NewScheduler(db, &ScriptedRunner{}) // 每次都失败
+	scheduler.SetClock(func() time.Time { return now })
+	var posts []string
+	scheduler.SetDeliver(func(_ ChatJID, content string) error {
+		posts = append(posts, content)
+		return nil
+	})
+
+	for _, wantDelay := range []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second} {
+		scheduler.runTask(context.Background(), *task)
+		got, _ := db.GetTask("retry")
+		if got.Status != TaskActive || got.NextRun == nil || !got.NextRun.Equal(now.Add(wantDelay)) {
+			t.Fatalf("after attempt %d: status=%s next=%v, want active %v", got.Attempt, got.Status, got.NextRun, now.Add(wantDelay))
+		}
+	}
+	if len(posts) != 0 {
+		t.Errorf("retries should not notify, got %q", posts)
+	}
+
+	scheduler.runTask(context.Background(), *task)
+	got, _ := db.GetTask("retry")
+	if got.Status != TaskFailed || got.NextRun != nil || got.Attempt != 0 {
+		t.Errorf("final: status=%s next=%v attempt=%d", got.Status, got.NextRun, got.Attempt)
+	}
+	if len(posts) != 1 || !strings.Contains(posts[0], "after 4 attempts") {
+		t.Errorf("posts = %q", posts)
+	}
+	if n, _ := db.CountTaskRuns("retry"); n != 4 {
+		t.Errorf("runs = %d, want 4", n)
+	}
+}
+
+func TestScheduler_RetryDelayCap(t *testing.T) {
+	s := NewScheduler(nil, nil)
+	task := Task{RetryDelay: 20 * time.Minute, Attempt: 5}
+	if d := s.retryDelay(task); d != maxRetryDelay {
+		t.Errorf("retryDelay = %v, want %v", d, maxRetryDelay)
+	}
+	task = Task{Attempt: 1}
+	if d := s.retryDelay(task); d != defaultRetryDelay {
+		t.Errorf("retryDelay = %v, want %v", d, defaultRetryDelay)
+	}
+}
+
+func TestScheduler_Timeout(t *testing.T) {
+	db := TestTempDB(t)
+	task := &Task{ID: "slow", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "p",
+		ScheduleType: "interval", ScheduleValue: "1h", Timeout: time.Second}
+	if err := db.SaveTask(task); err != nil {
+		t.Fatal(err)
+	}
+	scheduler := NewScheduler(db, runnerFunc(func(ctx context.Context, _ string, _ []Message) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	}))
+	scheduler.SetTimeout(time.Hour) // 任务自己的超时优先
+
+	start := time.Now()
+	scheduler.runTask(context.Background(), *task)
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("timeout not enforced, took %v", elapsed)
+	}
+	runs, _ := db.ListTaskRuns("slow", 1, 0)
+	if len(runs) != 1 || runs[0].Status != TaskRunError || !strings.Contains(runs[0].Error, "timed out after 1s") {
+		t.Errorf("runs = %+v", runs)
+	}
+	got, _ := db.GetTask("slow")
+	if got.Status != TaskActive || got.NextRun == nil {
+		t.Errorf("interval task should stay scheduled: %+v", got)
+	}
+}
+
+func TestScheduler_InterruptedRunKeepsSchedule(t *testing.T) {
+	db := TestTempDB(t)
+	task := &Task{ID: "int", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "p", ScheduleType: "once"}
+	if err := db.SaveTask(task); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	scheduler := NewScheduler(db, runnerFunc(func(ctx context.Context, _ string, _ []Message) (string, error) {
+		cancel()
+		return "", ctx.Err()
+	}))
+	scheduler.runTask(ctx, *task)
+
+	got, _ := db.GetTask("int")
+	if got.Status != TaskActive || got.NextRun == nil || !got.NextRun.Equal(task.NextRun.Truncate(time.Second)) {
+		t.Errorf("interrupted task changed: status=%s next=%v", got.Status, got.NextRun)
+	}
+}
+
+func TestScheduler_Misfire(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	missed := now.Add(-3*time.Hour - 30*time.Minute) // 08:30，错过了 09:00、10:00、11:00 等
+
+	tests := []struct {
+		policy    string
+		wantRuns  int
+		wantNext  time.Time
+		wantState string
+	}{
+		{MisfireOnce, 1, now.Add(time.Hour), TaskRunSuccess},
+		{MisfireAll, 1, missed.Add(time.Hour), TaskRunSuccess},
+		{MisfireSkip, 0, now.Add(time.Hour), TaskRunSkipped},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			db := TestTempDB(t)
+			task := &Task{ID: "m", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "p",
+				ScheduleType: "interval", ScheduleValue: "1h", NextRun: &missed, Misfire: tt.policy, CreatedAt: missed}
+			if err := db.SaveTask(task); err != nil {
+				t.Fatal(err)
+			}
+			runner := &ScriptedRunner{Replies: []string{"ok"}}
+			scheduler := NewScheduler(db, runner)
+			scheduler.SetClock(func() time.Time { return now })
+
+			scheduler.dispatch(context.Background(), *task)
+			waitIdle(t, scheduler)
+
+			if len(runner.Prompts) != tt.wantRuns {
+				t.Errorf("agent calls = %d, want %d", len(runner.Prompts), tt.wantRuns)
+			}
+			runs, _ := db.ListTaskRuns("m", 1, 0)
+			if len(runs) != 1 || runs[0].Status != tt.wantState {
+				t.Errorf("runs = %+v, want status %s", runs, tt.wantState)
+			}
+			got, _ := db.GetTask("m")
+			if got.NextRun == nil || !got.NextRun.Equal(tt.wantNext) {
+				t.Errorf("next_run = %v, want %v", got.NextRun, tt.wantNext)
+			}
+		})
+	}
+}
+
+// waitIdle 等待调度器没有进行中的任务
+func waitIdle(t *testing.T, s *Scheduler) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		n := len(s.inflight)
+		s.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("scheduler still busy")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestScheduler_EventTask(t *testing.T) {
+	db := TestTempDB(t)
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	task := &Task{ID: "ev", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "answer the question", ScheduleType: "event",
+		ScheduleValue: "keyword:help", Debounce: 30 * time.Second, RateLimit: 2, CreatedAt: now}
+	if err := db.SaveTask(task); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetTask("ev"); got.NextRun != nil || got.Debounce != 30*time.Second || got.RateLimit != 2 {
+		t.Fatalf("saved event task = %+v", got)
+	}
+
+	var prompts []string
+	scheduler := NewScheduler(db, runnerFunc(func(_ context.Context, _ string, msgs []Message) (string, error) {
+		prompts = append(prompts, msgs[len(msgs)-1].Content)
+		return "ok", nil
+	}))
+	scheduler.SetClock(func() time.Time { return now })
+	send := func(chat ChatJID, content string) {
+		scheduler.HandleEvent(Event{Kind: EventMessage, ChatJID: chat, Sender: "alice", Content: content, Time: now})
+	}
+	nextRun := func() *time.Time {
+		got, _ := db.GetTask("ev")
+		return got.NextRun
+	}
+
+	send("main@nanoclaw", "hello")
+	send("other@nanoclaw", "help")
+	if next := nextRun(); next != nil {
+		t.Fatalf("unmatched events scheduled a run at %v", next)
+	}
+
+	// 防抖：每次匹配把执行时间推迟到 Debounce 之后
+	send("main@nanoclaw", "help 1")
+	now = now.Add(10 * time.Second)
+	send("main@nanoclaw", "HELP 2")
+	if next := nextRun(); next == nil || !next.Equal(now.Add(30*time.Second)) {
+		t.Fatalf("NextRun = %v, want %v", next, now.Add(30*time.Second))
+	}
+	now = now.Add(30 * time.Second)
+	due, _ := db.GetDueTasks(now)
+	if len(due) != 1 {
+		t.Fatalf("due = %d", len(due))
+	}
+	scheduler.runTask(context.Background(), due[0])
+	if len(prompts) != 1 || !strings.Contains(prompts[0], "alice: help 1") || !strings.Contains(prompts[0], "alice: HELP 2") {
+		t.Fatalf("prompts = %q", prompts)
+	}
+	if got, _ := db.GetTask("ev"); got.Status != TaskActive || got.NextRun != nil {
+		t.Errorf("after run: status=%s next=%v", got.Status, got.NextRun)
+	}
+
+	// 限流：一小时内最多执行 RateLimit 次
+	send("main@nanoclaw", "help 3")
+	now = now.Add(30 * time.Second)
+	scheduler.runTask(context.Background(), *task)
+	send("main@nanoclaw", "help 4")
+	if next := nextRun(); next != nil {
+		t.Errorf("rate-limited event scheduled a run at %v", next)
+	}
+	now = now.Add(time.Hour)
+	send("main@nanoclaw", "help 5")
+	if next := nextRun(); next == nil {
+		t.Error("event after the rate window was ignored")
+	}
+}
+
+func TestScheduler_EventDuringRun(t *testing.T) {
+	db := TestTempDB(t)
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	task := &Task{ID: "ev", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "p", ScheduleType: "event",
+		ScheduleValue: "join", Debounce: 5 * time.Second, CreatedAt: now}
+	if err := db.SaveTask(task); err != nil {
+		t.Fatal(err)
+	}
+	var scheduler *Scheduler
+	scheduler = NewScheduler(db, runnerFunc(func(context.Context, string, []Message) (string, error) {
+		scheduler.HandleEvent(Event{Kind: EventJoin, ChatJID: "main@nanoclaw", Sender: "carol"})
+		return "welcome", nil
+	}))
+	scheduler.SetClock(func() time.Time { return now })
+	scheduler.HandleEvent(Event{Kind: EventJoin, ChatJID: "main@nanoclaw", Sender: "bob"})
+	scheduler.runTask(context.Background(), *task)
+
+	got, _ := db.GetTask("ev")
+	if got.NextRun == nil || !got.NextRun.Equal(now.Add(5*time.Second)) {
+		t.Errorf("NextRun = %v, want a follow-up run for the event during the run", got.NextRun)
+	}
+}
+
+func TestScheduler_PausedDuringRun(t *testing.T) {
+	for _, tt := range []struct {
+		name, scheduleType, scheduleValue string
+		to                                string
+	}{
+		{"interval paused", "interval", "1h", TaskPaused},
+		{"once paused", "once", "", TaskPaused},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			db := TestTempDB(t)
+			task := &Task{ID: "mid", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "p",
+				ScheduleType: tt.scheduleType, ScheduleValue: tt.scheduleValue}
+			if err := db.SaveTask(task); err != nil {
+				t.Fatal(err)
+			}
+			var pausedNext *time.Time
+			scheduler := NewScheduler(db, runnerFunc(func(context.Context, string, []Message) (string, error) {
+				// 执行期间被暂停（如通过 /task pause 或 tasks.pause）
+				current, _ := db.GetTask("mid")
+				if err := current.SetStatus(tt.to, time.Now()); err != nil {
+					t.Error(err)
+				}
+				db.SaveTask(current)
+				pausedNext = current.NextRun
+				return "done", nil
+			}))
+			scheduler.runTask(context.Background(), *task)
+
+			got, _ := db.GetTask("mid")
+			if got.Status != tt.to {
+				t.Errorf("status = %s, want %s", got.Status, tt.to)
+			}
+			if (got.NextRun == nil) != (pausedNext == nil) || (got.NextRun != nil && !got.NextRun.Equal(pausedNext.Truncate(time.Second))) {
+				t.Errorf("next_run = %v, want %v", got.NextRun, pausedNext)
+			}
+			if got.LastResult != "done" || got.LastRun == nil {
+				t.Errorf("result not recorded: %q %v", got.LastResult, got.LastRun)
+			}
+		})
+	}
+}
+
+func TestScheduler_DeletedDuringRun(t *testing.T) {
+	db := TestTempDB(t)
+	task := &Task{ID: "gone", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "p", ScheduleType: "interval", ScheduleValue: "1h"}
+	if err := db.SaveTask(task); err != nil {
+		t.Fatal(err)
+	}
+	scheduler := NewScheduler(db, runnerFunc(func(context.Context, string, []Message) (string, error) {
+		return "done", db.DeleteTask("gone")
+	}))
+	scheduler.runTask(context.Background(), *task)
+	if _, err := db.GetTask("gone"); err == nil {
+		t.Error("deleted task was recreated")
+	}
+}
@@ -0,0 +1,11 @@
+//go:build linux && !(amd64 || arm64)
+
+package internal
+
+import "log/slog"
+
+// installSeccomp 该架构没有内置的过滤规则，只依赖命名空间、Landlock 和资源限制
+func installSeccomp(restrictSocket bool) error {
+	slog.Warn("sandbox: seccomp filter not available on this architecture")
+	return nil
+}
@@ -0,0 +1,455 @@
+package internal
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 技能包
+//
+// 技能包是一个包含 SKILL.md 的目录（或其 .tar.gz 打包），可选包含：
+//   - *.lua 脚本（安装前做语法检查）
+//   - CHECKSUMS：sha256sum 格式的文件清单，存在时逐一校验
+//
+// SKILL.md frontmatter 中 name、version 必填，min_nanoclaw 可选。
+
+const (
+	checksumsFile    = "CHECKSUMS"
+	maxBundleSize    = 16 << 20
+	maxBundleEntries = 1000
+)
+
+// InstalledSkill 已安装技能记录
+type InstalledSkill struct {
+	Name        string
+	Version     string
+	Source      string // 安装来源（路径或压缩包）
+	Checksum    string // 技能包整体sha256
+	Path        string
+	InstalledAt time.Time
+}
+
+// SkillBundle 校验通过的技能包
+type SkillBundle struct {
+	Skill       *Skill
+	Dir         string
+	MinNanoclaw string
+	Checksum    string
+}
+
+// ErrSkillUpToDate 相同版本、相同内容的技能已安装
+var ErrSkillUpToDate = errors.New("skill already installed")
+
+// InstallSkill 校验并安装技能包到 skillsDir，记录到数据库
+//
+// 低于已安装版本的技能包会被拒绝，force 为 true 时允许降级或重装。
+func InstallSkill(db *DB, skillsDir, src string, force bool) (*InstalledSkill, error) {
+	root := src
+	if !isDir(src) {
+		tmp, err := os.MkdirTemp("", "nanoclaw-skill-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(tmp)
+		if err := extractTarGz(src, tmp); err != nil {
+			return nil, fmt.Errorf("extract %s: %w", src, err)
+		}
+		root = tmp
+	}
+
+	root, err := bundleRoot(root)
+	if err != nil {
+		return nil, err
+	}
+	bundle, err := ValidateSkillBundle(root)
+	if err != nil {
+		return nil, err
+	}
+	name, version := bundle.Skill.Name, bundle.Skill.Version
+
+	if old, err := db.GetInstalledSkill(name); err == nil && !force {
+		cmp, _ := compareVersions(version, old.Version)
+		switch {
+		case cmp < 0:
+			return nil, fmt.Errorf("skill %s: version %s is older than installed %s (use --force to downgrade)", name, version, old.Version)
+		case cmp == 0 && old.Checksum == bundle.Checksum:
+			return old, ErrSkillUpToDate
+		case cmp == 0:
+			return nil, fmt.Errorf("skill %s: version %s already installed with different contents (bump the version or use --force)", name, version)
+		}
+	}
+
+	dest := filepath.Join(skillsDir, name)
+	if err := replaceDir(root, dest); err != nil {
+		return nil, fmt.Errorf("install %s: %w", name, err)
+	}
+
+	inst := &InstalledSkill{
+		Name:        name,
+		Version:     version,
+		Source:      src,
+		Checksum:    bundle.Checksum,
+		Path:        dest,
+		InstalledAt: time.Now(),
+	}
+	if err := db.SaveInstalledSkill(inst); err != nil {
+		return nil, err
+	}
+	return inst, nil
+}
+
+// RemoveSkill 卸载已安装技能
+func RemoveSkill(db *DB, name string) error {
+	inst, err := db.GetInstalledSkill(name)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("skill not installed: %s", name)
+		}
+		return err
+	}
+	if err := os.RemoveAll(inst.Path); err != nil {
+		return err
+	}
+	return db.DeleteInstalledSkill(name)
+}
+
+// ValidateSkillBundle 校验技能包目录：SKILL.md、版本兼容性、脚本语法和校验和
+func ValidateSkillBundle(dir string) (*SkillBundle, error) {
+	dir = filepath.Clean(dir)
+	skill, err := parseSkillDir(os.DirFS(filepath.Dir(dir)), filepath.Base(dir))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	meta, _ := readFrontmatter(filepath.Join(dir, "SKILL.md"))
+	if meta["name"] == "" {
+		return nil, fmt.Errorf("invalid bundle: SKILL.md must declare name")
+	}
+	if !validSkillName(skill.Name) {
+		return nil, fmt.Errorf("invalid bundle: bad skill name %q", skill.Name)
+	}
+	if _, err := parseVersion(skill.Version); err != nil {
+		return nil, fmt.Errorf("invalid bundle: version: %w", err)
+	}
+	bundle := &SkillBundle{Skill: skill, Dir: dir, MinNanoclaw: meta["min_nanoclaw"]}
+	if bundle.MinNanoclaw != "" {
+		cmp, err := compareVersions(Version, bundle.MinNanoclaw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bundle: min_nanoclaw: %w", err)
+		}
+		if cmp < 0 {
+			return nil, fmt.Errorf("skill %s %s requires nanoclaw >= %s (running %s)", skill.Name, skill.Version, bundle.MinNanoclaw, Version)
+		}
+	}
+
+	files, err := bundleFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f, ".lua") {
+			data, err := os.ReadFile(filepath.Join(dir, f))
+			if err != nil {
+				return nil, err
+			}
+			if err := checkLuaSyntax(string(data), f); err != nil {
+				return nil, fmt.Errorf("invalid bundle: %w", err)
+			}
+		}
+	}
+	if err := verifyChecksums(dir, files); err != nil {
+		return nil, err
+	}
+	if bundle.Checksum, err = bundleChecksum(dir, files); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+func readFrontmatter(file string) (map[string]string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	meta, _ := splitFrontmatter(string(data))
+	return meta, nil
+}
+
+func validSkillName(name string) bool {
+	if name == "" || strings.HasPrefix(name, ".") {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// bundleRoot 定位技能包根目录：SKILL.md 在根部，或唯一的顶层子目录中
+func bundleRoot(dir string) (string, error) {
+	if _, err := os.Stat(filepath.Join(dir, "SKILL.md")); err == nil {
+		return dir, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	if len(entries) == 1 && entries[0].IsDir() {
+		sub := filepath.Join(dir, entries[0].Name())
+		if _, err := os.Stat(filepath.Join(sub, "SKILL.md")); err == nil {
+			return sub, nil
+		}
+	}
+	return "", fmt.Errorf("invalid bundle: SKILL.md not found in %s", dir)
+}
+
+// bundleFiles 返回技能包内所有普通文件的相对路径（已排序，斜杠分隔）
+func bundleFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return fmt.Errorf("invalid bundle: symlink not allowed: %s", p)
+		}
+		if d.Type().IsRegular() {
+			rel, _ := filepath.Rel(dir, p)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files, err
+}
+
+// verifyChecksums 按 CHECKSUMS 清单校验文件，清单外的文件也视为错误
+func verifyChecksums(dir string, files []string) error {
+	f, err := os.Open(filepath.Join(dir, checksumsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	listed := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		sum, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return fmt.Errorf("invalid %s line: %q", checksumsFile, line)
+		}
+		name = strings.TrimPrefix(strings.TrimSpace(name), "*")
+		got, err := fileSHA256(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return fmt.Errorf("checksum %s: %w", name, err)
+		}
+		if !strings.EqualFold(got, sum) {
+			return fmt.Errorf("checksum mismatch: %s", name)
+		}
+		listed[name] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, name := range files {
+		if name != checksumsFile && !listed[name] {
+			return fmt.Errorf("file not listed in %s: %s", checksumsFile, name)
+		}
+	}
+	return nil
+}
+
+// bundleChecksum 对所有文件的路径和内容计算整体sha256
+func bundleChecksum(dir string, files []string) (string, error) {
+	h := sha256.New()
+	for _, name := range files {
+		sum, err := fileSHA256(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s  %s\n", sum, name)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// extractTarGz 解压 .tar.gz，拒绝越界路径、链接和超大包
+func extractTarGz(src, dest string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	var total int64
+	for n := 0; ; n++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if n >= maxBundleEntries {
+			return fmt.Errorf("too many entries")
+		}
+		name := filepath.Clean(filepath.FromSlash(hdr.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("illegal path: %s", hdr.Name)
+		}
+		target := filepath.Join(dest, name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			total += hdr.Size
+			if total > maxBundleSize {
+				return fmt.Errorf("bundle too large")
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, io.LimitReader(tr, hdr.Size))
+			out.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported entry type in %s", hdr.Name)
+		}
+	}
+}
+
+// replaceDir 把 src 复制到 dest：先写入同级临时目录再重命名，避免热重载读到半成品
+func replaceDir(src, dest string) error {
+	parent := filepath.Dir(dest)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(parent, "."+filepath.Base(dest)+".new-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	files, err := bundleFiles(src)
+	if err != nil {
+		return err
+	}
+	for _, name := range files {
+		data, err := os.ReadFile(filepath.Join(src, filepath.FromSlash(name)))
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(tmp, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	old := tmp + ".old"
+	if err := os.Rename(dest, old); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Rename(old, dest)
+		return err
+	}
+	return os.RemoveAll(old)
+}
+
+func isDir(p string) bool {
+	info, err := os.Stat(p)
+	return err == nil && info.IsDir()
+}
+
+// parseVersion 解析 major.minor.patch（允许省略后两段，忽略 -pre/+build 后缀）
+func parseVersion(v string) ([3]int, error) {
+	var parts [3]int
+	core := strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(core, "-+"); i >= 0 {
+		core = core[:i]
+	}
+	fields := strings.Split(core, ".")
+	if core == "" || len(fields) > 3 {
+		return parts, fmt.Errorf("invalid version %q", v)
+	}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 {
+			return parts, fmt.Errorf("invalid version %q", v)
+		}
+		parts[i] = n
+	}
+	return parts, nil
+}
+
+// compareVersions 比较两个版本号，返回 -1/0/1
+func compareVersions(a, b string) (int, error) {
+	va, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := range va {
+		if va[i] != vb[i] {
+			if va[i] < vb[i] {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeBundle(t *testing.T, dir string, files map[string]string) string {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func skillMD(name, version, extra string) string {
+	return fmt.Sprintf("---\nname: %s\nversion: %s\ndescription: test\n%s---\n# %s\n", name, version, extra, name)
+}
+
+func TestValidateSkillBundle(t *testing.T) {
+	sum := func(s string) string {
+		h := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(h[:])
+	}
+	script := `return "ok"`
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{"valid", map[string]string{"SKILL.md": skillMD("a", "1.0.0", ""), "script.lua": script}, ""},
+		{"missing SKILL.md", map[string]string{"script.lua": script}, "SKILL.md"},
+		{"missing name", map[string]string{"SKILL.md": "---\nversion: 1.0.0\n---\n"}, "must declare name"},
+		{"bad version", map[string]string{"SKILL.md": skillMD("a", "latest", "")}, "version"},
+		{"too new", map[string]string{"SKILL.md": skillMD("a", "1.0.0", "min_nanoclaw: 99.0\n")}, "requires nanoclaw"},
+		{"compatible", map[string]string{"SKILL.md": skillMD("a", "1.0.0", "min_nanoclaw: 0.1\n")}, ""},
+		{"lua syntax", map[string]string{"SKILL.md": skillMD("a", "1.0.0", ""), "lib/util.lua": "return ("}, "lua syntax"},
+		{"checksums ok", map[string]string{
+			"SKILL.md":   skillMD("a", "1.0.0", ""),
+			"script.lua": script,
+			"CHECKSUMS":  sum(skillMD("a", "1.0.0", "")) + "  SKILL.md\n" + sum(script) + "  script.lua\n",
+		}, ""},
+		{"checksum mismatch", map[string]string{
+			"SKILL.md":   skillMD("a", "1.0.0", ""),
+			"script.lua": script,
+			"CHECKSUMS":  sum(skillMD("a", "1.0.0", "")) + "  SKILL.md\n" + sum("tampered") + "  script.lua\n",
+		}, "checksum mismatch"},
+		{"unlisted file", map[string]string{
+			"SKILL.md":   skillMD("a", "1.0.0", ""),
+			"script.lua": script,
+			"CHECKSUMS":  sum(skillMD("a", "1.0.0", "")) + "  SKILL.md\n",
+		}, "not listed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := writeBundle(t, filepath.Join(t.TempDir(), "a"), tt.files)
+			_, err := ValidateSkillBundle(dir)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func writeTarGz(t *testing.T, path string, files map[string]string) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstallSkill_Lifecycle(t *testing.T) {
+	db := TestTempDB(t)
+	skillsDir := filepath.Join(t.TempDir(), "skills")
+	src := t.TempDir()
+
+	// 压缩包安装（顶层目录）
+	tarball := filepath.Join(src, "hello-1.0.0.tar.gz")
+	writeTarGz(t, tarball, map[string]string{
+		"hello/SKILL.md":   skillMD("hello", "1.0.0", ""),
+		"hello/script.lua": `return "v1"`,
+	})
+	inst, err := InstallSkill(db, skillsDir, tarball, false)
+	if err != nil {
+		t.Fatalf("InstallSkill failed: %v", err)
+	}
+	if inst.Path != filepath.Join(skillsDir, "hello") || inst.Version != "1.0.0" {
+		t.Errorf("unexpected install record: %+v", inst)
+	}
+	if _, err := os.Stat(filepath.Join(skillsDir, "hello", "script.lua")); err != nil {
+		t.Errorf("script not installed: %v", err)
+	}
+
+	// 重复安装
+	if _, err := InstallSkill(db, skillsDir, tarball, false); !errors.Is(err, ErrSkillUpToDate) {
+		t.Errorf("reinstall error = %v, want ErrSkillUpToDate", err)
+	}
+
+	// 升级（目录安装）
+	v2 := writeBundle(t, filepath.Join(src, "v2"), map[string]string{
+		"SKILL.md":   skillMD("hello", "1.1.0", ""),
+		"script.lua": `return "v2"`,
+	})
+	if _, err := InstallSkill(db, skillsDir, v2, false); err != nil {
+		t.Fatalf("upgrade failed: %v", err)
+	}
+
+	// 降级需要 force
+	if _, err := InstallSkill(db, skillsDir, tarball, false); err == nil || !strings.Contains(err.Error(), "older") {
+		t.Errorf("downgrade error = %v, want refusal", err)
+	}
+	if _, err := InstallSkill(db, skillsDir, tarball, true); err != nil {
+		t.Errorf("forced downgrade failed: %v", err)
+	}
+	got, err := db.GetInstalledSkill("hello")
+	if err != nil || got.Version != "1.0.0" {
+		t.Errorf("installed = %+v, %v", got, err)
+	}
+
+	// 安装后可被注册表加载，且可按群组禁用
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+	if err := registry.LoadFromDir(skillsDir); err != nil {
+		t.Fatalf("LoadFromDir failed: %v", err)
+	}
+	if err := db.SetSkillEnabled("hello", "team", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := registry.Lookup("team", "hello"); ok {
+		t.Error("disabled skill visible in team")
+	}
+	if _, ok := registry.Lookup("main", "hello"); !ok {
+		t.Error("skill should stay visible in main")
+	}
+	if n := len(registry.Skills("team")); n != 0 {
+		t.Errorf("team skills = %d, want 0", n)
+	}
+
+	// 卸载
+	if err := RemoveSkill(db, "hello"); err != nil {
+		t.Fatalf("RemoveSkill failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(skillsDir, "hello")); !os.IsNotExist(err) {
+		t.Error("skill dir not removed")
+	}
+	if states, _ := db.SkillGroupStates("hello"); len(states) != 0 {
+		t.Error("group states not removed")
+	}
+	if err := RemoveSkill(db, "hello"); err == nil {
+		t.Error("Expected error removing uninstalled skill")
+	}
+}
+
+func TestExtractTarGz_RejectsTraversal(t *testing.T) {
+	tarball := filepath.Join(t.TempDir(), "evil.tar.gz")
+	writeTarGz(t, tarball, map[string]string{"../evil.lua": "x"})
+	if err := extractTarGz(tarball, t.TempDir()); err == nil {
+		t.Error("Expected traversal to be rejected")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0", "1.0.0", 0},
+		{"v1.2.0", "1.10.0", -1},
+		{"2.0.0-beta", "1.9.9", 1},
+	}
+	for _, tt := range tests {
+		got, err := compareVersions(tt.a, tt.b)
+		if err != nil || got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, %v; want %d", tt.a, tt.b, got, err, tt.want)
+		}
+	}
+	if _, err := compareVersions("x", "1.0"); err == nil {
+		t.Error("Expected error for invalid version")
+	}
+}
@@ -2,21 +2,80 @@ package internal
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/google/uuid"
 	"github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
 )
 
+// SkillSource 技能来源，数值越大优先级越高
+type SkillSource int
+
+const (
+	SourceBuiltin SkillSource = iota // 内置（嵌入二进制）
+	SourceGlobal                     // 全局用户技能目录
+	SourceGroup                      // 群组私有技能目录
+)
+
+func (s SkillSource) String() string {
+	switch s {
+	case SourceBuiltin:
+		return "builtin"
+	case SourceGlobal:
+		return "global"
+	case SourceGroup:
+		return "group"
+	}
+	return "unknown"
+}
+
 // Skill 技能定义
 type Skill struct {
-	Name        string
-	Description string
-	Version     string
-	Steps       []SkillStep
-	LuaScript   string
+	Name         string
+	Description  string
+	Version      string
+	Steps        []SkillStep
+	LuaScript    string
+	Source       SkillSource
+	Path         string   // 技能所在路径（内置技能为嵌入路径）
+	Capabilities []string // 声明的能力，见 Cap* 常量
+}
+
+// 技能能力：非内置技能须在 SKILL.md 的 capabilities 中声明后才能使用对应Lua模块。
+// log、uuid、json、time 始终可用。
+const (
+	CapDB    = "db"    // db.exec / db.query
+	CapChat  = "chat"  // chat.send
+	CapTasks = "tasks" // tasks.create / list / pause
+	CapKV    = "kv"    // kv.get / set（按群组隔离）
+	CapHTTP  = "http"  // http.request（仅限白名单主机）
+	CapAgent = "agent" // agent.ask
+	CapExec  = "exec"  // exec.run（在沙箱中执行命令）
+)
+
+// Allows 检查技能是否具备某项能力，内置技能拥有全部能力
+func (s *Skill) Allows(capability string) bool {
+	if s.Source == SourceBuiltin {
+		return true
+	}
+	for _, c := range s.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
 }
 
 // SkillStep 技能步骤
@@ -30,128 +89,515 @@ type SkillContext struct {
 	GroupFolder string
 	ChatJID     ChatJID
 	Args        map[string]string
+	Argv        []string // 位置参数，在Lua中为全局 arg 表
 }
 
 // SkillRegistry 技能注册表
+//
+// 技能分三层：内置 < 全局 < 群组。群组技能只对所属群组可见，
+// 同名时高优先级覆盖低优先级。全局层和群组层可通过 Reload 整体原子替换，
+// 正在执行的技能持有旧版本指针，不受替换影响。
 type SkillRegistry struct {
-	skills map[string]*Skill
-	L      *lua.LState
-	db     *DB
+	mu      sync.RWMutex
+	builtin map[string]*Skill
+	skills  map[string]*Skill            // 全局
+	groups  map[string]map[string]*Skill // 群组folder -> 群组私有技能
+	L       *lua.LState                  // 拥有全部能力的共享状态（调试用），技能执行使用独立状态
+	db      *DB
+
+	chatSend  func(ChatJID, string) error
+	httpAllow []string
+	agent     Runner
+	sandbox   *Sandbox
+	onTasks   func()           // 任务新建或恢复后通知调度器
+	now       func() time.Time // 可替换时钟（技能测试使用固定时间）
+	newID     func() string    // 可替换ID生成器
+
+	dataDir  string // 群组技能数据库所在目录，为空时使用内存数据库
+	dbMu     sync.Mutex
+	groupDBs map[string]*sql.DB // 群组folder -> db 模块使用的数据库
 }
 
 // NewSkillRegistry 创建技能注册表
 func NewSkillRegistry(db *DB) *SkillRegistry {
-	L := lua.NewState()
+	L := newLuaState()
 	sr := &SkillRegistry{
-		skills: make(map[string]*Skill),
-		L:      L,
-		db:     db,
+		builtin:  make(map[string]*Skill),
+		skills:   make(map[string]*Skill),
+		groups:   make(map[string]map[string]*Skill),
+		L:        L,
+		db:       db,
+		now:      time.Now,
+		newID:    func() string { return uuid.New().String() },
+		groupDBs: make(map[string]*sql.DB),
 	}
-	sr.initLuaBindings()
+	sr.openLibs(L, &Skill{Source: SourceBuiltin}, SkillContext{})
 	return sr
 }
 
-// Close 关闭Lua状态
+// SetChatSender 设置 chat.send 的投递函数
+func (sr *SkillRegistry) SetChatSender(fn func(ChatJID, string) error) {
+	sr.chatSend = fn
+}
+
+// SetAgent 设置 agent.ask 使用的LLM
+func (sr *SkillRegistry) SetAgent(r Runner) {
+	sr.agent = r
+}
+
+// SetSandbox 设置 exec.run 使用的沙箱，未设置时 exec.run 不可用
+func (sr *SkillRegistry) SetSandbox(s *Sandbox) {
+	sr.sandbox = s
+}
+
+// SetTaskNotifier 设置任务变化回调（通常为 Scheduler.Wake）
+func (sr *SkillRegistry) SetTaskNotifier(fn func()) {
+	sr.onTasks = fn
+}
+
+// SetHTTPAllowlist 设置 http.request 允许访问的主机（".example.com" 匹配所有子域名）
+func (sr *SkillRegistry) SetHTTPAllowlist(hosts []string) {
+	sr.httpAllow = hosts
+}
+
+// SetDataDir 设置 db 模块的数据目录，每个群组使用其中的 <folder>.db；
+// 未设置时群组数据库只存在于内存中（技能测试使用）
+func (sr *SkillRegistry) SetDataDir(dir string) {
+	sr.dataDir = dir
+}
+
+// Close 关闭Lua状态和群组数据库
 func (sr *SkillRegistry) Close() {
 	sr.L.Close()
+	sr.dbMu.Lock()
+	defer sr.dbMu.Unlock()
+	for folder, db := range sr.groupDBs {
+		db.Close()
+		delete(sr.groupDBs, folder)
+	}
 }
 
-// Register 注册技能
+// Register 按技能来源注册到内置层或全局层
 func (sr *SkillRegistry) Register(s *Skill) {
-	sr.skills[s.Name] = s
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if s.Source == SourceBuiltin {
+		sr.builtin[s.Name] = s
+	} else {
+		sr.skills[s.Name] = s
+	}
 }
 
-// Get 获取技能
+// RegisterGroup 注册群组私有技能
+func (sr *SkillRegistry) RegisterGroup(folder string, s *Skill) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.groups[folder] == nil {
+		sr.groups[folder] = make(map[string]*Skill)
+	}
+	sr.groups[folder][s.Name] = s
+}
+
+// Get 获取全局技能（全局层优先于内置层）
 func (sr *SkillRegistry) Get(name string) (*Skill, bool) {
-	s, ok := sr.skills[name]
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+	return sr.get(name)
+}
+
+func (sr *SkillRegistry) get(name string) (*Skill, bool) {
+	if s, ok := sr.skills[name]; ok {
+		return s, true
+	}
+	s, ok := sr.builtin[name]
 	return s, ok
 }
 
+// Lookup 按群组解析技能：群组私有技能优先于全局技能，在该群组被禁用的技能不可见
+func (sr *SkillRegistry) Lookup(folder, name string) (*Skill, bool) {
+	if sr.disabled(folder)[name] {
+		return nil, false
+	}
+	sr.mu.RLock()
+	defer sr.mu.RUnlock()
+	if s, ok := sr.groups[folder][name]; ok {
+		return s, true
+	}
+	return sr.get(name)
+}
+
+// disabled 返回群组禁用的技能集合（直接读数据库，CLI修改后立即生效）
+func (sr *SkillRegistry) disabled(folder string) map[string]bool {
+	if sr.db == nil {
+		return nil
+	}
+	disabled, err := sr.db.DisabledSkills(folder)
+	if err != nil {
+		slog.Warn("load disabled skills", "group", folder, "err", err)
+	}
+	return disabled
+}
+
+// Skills 返回群组可见的有效技能集合（按名称排序）
+func (sr *SkillRegistry) Skills(folder string) []*Skill {
+	disabled := sr.disabled(folder)
+	sr.mu.RLock()
+	merged := make(map[string]*Skill, len(sr.builtin)+len(sr.skills))
+	for _, layer := range []map[string]*Skill{sr.builtin, sr.skills, sr.groups[folder]} {
+		for name, s := range layer {
+			merged[name] = s
+		}
+	}
+	sr.mu.RUnlock()
+
+	list := make([]*Skill, 0, len(merged))
+	for name, s := range merged {
+		if !disabled[name] {
+			list = append(list, s)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
 // Execute 执行技能
 func (sr *SkillRegistry) Execute(ctx context.Context, name string, sc SkillContext) error {
-	skill, ok := sr.skills[name]
+	_, err := sr.Run(ctx, name, sc)
+	return err
+}
+
+// Run 执行技能并返回脚本的返回值
+//
+// 每次执行使用独立的Lua状态，只加载技能声明的能力对应的模块，
+// 因此并发执行互不影响，热重载也不会打断正在执行的技能。
+func (sr *SkillRegistry) Run(ctx context.Context, name string, sc SkillContext) (string, error) {
+	skill, ok := sr.Lookup(sc.GroupFolder, name)
 	if !ok {
-		return fmt.Errorf("skill not found: %s", name)
+		return "", fmt.Errorf("skill not found: %s", name)
+	}
+	if ctx == nil {
+		ctx = context.Background()
 	}
 
+	L := newLuaState()
+	defer L.Close()
+	L.SetContext(ctx)
+	sr.openLibs(L, skill, sc)
+
 	// 设置上下文变量
-	sr.L.SetGlobal("GROUP_FOLDER", lua.LString(sc.GroupFolder))
-	sr.L.SetGlobal("CHAT_JID", lua.LString(string(sc.ChatJID)))
+	L.SetGlobal("GROUP_FOLDER", lua.LString(sc.GroupFolder))
+	L.SetGlobal("CHAT_JID", lua.LString(string(sc.ChatJID)))
+	argv := L.NewTable()
+	for _, a := range sc.Argv {
+		argv.Append(lua.LString(a))
+	}
+	L.SetGlobal("arg", argv)
 
 	// 执行步骤
 	for _, step := range skill.Steps {
-		if err := sr.executeStep(step); err != nil {
-			return err
+		if err := sr.executeStep(skill, step, sc); err != nil {
+			return "", err
 		}
 	}
 
 	// 执行Lua脚本
-	if skill.LuaScript != "" {
-		if err := sr.L.DoString(skill.LuaScript); err != nil {
-			return fmt.Errorf("lua error: %w", err)
+	if skill.LuaScript == "" {
+		return "", nil
+	}
+	top := L.GetTop()
+	if err := L.DoString(skill.LuaScript); err != nil {
+		return "", fmt.Errorf("lua error: %w", err)
+	}
+	if L.GetTop() > top {
+		if ret := L.Get(top + 1); ret != lua.LNil {
+			return ret.String(), nil
 		}
 	}
+	return "", nil
+}
 
-	return nil
+// SkillError 单个技能解析失败
+type SkillError struct {
+	Path string
+	Err  error
+}
+
+func (e *SkillError) Error() string {
+	return fmt.Sprintf("skill %s: %v", e.Path, e.Err)
 }
 
-// LoadFromDir 从目录加载技能
+func (e *SkillError) Unwrap() error {
+	return e.Err
+}
+
+// LoadAll 按优先级加载全部技能：内置 < 全局用户目录 < 各群组的 skills 目录
+//
+// 无效技能会被跳过，其错误合并后返回；有效技能仍然生效。
+func (sr *SkillRegistry) LoadAll(builtin fs.FS, globalDir, groupsDir string) error {
+	var errs []error
+	if builtin != nil {
+		if err := sr.LoadBuiltin(builtin); err != nil {
+			errs = append(errs, fmt.Errorf("load builtin skills: %w", err))
+		}
+	}
+	if err := sr.Reload(globalDir, groupsDir); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// LoadBuiltin 从嵌入文件系统加载内置技能（根目录下的 builtin/）
+func (sr *SkillRegistry) LoadBuiltin(fsys fs.FS) error {
+	skills, errs := readSkills(fsys, "builtin", SourceBuiltin)
+	for _, s := range skills {
+		sr.Register(s)
+	}
+	return errors.Join(errs...)
+}
+
+// LoadFromDir 从目录加载全局技能
 func (sr *SkillRegistry) LoadFromDir(dir string) error {
-	entries, err := os.ReadDir(dir)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil
+	skills, errs := readDirSkills(dir, SourceGlobal)
+	for _, s := range skills {
+		sr.Register(s)
+	}
+	return errors.Join(errs...)
+}
+
+// LoadGroupDir 从目录加载群组私有技能
+func (sr *SkillRegistry) LoadGroupDir(folder, dir string) error {
+	skills, errs := readDirSkills(dir, SourceGroup)
+	for _, s := range skills {
+		sr.RegisterGroup(folder, s)
+	}
+	return errors.Join(errs...)
+}
+
+// Reload 重新读取全局层和所有群组层并原子替换
+//
+// 解析失败的技能保留其上一个有效版本，错误合并后返回。
+func (sr *SkillRegistry) Reload(globalDir, groupsDir string) error {
+	sr.mu.RLock()
+	previous := make(map[string]*Skill)
+	for _, layer := range sr.groups {
+		for _, s := range layer {
+			previous[s.Path] = s
+		}
+	}
+	for _, s := range sr.skills {
+		previous[s.Path] = s
+	}
+	sr.mu.RUnlock()
+
+	var errs []error
+	keep := func(layer map[string]*Skill, failed []error) {
+		for _, err := range failed {
+			var se *SkillError
+			if errors.As(err, &se) {
+				if old, ok := previous[se.Path]; ok {
+					layer[old.Name] = old
+				}
+			}
+			errs = append(errs, err)
 		}
-		return err
 	}
 
+	global := make(map[string]*Skill)
+	skills, failed := readDirSkills(globalDir, SourceGlobal)
+	for _, s := range skills {
+		global[s.Name] = s
+	}
+	keep(global, failed)
+
+	groups := make(map[string]map[string]*Skill)
+	entries, err := os.ReadDir(groupsDir)
+	if err != nil && !os.IsNotExist(err) {
+		errs = append(errs, fmt.Errorf("read groups dir: %w", err))
+	}
 	for _, entry := range entries {
 		if !entry.IsDir() {
 			continue
 		}
-		skillDir := filepath.Join(dir, entry.Name())
-		if err := sr.loadSkill(skillDir); err != nil {
-			continue // 跳过无效技能
+		layer := make(map[string]*Skill)
+		skills, failed := readDirSkills(filepath.Join(groupsDir, entry.Name(), "skills"), SourceGroup)
+		for _, s := range skills {
+			layer[s.Name] = s
+		}
+		keep(layer, failed)
+		if len(layer) > 0 {
+			groups[entry.Name()] = layer
 		}
 	}
-	return nil
+
+	sr.mu.Lock()
+	sr.skills = global
+	sr.groups = groups
+	sr.mu.Unlock()
+
+	return errors.Join(errs...)
 }
 
-// loadSkill 加载单个技能
-func (sr *SkillRegistry) loadSkill(dir string) error {
-	name := filepath.Base(dir)
+// readDirSkills 从磁盘目录读取技能，Path 为磁盘路径
+func readDirSkills(dir string, source SkillSource) ([]*Skill, []error) {
+	skills, errs := readSkills(os.DirFS(dir), ".", source)
+	for _, s := range skills {
+		s.Path = filepath.Join(dir, filepath.FromSlash(s.Path))
+	}
+	for _, err := range errs {
+		var se *SkillError
+		if errors.As(err, &se) {
+			se.Path = filepath.Join(dir, filepath.FromSlash(se.Path))
+		}
+	}
+	return skills, errs
+}
 
-	// 读取SKILL.md
-	skillPath := filepath.Join(dir, "SKILL.md")
-	data, err := os.ReadFile(skillPath)
+// readSkills 读取目录中的技能
+//
+// 支持两种布局：
+//   - <name>/SKILL.md（可选 script.lua）
+//   - <name>.lua 单文件技能，开头的 "--" 注释行作为描述
+func readSkills(fsys fs.FS, dir string, source SkillSource) ([]*Skill, []error) {
+	entries, err := fs.ReadDir(fsys, dir)
 	if err != nil {
-		return err
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, []error{err}
 	}
 
-	skill := &Skill{Name: name}
-	// 简化的解析：假设第一行是描述
-	lines := strings.Split(string(data), "\n")
-	if len(lines) > 0 {
-		skill.Description = strings.TrimPrefix(lines[0], "# ")
+	var skills []*Skill
+	var errs []error
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue // 隐藏文件及安装过程中的临时目录
+		}
+		p := path.Join(dir, entry.Name())
+		var skill *Skill
+		if entry.IsDir() {
+			skill, err = parseSkillDir(fsys, p)
+		} else if strings.HasSuffix(entry.Name(), ".lua") {
+			skill, err = parseLuaSkill(fsys, p)
+		} else {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, &SkillError{Path: p, Err: err})
+			continue
+		}
+		skill.Source = source
+		skill.Path = p
+		skills = append(skills, skill)
+	}
+	return skills, errs
+}
+
+// parseSkillDir 解析 SKILL.md 目录技能
+func parseSkillDir(fsys fs.FS, dir string) (*Skill, error) {
+	data, err := fs.ReadFile(fsys, path.Join(dir, "SKILL.md"))
+	if err != nil {
+		return nil, err
+	}
+
+	skill := &Skill{Name: path.Base(dir)}
+	meta, body := splitFrontmatter(string(data))
+	if v := meta["name"]; v != "" {
+		skill.Name = v
+	}
+	skill.Version = meta["version"]
+	skill.Description = meta["description"]
+	skill.Capabilities = splitList(meta["capabilities"])
+	if skill.Description == "" {
+		// 无frontmatter时，第一行是描述
+		lines := strings.Split(body, "\n")
+		if len(lines) > 0 {
+			skill.Description = strings.TrimPrefix(lines[0], "# ")
+		}
 	}
 
 	// 读取script.lua（可选）
-	scriptPath := filepath.Join(dir, "script.lua")
-	if scriptData, err := os.ReadFile(scriptPath); err == nil {
+	if scriptData, err := fs.ReadFile(fsys, path.Join(dir, "script.lua")); err == nil {
 		skill.LuaScript = string(scriptData)
+		if err := checkLuaSyntax(skill.LuaScript, path.Join(dir, "script.lua")); err != nil {
+			return nil, err
+		}
 	}
+	return skill, nil
+}
+
+// parseLuaSkill 解析单文件Lua技能
+func parseLuaSkill(fsys fs.FS, file string) (*Skill, error) {
+	data, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return nil, err
+	}
+
+	skill := &Skill{
+		Name:      strings.TrimSuffix(path.Base(file), ".lua"),
+		LuaScript: string(data),
+	}
+	if err := checkLuaSyntax(skill.LuaScript, file); err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(skill.LuaScript, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "--") {
+			break
+		}
+		comment := strings.TrimSpace(strings.TrimPrefix(line, "--"))
+		if k, v, ok := strings.Cut(comment, ":"); ok && strings.TrimSpace(k) == "capabilities" {
+			skill.Capabilities = splitList(v)
+		} else if skill.Description == "" {
+			skill.Description = comment
+		}
+	}
+	return skill, nil
+}
 
-	sr.Register(skill)
+// checkLuaSyntax 只做语法检查，不执行
+func checkLuaSyntax(script, name string) error {
+	if _, err := parse.Parse(strings.NewReader(script), name); err != nil {
+		return fmt.Errorf("lua syntax: %w", err)
+	}
 	return nil
 }
 
+// splitFrontmatter 拆分 "---" 包裹的 key: value 头部
+func splitFrontmatter(content string) (map[string]string, string) {
+	meta := make(map[string]string)
+	if !strings.HasPrefix(content, "---\n") {
+		return meta, content
+	}
+	rest := content[len("---\n"):]
+	end := strings.Index(rest, "\n---")
+	if end < 0 {
+		return meta, content
+	}
+	for _, line := range strings.Split(rest[:end], "\n") {
+		k, v, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		meta[strings.TrimSpace(k)] = strings.Trim(strings.TrimSpace(v), `"'`)
+	}
+	body := strings.TrimPrefix(rest[end+len("\n---"):], "\n")
+	return meta, strings.TrimLeft(body, "\n")
+}
+
 // executeStep 执行步骤
-func (sr *SkillRegistry) executeStep(step SkillStep) error {
+func (sr *SkillRegistry) executeStep(skill *Skill, step SkillStep, sc SkillContext) error {
 	switch step.Action {
 	case "log":
 		fmt.Printf("[SKILL] %s\n", step.Params["message"])
 	case "db_exec":
-		_, err := sr.db.Exec(step.Params["sql"])
+		if !skill.Allows(CapDB) {
+			return fmt.Errorf("skill %s lacks capability %q", skill.Name, CapDB)
+		}
+		db, err := sr.groupDB(sc.GroupFolder, step.Params["sql"])
+		if err != nil {
+			return err
+		}
+		_, err = db.Exec(step.Params["sql"])
 		return err
 	default:
 		return fmt.Errorf("unknown action: %s", step.Action)
@@ -159,38 +605,177 @@ func (sr *SkillRegistry) executeStep(step SkillStep) error {
 	return nil
 }
 
-// initLuaBindings 初始化Lua绑定
-func (sr *SkillRegistry) initLuaBindings() {
-	// 注册db模块
-	mod := sr.L.NewTable()
-	sr.L.SetField(mod, "exec", sr.L.NewFunction(sr.luaDBExec))
-	sr.L.SetField(mod, "query", sr.L.NewFunction(sr.luaDBQuery))
-	sr.L.SetGlobal("db", mod)
-
-	// 注册log函数
-	sr.L.SetGlobal("log", sr.L.NewFunction(sr.luaLog))
+// skillSQLStatements 技能可以执行的语句（按首个关键字）。ATTACH、DETACH、PRAGMA、VACUUM 等
+// 可以打开其他数据库文件或修改连接设置的语句都不在其中
+var skillSQLStatements = map[string]bool{
+	"SELECT": true, "INSERT": true, "UPDATE": true, "DELETE": true, "REPLACE": true,
+	"WITH": true, "CREATE": true, "DROP": true, "ALTER": true,
+}
 
-	// 注册uuid函数
-	sr.L.SetGlobal("uuid", sr.L.NewFunction(sr.luaUUID))
+// checkSkillSQL 检查技能SQL只有一条语句，且语句类型在 skillSQLStatements 中
+func checkSkillSQL(query string) error {
+	keyword := ""
+	ended := false // 已遇到语句末尾的分号
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			i++
+			continue
+		case strings.HasPrefix(query[i:], "--"):
+			if j := strings.IndexByte(query[i:], '\n'); j >= 0 {
+				i += j + 1
+			} else {
+				i = len(query)
+			}
+			continue
+		case strings.HasPrefix(query[i:], "/*"):
+			if j := strings.Index(query[i+2:], "*/"); j >= 0 {
+				i += j + 4
+			} else {
+				i = len(query)
+			}
+			continue
+		}
+		if ended {
+			return errors.New("only one SQL statement is allowed")
+		}
+		switch {
+		case c == ';':
+			ended = true
+			i++
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			// 字符串和引用的标识符整体跳过（'' 等转义视为相邻的两段）
+			quote := c
+			if c == '[' {
+				quote = ']'
+			}
+			if j := strings.IndexByte(query[i+1:], quote); j >= 0 {
+				i += j + 2
+			} else {
+				i = len(query)
+			}
+		case keyword == "":
+			j := i
+			for j < len(query) && (query[j] >= 'A' && query[j] <= 'Z' || query[j] >= 'a' && query[j] <= 'z') {
+				j++
+			}
+			if j == i {
+				return fmt.Errorf("SQL statement starting with %q is not allowed", c)
+			}
+			keyword = strings.ToUpper(query[i:j])
+			if !skillSQLStatements[keyword] {
+				return fmt.Errorf("SQL statement %s is not allowed", keyword)
+			}
+			i = j
+		default:
+			i++
+		}
+	}
+	if keyword == "" {
+		return errors.New("empty SQL statement")
+	}
+	return nil
 }
 
-// luaDBExec Lua绑定：执行SQL
-func (sr *SkillRegistry) luaDBExec(L *lua.LState) int {
-	sql := L.CheckString(1)
-	_, err := sr.db.Exec(sql)
+// groupDB 检查技能SQL并返回群组的数据库
+//
+// 每个群组使用独立的SQLite数据库，技能看不到守护进程的数据库（任务、令牌等）
+// 和其他群组的数据；数据库文件放在数据目录而不是群组目录，沙箱中的命令无法替换它。
+func (sr *SkillRegistry) groupDB(folder, query string) (*sql.DB, error) {
+	if err := checkSkillSQL(query); err != nil {
+		return nil, err
+	}
+	if folder == "" || folder == "." || folder == ".." || strings.ContainsAny(folder, `/\`) {
+		return nil, fmt.Errorf("db requires a group folder, got %q", folder)
+	}
+
+	sr.dbMu.Lock()
+	defer sr.dbMu.Unlock()
+	if db, ok := sr.groupDBs[folder]; ok {
+		return db, nil
+	}
+	path := ":memory:"
+	if sr.dataDir != "" {
+		if err := os.MkdirAll(sr.dataDir, 0700); err != nil {
+			return nil, err
+		}
+		path = filepath.Join(sr.dataDir, folder+".db")
+	}
+	db, err := sql.Open("sqlite", path)
 	if err != nil {
-		L.Push(lua.LString(err.Error()))
+		return nil, fmt.Errorf("open group db: %w", err)
+	}
+	// 单个连接：内存数据库随连接存在，文件数据库的写入本来也是串行的
+	db.SetMaxOpenConns(1)
+	sr.groupDBs[folder] = db
+	return db, nil
+}
+
+// luaDBExec Lua绑定：在群组数据库中执行SQL（同时支持 db.exec(sql, ...) 与 db:exec(sql, ...)）
+func (sr *SkillRegistry) luaDBExec(sc SkillContext) lua.LGFunction {
+	return func(L *lua.LState) int {
+		off := argOffset(L)
+		query := L.CheckString(off + 1)
+		args := luaArgs(L, off+2)
+		db, err := sr.groupDB(sc.GroupFolder, query)
+		if err == nil {
+			_, err = db.Exec(query, args...)
+		}
+		if err != nil {
+			L.Push(lua.LString(err.Error()))
+			return 1
+		}
+		L.Push(lua.LNil)
 		return 1
 	}
-	L.Push(lua.LNil)
-	return 1
 }
 
-// luaDBQuery Lua绑定：查询SQL
-func (sr *SkillRegistry) luaDBQuery(L *lua.LState) int {
-	// 简化实现，返回空表
-	L.Push(lua.LString("not implemented"))
-	return 1
+// luaDBQuery Lua绑定：在群组数据库中查询SQL，返回行数组（每行是 列名->值 的表）
+func (sr *SkillRegistry) luaDBQuery(sc SkillContext) lua.LGFunction {
+	return func(L *lua.LState) int {
+		off := argOffset(L)
+		query := L.CheckString(off + 1)
+		args := luaArgs(L, off+2)
+		db, err := sr.groupDB(sc.GroupFolder, query)
+		if err != nil {
+			return luaFail(L, err)
+		}
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return luaFail(L, err)
+		}
+		defer rows.Close()
+
+		cols, err := rows.Columns()
+		if err != nil {
+			return luaFail(L, err)
+		}
+		result := L.NewTable()
+		for rows.Next() {
+			vals := make([]any, len(cols))
+			ptrs := make([]any, len(cols))
+			for i := range vals {
+				ptrs[i] = &vals[i]
+			}
+			if err := rows.Scan(ptrs...); err != nil {
+				return luaFail(L, err)
+			}
+			row := L.NewTable()
+			for i, col := range cols {
+				if b, ok := vals[i].([]byte); ok {
+					vals[i] = string(b)
+				}
+				row.RawSetString(col, goToLua(L, vals[i]))
+			}
+			result.Append(row)
+		}
+		if err := rows.Err(); err != nil {
+			return luaFail(L, err)
+		}
+		L.Push(result)
+		return 1
+	}
 }
 
 // luaLog Lua绑定：日志
@@ -202,6 +787,38 @@ func (sr *SkillRegistry) luaLog(L *lua.LState) int {
 
 // luaUUID Lua绑定：生成UUID
 func (sr *SkillRegistry) luaUUID(L *lua.LState) int {
-	L.Push(lua.LString(fmt.Sprintf("%d", os.Getpid())))
+	L.Push(lua.LString(sr.newID()))
 	return 1
 }
+
+// splitList 拆分逗号分隔列表
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// argOffset 方法调用语法（obj:fn）会把表本身作为第一个参数
+func argOffset(L *lua.LState) int {
+	if L.GetTop() > 0 && L.Get(1).Type() == lua.LTTable {
+		return 1
+	}
+	return 0
+}
+
+// luaArgs 把从 start 开始的Lua参数转换为SQL绑定参数
+func luaArgs(L *lua.LState, start int) []any {
+	var args []any
+	for i := start; i <= L.GetTop(); i++ {
+		arg, err := luaToGo(L.Get(i))
+		if err != nil {
+			L.ArgError(i, err.Error())
+		}
+		args = append(args, arg)
+	}
+	return args
+}
@@ -0,0 +1,770 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yuin/gopher-lua"
+)
+
+// Lua标准库
+//
+// 始终可用：
+//   log(msg)                          输出日志
+//   uuid()                            生成随机UUID
+//   json.encode(value) -> str         Lua值编码为JSON
+//   json.decode(str) -> value         JSON解码为Lua值（null 解码为 nil）
+//   time.now() -> ts                  当前Unix时间（秒，含小数）
+//   time.format(ts[, layout[, tz]])   按Go布局格式化，默认RFC3339
+//   time.parse(str[, layout[, tz]])   解析为Unix时间，失败返回 nil, err
+//
+// 需要声明能力：
+//   db     db.exec(sql, ...) -> err；db.query(sql, ...) -> rows, err
+//          （每个群组独立的数据库，每次一条 SELECT/INSERT/UPDATE/DELETE/REPLACE/WITH/CREATE/DROP/ALTER 语句）
+//   chat   chat.send([jid,] text) -> true | nil, err（jid 默认为 CHAT_JID，须为注册到当前群组的会话）
+//   tasks  tasks.create(prompt, type, value[, opts]) -> id | nil, err
+//          opts: silent, timezone, retries, retry_delay, timeout（Go时长字符串）, misfire（once/all/skip）,
+//                debounce, rate_limit（event 任务，type 为 "event"，value 如 "/regex/"、"keyword:a,b"、"join"）,
+//                steps（工作流：{{name=, prompt= 或 skill=, args={...}, depends_on={...}}, ...}，{} 清除步骤）
+//          tasks.list([status]) -> 当前群组任务数组；tasks.get(id) -> task | nil, err
+//          tasks.update(id, opts) -> true | nil, err（opts 另可含 prompt, schedule_type, schedule_value）
+//          tasks.pause(id) / tasks.resume(id) / tasks.run(id)（立即执行）/ tasks.delete(id) -> true | nil, err
+//   kv     kv.get(key) -> value | nil；kv.set(key, value|nil) -> true | nil, err
+//   http   http.request{url=, method=, headers=, body=, timeout=} -> {status, headers, body} | nil, err
+//   agent  agent.ask(prompt) -> reply | nil, err（使用当前群组的记忆调用LLM）
+//   exec   exec.run(command[, {stdin=}]) -> {status, stdout, stderr} | nil, err
+//          （/bin/sh -c 在群组沙箱中执行，工作目录为群组目录，见 Sandbox）
+//
+// Lua自带的库只加载 base、table、string、math、coroutine 和 os 中与时间相关的函数，
+// 没有 io、dofile/loadfile 和 os.execute 等可访问文件系统或启动进程的函数。
+//
+// 出错时遵循Lua惯例返回 nil 和错误字符串，而不是抛出异常；参数本身不合法时
+// （类型不对、表引用了自身或嵌套过深）与 L.CheckString 等一样抛出Lua错误。
+
+const (
+	luaHTTPTimeout = 10 * time.Second
+	luaHTTPMaxBody = 1 << 20
+	luaMaxDepth    = 100 // luaToGo 允许的最大表嵌套层数
+)
+
+// luaOSAllowed 保留的 os 函数
+var luaOSAllowed = map[string]bool{"clock": true, "date": true, "difftime": true, "time": true}
+
+// newLuaState 创建只含安全标准库的Lua状态
+func newLuaState() *lua.LState {
+	L := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range []struct {
+		name string
+		open lua.LGFunction
+	}{
+		{lua.BaseLibName, lua.OpenBase},
+		{lua.TabLibName, lua.OpenTable},
+		{lua.StringLibName, lua.OpenString},
+		{lua.MathLibName, lua.OpenMath},
+		{lua.CoroutineLibName, lua.OpenCoroutine},
+		{lua.OsLibName, lua.OpenOs},
+	} {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	L.SetGlobal("dofile", lua.LNil)
+	L.SetGlobal("loadfile", lua.LNil)
+	if osLib, ok := L.GetGlobal("os").(*lua.LTable); ok {
+		osLib.ForEach(func(k, _ lua.LValue) {
+			if !luaOSAllowed[k.String()] {
+				osLib.RawSet(k, lua.LNil)
+			}
+		})
+	}
+	return L
+}
+
+// openLibs 按技能能力向Lua状态注册模块
+func (sr *SkillRegistry) openLibs(L *lua.LState, skill *Skill, sc SkillContext) {
+	L.SetGlobal("log", L.NewFunction(sr.luaLog))
+	L.SetGlobal("uuid", L.NewFunction(sr.luaUUID))
+	L.SetGlobal("json", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"encode": luaJSONEncode,
+		"decode": luaJSONDecode,
+	}))
+	L.SetGlobal("time", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"now":    sr.luaTimeNow,
+		"format": luaTimeFormat,
+		"parse":  luaTimeParse,
+	}))
+
+	if skill.Allows(CapDB) {
+		L.SetGlobal("db", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"exec":  sr.luaDBExec(sc),
+			"query": sr.luaDBQuery(sc),
+		}))
+	}
+	if skill.Allows(CapChat) {
+		L.SetGlobal("chat", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"send": sr.luaChatSend(sc),
+		}))
+	}
+	if skill.Allows(CapTasks) {
+		L.SetGlobal("tasks", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"create": sr.luaTaskCreate(sc),
+			"list":   sr.luaTaskList(sc),
+			"get":    sr.luaTaskGet(sc),
+			"update": sr.luaTaskUpdate(sc),
+			"pause":  sr.luaTaskSetStatus(sc, TaskPaused),
+			"resume": sr.luaTaskSetStatus(sc, TaskActive),
+			"run":    sr.luaTaskRun(sc),
+			"delete": sr.luaTaskDelete(sc),
+		}))
+	}
+	if skill.Allows(CapKV) {
+		L.SetGlobal("kv", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"get": sr.luaKVGet(sc),
+			"set": sr.luaKVSet(sc),
+		}))
+	}
+	if skill.Allows(CapHTTP) {
+		L.SetGlobal("http", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"request": sr.luaHTTPRequest,
+		}))
+	}
+	if skill.Allows(CapAgent) {
+		L.SetGlobal("agent", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"ask": sr.luaAgentAsk(sc),
+		}))
+	}
+	if skill.Allows(CapExec) {
+		L.SetGlobal("exec", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+			"run": sr.luaExecRun(sc),
+		}))
+	}
+}
+
+// luaFail 按Lua惯例返回 nil, err
+func luaFail(L *lua.LState, err error) int {
+	L.Push(lua.LNil)
+	L.Push(lua.LString(err.Error()))
+	return 2
+}
+
+func luaJSONEncode(L *lua.LState) int {
+	v, err := luaToGo(L.CheckAny(1))
+	if err != nil {
+		L.RaiseError("json.encode: %v", err)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return luaFail(L, err)
+	}
+	L.Push(lua.LString(data))
+	return 1
+}
+
+func luaJSONDecode(L *lua.LState) int {
+	var v any
+	if err := json.Unmarshal([]byte(L.CheckString(1)), &v); err != nil {
+		return luaFail(L, err)
+	}
+	L.Push(goToLua(L, v))
+	return 1
+}
+
+func (sr *SkillRegistry) luaTimeNow(L *lua.LState) int {
+	L.Push(lua.LNumber(float64(sr.now().UnixNano()) / 1e9))
+	return 1
+}
+
+// luaTimeArgs 解析可选的 layout 与 tz 参数
+func luaTimeArgs(L *lua.LState, n int) (string, *time.Location, error) {
+	layout := L.OptString(n, time.RFC3339)
+	loc, err := LoadTimezone(L.OptString(n+1, ""))
+	return layout, loc, err
+}
+
+func luaTimeFormat(L *lua.LState) int {
+	ts := float64(L.CheckNumber(1))
+	layout, loc, err := luaTimeArgs(L, 2)
+	if err != nil {
+		return luaFail(L, err)
+	}
+	sec := int64(ts)
+	t := time.Unix(sec, int64((ts-float64(sec))*1e9)).In(loc)
+	L.Push(lua.LString(t.Format(layout)))
+	return 1
+}
+
+func luaTimeParse(L *lua.LState) int {
+	s := L.CheckString(1)
+	layout, loc, err := luaTimeArgs(L, 2)
+	if err != nil {
+		return luaFail(L, err)
+	}
+	t, err := time.ParseInLocation(layout, s, loc)
+	if err != nil {
+		return luaFail(L, err)
+	}
+	L.Push(lua.LNumber(float64(t.UnixNano()) / 1e9))
+	return 1
+}
+
+func (sr *SkillRegistry) luaChatSend(sc SkillContext) lua.LGFunction {
+	return func(L *lua.LState) int {
+		jid, text := sc.ChatJID, L.CheckString(1)
+		if L.GetTop() >= 2 {
+			jid, text = ChatJID(L.CheckString(1)), L.CheckString(2)
+		}
+		if sr.chatSend == nil {
+			return luaFail(L, fmt.Errorf("chat not available"))
+		}
+		if jid == "" {
+			return luaFail(L, fmt.Errorf("no chat jid"))
+		}
+		// 只能发往注册到本群组的会话
+		if g, err := sr.db.GetGroup(jid); err != nil || g.Folder != sc.GroupFolder {
+			return luaFail(L, fmt.Errorf("chat %s does not belong to group %s", jid, sc.GroupFolder))
+		}
+		if err := sr.chatSend(jid, text); err != nil {
+			return luaFail(L, err)
+		}
+		L.Push(lua.LTrue)
+		return 1
+	}
+}
+
+func (sr *SkillRegistry) luaTaskCreate(sc SkillContext) lua.LGFunction {
+	return func(L *lua.LState) int {
+		now := sr.now()
+		task := &Task{
+			ID:            sr.newID(),
+			GroupFolder:   sc.GroupFolder,
+			ChatJID:       sc.ChatJID,
+			Prompt:        L.CheckString(1),
+			ScheduleType:  L.OptString(2, "once"),
+			ScheduleValue: L.OptString(3, ""),
+			Status:        TaskActive,
+			CreatedAt:     now,
+		}
+		var steps []WorkflowStep
+		if opts, ok := L.Get(4).(*lua.LTable); ok {
+			if err := applyTaskOpts(task, opts); err != nil {
+				return luaFail(L, err)
+			}
+			var err error
+			if steps, _, err = luaStepsOpt(opts); err != nil {
+				return luaFail(L, err)
+			}
+		}
+		if err := sr.db.SaveTask(task); err != nil {
+			return luaFail(L, err)
+		}
+		if len(steps) > 0 {
+			if err := sr.db.SaveTaskSteps(task.ID, steps); err != nil {
+				return luaFail(L, err)
+			}
+		}
+		sr.notifyTasks()
+		L.Push(lua.LString(task.ID))
+		return 1
+	}
+}
+
+// applyTaskOpts 将 opts 表中出现的字段写入任务，未出现的字段保持不变
+func applyTaskOpts(task *Task, opts *lua.LTable) error {
+	if v, ok := opts.RawGetString("prompt").(lua.LString); ok {
+		task.Prompt = string(v)
+	}
+	if v, ok := opts.RawGetString("schedule_type").(lua.LString); ok {
+		task.ScheduleType = string(v)
+	}
+	if v, ok := opts.RawGetString("schedule_value").(lua.LString); ok {
+		task.ScheduleValue = string(v)
+	}
+	if v := opts.RawGetString("silent"); v != lua.LNil {
+		task.Silent = lua.LVAsBool(v)
+	}
+	if v, ok := opts.RawGetString("timezone").(lua.LString); ok {
+		task.Timezone = string(v)
+	}
+	if v, ok := opts.RawGetString("retries").(lua.LNumber); ok {
+		task.RetryLimit = int(v)
+	}
+	if v, ok := opts.RawGetString("misfire").(lua.LString); ok {
+		task.Misfire = string(v)
+	}
+	if v, ok := opts.RawGetString("rate_limit").(lua.LNumber); ok {
+		task.RateLimit = int(v)
+	}
+	var err error
+	if task.RetryDelay, err = luaDurationOpt(opts, "retry_delay", task.RetryDelay); err != nil {
+		return err
+	}
+	if task.Timeout, err = luaDurationOpt(opts, "timeout", task.Timeout); err != nil {
+		return err
+	}
+	if task.Debounce, err = luaDurationOpt(opts, "debounce", task.Debounce); err != nil {
+		return err
+	}
+	return nil
+}
+
+// luaStepsOpt 读取 opts.steps 中的工作流步骤并校验；第二个返回值表示是否设置了该选项
+func luaStepsOpt(opts *lua.LTable) ([]WorkflowStep, bool, error) {
+	v := opts.RawGetString("steps")
+	if v == lua.LNil {
+		return nil, false, nil
+	}
+	tbl, ok := v.(*lua.LTable)
+	if !ok {
+		return nil, false, errors.New("steps must be a table")
+	}
+	var steps []WorkflowStep
+	for i := 1; i <= tbl.Len(); i++ {
+		st, ok := tbl.RawGetInt(i).(*lua.LTable)
+		if !ok {
+			return nil, false, fmt.Errorf("step %d must be a table", i)
+		}
+		steps = append(steps, WorkflowStep{
+			Name:      lua.LVAsString(st.RawGetString("name")),
+			Prompt:    lua.LVAsString(st.RawGetString("prompt")),
+			Skill:     lua.LVAsString(st.RawGetString("skill")),
+			Args:      luaStrings(st.RawGetString("args")),
+			DependsOn: luaStrings(st.RawGetString("depends_on")),
+		})
+	}
+	if _, err := OrderWorkflow(steps); err != nil {
+		return nil, false, err
+	}
+	return steps, true, nil
+}
+
+// luaStrings 把Lua数组转换为字符串切片，非表返回 nil
+func luaStrings(v lua.LValue) []string {
+	tbl, ok := v.(*lua.LTable)
+	if !ok {
+		return nil
+	}
+	var out []string
+	for i := 1; i <= tbl.Len(); i++ {
+		out = append(out, lua.LVAsString(tbl.RawGetInt(i)))
+	}
+	return out
+}
+
+// luaDurationOpt 读取时长选项，接受 "90s" 形式的字符串或秒数；未设置时返回 def
+func luaDurationOpt(opts *lua.LTable, key string, def time.Duration) (time.Duration, error) {
+	switch v := opts.RawGetString(key).(type) {
+	case lua.LString:
+		d, err := time.ParseDuration(string(v))
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		return d, nil
+	case lua.LNumber:
+		return time.Duration(float64(v) * float64(time.Second)), nil
+	}
+	return def, nil
+}
+
+func (sr *SkillRegistry) notifyTasks() {
+	if sr.onTasks != nil {
+		sr.onTasks()
+	}
+}
+
+// groupTask 获取当前群组的任务，其他群组的任务视为不存在
+func (sr *SkillRegistry) groupTask(sc SkillContext, id string) (*Task, error) {
+	task, err := sr.db.GetTask(id)
+	if err != nil || task.GroupFolder != sc.GroupFolder {
+		return nil, fmt.Errorf("task not found: %s", id)
+	}
+	return task, nil
+}
+
+func luaTaskTable(L *lua.LState, t *Task) *lua.LTable {
+	row := L.NewTable()
+	row.RawSetString("id", lua.LString(t.ID))
+	row.RawSetString("prompt", lua.LString(t.Prompt))
+	row.RawSetString("schedule_type", lua.LString(t.ScheduleType))
+	row.RawSetString("schedule_value", lua.LString(t.ScheduleValue))
+	row.RawSetString("status", lua.LString(t.Status))
+	row.RawSetString("silent", lua.LBool(t.Silent))
+	row.RawSetString("timezone", lua.LString(t.Timezone))
+	row.RawSetString("retries", lua.LNumber(t.RetryLimit))
+	row.RawSetString("misfire", lua.LString(t.Misfire))
+	row.RawSetString("last_result", lua.LString(t.LastResult))
+	if t.NextRun != nil {
+		row.RawSetString("next_run", lua.LNumber(t.NextRun.Unix()))
+	}
+	if t.LastRun != nil {
+		row.RawSetString("last_run", lua.LNumber(t.LastRun.Unix()))
+	}
+	return row
+}
+
+func (sr *SkillRegistry) luaTaskList(sc SkillContext) lua.LGFunction {
+	return func(L *lua.LState) int {
+		tasks, err := sr.db.FindTasks(TaskFilter{GroupFolder: sc.GroupFolder, Status: L.OptString(1, "")})
+		if err != nil {
+			return luaFail(L, err)
+		}
+		result := L.NewTable()
+		for i := range tasks {
+			result.Append(luaTaskTable(L, &tasks[i]))
+		}
+		L.Push(result)
+		return 1
+	}
+}
+
+func (sr *SkillRegistry) luaTaskGet(sc SkillContext) lua.LGFunction {
+	return func(L *lua.LState) int {
+		task, err := sr.groupTask(sc, L.CheckString(1))
+		if err != nil {
+			return luaFail(L, err)
+		}
+		L.Push(luaTaskTable(L, task))
+		return 1
+	}
+}
+
+func (sr *SkillRegistry) luaTaskUpdate(sc SkillContext) lua.LGFunction {
+	return func(L *lua.LState) int {
+		task, err := sr.groupTask(sc, L.CheckString(1))
+		if err != nil {
+			return luaFail(L, err)
+		}
+		before := *task
+		opts := L.CheckTable(2)
+		if err := applyTaskOpts(task, opts); err != nil {
+			return luaFail(L, err)
+		}
+		steps, setSteps, err := luaStepsOpt(opts)
+		if err != nil {
+			return luaFail(L, err)
+		}
+		if task.ScheduleType != before.ScheduleType || task.ScheduleValue != before.ScheduleValue || task.Timezone != before.Timezone {
+			if err := task.Reschedule(sr.now()); err != nil {
+				return luaFail(L, err)
+			}
+		}
+		if err := sr.db.SaveTask(task); err != nil {
+			return luaFail(L, err)
+		}
+		if setSteps {
+			if err := sr.db.SaveTaskSteps(task.ID, steps); err != nil {
+				return luaFail(L, err)
+			}
+		}
+		sr.notifyTasks()
+		L.Push(lua.LTrue)
+		return 1
+	}
+}
+
+func (sr *SkillRegistry) luaTaskSetStatus(sc SkillContext, status string) lua.LGFunction {
+	return func(L *lua.LState) int {
+		task, err := sr.groupTask(sc, L.CheckString(1))
+		if err != nil {
+			return luaFail(L, err)
+		}
+		if err := task.SetStatus(status, sr.now()); err != nil {
+			return luaFail(L, err)
+		}
+		if err := sr.db.SaveTask(task); err != nil {
+			return luaFail(L, err)
+		}
+		sr.notifyTasks()
+		L.Push(lua.LTrue)
+		return 1
+	}
+}
+
+func (sr *SkillRegistry) luaTaskRun(sc SkillContext) lua.LGFunction {
+	return func(L *lua.LState) int {
+		task, err := sr.groupTask(sc, L.CheckString(1))
+		if err != nil {
+			return luaFail(L, err)
+		}
+		if err := sr.db.TriggerTask(task.ID, sr.now()); err != nil {
+			return luaFail(L, err)
+		}
+		sr.notifyTasks()
+		L.Push(lua.LTrue)
+		return 1
+	}
+}
+
+func (sr *SkillRegistry) luaTaskDelete(sc SkillContext) lua.LGFunction {
+	return func(L *lua.LState) int {
+		task, err := sr.groupTask(sc, L.CheckString(1))
+		if err != nil {
+			return luaFail(L, err)
+		}
+		if err := sr.db.DeleteTask(task.ID); err != nil {
+			return luaFail(L, err)
+		}
+		sr.notifyTasks()
+		L.Push(lua.LTrue)
+		return 1
+	}
+}
+
+func (sr *SkillRegistry) luaKVGet(sc SkillContext) lua.LGFunction {
+	return func(L *lua.LState) int {
+		v, ok, err := sr.db.GetKV(sc.GroupFolder, L.CheckString(1))
+		if err != nil {
+			return luaFail(L, err)
+		}
+		if !ok {
+			L.Push(lua.LNil)
+			return 1
+		}
+		L.Push(lua.LString(v))
+		return 1
+	}
+}
+
+func (sr *SkillRegistry) luaKVSet(sc SkillContext) lua.LGFunction {
+	return func(L *lua.LState) int {
+		key := L.CheckString(1)
+		var err error
+		if v := L.Get(2); v == lua.LNil {
+			err = sr.db.DeleteKV(sc.GroupFolder, key)
+		} else {
+			err = sr.db.SetKV(sc.GroupFolder, key, lua.LVAsString(v))
+		}
+		if err != nil {
+			return luaFail(L, err)
+		}
+		L.Push(lua.LTrue)
+		return 1
+	}
+}
+
+func (sr *SkillRegistry) luaAgentAsk(sc SkillContext) lua.LGFunction {
+	return func(L *lua.LState) int {
+		if sr.agent == nil {
+			return luaFail(L, fmt.Errorf("agent not available"))
+		}
+		msgs := []Message{{
+			ChatJID:   sc.ChatJID,
+			Sender:    "skill",
+			Content:   L.CheckString(1),
+			Timestamp: sr.now(),
+		}}
+		reply, err := sr.agent.Run(L.Context(), sc.GroupFolder, msgs)
+		if err != nil {
+			return luaFail(L, err)
+		}
+		L.Push(lua.LString(reply))
+		return 1
+	}
+}
+
+// luaExecRun exec.run(command[, {stdin=}])
+func (sr *SkillRegistry) luaExecRun(sc SkillContext) lua.LGFunction {
+	return func(L *lua.LState) int {
+		if sr.sandbox == nil {
+			return luaFail(L, fmt.Errorf("exec not available"))
+		}
+		command := L.CheckString(1)
+		var stdin string
+		if opts := L.OptTable(2, nil); opts != nil {
+			stdin = lua.LVAsString(opts.RawGetString("stdin"))
+		}
+		res, err := sr.sandbox.Run(L.Context(), sc.GroupFolder, command, stdin)
+		if err != nil {
+			return luaFail(L, err)
+		}
+		t := L.NewTable()
+		t.RawSetString("status", lua.LNumber(res.Status))
+		t.RawSetString("stdout", lua.LString(res.Stdout))
+		t.RawSetString("stderr", lua.LString(res.Stderr))
+		L.Push(t)
+		return 1
+	}
+}
+
+// hostAllowed 检查主机是否在白名单中
+func (sr *SkillRegistry) hostAllowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, h := range sr.httpAllow {
+		h = strings.ToLower(h)
+		if h == host || (strings.HasPrefix(h, ".") && strings.HasSuffix(host, h)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (sr *SkillRegistry) luaHTTPRequest(L *lua.LState) int {
+	opts := L.CheckTable(1)
+	u, err := url.Parse(lua.LVAsString(opts.RawGetString("url")))
+	if err != nil {
+		return luaFail(L, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return luaFail(L, fmt.Errorf("unsupported scheme: %q", u.Scheme))
+	}
+	if !sr.hostAllowed(u.Hostname()) {
+		return luaFail(L, fmt.Errorf("host not allowed: %s", u.Hostname()))
+	}
+
+	method := strings.ToUpper(lua.LVAsString(opts.RawGetString("method")))
+	if method == "" {
+		method = http.MethodGet
+	}
+	timeout := luaHTTPTimeout
+	if n, ok := opts.RawGetString("timeout").(lua.LNumber); ok && n > 0 {
+		timeout = time.Duration(float64(n) * float64(time.Second))
+	}
+	ctx, cancel := context.WithTimeout(L.Context(), timeout)
+	defer cancel()
+
+	var body io.Reader
+	if b := opts.RawGetString("body"); b != lua.LNil {
+		body = strings.NewReader(lua.LVAsString(b))
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return luaFail(L, err)
+	}
+	if headers, ok := opts.RawGetString("headers").(*lua.LTable); ok {
+		headers.ForEach(func(k, v lua.LValue) {
+			req.Header.Set(lua.LVAsString(k), lua.LVAsString(v))
+		})
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			if !sr.hostAllowed(r.URL.Hostname()) {
+				return fmt.Errorf("redirect to host not allowed: %s", r.URL.Hostname())
+			}
+			if len(via) >= 5 {
+				return fmt.Errorf("too many redirects")
+			}
+			return nil
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return luaFail(L, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, luaHTTPMaxBody))
+	if err != nil {
+		return luaFail(L, err)
+	}
+
+	result := L.NewTable()
+	result.RawSetString("status", lua.LNumber(resp.StatusCode))
+	result.RawSetString("body", lua.LString(data))
+	headers := L.NewTable()
+	for k := range resp.Header {
+		headers.RawSetString(k, lua.LString(resp.Header.Get(k)))
+	}
+	result.RawSetString("headers", headers)
+	L.Push(result)
+	return 1
+}
+
+// luaToGo 把Lua值转换为Go值：连续整数键的表转为切片，其余表转为 map。
+// 表引用了自身（直接或间接）或嵌套超过 luaMaxDepth 层时返回错误
+func luaToGo(v lua.LValue) (any, error) {
+	return luaToGoAt(v, map[*lua.LTable]bool{}, 0)
+}
+
+// luaToGoAt 递归转换；path 记录当前路径上的表，同一个表在不同分支中出现不算循环
+func luaToGoAt(v lua.LValue, path map[*lua.LTable]bool, depth int) (any, error) {
+	switch v := v.(type) {
+	case lua.LBool:
+		return bool(v), nil
+	case lua.LNumber:
+		if f := float64(v); f == float64(int64(f)) {
+			return int64(f), nil
+		}
+		return float64(v), nil
+	case lua.LString:
+		return string(v), nil
+	case *lua.LTable:
+		if path[v] {
+			return nil, errors.New("table contains a reference to itself")
+		}
+		if depth >= luaMaxDepth {
+			return nil, fmt.Errorf("table nested deeper than %d levels", luaMaxDepth)
+		}
+		path[v] = true
+		defer delete(path, v)
+
+		if n := v.MaxN(); n > 0 && n == v.Len() {
+			arr := make([]any, 0, n)
+			for i := 1; i <= n; i++ {
+				item, err := luaToGoAt(v.RawGetInt(i), path, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, item)
+			}
+			return arr, nil
+		}
+		m := make(map[string]any)
+		var err error
+		v.ForEach(func(k, val lua.LValue) {
+			if err != nil {
+				return
+			}
+			m[lua.LVAsString(k)], err = luaToGoAt(val, path, depth+1)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+	return nil, nil
+}
+
+// goToLua 把Go值（JSON解码结果或数据库列值）转换为Lua值
+func goToLua(L *lua.LState, v any) lua.LValue {
+	switch v := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(v)
+	case float64:
+		return lua.LNumber(v)
+	case int64:
+		return lua.LNumber(v)
+	case string:
+		return lua.LString(v)
+	case []byte:
+		return lua.LString(v)
+	case time.Time:
+		return lua.LString(v.Format(time.RFC3339))
+	case []any:
+		t := L.NewTable()
+		for _, item := range v {
+			t.Append(goToLua(L, item))
+		}
+		return t
+	case map[string]any:
+		t := L.NewTable()
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			t.RawSetString(k, goToLua(L, v[k]))
+		}
+		return t
+	}
+	return lua.LString(fmt.Sprint(v))
+}
@@ -0,0 +1,563 @@
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/linkerlin/nanoclaw.go/skills"
+)
+
+// runLua 以给定能力执行一段脚本并返回其返回值
+func runLua(t *testing.T, registry *SkillRegistry, script string, caps ...string) (string, error) {
+	t.Helper()
+	registry.RegisterGroup("test", &Skill{
+		Name:         "inline",
+		Source:       SourceGroup,
+		LuaScript:    script,
+		Capabilities: caps,
+	})
+	return registry.Run(nil, "inline", SkillContext{GroupFolder: "test", ChatJID: "test@nanoclaw"})
+}
+
+func TestLuaStdlib_AlwaysAvailable(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	tests := []struct {
+		name   string
+		script string
+		want   string
+	}{
+		{"json roundtrip", `return json.encode(json.decode('{"a":[1,2,"x"],"b":true}'))`, `{"a":[1,2,"x"],"b":true}`},
+		{"json decode field", `return json.decode('{"n":{"m":42}}').n.m`, "42"},
+		{"json invalid", `local v, err = json.decode("{"); return tostring(v) .. ":" .. tostring(err ~= nil)`, "nil:true"},
+		{"time format", `return time.format(0, "2006-01-02 15:04", "UTC")`, "1970-01-01 00:00"},
+		{"time parse", `return time.parse("2024-03-01 09:00", "2006-01-02 15:04", "Asia/Shanghai")`, "1709254800"},
+		{"time parse error", `local v, err = time.parse("nope"); return tostring(v)`, "nil"},
+		{"time now", `return time.now() > 1700000000`, "true"},
+		{"uuid", `return #uuid()`, "36"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runLua(t, registry, tt.script)
+			if err != nil {
+				t.Fatalf("Run failed: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLuaStdlib_Restricted(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	// 不能访问文件系统或启动进程，时间函数仍可用
+	tests := []struct {
+		script string
+		want   string
+	}{
+		{`return tostring(io)`, "nil"},
+		{`return tostring(dofile) .. tostring(loadfile)`, "nilnil"},
+		{`return tostring(os.execute) .. tostring(os.remove) .. tostring(os.getenv) .. tostring(os.exit)`, "nilnilnilnil"},
+		{`return os.date("!%Y", 0) .. ":" .. tostring(os.time() > 0) .. ":" .. type(os.clock())`, "1970:true:number"},
+		{`return string.upper("ok") .. table.concat({1, 2}) .. math.floor(1.5)`, "OK121"},
+	}
+	for _, tt := range tests {
+		got, err := runLua(t, registry, tt.script)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.script, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s = %q, want %q", tt.script, got, tt.want)
+		}
+	}
+}
+
+func TestLuaStdlib_CapabilityGating(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	for _, mod := range []string{"db", "chat", "tasks", "kv", "http"} {
+		got, err := runLua(t, registry, fmt.Sprintf(`return type(%s)`, mod))
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if got != "nil" {
+			t.Errorf("%s available without capability", mod)
+		}
+
+		got, err = runLua(t, registry, fmt.Sprintf(`return type(%s)`, mod), mod)
+		if err != nil {
+			t.Fatalf("Run failed: %v", err)
+		}
+		if got != "table" {
+			t.Errorf("%s not available with capability", mod)
+		}
+	}
+}
+
+func TestLuaStdlib_DB(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	got, err := runLua(t, registry, `
+		db.exec("CREATE TABLE notes (id INTEGER, body TEXT)")
+		db:exec("INSERT INTO notes VALUES (?, ?)", 1, "it's quoted")
+		local rows = db.query("SELECT id, body FROM notes WHERE id = ?", 1)
+		return rows[1].id .. ":" .. rows[1].body`, CapDB)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got != "1:it's quoted" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestLuaStdlib_DBScopedToGroup(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	registry.SetDataDir(filepath.Join(t.TempDir(), "skilldata"))
+	defer registry.Close()
+	if err := db.SaveTask(&Task{ID: "t1", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "secret",
+		ScheduleType: "once", Status: TaskActive, CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := runLua(t, registry, `assert(not db.exec("CREATE TABLE notes (body TEXT)"))
+		assert(not db.exec("INSERT INTO notes VALUES ('mine')"))`, CapDB); err != nil {
+		t.Fatal(err)
+	}
+	registry.RegisterGroup("other", &Skill{Name: "peek", Source: SourceGroup, Capabilities: []string{CapDB},
+		LuaScript: `local rows, err = db.query("SELECT body FROM notes"); return tostring(err)`})
+	if got, err := registry.Run(nil, "peek", SkillContext{GroupFolder: "other"}); err != nil || !strings.Contains(got, "no such table") {
+		t.Errorf("other group read notes: %q, %v", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(registry.dataDir, "test.db")); err != nil {
+		t.Errorf("group db file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{"tasks", `local _, err = db.query("SELECT prompt FROM tasks"); return err`, "no such table"},
+		{"api tokens", `return db.exec("DELETE FROM api_tokens")`, "no such table"},
+		{"task runs", `return db.exec("DELETE FROM task_runs")`, "no such table"},
+		{"installed skills", `local _, err = db.query("SELECT * FROM installed_skills"); return err`, "no such table"},
+		{"attach", `return db.exec("ATTACH DATABASE 'nanoclaw.db' AS d")`, "not allowed"},
+		{"attach lowercase", `return db.exec("  attach 'x.db' as d")`, "not allowed"},
+		{"pragma", `local _, err = db.query("PRAGMA database_list"); return err`, "not allowed"},
+		{"vacuum into", `return db.exec("VACUUM INTO '/tmp/x.db'")`, "not allowed"},
+		{"second statement", `return db.exec("SELECT 1; ATTACH 'x.db' AS d")`, "one SQL statement"},
+		{"comment", `return db.exec("/* x */ DETACH main")`, "not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runLua(t, registry, tt.script, CapDB)
+			if err != nil || !strings.Contains(got, tt.wantErr) {
+				t.Errorf("got %q, %v, want error containing %q", got, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckSkillSQL(t *testing.T) {
+	for _, q := range []string{
+		"SELECT 1",
+		"select 1;",
+		"  -- note\n INSERT INTO t VALUES ('a;b', \"c;d\") ; -- trailing",
+		"WITH x AS (SELECT 1) SELECT * FROM x",
+		"CREATE TABLE IF NOT EXISTS [t;] (v TEXT)",
+		"UPDATE t SET v = 'it''s; fine' /* ; */",
+	} {
+		if err := checkSkillSQL(q); err != nil {
+			t.Errorf("%q: %v", q, err)
+		}
+	}
+	for _, q := range []string{
+		"", ";", "-- only a comment", "ATTACH 'x' AS y", "PRAGMA writable_schema = 1",
+		"DETACH y", "VACUUM", "SELECT 1; SELECT 2", "SELECT ';'; DROP TABLE t", "(SELECT 1)",
+	} {
+		if err := checkSkillSQL(q); err == nil {
+			t.Errorf("%q: accepted", q)
+		}
+	}
+}
+
+func TestLuaStdlib_CyclicTable(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	// 同一个表出现在两个分支里不是循环
+	got, err := runLua(t, registry, `local x = {1}; return json.encode({a = x, b = x})`)
+	if err != nil || got != `{"a":[1],"b":[1]}` {
+		t.Errorf("shared table = %q, %v", got, err)
+	}
+
+	tests := []struct {
+		name    string
+		script  string
+		wantErr string
+	}{
+		{"self", `local t = {}; t.self = t; return json.encode(t)`, "reference to itself"},
+		{"indirect", `local a, b = {}, {}; a[1] = b; b[1] = a; return json.encode(a)`, "reference to itself"},
+		{"deep", `local t = {}; for i = 1, 200 do t = {t} end; return json.encode(t)`, "nested deeper"},
+		{"db arg", `local t = {}; t.self = t; db.exec("SELECT ?", t); return "unreachable"`, "reference to itself"},
+		{"caught", `local t = {}; t[1] = t; local ok = pcall(json.encode, t); return tostring(ok)`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := runLua(t, registry, tt.script, CapDB)
+			if tt.wantErr == "" {
+				if err != nil || got != "false" {
+					t.Errorf("got %q, %v", got, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got %q, err %v, want error containing %q", got, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLuaStdlib_ChatSend(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	var sent []string
+	registry.SetChatSender(func(jid ChatJID, text string) error {
+		sent = append(sent, string(jid)+"|"+text)
+		return nil
+	})
+
+	for _, g := range []Group{
+		{JID: "test@nanoclaw", Name: "Test", Folder: "test"},
+		{JID: "team@nanoclaw", Name: "Team", Folder: "team"},
+	} {
+		if err := db.SaveGroup(&g); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := runLua(t, registry, `chat.send("hello"); chat.send("test@nanoclaw", "hi")`, CapChat); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	want := []string{"test@nanoclaw|hello", "test@nanoclaw|hi"}
+	if strings.Join(sent, ",") != strings.Join(want, ",") {
+		t.Errorf("sent = %v, want %v", sent, want)
+	}
+
+	// 其他群组或未注册的会话
+	for _, jid := range []string{"team@nanoclaw", "unknown@nanoclaw"} {
+		got, err := runLua(t, registry, `local ok, err = chat.send("`+jid+`", "psst"); return tostring(ok) .. ":" .. tostring(err)`, CapChat)
+		if err != nil || !strings.Contains(got, "does not belong to group test") {
+			t.Errorf("%s: got %q, %v", jid, got, err)
+		}
+	}
+	if len(sent) != 2 {
+		t.Errorf("sent = %v", sent)
+	}
+}
+
+func TestLuaStdlib_Tasks(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	id, err := runLua(t, registry, `return tasks.create("report", "interval", "1h")`, CapTasks)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	task, err := db.GetTask(id)
+	if err != nil {
+		t.Fatalf("GetTask failed: %v", err)
+	}
+	if task.GroupFolder != "test" || task.ChatJID != "test@nanoclaw" || task.NextRun == nil {
+		t.Errorf("unexpected task: %+v", task)
+	}
+
+	got, err := runLua(t, registry, `
+		local ok = tasks.pause("`+id+`")
+		local list = tasks.list()
+		return #list .. ":" .. list[1].status .. ":" .. tostring(ok)`, CapTasks)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got != "1:paused:true" {
+		t.Errorf("got %q", got)
+	}
+
+	got, err = runLua(t, registry, `local id, err = tasks.create("x", "cron", "bogus"); return tostring(id)`, CapTasks)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got != "nil" {
+		t.Errorf("invalid schedule accepted: %q", got)
+	}
+
+	// 其他群组的任务不可操作
+	other := &Task{ID: "other-task", GroupFolder: "other", ChatJID: "o@nanoclaw", Prompt: "p", ScheduleType: "once", Status: "active"}
+	if err := db.SaveTask(other); err != nil {
+		t.Fatal(err)
+	}
+	got, err = runLua(t, registry, `local ok = tasks.pause("other-task"); return tostring(ok)`, CapTasks)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got != "nil" {
+		t.Errorf("paused task of another group")
+	}
+}
+
+func TestLuaStdlib_KV(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	got, err := runLua(t, registry, `
+		local before = kv.get("counter")
+		kv.set("counter", 41)
+		kv.set("counter", tonumber(kv.get("counter")) + 1)
+		return tostring(before) .. ":" .. kv.get("counter")`, CapKV)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got != "nil:42" {
+		t.Errorf("got %q", got)
+	}
+
+	// 键值按群组隔离
+	if _, ok, _ := db.GetKV("other", "counter"); ok {
+		t.Error("kv leaked to another group")
+	}
+	if _, err := runLua(t, registry, `kv.set("counter", nil)`, CapKV); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := db.GetKV("test", "counter"); ok {
+		t.Error("kv.set(key, nil) did not delete")
+	}
+}
+
+func TestLuaStdlib_HTTP(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Echo", r.Header.Get("X-Token"))
+		fmt.Fprintf(w, "%s %s", r.Method, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	script := `
+		local resp, err = http.request{url = "` + srv.URL + `/ping", method = "post", headers = {["X-Token"] = "t"}, body = "x"}
+		if not resp then return "error: " .. err end
+		return resp.status .. " " .. resp.body .. " " .. resp.headers["X-Echo"]`
+
+	got, err := runLua(t, registry, script, CapHTTP)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.HasPrefix(got, "error: host not allowed") {
+		t.Errorf("request to non-allowlisted host: %q", got)
+	}
+
+	registry.SetHTTPAllowlist([]string{"127.0.0.1"})
+	got, err = runLua(t, registry, script, CapHTTP)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if got != "200 POST /ping t" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestHostAllowed(t *testing.T) {
+	registry := &SkillRegistry{httpAllow: []string{"api.example.com", ".trusted.org"}}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"api.example.com", true},
+		{"API.example.com", true},
+		{"evil.example.com", false},
+		{"a.trusted.org", true},
+		{"trusted.org", false},
+		{"nottrusted.org", false},
+	}
+	for _, tt := range tests {
+		if got := registry.hostAllowed(tt.host); got != tt.want {
+			t.Errorf("hostAllowed(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestBuiltinTaskSkill(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+	if err := registry.LoadBuiltin(skills.Builtin); err != nil {
+		t.Fatal(err)
+	}
+
+	sc := SkillContext{GroupFolder: "main", ChatJID: "main@nanoclaw", Argv: []string{"create", "daily report", "cron", "0 9 * * *"}}
+	got, err := registry.Run(nil, "task", sc)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.HasPrefix(got, "Task created: ") {
+		t.Fatalf("got %q", got)
+	}
+
+	sc.Argv = []string{"list"}
+	got, err = registry.Run(nil, "task", sc)
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if !strings.Contains(got, "[active] cron 0 9 * * *") || !strings.Contains(got, "daily report") {
+		t.Errorf("list = %q", got)
+	}
+
+	sc.Argv = []string{"create", "--silent", "backup", "interval", "1h"}
+	if _, err := registry.Run(nil, "task", sc); err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	sc.Argv = []string{"list"}
+	got, _ = registry.Run(nil, "task", sc)
+	if !strings.Contains(got, "[active,silent] interval 1h") {
+		t.Errorf("list = %q", got)
+	}
+
+	woken := 0
+	registry.SetTaskNotifier(func() { woken++ })
+	sc.Argv = []string{"create", "--tz", "Asia/Shanghai", "standup", "cron", "0 9 * * 1-5"}
+	if got, err := registry.Run(nil, "task", sc); err != nil || !strings.HasPrefix(got, "Task created: ") {
+		t.Fatalf("Run = %q, %v", got, err)
+	}
+	if woken != 1 {
+		t.Errorf("notifier called %d times, want 1", woken)
+	}
+	sc.Argv = []string{"list"}
+	got, _ = registry.Run(nil, "task", sc)
+	if !strings.Contains(got, "0 9 * * 1-5 (Asia/Shanghai) next=") || !strings.Contains(got, "T09:00:00+08:00") {
+		t.Errorf("list = %q", got)
+	}
+
+	sc.Argv = []string{"create", "--retries", "2", "--retry-delay", "30s", "--timeout", "5m", "--misfire", "skip", "digest", "interval", "1h"}
+	got, err = registry.Run(nil, "task", sc)
+	if err != nil || !strings.HasPrefix(got, "Task created: ") {
+		t.Fatalf("Run = %q, %v", got, err)
+	}
+	task, err := db.GetTask(strings.TrimPrefix(got, "Task created: "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.RetryLimit != 2 || task.RetryDelay != 30*time.Second || task.Timeout != 5*time.Minute || task.Misfire != MisfireSkip {
+		t.Errorf("policy = %d %v %v %q", task.RetryLimit, task.RetryDelay, task.Timeout, task.Misfire)
+	}
+	sc.Argv = []string{"create", "--misfire", "later", "bad", "interval", "1h"}
+	if got, _ := registry.Run(nil, "task", sc); !strings.Contains(got, "invalid misfire policy") {
+		t.Errorf("create with bad misfire = %q", got)
+	}
+
+	sc.Argv = []string{"create", "--tz", "Nowhere/City", "bad", "cron", "0 9 * * *"}
+	if got, _ := registry.Run(nil, "task", sc); !strings.Contains(got, "invalid timezone") {
+		t.Errorf("create with bad tz = %q", got)
+	}
+}
+
+func TestBuiltinTaskSkill_Manage(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+	if err := registry.LoadBuiltin(skills.Builtin); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	registry.now = func() time.Time { return now }
+
+	run := func(folder string, argv ...string) string {
+		t.Helper()
+		got, err := registry.Run(nil, "task", SkillContext{GroupFolder: folder, ChatJID: "main@nanoclaw", Argv: argv})
+		if err != nil {
+			t.Fatalf("%v: %v", argv, err)
+		}
+		return got
+	}
+	id := strings.TrimPrefix(run("main", "create", "daily report", "interval", "1h"), "Task created: ")
+
+	if got := run("main", "show", id); !strings.Contains(got, "status:   active") || !strings.Contains(got, "prompt:   daily report") {
+		t.Errorf("show = %q", got)
+	}
+	if got := run("other", "show", id); !strings.Contains(got, "task not found") {
+		t.Errorf("show from other group = %q", got)
+	}
+
+	got := run("main", "edit", id, "--prompt", "weekly report", "--schedule", "interval", "2h", "--silent")
+	if got != "Task "+id+" updated" {
+		t.Fatalf("edit = %q", got)
+	}
+	task, _ := db.GetTask(id)
+	if task.Prompt != "weekly report" || task.ScheduleValue != "2h" || !task.Silent || !task.NextRun.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("edited task = %+v", task)
+	}
+	if got := run("main", "edit", id, "--schedule", "cron", "bad"); !strings.HasPrefix(got, "Failed: ") {
+		t.Errorf("edit with bad schedule = %q", got)
+	}
+
+	if got := run("main", "run", id); got != "Task "+id+" triggered" {
+		t.Errorf("run = %q", got)
+	}
+	if task, _ := db.GetTask(id); !task.NextRun.Equal(now) {
+		t.Errorf("NextRun after run = %v", task.NextRun)
+	}
+	run("main", "pause", id)
+	if got := run("main", "list", "paused"); !strings.Contains(got, id) {
+		t.Errorf("list paused = %q", got)
+	}
+	if got := run("main", "list", "active"); got != "No tasks" {
+		t.Errorf("list active = %q", got)
+	}
+	if got := run("main", "run", id); !strings.Contains(got, "is paused") {
+		t.Errorf("run paused = %q", got)
+	}
+
+	if got := run("main", "delete", id); got != "Task "+id+" deleted" {
+		t.Errorf("delete = %q", got)
+	}
+	if got := run("main", "list"); got != "No tasks" {
+		t.Errorf("list after delete = %q", got)
+	}
+	if got := run("main", "delete"); got != "Usage: /task delete <id>" {
+		t.Errorf("delete without id = %q", got)
+	}
+
+	got = run("main", "create", "--debounce", "30s", "--rate-limit", "5", "answer", "event", "keyword:help")
+	task, err := db.GetTask(strings.TrimPrefix(got, "Task created: "))
+	if err != nil {
+		t.Fatalf("create event task = %q", got)
+	}
+	if task.ScheduleType != "event" || task.Debounce != 30*time.Second || task.RateLimit != 5 || task.NextRun != nil {
+		t.Errorf("event task = %+v", task)
+	}
+	if got := run("main", "create", "answer", "event", "/(/"); !strings.Contains(got, "invalid event") {
+		t.Errorf("create with bad pattern = %q", got)
+	}
+}
@@ -1,10 +1,13 @@
 package internal
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/linkerlin/nanoclaw.go/skills"
 )
 
 func TestSkillRegistryXSkip_RegisterAndGet(t *testing.T) {
@@ -168,11 +171,11 @@ func TestSkillRegistryXSkipFail_LuaBindings(t *testing.T) {
 		t.Errorf("uuid function failed: %v", err)
 	}
 	
-	// 测试db:exec函数（创建表）
+	// 共享状态不属于任何群组，db:exec 返回错误
 	err = registry.L.DoString(`
 		local err = db:exec("CREATE TABLE IF NOT EXISTS test_lua (id TEXT PRIMARY KEY)")
-		if err then
-			log("Error: " .. err)
+		if not err then
+			error("db:exec without a group folder succeeded")
 		end
 	`)
 	if err != nil {
@@ -223,3 +226,263 @@ func TestSkillRegistryXSkip_NotFound(t *testing.T) {
 		t.Error("Expected error for non-existent skill")
 	}
 }
+
+func writeSkill(t *testing.T, dir, name, skillMD, script string) {
+	t.Helper()
+	skillDir := filepath.Join(dir, name)
+	if err := os.MkdirAll(skillDir, 0755); err != nil {
+		t.Fatalf("Failed to create skill dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(skillDir, "SKILL.md"), []byte(skillMD), 0644); err != nil {
+		t.Fatalf("Failed to write SKILL.md: %v", err)
+	}
+	if script != "" {
+		if err := os.WriteFile(filepath.Join(skillDir, "script.lua"), []byte(script), 0644); err != nil {
+			t.Fatalf("Failed to write script.lua: %v", err)
+		}
+	}
+}
+
+func TestSkillRegistry_LoadBuiltin(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	if err := registry.LoadBuiltin(skills.Builtin); err != nil {
+		t.Fatalf("LoadBuiltin failed: %v", err)
+	}
+
+	for _, name := range []string{"task", "group"} {
+		got, ok := registry.Get(name)
+		if !ok {
+			t.Fatalf("Expected builtin skill %q", name)
+		}
+		if got.Source != SourceBuiltin {
+			t.Errorf("%s Source = %v, want builtin", name, got.Source)
+		}
+		if got.LuaScript == "" {
+			t.Errorf("%s LuaScript is empty", name)
+		}
+	}
+
+	task, _ := registry.Get("task")
+	if task.Description != "Task management skill" {
+		t.Errorf("Description = %q, want %q", task.Description, "Task management skill")
+	}
+}
+
+func TestSkillRegistry_LoadAll_Precedence(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	globalDir := filepath.Join(t.TempDir(), "skills")
+	groupsDir := filepath.Join(t.TempDir(), "groups")
+
+	// 全局技能覆盖内置同名技能
+	writeSkill(t, globalDir, "task", "# Global task\n", "")
+	writeSkill(t, globalDir, "weather", "# Global weather\n", "")
+	// 群组技能只覆盖本群组
+	writeSkill(t, filepath.Join(groupsDir, "team", "skills"), "weather", "# Team weather\n", "")
+	writeSkill(t, filepath.Join(groupsDir, "team", "skills"), "standup", "# Team standup\n", "")
+	if err := os.MkdirAll(filepath.Join(groupsDir, "main"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := registry.LoadAll(skills.Builtin, globalDir, groupsDir); err != nil {
+		t.Fatalf("LoadAll failed: %v", err)
+	}
+
+	tests := []struct {
+		folder, name string
+		wantDesc     string
+		wantSource   SkillSource
+		wantOK       bool
+	}{
+		{"main", "task", "Global task", SourceGlobal, true},
+		{"main", "group", "Group management skill", SourceBuiltin, true},
+		{"main", "weather", "Global weather", SourceGlobal, true},
+		{"main", "standup", "", 0, false},
+		{"team", "weather", "Team weather", SourceGroup, true},
+		{"team", "standup", "Team standup", SourceGroup, true},
+		{"team", "task", "Global task", SourceGlobal, true},
+	}
+	for _, tt := range tests {
+		got, ok := registry.Lookup(tt.folder, tt.name)
+		if ok != tt.wantOK {
+			t.Errorf("Lookup(%q, %q) ok = %v, want %v", tt.folder, tt.name, ok, tt.wantOK)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if got.Description != tt.wantDesc || got.Source != tt.wantSource {
+			t.Errorf("Lookup(%q, %q) = (%q, %v), want (%q, %v)", tt.folder, tt.name, got.Description, got.Source, tt.wantDesc, tt.wantSource)
+		}
+	}
+
+	if n := len(registry.Skills("main")); n != 3 {
+		t.Errorf("main effective skills = %d, want 3", n)
+	}
+	if n := len(registry.Skills("team")); n != 4 {
+		t.Errorf("team effective skills = %d, want 4", n)
+	}
+
+	// 内置技能重新加载不得覆盖全局技能
+	if err := registry.LoadBuiltin(skills.Builtin); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := registry.Get("task"); got.Source != SourceGlobal {
+		t.Errorf("builtin reload overrode global skill")
+	}
+}
+
+func TestSkillRegistry_Frontmatter(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	dir := t.TempDir()
+	writeSkill(t, dir, "dir-name", `---
+name: report
+description: "Daily report"
+version: 1.2.0
+---
+# Report
+
+Body.
+`, "")
+
+	if err := registry.LoadFromDir(dir); err != nil {
+		t.Fatalf("LoadFromDir failed: %v", err)
+	}
+	got, ok := registry.Get("report")
+	if !ok {
+		t.Fatal("Expected skill named by frontmatter")
+	}
+	if got.Description != "Daily report" || got.Version != "1.2.0" {
+		t.Errorf("got (%q, %q), want (%q, %q)", got.Description, got.Version, "Daily report", "1.2.0")
+	}
+}
+
+func TestSkillRegistry_ExecuteGroupSkillWithArgs(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	registry.RegisterGroup("team", &Skill{
+		Name:         "mark",
+		Source:       SourceGroup,
+		Capabilities: []string{CapDB},
+		LuaScript: `db.exec("CREATE TABLE IF NOT EXISTS marks (v TEXT)")
+			db:exec("INSERT INTO marks (v) VALUES ('" .. GROUP_FOLDER .. ":" .. arg[1] .. "')")`,
+	})
+
+	if err := registry.Execute(nil, "mark", SkillContext{GroupFolder: "main"}); err == nil {
+		t.Error("Expected group skill to be invisible to other groups")
+	}
+	if err := registry.Execute(nil, "mark", SkillContext{GroupFolder: "team", Argv: []string{"x"}}); err != nil {
+		t.Fatalf("Execute failed: %v", err)
+	}
+
+	// 技能的表在群组数据库中，而不是守护进程的数据库
+	if _, err := db.Exec(`SELECT v FROM marks`); err == nil {
+		t.Error("skill table created in the daemon db")
+	}
+	gdb, err := registry.groupDB("team", `SELECT v FROM marks`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := gdb.QueryRow(`SELECT v FROM marks`).Scan(&v); err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	if v != "team:x" {
+		t.Errorf("v = %q, want %q", v, "team:x")
+	}
+}
+
+func TestSkillRegistry_Reload_KeepsPreviousOnError(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	globalDir := t.TempDir()
+	groupsDir := t.TempDir()
+	writeSkill(t, globalDir, "greet", "# Greet v1\n", `log("v1")`)
+	writeSkill(t, globalDir, "bye", "# Bye\n", "")
+	if err := registry.Reload(globalDir, groupsDir); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+
+	// 语法错误：保留旧版本并报告错误；被删除的技能消失
+	writeSkill(t, globalDir, "greet", "# Greet v2\n", `log("v2"`)
+	if err := os.RemoveAll(filepath.Join(globalDir, "bye")); err != nil {
+		t.Fatal(err)
+	}
+	err := registry.Reload(globalDir, groupsDir)
+	if err == nil {
+		t.Fatal("Expected parse error from Reload")
+	}
+	var se *SkillError
+	if !errors.As(err, &se) || se.Path != filepath.Join(globalDir, "greet") {
+		t.Errorf("error = %v, want SkillError for greet", err)
+	}
+	if got, ok := registry.Get("greet"); !ok || got.Description != "Greet v1" {
+		t.Errorf("greet = %+v, want previous version kept", got)
+	}
+	if _, ok := registry.Get("bye"); ok {
+		t.Error("Expected removed skill to be unloaded")
+	}
+
+	// 修复后生效
+	writeSkill(t, globalDir, "greet", "# Greet v3\n", `log("v3")`)
+	if err := registry.Reload(globalDir, groupsDir); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if got, _ := registry.Get("greet"); got.Description != "Greet v3" {
+		t.Errorf("Description = %q, want %q", got.Description, "Greet v3")
+	}
+}
+
+func TestSkillRegistry_Reload_DuringExecution(t *testing.T) {
+	db := TestTempDB(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	globalDir := t.TempDir()
+	writeSkill(t, globalDir, "slow", "---\ncapabilities: db\n---\n# Slow\n", `
+		db.exec("CREATE TABLE IF NOT EXISTS runs (v TEXT)")
+		local t0 = os.clock()
+		while os.clock() - t0 < 0.2 do end
+		db.exec("INSERT INTO runs (v) VALUES ('v1')")`)
+	if err := registry.Reload(globalDir, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- registry.Execute(nil, "slow", SkillContext{GroupFolder: "main"})
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	writeSkill(t, globalDir, "slow", "---\ncapabilities: db\n---\n# Slow v2\n", `db.exec("INSERT INTO runs (v) VALUES ('v2')")`)
+	if err := registry.Reload(globalDir, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("in-flight Execute failed: %v", err)
+	}
+
+	gdb, err := registry.groupDB("main", `SELECT v FROM runs`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v string
+	if err := gdb.QueryRow(`SELECT v FROM runs`).Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v != "v1" {
+		t.Errorf("in-flight run used %q, want v1", v)
+	}
+}
@@ -0,0 +1,306 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 技能测试工具
+//
+// 测试用例放在技能目录的 tests/ 下：<case>.json 描述输入，<case>.golden 为期望的记录。
+// 每个用例使用一次性数据库、假聊天通道和脚本化LLM，时间和ID都是确定的，
+// 因此记录可以逐字比较。
+//
+// <case>.json 字段：
+//   group, chat_jid  执行上下文（默认 main / main@nanoclaw）
+//   args             位置参数
+//   now              固定时间（RFC3339，默认 2024-01-01T00:00:00Z）
+//   setup            执行前在守护进程数据库中运行的SQL（如预置任务；技能 db 模块使用独立的群组数据库）
+//   kv               预置的群组键值
+//   llm              agent.ask 依次返回的回复
+
+// SkillTestCase 技能测试用例
+type SkillTestCase struct {
+	Name    string            `json:"-"`
+	Group   string            `json:"group"`
+	ChatJID ChatJID           `json:"chat_jid"`
+	Args    []string          `json:"args"`
+	Now     string            `json:"now"`
+	Setup   []string          `json:"setup"`
+	KV      map[string]string `json:"kv"`
+	LLM     []string          `json:"llm"`
+}
+
+// SkillTestResult 单个用例的结果
+type SkillTestResult struct {
+	Name    string
+	Passed  bool
+	Updated bool   // update 模式下写入了 golden 文件
+	Diff    string // 期望与实际记录的差异
+	Err     error  // 用例无法执行（配置错误等）
+}
+
+// ScriptedRunner 按顺序返回预设回复的假LLM，并记录收到的提示
+type ScriptedRunner struct {
+	Replies []string
+	Prompts []string
+}
+
+// Run 实现 Runner
+func (r *ScriptedRunner) Run(ctx context.Context, groupFolder string, messages []Message) (string, error) {
+	var prompt string
+	if len(messages) > 0 {
+		prompt = messages[len(messages)-1].Content
+	}
+	r.Prompts = append(r.Prompts, prompt)
+	if len(r.Prompts) > len(r.Replies) {
+		return "", fmt.Errorf("scripted llm: no reply for call %d", len(r.Prompts))
+	}
+	return r.Replies[len(r.Prompts)-1], nil
+}
+
+// RunSkillTests 运行技能目录下的所有测试用例；update 为 true 时用实际记录覆盖 golden 文件
+func RunSkillTests(skillDir string, update bool) ([]SkillTestResult, error) {
+	skillDir = filepath.Clean(skillDir)
+	skill, err := parseSkillDir(os.DirFS(filepath.Dir(skillDir)), filepath.Base(skillDir))
+	if err != nil {
+		return nil, fmt.Errorf("load skill: %w", err)
+	}
+	skill.Source = SourceGlobal
+	skill.Path = skillDir
+
+	cases, err := filepath.Glob(filepath.Join(skillDir, "tests", "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("no test cases in %s", filepath.Join(skillDir, "tests"))
+	}
+	sort.Strings(cases)
+
+	var results []SkillTestResult
+	for _, file := range cases {
+		results = append(results, runSkillTestCase(skill, file, update))
+	}
+	return results, nil
+}
+
+func runSkillTestCase(skill *Skill, file string, update bool) SkillTestResult {
+	name := strings.TrimSuffix(filepath.Base(file), ".json")
+	result := SkillTestResult{Name: name}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	tc := SkillTestCase{Name: name, Group: "main", ChatJID: "main@nanoclaw", Now: "2024-01-01T00:00:00Z"}
+	if err := json.Unmarshal(data, &tc); err != nil {
+		result.Err = fmt.Errorf("parse %s: %w", file, err)
+		return result
+	}
+
+	transcript, err := RunSkillTestCase(skill, tc)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	goldenPath := strings.TrimSuffix(file, ".json") + ".golden"
+	if update {
+		if err := os.WriteFile(goldenPath, []byte(transcript), 0644); err != nil {
+			result.Err = err
+			return result
+		}
+		result.Passed, result.Updated = true, true
+		return result
+	}
+
+	golden, err := os.ReadFile(goldenPath)
+	if err != nil {
+		result.Err = fmt.Errorf("read golden (run with --update to create): %w", err)
+		return result
+	}
+	if string(golden) == transcript {
+		result.Passed = true
+		return result
+	}
+	result.Diff = lineDiff(string(golden), transcript)
+	return result
+}
+
+// RunSkillTestCase 在隔离环境中执行技能并返回记录
+func RunSkillTestCase(skill *Skill, tc SkillTestCase) (string, error) {
+	now, err := time.Parse(time.RFC3339, tc.Now)
+	if err != nil {
+		return "", fmt.Errorf("invalid now: %w", err)
+	}
+
+	tmp, err := os.MkdirTemp("", "nanoclaw-skilltest-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	db, err := OpenTempDB(tmp)
+	if err != nil {
+		return "", err
+	}
+	defer db.Close()
+
+	for _, stmt := range tc.Setup {
+		if _, err := db.Exec(stmt); err != nil {
+			return "", fmt.Errorf("setup %q: %w", stmt, err)
+		}
+	}
+	keys := sortedKeys(tc.KV)
+	for _, k := range keys {
+		if err := db.SetKV(tc.Group, k, tc.KV[k]); err != nil {
+			return "", err
+		}
+	}
+
+	// chat.send 只能发往注册到技能所在群组的会话
+	if tc.ChatJID != "" {
+		if err := db.SaveGroup(&Group{JID: tc.ChatJID, Name: tc.Group, Folder: tc.Group, AddedAt: now}); err != nil {
+			return "", err
+		}
+	}
+
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+	registry.now = func() time.Time { return now }
+	seq := 0
+	registry.newID = func() string {
+		seq++
+		return fmt.Sprintf("id-%d", seq)
+	}
+	var chat []string
+	registry.SetChatSender(func(jid ChatJID, text string) error {
+		chat = append(chat, fmt.Sprintf("[%s] %s", jid, text))
+		return nil
+	})
+	llm := &ScriptedRunner{Replies: tc.LLM}
+	registry.SetAgent(llm)
+	registry.RegisterGroup(tc.Group, skill)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	output, runErr := registry.Run(ctx, skill.Name, SkillContext{
+		GroupFolder: tc.Group,
+		ChatJID:     tc.ChatJID,
+		Argv:        tc.Args,
+	})
+
+	var sb strings.Builder
+	section := func(title string, lines []string) {
+		fmt.Fprintf(&sb, "== %s\n", title)
+		for _, l := range lines {
+			sb.WriteString(l)
+			sb.WriteString("\n")
+		}
+	}
+	section("output", nonEmpty(output))
+	if runErr != nil {
+		section("error", []string{runErr.Error()})
+	}
+	section("chat", chat)
+
+	var llmLines []string
+	for i, p := range llm.Prompts {
+		llmLines = append(llmLines, "> "+p)
+		if i < len(llm.Replies) {
+			llmLines = append(llmLines, "< "+llm.Replies[i])
+		}
+	}
+	section("llm", llmLines)
+
+	tasks, err := db.ListTasks(tc.Group)
+	if err != nil {
+		return "", err
+	}
+	var taskLines []string
+	for _, t := range tasks {
+		next := "-"
+		if t.NextRun != nil {
+			next = t.NextRun.UTC().Format(time.RFC3339)
+		}
+		taskLines = append(taskLines, fmt.Sprintf("%s [%s] %s %q next=%s: %s", t.ID, t.Status, t.ScheduleType, t.ScheduleValue, next, t.Prompt))
+	}
+	section("tasks", taskLines)
+
+	rows, err := db.Query(`SELECT key, value FROM kv WHERE group_folder = ? ORDER BY key`, tc.Group)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	var kvLines []string
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return "", err
+		}
+		kvLines = append(kvLines, k+"="+v)
+	}
+	section("kv", kvLines)
+	return sb.String(), rows.Err()
+}
+
+func nonEmpty(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// lineDiff 基于最长公共子序列的逐行差异，"-" 为期望，"+" 为实际
+func lineDiff(want, got string) string {
+	a := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	b := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var sb strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			sb.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			sb.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			sb.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const greeterScript = `
+local city = kv.get("city") or "nowhere"
+local reply = agent.ask("weather in " .. city)
+chat.send(reply)
+tasks.create("check " .. city, "interval", "1h")
+kv.set("last", time.format(time.now()))
+return "hello " .. (arg[1] or "?") .. " " .. uuid()
+`
+
+func TestRunSkillTests(t *testing.T) {
+	dir := t.TempDir()
+	writeSkill(t, dir, "greeter", "---\nname: greeter\ncapabilities: chat, tasks, kv, agent\n---\n", greeterScript)
+	skillDir := filepath.Join(dir, "greeter")
+	testsDir := filepath.Join(skillDir, "tests")
+	if err := os.MkdirAll(testsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	caseJSON := `{"args": ["bob"], "kv": {"city": "Paris"}, "llm": ["sunny"]}`
+	if err := os.WriteFile(filepath.Join(testsDir, "basic.json"), []byte(caseJSON), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有 golden 时报错
+	results, err := RunSkillTests(skillDir, false)
+	if err != nil {
+		t.Fatalf("RunSkillTests: %v", err)
+	}
+	if len(results) != 1 || results[0].Err == nil {
+		t.Fatalf("expected missing golden error, got %+v", results)
+	}
+
+	// update 生成 golden
+	results, err = RunSkillTests(skillDir, true)
+	if err != nil || !results[0].Updated {
+		t.Fatalf("update failed: %v %+v", err, results)
+	}
+	golden, err := os.ReadFile(filepath.Join(testsDir, "basic.golden"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `== output
+hello bob id-2
+== chat
+[main@nanoclaw] sunny
+== llm
+> weather in Paris
+< sunny
+== tasks
+id-1 [active] interval "1h" next=2024-01-01T01:00:00Z: check Paris
+== kv
+city=Paris
+last=2024-01-01T00:00:00Z
+`
+	if string(golden) != want {
+		t.Fatalf("golden mismatch:\n%s", lineDiff(want, string(golden)))
+	}
+
+	// 再次运行应当通过（确定性）
+	results, err = RunSkillTests(skillDir, false)
+	if err != nil || !results[0].Passed {
+		t.Fatalf("expected pass, got %v %+v", err, results)
+	}
+
+	// 修改 golden 后报告差异
+	if err := os.WriteFile(filepath.Join(testsDir, "basic.golden"), []byte(strings.Replace(want, "sunny", "rainy", 1)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	results, _ = RunSkillTests(skillDir, false)
+	if results[0].Passed || !strings.Contains(results[0].Diff, "- [main@nanoclaw] rainy") || !strings.Contains(results[0].Diff, "+ [main@nanoclaw] sunny") {
+		t.Fatalf("expected diff, got %+v", results[0])
+	}
+}
+
+func TestRunSkillTestCase_ScriptedLLMExhausted(t *testing.T) {
+	skill := &Skill{Name: "asker", Source: SourceGlobal, Capabilities: []string{CapAgent},
+		LuaScript: `local r, err = agent.ask("hi") return err`}
+	transcript, err := RunSkillTestCase(skill, SkillTestCase{Group: "main", ChatJID: "main@nanoclaw", Now: "2024-01-01T00:00:00Z"})
+	if err != nil {
+		t.Fatalf("RunSkillTestCase: %v", err)
+	}
+	if !strings.Contains(transcript, "no reply for call 1") {
+		t.Errorf("expected exhausted error in transcript:\n%s", transcript)
+	}
+}
+
+func TestRunSkillTestCase_CapabilitiesEnforced(t *testing.T) {
+	skill := &Skill{Name: "sneaky", Source: SourceGlobal, LuaScript: `chat.send("x")`}
+	transcript, err := RunSkillTestCase(skill, SkillTestCase{Group: "main", ChatJID: "main@nanoclaw", Now: "2024-01-01T00:00:00Z"})
+	if err != nil {
+		t.Fatalf("RunSkillTestCase: %v", err)
+	}
+	if !strings.Contains(transcript, "== error") {
+		t.Errorf("expected capability error:\n%s", transcript)
+	}
+}
+
+func TestLineDiff(t *testing.T) {
+	got := lineDiff("a\nb\nc\n", "a\nx\nc\n")
+	want := "  a\n- b\n+ x\n  c\n"
+	if got != want {
+		t.Errorf("lineDiff = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,200 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	proposalTTL = 30 * time.Minute // 待确认的任务保留时间
+	previewRuns = 3                // 预览中列出的执行次数
+)
+
+// TaskTools 供Agent创建定时任务的工具
+//
+// schedule_task 只解析调度并返回接下来的执行时间，任务暂存为该会话的待确认任务；
+// 用户在之后的消息中同意后，模型调用 confirm_task 才真正保存。每个会话最多一个待确认任务。
+type TaskTools struct {
+	db *DB
+
+	mu      sync.Mutex
+	pending map[ChatJID]*taskProposal
+
+	onTasks func()
+	now     func() time.Time
+	newID   func() string
+}
+
+type taskProposal struct {
+	task     Task
+	created  time.Time
+	lastUser MessageID // 提出任务时最新的用户消息
+}
+
+// NewTaskTools 创建任务工具
+func NewTaskTools(db *DB) *TaskTools {
+	return &TaskTools{
+		db:      db,
+		pending: make(map[ChatJID]*taskProposal),
+		now:     time.Now,
+		newID:   func() string { return uuid.New().String() },
+	}
+}
+
+// SetTaskNotifier 设置任务保存后的通知（唤醒调度器）
+func (tt *TaskTools) SetTaskNotifier(fn func()) {
+	tt.onTasks = fn
+}
+
+// Tools 返回注册到Agent的工具
+func (tt *TaskTools) Tools() []AgentTool {
+	return []AgentTool{
+		{
+			Name: "schedule_task",
+			Description: "Propose a scheduled task for this chat. The task is NOT saved: the result lists the next run times, " +
+				"which you must show to the user and ask them to confirm. Give either `when` (a natural-language time such as " +
+				"\"every weekday at 9am\", \"in 2 hours\", \"每天早上9点\", \"明天下午3点\") or `schedule_type` and `schedule_value`.",
+			Parameters: map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"prompt":         map[string]any{"type": "string", "description": "What to do when the task runs, written as an instruction to yourself"},
+					"when":           map[string]any{"type": "string", "description": "Natural-language schedule in English or Chinese"},
+					"schedule_type":  map[string]any{"type": "string", "enum": []string{"cron", "interval", "once"}},
+					"schedule_value": map[string]any{"type": "string", "description": "cron expression, Go duration such as 30m, or RFC3339 time"},
+					"timezone":       map[string]any{"type": "string", "description": "IANA timezone, e.g. Asia/Shanghai; defaults to the server timezone"},
+					"silent":         map[string]any{"type": "boolean", "description": "Do not post results to the chat"},
+				},
+				"required": []string{"prompt"},
+			},
+			Call: tt.propose,
+		},
+		{
+			Name:        "confirm_task",
+			Description: "Save the task proposed by schedule_task in this chat. Only call this after the user has seen the next run times and agreed.",
+			Parameters:  map[string]any{"type": "object", "properties": map[string]any{}},
+			Call:        tt.confirm,
+		},
+	}
+}
+
+type scheduleArgs struct {
+	Prompt        string `json:"prompt"`
+	When          string `json:"when"`
+	ScheduleType  string `json:"schedule_type"`
+	ScheduleValue string `json:"schedule_value"`
+	Timezone      string `json:"timezone"`
+	Silent        bool   `json:"silent"`
+}
+
+func (tt *TaskTools) propose(_ context.Context, tc ToolContext, raw string) (string, error) {
+	var args scheduleArgs
+	if err := json.Unmarshal([]byte(raw), &args); err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(args.Prompt) == "" {
+		return "", errors.New("prompt is required")
+	}
+	loc, err := LoadTimezone(args.Timezone)
+	if err != nil {
+		return "", err
+	}
+	now := tt.now().In(loc)
+
+	sched := Schedule{Type: args.ScheduleType, Value: args.ScheduleValue}
+	if args.When != "" {
+		if sched, err = ParseSchedule(args.When, now); err != nil {
+			return "", fmt.Errorf("%w; pass schedule_type and schedule_value instead", err)
+		}
+	} else if sched.Type == "" {
+		return "", errors.New("either when or schedule_type is required")
+	}
+
+	task := Task{
+		ID:            tt.newID(),
+		GroupFolder:   tc.GroupFolder,
+		ChatJID:       tc.ChatJID,
+		Prompt:        args.Prompt,
+		ScheduleType:  sched.Type,
+		ScheduleValue: sched.Value,
+		Timezone:      args.Timezone,
+		Silent:        args.Silent,
+		Status:        TaskActive,
+	}
+	runs, err := task.UpcomingRuns(now, previewRuns)
+	if err != nil {
+		return "", err
+	}
+	if len(runs) == 0 {
+		return "", fmt.Errorf("schedule type %q has no run times; use cron, interval or once", task.ScheduleType)
+	}
+	if task.ScheduleType == "once" && runs[0].Before(now) {
+		return "", fmt.Errorf("%s is in the past", runs[0].In(loc).Format(time.RFC3339))
+	}
+
+	tt.mu.Lock()
+	tt.pending[tc.ChatJID] = &taskProposal{task: task, created: tt.now(), lastUser: lastUserMessage(tc.Messages)}
+	tt.mu.Unlock()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Proposed task (not saved yet)\nprompt: %s\nschedule: %s %s", task.Prompt, task.ScheduleType, task.ScheduleValue)
+	if task.Timezone != "" {
+		fmt.Fprintf(&sb, " (%s)", task.Timezone)
+	}
+	sb.WriteString("\nnext runs:")
+	for _, r := range runs {
+		fmt.Fprintf(&sb, "\n- %s", r.In(loc).Format("Mon 2006-01-02 15:04 MST"))
+	}
+	sb.WriteString("\nShow these run times to the user and ask them to confirm; call confirm_task after they agree.")
+	return sb.String(), nil
+}
+
+func (tt *TaskTools) confirm(_ context.Context, tc ToolContext, _ string) (string, error) {
+	tt.mu.Lock()
+	p := tt.pending[tc.ChatJID]
+	tt.mu.Unlock()
+	if p == nil || tt.now().Sub(p.created) > proposalTTL {
+		return "", errors.New("no task is waiting for confirmation in this chat; call schedule_task first")
+	}
+	// 用户必须在看到预览之后发过消息，防止模型在同一轮中自行确认
+	if lastUserMessage(tc.Messages) == p.lastUser {
+		return "", errors.New("the user has not confirmed yet; show them the next run times and wait for their reply")
+	}
+
+	task := p.task
+	task.CreatedAt = tt.now()
+	task.NextRun = nil
+	if err := task.Reschedule(task.CreatedAt); err != nil {
+		return "", err
+	}
+	if err := tt.db.SaveTask(&task); err != nil {
+		return "", err
+	}
+	tt.mu.Lock()
+	if tt.pending[tc.ChatJID] == p {
+		delete(tt.pending, tc.ChatJID)
+	}
+	tt.mu.Unlock()
+	if tt.onTasks != nil {
+		tt.onTasks()
+	}
+	if task.NextRun == nil {
+		return fmt.Sprintf("Task %s saved", task.ID), nil
+	}
+	return fmt.Sprintf("Task %s saved; next run %s", task.ID, task.NextRun.Format(time.RFC3339)), nil
+}
+
+func lastUserMessage(messages []Message) MessageID {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if !messages[i].IsBotMessage {
+			return messages[i].ID
+		}
+	}
+	return ""
+}
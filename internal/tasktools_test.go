@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestTaskTools_ProposeConfirm(t *testing.T) {
+	db := TestTempDB(t)
+	tools := NewTaskTools(db)
+	now := time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC)
+	tools.now = func() time.Time { return now }
+	tools.newID = func() string { return "task-1" }
+	notified := 0
+	tools.SetTaskNotifier(func() { notified++ })
+	propose, confirm := tools.Tools()[0].Call, tools.Tools()[1].Call
+
+	ask := Message{ID: "m1", ChatJID: "main@nanoclaw", Content: "@Andy remind me every weekday at 9 to post the standup"}
+	tc := ToolContext{GroupFolder: "main", ChatJID: "main@nanoclaw", Messages: []Message{ask}}
+	out, err := propose(context.Background(), tc, `{"prompt":"post the standup","when":"每个工作日早上9点","timezone":"Asia/Shanghai"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"not saved", "cron 0 9 * * 1-5 (Asia/Shanghai)", "Thu 2024-01-04 09:00 CST", "Fri 2024-01-05 09:00 CST", "Mon 2024-01-08 09:00 CST"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("preview missing %q:\n%s", want, out)
+		}
+	}
+
+	// 同一轮对话中不能直接确认
+	if _, err := confirm(context.Background(), tc, `{}`); err == nil || !strings.Contains(err.Error(), "not confirmed") {
+		t.Errorf("confirm before reply err = %v", err)
+	}
+	if tasks, _ := db.ListTasks("main"); len(tasks) != 0 {
+		t.Fatalf("task saved before confirmation: %+v", tasks)
+	}
+
+	tc.Messages = append(tc.Messages, Message{ID: "m2", IsBotMessage: true, Content: "preview"}, Message{ID: "m3", Content: "@Andy yes"})
+	if _, err := confirm(context.Background(), ToolContext{ChatJID: "other@nanoclaw", Messages: tc.Messages}, `{}`); err == nil {
+		t.Error("confirm from another chat should fail")
+	}
+	out, err = confirm(context.Background(), tc, `{}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task, err := db.GetTask("task-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.ScheduleValue != "0 9 * * 1-5" || task.Timezone != "Asia/Shanghai" || task.ChatJID != "main@nanoclaw" || task.Prompt != "post the standup" {
+		t.Errorf("saved task = %+v", task)
+	}
+	if want := time.Date(2024, 1, 4, 1, 0, 0, 0, time.UTC); !task.NextRun.Equal(want) {
+		t.Errorf("NextRun = %v, want %v", task.NextRun, want)
+	}
+	if !strings.Contains(out, "task-1 saved") || notified != 1 {
+		t.Errorf("confirm = %q, notified %d", out, notified)
+	}
+	if _, err := confirm(context.Background(), tc, `{}`); err == nil {
+		t.Error("second confirm should fail")
+	}
+}
+
+func TestTaskTools_ProposeErrors(t *testing.T) {
+	tools := NewTaskTools(TestTempDB(t))
+	tools.now = func() time.Time { return time.Date(2024, 1, 3, 10, 0, 0, 0, time.UTC) }
+	propose := tools.Tools()[0].Call
+	tc := ToolContext{GroupFolder: "main", ChatJID: "main@nanoclaw"}
+
+	tests := []struct {
+		args    string
+		wantErr string
+	}{
+		{`{"when":"every day at 9"}`, "prompt is required"},
+		{`{"prompt":"p"}`, "either when or schedule_type"},
+		{`{"prompt":"p","when":"whenever"}`, "unrecognized schedule"},
+		{`{"prompt":"p","schedule_type":"cron","schedule_value":"bad"}`, "invalid cron"},
+		{`{"prompt":"p","schedule_type":"once","schedule_value":"2023-01-01T00:00:00Z"}`, "in the past"},
+		{`{"prompt":"p","when":"today at 9"}`, "in the past"},
+		{`{"prompt":"p","when":"in 5 minutes","timezone":"Nowhere/City"}`, "invalid timezone"},
+	}
+	for _, tt := range tests {
+		if _, err := propose(context.Background(), tc, tt.args); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("propose(%s) err = %v, want %q", tt.args, err, tt.wantErr)
+		}
+	}
+}
+
+// fakeLLM 依次返回 replies 中的消息，并记录每次请求
+func fakeLLM(t *testing.T, replies []openai.ChatCompletionMessage) (*Agent, *[]openai.ChatCompletionRequest) {
+	var reqs []openai.ChatCompletionRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		reqs = append(reqs, req)
+		if len(reqs) > len(replies) {
+			http.Error(w, "no more replies", http.StatusInternalServerError)
+			return
+		}
+		reply := replies[len(reqs)-1]
+		if req.Stream {
+			writeStream(w, reply)
+			return
+		}
+		json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{{Message: reply}},
+			Usage:   openai.Usage{PromptTokens: 10, CompletionTokens: 2},
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := openai.DefaultConfig("test")
+	cfg.BaseURL = srv.URL
+	return &Agent{client: openai.NewClientWithConfig(cfg), model: "test", groupsDir: t.TempDir(), memory: map[string]string{}}, &reqs
+}
+
+// writeStream 以 SSE 分片返回 reply：内容逐字发送，工具调用的参数拆成两段
+func writeStream(w http.ResponseWriter, reply openai.ChatCompletionMessage) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	send := func(chunk openai.ChatCompletionStreamResponse) {
+		b, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", b)
+	}
+	delta := func(d openai.ChatCompletionStreamChoiceDelta) {
+		send(openai.ChatCompletionStreamResponse{Choices: []openai.ChatCompletionStreamChoice{{Delta: d}}})
+	}
+	for _, r := range reply.Content {
+		delta(openai.ChatCompletionStreamChoiceDelta{Content: string(r)})
+	}
+	for i, tc := range reply.ToolCalls {
+		idx := i
+		half := len(tc.Function.Arguments) / 2
+		delta(openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{{Index: &idx, ID: tc.ID, Type: tc.Type,
+			Function: openai.FunctionCall{Name: tc.Function.Name, Arguments: tc.Function.Arguments[:half]}}}})
+		delta(openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{{Index: &idx,
+			Function: openai.FunctionCall{Arguments: tc.Function.Arguments[half:]}}}})
+	}
+	send(openai.ChatCompletionStreamResponse{Usage: &openai.Usage{PromptTokens: 10, CompletionTokens: 2}})
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func TestAgent_ToolCalls(t *testing.T) {
+	db := TestTempDB(t)
+	tools := NewTaskTools(db)
+	toolCall := func(id, name, args string) openai.ChatCompletionMessage {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{
+			{ID: id, Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: name, Arguments: args}},
+		}}
+	}
+	agent, reqs := fakeLLM(t, []openai.ChatCompletionMessage{
+		toolCall("c1", "schedule_task", `{"prompt":"post the standup","when":"every weekday at 9am"}`),
+		toolCall("c2", "nope", `{}`),
+		{Role: openai.ChatMessageRoleAssistant, Content: "I'll remind you at 9 on weekdays. OK?"},
+	})
+	agent.RegisterTools(tools.Tools()...)
+
+	msgs := []Message{{ID: "m1", ChatJID: "main@nanoclaw", Content: "@Andy remind me every weekday at 9 to post the standup"}}
+	resp, usage, err := agent.RunWithUsage(context.Background(), "main", msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != "I'll remind you at 9 on weekdays. OK?" || usage.PromptTokens != 30 || usage.CompletionTokens != 6 {
+		t.Errorf("resp = %q, usage = %+v", resp, usage)
+	}
+	if len(*reqs) != 3 || len((*reqs)[0].Tools) != 2 {
+		t.Fatalf("requests = %d", len(*reqs))
+	}
+	second := (*reqs)[1].Messages
+	if got := second[len(second)-1]; got.ToolCallID != "c1" || !strings.Contains(got.Content, "cron 0 9 * * 1-5") {
+		t.Errorf("tool result = %+v", got)
+	}
+	third := (*reqs)[2].Messages
+	if got := third[len(third)-1].Content; got != "error: unknown tool nope" {
+		t.Errorf("unknown tool result = %q", got)
+	}
+}
+
+func TestAgent_ToolCallLimit(t *testing.T) {
+	var replies []openai.ChatCompletionMessage
+	for i := 0; i <= maxToolRounds; i++ {
+		replies = append(replies, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{
+			{ID: fmt.Sprint(i), Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: "echo", Arguments: `{}`}},
+		}})
+	}
+	agent, _ := fakeLLM(t, replies)
+	agent.RegisterTools(AgentTool{Name: "echo", Call: func(context.Context, ToolContext, string) (string, error) { return "ok", nil }})
+	if _, err := agent.Run(context.Background(), "main", []Message{{Content: "loop"}}); err == nil || !strings.Contains(err.Error(), "rounds of tool calls") {
+		t.Errorf("err = %v", err)
+	}
+}
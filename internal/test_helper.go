@@ -2,14 +2,18 @@ package internal
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 )
 
+// OpenTempDB 在目录中创建一次性数据库（测试和技能测试工具共用）
+func OpenTempDB(dir string) (*DB, error) {
+	return OpenDB(filepath.Join(dir, "test.db"))
+}
+
 // TestTempDB 创建临时数据库用于测试
 func TestTempDB(t *testing.T) *DB {
-	tmpDir := t.TempDir()
-	dbPath := tmpDir + "/test.db"
-	db, err := OpenDB(dbPath)
+	db, err := OpenTempDB(t.TempDir())
 	if err != nil {
 		t.Fatalf("Failed to open temp db: %v", err)
 	}
@@ -24,6 +28,7 @@ func TestConfig(t *testing.T) *Config {
 	cfg := LoadConfig()
 	cfg.App.DataDir = t.TempDir()
 	cfg.App.GroupsDir = t.TempDir() + "/groups"
+	cfg.App.SkillsDir = t.TempDir() + "/skills"
 	return cfg
 }
 
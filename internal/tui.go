@@ -3,6 +3,7 @@ package internal
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 	"time"
 
@@ -25,6 +26,48 @@ type ThinkingMsg struct {
 	Thinking bool
 }
 
+// StatusMsg 状态栏通知（如技能重载结果）
+type StatusMsg struct {
+	Text  string
+	Error bool
+}
+
+// tuiReloadMsg attach 模式重新连接后重新加载群组和消息
+type tuiReloadMsg struct{}
+
+// tuiTasksMsg 任务执行结束，刷新任务视图
+type tuiTasksMsg struct{}
+
+// tuiTaskViewMsg 在事件循环之外加载的任务视图数据；执行记录只属于选中的任务
+type tuiTaskViewMsg struct {
+	folder  string
+	tasks   []Task
+	sel     int
+	runs    []TaskRun
+	err     error
+	runsErr error
+}
+
+// tuiTaskDoneMsg 任务视图中的修改已完成
+type tuiTaskDoneMsg struct {
+	status  StatusMsg
+	changed bool
+}
+
+// TUIBackend TUI读取和修改数据的方式：进程内运行时直接访问数据库，attach 时通过IPC访问守护进程
+type TUIBackend interface {
+	Groups() ([]Group, error)
+	Messages(chatJID ChatJID, limit int) ([]Message, error)
+	Tasks(folder string) ([]Task, error)
+	TaskRuns(taskID string, limit int) ([]TaskRun, error)
+	SetTaskStatus(taskID, status string) error
+	TriggerTask(taskID string) error
+	DeleteTask(taskID string) error
+}
+
+// tuiHistoryLimit 启动时每个会话加载的历史消息数
+const tuiHistoryLimit = 50
+
 // FocusPane 焦点面板
 type FocusPane int
 
@@ -37,9 +80,10 @@ const (
 // TUI Bubbletea v2 TUI模型
 type TUI struct {
 	width, height int
-	db            *DB
+	backend       TUIBackend
+	attached      bool // 通过IPC连接到守护进程，退出TUI不影响守护进程
 	queue         *GroupQueue
-	agent         *Agent
+	agent         Runner
 	cfg           *Config
 
 	groups      []Group
@@ -50,21 +94,32 @@ type TUI struct {
 	input       textarea.Model
 	focus       FocusPane
 	onSend      func(ChatJID, string)
+	status      StatusMsg
+	program     *tea.Program
+	showTasks   bool      // 主区域显示任务及执行历史
+	tasksLoaded bool      // 任务视图打开后已收到第一次加载结果
+	tasks       []Task    // 任务视图中的任务
+	taskSel     int       // 任务视图中选中的任务
+	tasksErr    error     // 加载任务失败的原因
+	taskRuns    []TaskRun // 选中任务最近的执行记录
+	taskRunsFor string    // taskRuns 所属的任务ID
+	taskRunsErr error     // 加载执行记录失败的原因
+	confirmDel  string    // 等待再次按 d 确认删除的任务ID
+	onTasks     func()    // 任务被修改后通知调度器
 }
 
-// NewTUI 创建TUI
-func NewTUI(db *DB, queue *GroupQueue, agent *Agent, cfg *Config) *TUI {
-	// 加载群组
-	groups := []Group{
-		{JID: "main@nanoclaw", Name: "Main", Folder: "main", RequiresTrigger: true},
-	}
+// NewTUI 创建与引擎运行在同一进程中的TUI
+func NewTUI(db *DB, queue *GroupQueue, agent Runner, cfg *Config) *TUI {
+	t := newTUI(dbBackend{db: db}, cfg)
+	t.queue = queue
+	t.agent = agent
+	return t
+}
 
+// newTUI 创建TUI并从 backend 加载群组和最近的消息
+func newTUI(backend TUIBackend, cfg *Config) *TUI {
 	// 创建群组列表
-	items := make([]list.Item, len(groups))
-	for i, g := range groups {
-		items[i] = groupItem{group: g}
-	}
-	l := list.New(items, list.NewDefaultDelegate(), 0, 0)
+	l := list.New(nil, list.NewDefaultDelegate(), 0, 0)
 	l.Title = "Groups"
 	l.SetShowHelp(false)
 
@@ -77,12 +132,9 @@ func NewTUI(db *DB, queue *GroupQueue, agent *Agent, cfg *Config) *TUI {
 	ta.SetHeight(3)
 	ta.ShowLineNumbers = false
 
-	return &TUI{
-		db:        db,
-		queue:     queue,
-		agent:     agent,
+	t := &TUI{
+		backend:   backend,
 		cfg:       cfg,
-		groups:    groups,
 		groupList: l,
 		messages:  make(map[ChatJID][]Message),
 		thinking:  make(map[ChatJID]bool),
@@ -90,6 +142,35 @@ func NewTUI(db *DB, queue *GroupQueue, agent *Agent, cfg *Config) *TUI {
 		input:     ta,
 		focus:     FocusInput,
 	}
+	t.load()
+	return t
+}
+
+// load 加载群组列表和各会话最近的消息；没有已注册的群组时显示默认群组
+func (t *TUI) load() {
+	groups, err := t.backend.Groups()
+	if err != nil {
+		t.status = StatusMsg{Text: "load groups: " + err.Error(), Error: true}
+	}
+	if len(groups) == 0 {
+		groups = []Group{{JID: "main@nanoclaw", Name: "Main", Folder: "main", RequiresTrigger: true}}
+	}
+	t.groups = groups
+	items := make([]list.Item, len(groups))
+	for i, g := range groups {
+		items[i] = groupItem{group: g}
+	}
+	t.groupList.SetItems(items)
+
+	for _, g := range groups {
+		msgs, err := t.backend.Messages(g.JID, tuiHistoryLimit)
+		if err != nil {
+			t.status = StatusMsg{Text: "load messages: " + err.Error(), Error: true}
+			continue
+		}
+		t.messages[g.JID] = msgs
+		t.updateViewport(g.JID)
+	}
 }
 
 // SetOnSend 设置发送回调
@@ -97,6 +178,11 @@ func (t *TUI) SetOnSend(fn func(ChatJID, string)) {
 	t.onSend = fn
 }
 
+// SetOnTasksChanged 设置任务视图修改任务后的回调
+func (t *TUI) SetOnTasksChanged(fn func()) {
+	t.onTasks = fn
+}
+
 // Init 初始化 - v2: 返回 tea.Cmd
 func (t *TUI) Init() tea.Cmd {
 	return tea.Batch(
@@ -106,6 +192,7 @@ func (t *TUI) Init() tea.Cmd {
 
 // Update 更新 - v2: 返回 (tea.Model, tea.Cmd)
 func (t *TUI) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmds []tea.Cmd
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		t.width = msg.Width
@@ -115,9 +202,16 @@ func (t *TUI) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyPressMsg:
 		// v2: 使用 KeyPressMsg 和 key.String() 或 key.Key().Code
 		key := msg.Key()
+		if t.showTasks && t.focus == FocusMain {
+			if cmd, ok := t.handleTaskKey(key.String()); ok {
+				return t, cmd
+			}
+		}
 		switch key.String() {
 		case "ctrl+c", "esc":
 			return t, tea.Quit
+		case "ctrl+t":
+			cmds = append(cmds, t.toggleTasks())
 		case "tab":
 			t.focus = (t.focus + 1) % 3
 			if t.focus == FocusInput {
@@ -126,25 +220,60 @@ func (t *TUI) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				t.input.Blur()
 			}
 		case "enter":
+			// 回车发送，不传给输入框
 			if t.focus == FocusInput {
-				t.sendMessage()
+				return t, t.sendMessage()
 			}
 		}
 
 	case TUIMsg:
+		// attach 时加载历史和订阅通知之间的消息可能重复
+		if t.hasMessage(msg.ChatJID, msg.Message.ID) {
+			break
+		}
 		if _, ok := t.messages[msg.ChatJID]; !ok {
 			t.messages[msg.ChatJID] = []Message{}
 		}
 		t.messages[msg.ChatJID] = append(t.messages[msg.ChatJID], msg.Message)
 		t.updateViewport(msg.ChatJID)
+		cmds = append(cmds, t.refreshTasks())
+
+	case tuiReloadMsg:
+		t.load()
+		cmds = append(cmds, t.refreshTasks())
+
+	case tuiTasksMsg:
+		return t, t.refreshTasks()
+
+	case tuiTaskViewMsg:
+		// 关闭任务视图或切换群组后才到达的结果已经过时
+		if !t.showTasks || msg.folder != t.currentFolder() {
+			return t, nil
+		}
+		t.tasksLoaded = true
+		t.tasks, t.taskSel, t.tasksErr = msg.tasks, msg.sel, msg.err
+		t.taskRuns, t.taskRunsFor, t.taskRunsErr = msg.runs, "", msg.runsErr
+		if msg.sel < len(msg.tasks) {
+			t.taskRunsFor = msg.tasks[msg.sel].ID
+		}
+		return t, nil
+
+	case tuiTaskDoneMsg:
+		t.status = msg.status
+		if msg.changed && t.onTasks != nil {
+			t.onTasks()
+		}
+		return t, t.refreshTasks()
 
 	case ThinkingMsg:
 		t.thinking[msg.ChatJID] = msg.Thinking
 		t.updateViewport(msg.ChatJID)
+
+	case StatusMsg:
+		t.status = msg
 	}
 
 	// 委托给子组件
-	var cmds []tea.Cmd
 	switch t.focus {
 	case FocusSidebar:
 		m, cmd := t.groupList.Update(msg)
@@ -190,16 +319,20 @@ func (t *TUI) View() string {
 	header := lipgloss.NewStyle().
 		Bold(true).Foreground(lipgloss.Color("62")).
 		Padding(0, 1).
-		Render(fmt.Sprintf("nanoclaw  ›  %s", groupName))
+		Render(fmt.Sprintf("%s  ›  %s", t.title(), groupName))
 
 	// 消息视图
 	vpH := t.height - 10
 	vp := t.getViewport(chatJID, mainW-2, vpH)
+	content := vp.View()
+	if t.showTasks {
+		content = t.renderTasks()
+	}
 	main := lipgloss.NewStyle().
 		Border(lipgloss.RoundedBorder()).
 		BorderForeground(lipgloss.Color("62")).
-		Width(mainW).Height(vpH + 2).
-		Render(vp.View())
+		Width(mainW).Height(vpH + 2).MaxHeight(vpH + 2).
+		Render(content)
 
 	// 输入框
 	t.input.SetWidth(mainW - 2)
@@ -209,23 +342,64 @@ func (t *TUI) View() string {
 		Width(mainW).Render(t.input.View())
 
 	// 状态栏
-	statusText := "Tab: switch  Enter: send  Ctrl+C: quit"
+	statusText := "Tab: switch  Enter: send  Ctrl+T: tasks  Ctrl+C: quit"
+	if t.attached {
+		statusText = "Tab: switch  Enter: send  Ctrl+T: tasks  Ctrl+C: detach"
+	}
+	if t.showTasks && t.focus == FocusMain {
+		statusText = "j/k: select  p: pause/resume  r: run now  d: delete  Esc: close"
+	}
 	if t.thinking[chatJID] {
 		statusText = lipgloss.NewStyle().Foreground(lipgloss.Color("214")).Italic(true).Render("⟳ thinking...") + "  " + statusText
 	}
+	if t.status.Text != "" {
+		color := lipgloss.Color("70")
+		if t.status.Error {
+			color = lipgloss.Color("196")
+		}
+		statusText = lipgloss.NewStyle().Foreground(color).Render(t.status.Text) + "  " + statusText
+	}
 	status := lipgloss.NewStyle().Foreground(lipgloss.Color("241")).Padding(0, 1).Render(statusText)
 
 	right := lipgloss.JoinVertical(lipgloss.Left, header, main, input, status)
 	return lipgloss.JoinHorizontal(lipgloss.Top, sidebar, right)
 }
 
-// Run 运行TUI
+// Program 返回TUI程序，供其他组件通过 Send 推送消息
+func (t *TUI) Program() *tea.Program {
+	if t.program == nil {
+		t.program = tea.NewProgram(t, tea.WithAltScreen())
+	}
+	return t.program
+}
+
+// Run 运行TUI，ctx 取消时退出
 func (t *TUI) Run(ctx context.Context) error {
-	p := tea.NewProgram(t, tea.WithAltScreen())
-	_, err := p.Run()
+	stop := context.AfterFunc(ctx, t.Program().Quit)
+	defer stop()
+	_, err := t.Program().Run()
 	return err
 }
 
+// title 标题栏中的程序名
+func (t *TUI) title() string {
+	if t.attached {
+		return "nanoclaw (attached)"
+	}
+	return "nanoclaw"
+}
+
+// hasMessage 会话最近的消息中是否已有该ID
+func (t *TUI) hasMessage(chatJID ChatJID, id MessageID) bool {
+	msgs := t.messages[chatJID]
+	for i := len(msgs) - 1; i >= 0 && i >= len(msgs)-tuiHistoryLimit; i-- {
+		if msgs[i].ID == id {
+			return true
+		}
+	}
+	return false
+}
+
 // SendBotMessage 发送机器人消息到TUI
 func (t *TUI) SendBotMessage(chatJID ChatJID, content string) {
 	msg := Message{
@@ -241,15 +415,196 @@ func (t *TUI) SendBotMessage(chatJID ChatJID, content string) {
 	_ = msg
 }
 
-func (t *TUI) sendMessage() {
+// sendMessage 清空输入框并返回发送消息的命令
+//
+// onSend 在命令中（事件循环之外）执行：编排器通过 Program.Send 把消息送回TUI，
+// 在 Update 中同步调用会阻塞事件循环。
+func (t *TUI) sendMessage() tea.Cmd {
 	text := strings.TrimSpace(t.input.Value())
 	if text == "" || t.onSend == nil {
-		return
+		return nil
 	}
 
 	chatJID := t.currentChatJID()
-	t.onSend(chatJID, text)
+	onSend := t.onSend
 	t.input.Reset()
+	return func() tea.Msg {
+		onSend(chatJID, text)
+		return nil
+	}
+}
+
+// toggleTasks 打开或关闭任务视图，打开时焦点移到任务列表并开始加载任务
+func (t *TUI) toggleTasks() tea.Cmd {
+	t.showTasks = !t.showTasks
+	t.confirmDel = ""
+	t.tasksLoaded = false
+	if t.showTasks {
+		t.focus = FocusMain
+		t.input.Blur()
+	} else {
+		t.focus = FocusInput
+		t.input.Focus()
+	}
+	return t.refreshTasks()
+}
+
+// handleTaskKey 处理任务视图的按键，返回需要在后台执行的命令和是否已处理
+func (t *TUI) handleTaskKey(key string) (tea.Cmd, bool) {
+	if key != "d" {
+		t.confirmDel = ""
+	}
+	id, status := "", ""
+	if t.taskSel < len(t.tasks) {
+		id, status = t.tasks[t.taskSel].ID, t.tasks[t.taskSel].Status
+	}
+
+	switch key {
+	case "esc":
+		return t.toggleTasks(), true
+	case "j", "down":
+		if t.taskSel < len(t.tasks)-1 {
+			t.taskSel++
+		}
+	case "k", "up":
+		if t.taskSel > 0 {
+			t.taskSel--
+		}
+	case "p":
+		if id == "" {
+			return nil, true
+		}
+		to := TaskPaused
+		if status != TaskActive {
+			to = TaskActive
+		}
+		return t.taskAction(id, "task "+to, func() error {
+			return t.backend.SetTaskStatus(id, to)
+		}), true
+	case "r":
+		if id == "" {
+			return nil, true
+		}
+		return t.taskAction(id, "task triggered", func() error {
+			return t.backend.TriggerTask(id)
+		}), true
+	case "d":
+		if id == "" {
+			return nil, true
+		}
+		if t.confirmDel != id {
+			t.confirmDel = id
+			t.status = StatusMsg{Text: "press d again to delete " + id}
+			return nil, true
+		}
+		t.confirmDel = ""
+		return t.taskAction(id, "task deleted", func() error {
+			return t.backend.DeleteTask(id)
+		}), true
+	default:
+		return nil, false
+	}
+	// 选中的任务变了，加载它的执行记录
+	return t.refreshTasks(), true
+}
+
+// taskAction 返回在事件循环之外执行任务修改的命令，结果显示在状态栏
+func (t *TUI) taskAction(id, done string, fn func() error) tea.Cmd {
+	return func() tea.Msg {
+		if err := fn(); err != nil {
+			return tuiTaskDoneMsg{status: StatusMsg{Text: fmt.Sprintf("%s: %v", id, err), Error: true}}
+		}
+		return tuiTaskDoneMsg{status: StatusMsg{Text: done + ": " + id}, changed: true}
+	}
+}
+
+// refreshTasks 返回在事件循环之外重新加载当前群组任务的命令；attach 时每次读取都是一次IPC调用，
+// 所以执行记录只加载选中的任务。任务视图未打开时返回 nil
+func (t *TUI) refreshTasks() tea.Cmd {
+	if !t.showTasks {
+		return nil
+	}
+	backend, folder, sel := t.backend, t.currentFolder(), t.taskSel
+	selID := ""
+	if sel < len(t.tasks) {
+		selID = t.tasks[sel].ID
+	}
+	return func() tea.Msg {
+		msg := tuiTaskViewMsg{folder: folder}
+		if msg.tasks, msg.err = backend.Tasks(folder); msg.err != nil {
+			return msg
+		}
+		// 选中的任务可能已被删除，此时保持原来的位置
+		msg.sel = slices.IndexFunc(msg.tasks, func(task Task) bool { return task.ID == selID })
+		if msg.sel < 0 {
+			msg.sel = max(min(sel, len(msg.tasks)-1), 0)
+		}
+		if msg.sel < len(msg.tasks) {
+			msg.runs, msg.runsErr = backend.TaskRuns(msg.tasks[msg.sel].ID, 5)
+		}
+		return msg
+	}
+}
+
+// renderTasks 渲染已加载的任务，选中的任务下面列出最近的执行记录
+func (t *TUI) renderTasks() string {
+	dim := lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+	red := lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+	switch {
+	case !t.tasksLoaded:
+		return dim.Render("Loading tasks...")
+	case t.tasksErr != nil:
+		return red.Render("load tasks: " + t.tasksErr.Error())
+	case len(t.tasks) == 0:
+		return dim.Render("No scheduled tasks. Ctrl+T to go back.")
+	}
+
+	var sb strings.Builder
+	for i, task := range t.tasks {
+		next, last := "-", "-"
+		if task.NextRun != nil {
+			next = task.NextRun.Local().Format("01-02 15:04:05")
+		}
+		if task.LastRun != nil {
+			last = task.LastRun.Local().Format("01-02 15:04:05")
+		}
+		marker := "  "
+		if i == t.taskSel {
+			marker = "› "
+		}
+		sb.WriteString(marker + lipgloss.NewStyle().Bold(true).Render(fmt.Sprintf("%s [%s] %s %s", task.ID, task.Status, task.ScheduleType, task.ScheduleValue)))
+		sb.WriteString(dim.Render(fmt.Sprintf("  next %s  last %s  %s", next, last, task.Prompt)))
+		sb.WriteString("\n")
+
+		if i != t.taskSel || task.ID != t.taskRunsFor {
+			continue
+		}
+		if t.taskRunsErr != nil {
+			sb.WriteString(red.Render("    load runs: " + t.taskRunsErr.Error()))
+			sb.WriteString("\n")
+			continue
+		}
+		for _, r := range t.taskRuns {
+			color := lipgloss.Color("70")
+			if r.Status == TaskRunError {
+				color = lipgloss.Color("196")
+			}
+			line := fmt.Sprintf("    %s %6s %s %s",
+				r.StartedAt.Local().Format("01-02 15:04"), r.Duration().Round(100*time.Millisecond),
+				lipgloss.NewStyle().Foreground(color).Render(fmt.Sprintf("%-7s", r.Status)), r.Summary(50))
+			sb.WriteString(line)
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+// currentFolder 当前群组的目录，没有群组时为空
+func (t *TUI) currentFolder() string {
+	if i := t.groupList.Index(); i >= 0 && i < len(t.groups) {
+		return t.groups[i].Folder
+	}
+	return ""
 }
 
 func (t *TUI) currentChatJID() ChatJID {
@@ -323,6 +678,46 @@ func (t *TUI) recalcLayout() {
 	}
 }
 
+// dbBackend 进程内运行时直接访问数据库
+type dbBackend struct {
+	db *DB
+}
+
+func (b dbBackend) Groups() ([]Group, error) {
+	return b.db.ListGroups()
+}
+
+func (b dbBackend) Messages(chatJID ChatJID, limit int) ([]Message, error) {
+	return b.db.GetMessages(chatJID, limit)
+}
+
+func (b dbBackend) Tasks(folder string) ([]Task, error) {
+	return b.db.ListTasks(folder)
+}
+
+func (b dbBackend) TaskRuns(taskID string, limit int) ([]TaskRun, error) {
+	return b.db.ListTaskRuns(taskID, limit, 0)
+}
+
+func (b dbBackend) SetTaskStatus(taskID, status string) error {
+	task, err := b.db.GetTask(taskID)
+	if err != nil {
+		return err
+	}
+	if err := task.SetStatus(status, time.Now()); err != nil {
+		return err
+	}
+	return b.db.SaveTask(task)
+}
+
+func (b dbBackend) TriggerTask(taskID string) error {
+	return b.db.TriggerTask(taskID, time.Now())
+}
+
+func (b dbBackend) DeleteTask(taskID string) error {
+	return b.db.DeleteTask(taskID)
+}
+
 // groupItem 列表项
 type groupItem struct {
 	group Group
@@ -0,0 +1,210 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea/v2"
+)
+
+// attachCallTimeout attach 模式下单次IPC调用的最长等待时间，避免守护进程无响应时界面卡住
+const attachCallTimeout = 10 * time.Second
+
+// attachRetryInterval 与守护进程断开后重新连接的间隔
+const attachRetryInterval = 2 * time.Second
+
+// attachTopics attach 模式订阅的通知
+var attachTopics = []string{NotifyMessage, NotifyThinking, NotifyTaskRun}
+
+// ipcBackend attach 模式下通过IPC访问守护进程
+type ipcBackend struct {
+	client *IPCClient
+}
+
+func (b ipcBackend) invoke(method string, params, result any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), attachCallTimeout)
+	defer cancel()
+	return b.client.InvokeContext(ctx, method, params, result)
+}
+
+func (b ipcBackend) Groups() ([]Group, error) {
+	var infos []GroupInfo
+	if err := b.invoke("groups.list", nil, &infos); err != nil {
+		return nil, err
+	}
+	groups := make([]Group, len(infos))
+	for i, g := range infos {
+		groups[i] = Group{JID: g.JID, Name: g.Name, Folder: g.Folder, TriggerPattern: g.TriggerPattern,
+			RequiresTrigger: g.RequiresTrigger, AddedAt: g.AddedAt}
+	}
+	return groups, nil
+}
+
+func (b ipcBackend) Messages(chatJID ChatJID, limit int) ([]Message, error) {
+	var infos []MessageInfo
+	if err := b.invoke("messages.list", map[string]any{"chat_jid": chatJID, "limit": limit}, &infos); err != nil {
+		return nil, err
+	}
+	msgs := make([]Message, len(infos))
+	for i, m := range infos {
+		msgs[i] = messageFromInfo(m)
+	}
+	return msgs, nil
+}
+
+func (b ipcBackend) Tasks(folder string) ([]Task, error) {
+	var infos []TaskInfo
+	if err := b.invoke("tasks.list", map[string]string{"group_folder": folder}, &infos); err != nil {
+		return nil, err
+	}
+	tasks := make([]Task, len(infos))
+	for i, t := range infos {
+		tasks[i] = Task{ID: t.ID, GroupFolder: t.GroupFolder, ChatJID: t.ChatJID, Prompt: t.Prompt,
+			ScheduleType: t.ScheduleType, ScheduleValue: t.ScheduleValue, Timezone: t.Timezone, Status: t.Status,
+			NextRun: t.NextRun, LastRun: t.LastRun, LastResult: t.LastResult, Silent: t.Silent, CreatedAt: t.CreatedAt}
+	}
+	return tasks, nil
+}
+
+func (b ipcBackend) TaskRuns(taskID string, limit int) ([]TaskRun, error) {
+	var infos []TaskRunInfo
+	if err := b.invoke("tasks.runs", map[string]any{"task_id": taskID, "limit": limit}, &infos); err != nil {
+		return nil, err
+	}
+	runs := make([]TaskRun, len(infos))
+	for i, r := range infos {
+		runs[i] = TaskRun{ID: r.RunID, TaskID: r.TaskID, StartedAt: r.StartedAt, EndedAt: r.EndedAt,
+			Status: r.Status, Error: r.Error, Output: r.Output}
+	}
+	return runs, nil
+}
+
+func (b ipcBackend) SetTaskStatus(taskID, status string) error {
+	method := "tasks.resume"
+	if status == TaskPaused {
+		method = "tasks.pause"
+	}
+	return b.invoke(method, map[string]string{"task_id": taskID}, nil)
+}
+
+func (b ipcBackend) TriggerTask(taskID string) error {
+	return b.invoke("tasks.trigger", map[string]string{"task_id": taskID}, nil)
+}
+
+func (b ipcBackend) DeleteTask(taskID string) error {
+	return b.invoke("tasks.delete", map[string]string{"task_id": taskID}, nil)
+}
+
+// messageFromInfo 从JSON表示还原消息
+func messageFromInfo(m MessageInfo) Message {
+	return Message{ID: m.ID, ChatJID: m.ChatJID, Sender: m.Sender, SenderName: m.SenderName,
+		Content: m.Content, Timestamp: m.Timestamp, IsBotMessage: m.IsBot}
+}
+
+// notificationMsg 把守护进程推送的通知转换为TUI消息，不需要处理的返回 nil
+func notificationMsg(method string, params json.RawMessage) tea.Msg {
+	switch method {
+	case NotifyMessage:
+		var m MessageInfo
+		if json.Unmarshal(params, &m) == nil {
+			return TUIMsg{ChatJID: m.ChatJID, Message: messageFromInfo(m)}
+		}
+	case NotifyThinking:
+		var t ThinkingInfo
+		if json.Unmarshal(params, &t) == nil {
+			return ThinkingMsg{ChatJID: t.ChatJID, Thinking: t.Thinking}
+		}
+	case NotifyTaskRun:
+		return tuiTasksMsg{}
+	case NotifyLagged:
+		// 丢失了通知，重新加载
+		return tuiReloadMsg{}
+	}
+	return nil
+}
+
+// AttachTUI 运行通过IPC连接到守护进程（nanoclaw serve）的TUI，直到 ctx 取消或用户退出
+//
+// 每个会话有独立的连接和订阅，可以同时连接多个；退出TUI不影响守护进程。
+// 连接断开后每隔 attachRetryInterval 重新连接，成功后重新订阅并加载消息。
+// sender 为本会话发送的消息的发送者名称。
+func AttachTUI(ctx context.Context, client *IPCClient, cfg *Config, sender string) error {
+	// 通知在客户端的读取协程中到达，经缓冲转发给TUI，避免界面阻塞读取
+	events := make(chan tea.Msg, 256)
+	post := func(msg tea.Msg) {
+		select {
+		case events <- msg:
+		default:
+		}
+	}
+	disconnected := make(chan error, 1)
+	client.SetNotify(func(method string, params json.RawMessage) {
+		if msg := notificationMsg(method, params); msg != nil {
+			post(msg)
+		}
+	})
+	client.SetOnDisconnect(func(err error) {
+		select {
+		case disconnected <- err:
+		default:
+		}
+	})
+	if _, err := client.Subscribe(attachTopics, nil); err != nil {
+		return err
+	}
+
+	backend := ipcBackend{client: client}
+	t := newTUI(backend, cfg)
+	t.attached = true
+	// onSend 在事件循环之外执行，错误通过 Program 显示
+	t.SetOnSend(func(chatJID ChatJID, content string) {
+		params := map[string]any{"chat_jid": chatJID, "content": content, "sender": sender}
+		if err := backend.invoke("messages.send", params, nil); err != nil {
+			t.Program().Send(StatusMsg{Text: "send: " + err.Error(), Error: true})
+		}
+	})
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		for {
+			select {
+			case <-runCtx.Done():
+				return
+			case msg := <-events:
+				t.Program().Send(msg)
+			case err := <-disconnected:
+				t.Program().Send(StatusMsg{Text: fmt.Sprintf("disconnected from nanoclaw (%v), reconnecting...", err), Error: true})
+				if !reattach(runCtx, client) {
+					return
+				}
+				// 重连过程中失败的尝试留下的断开通知已经过时
+				select {
+				case <-disconnected:
+				default:
+				}
+				t.Program().Send(tuiReloadMsg{})
+				t.Program().Send(StatusMsg{Text: "reconnected"})
+			}
+		}
+	}()
+	return t.Run(runCtx)
+}
+
+// reattach 重新连接并订阅，直到成功（返回 true）或 ctx 取消
+func reattach(ctx context.Context, client *IPCClient) bool {
+	ticker := time.NewTicker(attachRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+		if _, err := client.Subscribe(attachTopics, nil); err == nil {
+			return true
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestTUI_IPCBackend(t *testing.T) {
+	db := TestTempDB(t)
+	queue := NewGroupQueue(1)
+	orch := NewOrchestrator(db, queue, nil, TestConfig(t))
+	chatJID := ChatJID("team@nanoclaw")
+	db.SaveGroup(&Group{JID: chatJID, Name: "Team", Folder: "team", AddedAt: time.Now()})
+	db.SaveMessage(&Message{ID: "m1", ChatJID: chatJID, Sender: "alice", SenderName: "alice", Content: "earlier", Timestamp: time.Now()})
+	db.SaveTask(&Task{ID: "t1", GroupFolder: "team", ChatJID: chatJID, Prompt: "report", ScheduleType: "interval", ScheduleValue: "1h"})
+
+	server := startTestIPC(t)
+	NewControlAPI(db, orch, queue).Register(server)
+	client := NewIPCClient(server.Path())
+	defer client.Close()
+
+	// 从守护进程加载群组和历史消息
+	tui := newTUI(ipcBackend{client: client}, TestConfig(t))
+	if len(tui.groups) != 1 || tui.currentChatJID() != chatJID {
+		t.Fatalf("groups = %+v", tui.groups)
+	}
+	if msgs := tui.messages[chatJID]; len(msgs) != 1 || msgs[0].Content != "earlier" {
+		t.Errorf("history = %+v", msgs)
+	}
+
+	// 任务视图通过IPC修改任务
+	runTUICmd(tui, tui.toggleTasks())
+	if len(tui.tasks) != 1 {
+		t.Fatalf("tasks = %+v", tui.tasks)
+	}
+	pressTaskKey(tui, "p")
+	if task, _ := db.GetTask("t1"); task.Status != TaskPaused {
+		t.Errorf("status = %s, status bar = %q", task.Status, tui.status.Text)
+	}
+
+	// 推送的消息转换为TUI消息，与已加载的历史重复的忽略
+	for _, id := range []MessageID{"m1", "m2"} {
+		params, _ := json.Marshal(MessageInfo{ID: id, ChatJID: chatJID, Content: string(id), IsBot: true})
+		tui.Update(notificationMsg(NotifyMessage, params))
+	}
+	if msgs := tui.messages[chatJID]; len(msgs) != 2 || msgs[1].Content != "m2" || !msgs[1].IsBotMessage {
+		t.Errorf("messages = %+v", msgs)
+	}
+	params, _ := json.Marshal(ThinkingInfo{ChatJID: chatJID, Thinking: true})
+	tui.Update(notificationMsg(NotifyThinking, params))
+	if !tui.thinking[chatJID] {
+		t.Error("thinking notification not applied")
+	}
+	if msg := notificationMsg(NotifyDelta, nil); msg != nil {
+		t.Errorf("delta = %#v, want nil", msg)
+	}
+}
@@ -1,6 +1,9 @@
 package internal
 
 import (
+	"io"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -258,3 +261,189 @@ func TestTUIXSkipLLM_renderMessages(t *testing.T) {
 		t.Error("renderMessages should return non-empty string")
 	}
 }
+
+func TestTUI_TaskPaneKeys(t *testing.T) {
+	db := TestTempDB(t)
+	tui := NewTUI(db, NewGroupQueue(1), nil, TestConfig(t))
+	for _, id := range []string{"a", "b"} {
+		task := &Task{ID: id, GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: id, ScheduleType: "interval", ScheduleValue: "1h"}
+		if err := db.SaveTask(task); err != nil {
+			t.Fatal(err)
+		}
+	}
+	changed := 0
+	tui.SetOnTasksChanged(func() { changed++ })
+
+	runTUICmd(tui, tui.toggleTasks())
+	if tui.focus != FocusMain || len(tui.tasks) != 2 {
+		t.Fatalf("focus = %v, tasks = %d", tui.focus, len(tui.tasks))
+	}
+	pressTaskKey(tui, "j")
+	pressTaskKey(tui, "p")
+	if task, _ := db.GetTask("b"); task.Status != TaskPaused {
+		t.Errorf("b status = %s", task.Status)
+	}
+	pressTaskKey(tui, "p")
+	if task, _ := db.GetTask("b"); task.Status != TaskActive {
+		t.Errorf("b status after resume = %s", task.Status)
+	}
+
+	pressTaskKey(tui, "d")
+	if _, err := db.GetTask("b"); err != nil {
+		t.Fatal("deleted without confirmation")
+	}
+	pressTaskKey(tui, "d")
+	if _, err := db.GetTask("b"); err == nil {
+		t.Error("task not deleted")
+	}
+	if changed != 3 || tui.taskSel != 0 || len(tui.tasks) != 1 {
+		t.Errorf("changed = %d, sel = %d, tasks = %d", changed, tui.taskSel, len(tui.tasks))
+	}
+
+	// 修改在命令中执行，不在 Update 中等待后端
+	cmd, _ := tui.handleTaskKey("p")
+	if task, _ := db.GetTask("a"); task.Status != TaskActive {
+		t.Errorf("a paused before the command ran: %s", task.Status)
+	}
+	runTUICmd(tui, cmd)
+	if task, _ := db.GetTask("a"); task.Status != TaskPaused || changed != 4 {
+		t.Errorf("a status = %s, changed = %d", task.Status, changed)
+	}
+
+	if _, ok := tui.handleTaskKey("x"); ok {
+		t.Error("unknown key handled")
+	}
+	pressTaskKey(tui, "esc")
+	if tui.showTasks || tui.focus != FocusInput {
+		t.Error("esc did not close the task pane")
+	}
+}
+
+// countingBackend 记录任务读取的次数，读取任务时可以阻塞
+type countingBackend struct {
+	TUIBackend
+	tasks, runs atomic.Int32
+	block       chan struct{}
+}
+
+func (b *countingBackend) Tasks(folder string) ([]Task, error) {
+	b.tasks.Add(1)
+	<-b.block
+	return b.TUIBackend.Tasks(folder)
+}
+
+func (b *countingBackend) TaskRuns(taskID string, limit int) ([]TaskRun, error) {
+	b.runs.Add(1)
+	return b.TUIBackend.TaskRuns(taskID, limit)
+}
+
+func TestTUI_TaskPaneLoadsInBackground(t *testing.T) {
+	db := TestTempDB(t)
+	for _, id := range []string{"a", "b", "c"} {
+		db.SaveTask(&Task{ID: id, GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: id, ScheduleType: "interval", ScheduleValue: "1h"})
+	}
+	backend := &countingBackend{TUIBackend: dbBackend{db: db}, block: make(chan struct{})}
+	tui := newTUI(backend, TestConfig(t))
+
+	// 打开任务视图和收到任务通知都不在 Update 中读取任务
+	cmd := tui.toggleTasks()
+	_, again := tui.Update(tuiTasksMsg{})
+	if cmd == nil || again == nil || backend.tasks.Load() != 0 {
+		t.Fatalf("cmd = %v, again = %v, Tasks calls = %d", cmd != nil, again != nil, backend.tasks.Load())
+	}
+	if !strings.Contains(tui.renderTasks(), "Loading") {
+		t.Errorf("view before load = %q", tui.renderTasks())
+	}
+
+	close(backend.block)
+	runTUICmd(tui, cmd)
+	if len(tui.tasks) != 3 || tui.taskRunsFor != "a" {
+		t.Fatalf("tasks = %d, runs for %q", len(tui.tasks), tui.taskRunsFor)
+	}
+	// 只加载选中任务的执行记录
+	if backend.runs.Load() != 1 {
+		t.Errorf("TaskRuns calls = %d, want 1", backend.runs.Load())
+	}
+	pressTaskKey(tui, "j")
+	if tui.taskSel != 1 || tui.taskRunsFor != "b" || backend.runs.Load() != 2 {
+		t.Errorf("sel = %d, runs for %q, TaskRuns calls = %d", tui.taskSel, tui.taskRunsFor, backend.runs.Load())
+	}
+
+	// 任务视图关闭后到达的结果被忽略
+	late := tui.refreshTasks()
+	runTUICmd(tui, tui.toggleTasks())
+	runTUICmd(tui, late)
+	if tui.tasksLoaded {
+		t.Error("stale task view applied after closing")
+	}
+}
+
+func TestTUI_SendWithProgram(t *testing.T) {
+	db := TestTempDB(t)
+	cfg := TestConfig(t)
+	queue := NewGroupQueue(1)
+	orch := NewOrchestrator(db, queue, nil, cfg)
+	tui := NewTUI(db, queue, nil, cfg)
+	tui.program = tea.NewProgram(tui, tea.WithInput(nil), tea.WithOutput(io.Discard))
+	tui.SetOnSend(func(chatJID ChatJID, content string) {
+		orch.HandleMessage(chatJID, "You", content)
+	})
+	// 编排器通过 Program.Send 把消息送回TUI，在事件循环中同步发送会死锁
+	orch.SetProgram(tui.Program())
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := tui.Program().Run()
+		done <- err
+	}()
+	tui.Program().Send(tea.KeyPressMsg{Code: 'h', Text: "h"})
+	tui.Program().Send(tea.KeyPressMsg{Code: 'i', Text: "i"})
+	tui.Program().Send(tea.KeyPressMsg{Code: tea.KeyEnter})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		msgs, _ := db.GetMessages(tui.currentChatJID(), 10)
+		if len(msgs) == 1 && msgs[0].Content == "hi" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("message not sent: %+v", msgs)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// 事件循环仍在运行：能处理退出
+	quit := make(chan struct{})
+	go func() {
+		tui.Program().Quit()
+		close(quit)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("event loop blocked")
+	}
+	<-quit
+	if msgs := tui.messages[tui.currentChatJID()]; len(msgs) != 1 || msgs[0].Content != "hi" {
+		t.Errorf("tui messages = %+v", msgs)
+	}
+	if tui.input.Value() != "" {
+		t.Errorf("input not cleared: %q", tui.input.Value())
+	}
+}
+
+// runTUICmd 执行命令并把结果交给 Update，直到没有后续命令，模拟事件循环
+func runTUICmd(tui *TUI, cmd tea.Cmd) {
+	for cmd != nil {
+		msg := cmd()
+		if msg == nil {
+			return
+		}
+		_, cmd = tui.Update(msg)
+	}
+}
+
+// pressTaskKey 在任务视图中按键并执行产生的命令
+func pressTaskKey(tui *TUI, key string) {
+	cmd, _ := tui.handleTaskKey(key)
+	runTUICmd(tui, cmd)
+}
@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Watcher 目录树变化监视器
+//
+// Linux 下使用 inotify，不可用时（或其他平台）退化为定时轮询。
+// 变化经过去抖后批量回调，回调参数为发生变化的路径；事件丢失时（inotify 队列溢出）
+// 参数为监视的根目录本身，表示其下任何文件都可能已变化。
+type Watcher struct {
+	roots    []string
+	interval time.Duration // 轮询间隔
+	debounce time.Duration
+	onChange func(paths []string)
+}
+
+// NewWatcher 创建监视器
+func NewWatcher(roots []string, onChange func(paths []string)) *Watcher {
+	return &Watcher{
+		roots:    roots,
+		interval: 2 * time.Second,
+		debounce: 300 * time.Millisecond,
+		onChange: onChange,
+	}
+}
+
+// Start 在后台监视，ctx 取消后退出
+func (w *Watcher) Start(ctx context.Context) {
+	events, err := watchInotify(ctx, w.roots)
+	if err != nil {
+		slog.Warn("inotify unavailable, falling back to polling", "err", err)
+		events = w.poll(ctx)
+	}
+	go w.dispatch(ctx, events)
+}
+
+// dispatch 去抖后回调
+func (w *Watcher) dispatch(ctx context.Context, events <-chan string) {
+	pending := make(map[string]bool)
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case p, ok := <-events:
+			if !ok {
+				return
+			}
+			pending[p] = true
+			timer = time.After(w.debounce)
+		case <-timer:
+			paths := make([]string, 0, len(pending))
+			for p := range pending {
+				paths = append(paths, p)
+			}
+			sort.Strings(paths)
+			pending = make(map[string]bool)
+			timer = nil
+			w.onChange(paths)
+		}
+	}
+}
+
+// poll 轮询模式：比较前后两次快照
+func (w *Watcher) poll(ctx context.Context) <-chan string {
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		prev := snapshot(w.roots)
+		ticker := time.NewTicker(w.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			cur := snapshot(w.roots)
+			for _, p := range diffSnapshots(prev, cur) {
+				select {
+				case ch <- p:
+				case <-ctx.Done():
+					return
+				}
+			}
+			prev = cur
+		}
+	}()
+	return ch
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+func snapshot(roots []string) map[string]fileStamp {
+	snap := make(map[string]fileStamp)
+	for _, root := range roots {
+		filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if info, err := d.Info(); err == nil && !d.IsDir() {
+				snap[p] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+			}
+			return nil
+		})
+	}
+	return snap
+}
+
+// diffSnapshots 返回新增、删除或修改过的文件
+func diffSnapshots(prev, cur map[string]fileStamp) []string {
+	var changed []string
+	for p, st := range cur {
+		if old, ok := prev[p]; !ok || old != st {
+			changed = append(changed, p)
+		}
+	}
+	for p := range prev {
+		if _, ok := cur[p]; !ok {
+			changed = append(changed, p)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// HotReload 监视技能目录与群组目录
+//
+// 技能文件变化时重载 registry；群组 CLAUDE.md 变化时让 agent 丢弃该群组记忆缓存。
+// report 在每次技能重载后调用，参数为解析错误（成功时为nil）。
+func HotReload(ctx context.Context, cfg *Config, registry *SkillRegistry, agent AgentRunner, report func(error)) *Watcher {
+	w := NewWatcher([]string{cfg.App.SkillsDir, cfg.App.GroupsDir}, func(paths []string) {
+		reloadSkills := false
+		for _, p := range paths {
+			rel, err := filepath.Rel(cfg.App.GroupsDir, p)
+			if err != nil || strings.HasPrefix(rel, "..") {
+				reloadSkills = true // 全局技能目录
+				continue
+			}
+			parts := strings.Split(filepath.ToSlash(rel), "/")
+			switch {
+			case rel == ".":
+				// 群组目录本身：事件已丢失，所有群组的技能和记忆都可能变化
+				reloadSkills = true
+				if entries, err := os.ReadDir(cfg.App.GroupsDir); err == nil && agent != nil {
+					for _, e := range entries {
+						if e.IsDir() {
+							agent.InvalidateMemory(e.Name())
+						}
+					}
+				}
+				slog.Info("all group memory reloaded")
+			case len(parts) == 2 && parts[1] == "CLAUDE.md":
+				if agent != nil {
+					agent.InvalidateMemory(parts[0])
+				}
+				slog.Info("group memory reloaded", "group", parts[0])
+			case len(parts) >= 2 && parts[1] == "skills":
+				reloadSkills = true
+			}
+		}
+		if !reloadSkills {
+			return
+		}
+		err := registry.Reload(cfg.App.SkillsDir, cfg.App.GroupsDir)
+		if err != nil {
+			slog.Warn("skill reload", "err", err)
+		} else {
+			slog.Info("skills reloaded")
+		}
+		if report != nil {
+			report(err)
+		}
+	})
+	w.Start(ctx)
+	return w
+}
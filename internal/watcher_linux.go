@@ -0,0 +1,117 @@
+//go:build linux
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY |
+	syscall.IN_CLOSE_WRITE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF
+
+// watchInotify 递归监视目录树，新建的子目录会自动加入监视
+func watchInotify(ctx context.Context, roots []string) (<-chan string, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	// 非阻塞fd交给运行时poller，Close 可以打断 Read
+	f := os.NewFile(uintptr(fd), "inotify")
+
+	dirs := make(map[int32]string)
+	addTree := func(root string) {
+		filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.IsDir() {
+				return nil
+			}
+			wd, err := syscall.InotifyAddWatch(fd, p, inotifyMask)
+			if errors.Is(err, syscall.ENOSPC) {
+				// 其余目录同样会失败，只记录一次
+				slog.Warn("inotify watch limit reached, changes below this directory are not detected (raise fs.inotify.max_user_watches)", "dir", p)
+				return filepath.SkipAll
+			}
+			if err != nil {
+				slog.Warn("inotify add watch", "dir", p, "err", err)
+				return nil
+			}
+			dirs[int32(wd)] = p
+			return nil
+		})
+	}
+	for _, root := range roots {
+		addTree(root)
+	}
+
+	ch := make(chan string)
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+	go func() {
+		defer close(ch)
+		buf := make([]byte, 64*1024)
+		for {
+			n, err := f.Read(buf)
+			if err != nil {
+				return
+			}
+			for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+				ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+				nameBytes := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(ev.Len)]
+				off += syscall.SizeofInotifyEvent + int(ev.Len)
+
+				// 队列溢出时事件已丢失：重新加入监视（期间新建的目录）并报告所有根目录
+				if ev.Mask&syscall.IN_Q_OVERFLOW != 0 {
+					slog.Warn("inotify queue overflowed, reloading everything")
+					for _, root := range roots {
+						addTree(root)
+					}
+					for _, root := range roots {
+						select {
+						case ch <- root:
+						case <-ctx.Done():
+							return
+						}
+					}
+					continue
+				}
+				dir, ok := dirs[ev.Wd]
+				if !ok {
+					continue
+				}
+				p := dir
+				if name := trimNul(nameBytes); name != "" {
+					p = filepath.Join(dir, name)
+				}
+				if ev.Mask&syscall.IN_ISDIR != 0 && ev.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+					addTree(p)
+				}
+				if ev.Mask&syscall.IN_IGNORED != 0 {
+					delete(dirs, ev.Wd)
+				}
+				select {
+				case ch <- p:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func trimNul(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package internal
+
+import (
+	"context"
+	"errors"
+)
+
+func watchInotify(ctx context.Context, roots []string) (<-chan string, error) {
+	return nil, errors.New("inotify not supported on this platform")
+}
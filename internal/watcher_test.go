@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiffSnapshots(t *testing.T) {
+	now := time.Now()
+	prev := map[string]fileStamp{
+		"a": {modTime: now, size: 1},
+		"b": {modTime: now, size: 1},
+		"c": {modTime: now, size: 1},
+	}
+	cur := map[string]fileStamp{
+		"a": {modTime: now, size: 1},
+		"b": {modTime: now.Add(time.Second), size: 1},
+		"d": {modTime: now, size: 1},
+	}
+
+	got := diffSnapshots(prev, cur)
+	want := []string{"b", "c", "d"}
+	if len(got) != len(want) {
+		t.Fatalf("diffSnapshots = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("diffSnapshots[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func waitChanged(t *testing.T, changed <-chan []string, want string) {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case paths := <-changed:
+			for _, p := range paths {
+				if p == want {
+					return
+				}
+			}
+		case <-deadline:
+			t.Fatalf("timed out waiting for change of %s", want)
+		}
+	}
+}
+
+func TestWatcher_DetectsChanges(t *testing.T) {
+	for _, mode := range []string{"inotify", "poll"} {
+		t.Run(mode, func(t *testing.T) {
+			root := t.TempDir()
+			changed := make(chan []string, 16)
+			w := NewWatcher([]string{root}, func(paths []string) { changed <- paths })
+			w.interval = 50 * time.Millisecond
+			w.debounce = 20 * time.Millisecond
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if mode == "poll" {
+				go w.dispatch(ctx, w.poll(ctx))
+			} else {
+				events, err := watchInotify(ctx, w.roots)
+				if err != nil {
+					t.Skipf("inotify unavailable: %v", err)
+				}
+				go w.dispatch(ctx, events)
+			}
+			time.Sleep(100 * time.Millisecond)
+
+			// 新建子目录中的文件也要被发现
+			sub := filepath.Join(root, "skill")
+			if err := os.MkdirAll(sub, 0755); err != nil {
+				t.Fatal(err)
+			}
+			time.Sleep(100 * time.Millisecond)
+			file := filepath.Join(sub, "SKILL.md")
+			if err := os.WriteFile(file, []byte("# v1\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+			waitChanged(t, changed, file)
+		})
+	}
+}
+
+func TestHotReload_SkillsAndMemory(t *testing.T) {
+	db := TestTempDB(t)
+	cfg := TestConfig(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	groupDir := filepath.Join(cfg.App.GroupsDir, "main")
+	if err := os.MkdirAll(groupDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(cfg.App.SkillsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	agent := &Agent{groupsDir: cfg.App.GroupsDir, memory: map[string]string{"main": "stale"}}
+
+	var mu sync.Mutex
+	var reports []error
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	HotReload(ctx, cfg, registry, agent, func(err error) {
+		mu.Lock()
+		reports = append(reports, err)
+		mu.Unlock()
+	})
+	time.Sleep(100 * time.Millisecond)
+
+	writeSkill(t, cfg.App.SkillsDir, "hello", "# Hello v1\n", "")
+	if err := os.WriteFile(filepath.Join(groupDir, "CLAUDE.md"), []byte("You are Andy."), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		_, ok := registry.Get("hello")
+		if ok && agent.groupMemory("main") == "You are Andy." {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	t.Fatalf("hot reload did not pick up changes (reports=%v)", reports)
+}
+
+func TestHotReload_LostEvents(t *testing.T) {
+	db := TestTempDB(t)
+	cfg := TestConfig(t)
+	registry := NewSkillRegistry(db)
+	defer registry.Close()
+
+	for _, dir := range []string{cfg.App.SkillsDir, filepath.Join(cfg.App.GroupsDir, "main"), filepath.Join(cfg.App.GroupsDir, "team")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	agent := &Agent{groupsDir: cfg.App.GroupsDir, memory: map[string]string{"main": "stale", "team": "stale"}}
+
+	// 不启动监视，直接以根目录回调，模拟队列溢出后的全量重载
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := HotReload(ctx, cfg, registry, agent, nil)
+	writeSkill(t, cfg.App.SkillsDir, "hello", "# Hello\n", "")
+	for _, folder := range []string{"main", "team"} {
+		if err := os.WriteFile(filepath.Join(cfg.App.GroupsDir, folder, "CLAUDE.md"), []byte("fresh "+folder), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.onChange([]string{cfg.App.SkillsDir, cfg.App.GroupsDir})
+
+	if _, ok := registry.Get("hello"); !ok {
+		t.Error("skills not reloaded")
+	}
+	for _, folder := range []string{"main", "team"} {
+		if got := agent.groupMemory(folder); got != "fresh "+folder {
+			t.Errorf("%s memory = %q", folder, got)
+		}
+	}
+}
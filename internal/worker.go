@@ -0,0 +1,378 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// workerConcurrency 父子进程之间每个连接同时处理的请求数，实际并发由 GroupQueue 控制
+const workerConcurrency = 64
+
+const (
+	workerMinBackoff   = time.Second      // 子进程退出后首次重启的等待时间，之后每次加倍
+	workerMaxBackoff   = 30 * time.Second // 重启等待时间上限
+	workerStableAfter  = time.Minute      // 运行超过该时间后退出，重启等待时间复位
+	workerStartTimeout = 30 * time.Second // 调用等待子进程可用的最长时间
+	workerStopTimeout  = 5 * time.Second  // 关闭连接后等待子进程退出的时间，超时后强制结束
+)
+
+// workerDeltaMethod 子进程推送回复片段的通知方法
+const workerDeltaMethod = "agent.delta"
+
+// AgentWorker 在降权子进程中运行Agent
+//
+// 以root运行时，父进程持有Socket和数据库，LLM请求和群组记忆的读取在切换到 NANOCLAW_USER
+// 的子进程中执行。父子进程通过两条 socketpair 使用 JSON-RPC 通信：父进程调用子进程的
+// agent.run（AgentRequest/AgentResponse）和 memory.invalidate；子进程调用父进程的
+// tools.list 和 tool.call，工具（如任务工具）仍在父进程中执行。子进程退出后自动重启。
+//
+// 子进程不可信：tool.call 只带 agent.run 的请求ID，工具使用的群组、会话和消息由父进程
+// 按请求ID查找自己发出的请求，未知或已结束的请求ID被拒绝。
+type AgentWorker struct {
+	path    string
+	args    []string
+	env     []string // 为 nil 时继承当前环境
+	backoff time.Duration
+	tools   []AgentTool
+
+	mu      sync.Mutex
+	client  *IPCClient    // 当前子进程，未运行时为 nil
+	ready   chan struct{} // 子进程可用时关闭
+	streams map[string]func(string)
+	calls   map[string]ToolContext // 正在执行的 agent.run，键为请求ID
+	nextID  int
+}
+
+// workerTool 父进程提供给子进程的工具定义
+type workerTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// workerToolCall tool.call 方法的参数
+type workerToolCall struct {
+	Name    string `json:"name"`
+	Args    string `json:"args"`
+	Request string `json:"request"` // 所属 agent.run 的请求ID
+}
+
+// workerRequestKey 子进程中 agent.run 的请求ID在 context 中的键
+type workerRequestKey struct{}
+
+// workerDelta 子进程推送的回复片段
+type workerDelta struct {
+	Stream string `json:"stream"`
+	Delta  string `json:"delta"`
+}
+
+// NewAgentWorker 创建以 path args... 启动子进程的 AgentWorker，子进程中应调用 RunWorker
+func NewAgentWorker(path string, args ...string) *AgentWorker {
+	return &AgentWorker{
+		path:    path,
+		args:    args,
+		backoff: workerMinBackoff,
+		ready:   make(chan struct{}),
+		streams: make(map[string]func(string)),
+		calls:   make(map[string]ToolContext),
+	}
+}
+
+// RegisterTools 注册可由LLM调用的工具，工具在父进程中执行（需在 Supervise 前调用）
+func (w *AgentWorker) RegisterTools(tools ...AgentTool) {
+	w.tools = append(w.tools, tools...)
+}
+
+// Supervise 启动子进程并在其退出后重启，直到 ctx 取消
+func (w *AgentWorker) Supervise(ctx context.Context) {
+	backoff := w.backoff
+	for {
+		started := time.Now()
+		err := w.runOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > workerStableAfter {
+			backoff = w.backoff
+		}
+		slog.Error("agent worker exited", "err", err, "restart_in", backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, workerMaxBackoff)
+	}
+}
+
+// runOnce 启动一个子进程并等待其退出
+func (w *AgentWorker) runOnce(ctx context.Context) error {
+	wait, agentConn, toolConn, err := startWorkerProcess(ctx, w.path, w.args, w.env)
+	if err != nil {
+		return err
+	}
+	defer agentConn.Close()
+	defer toolConn.Close()
+
+	client := NewIPCClientConn(agentConn)
+	client.SetNotify(w.onNotify)
+	defer w.setClient(nil)
+	defer client.Close()
+
+	tools := NewConnServer()
+	tools.SetConcurrency(workerConcurrency)
+	tools.Handle("tools.list", func(ctx context.Context, params json.RawMessage) (any, error) {
+		// 子进程完成降权、开始工作时获取工具列表，此后才把调用交给它
+		w.setClient(client)
+		return w.listTools(ctx, params)
+	})
+	tools.Handle("tool.call", w.callTool)
+	go tools.ServeConn(toolConn)
+	return wait()
+}
+
+// setClient 切换当前子进程，nil 表示没有可用的子进程
+func (w *AgentWorker) setClient(c *IPCClient) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if c == w.client {
+		return
+	}
+	if w.client == nil {
+		close(w.ready)
+	} else if c == nil {
+		w.ready = make(chan struct{})
+	}
+	w.client = c
+}
+
+// connection 返回当前子进程的客户端，子进程正在（重新）启动时等待
+func (w *AgentWorker) connection(ctx context.Context) (*IPCClient, error) {
+	timeout := time.After(workerStartTimeout)
+	for {
+		w.mu.Lock()
+		c, ready := w.client, w.ready
+		w.mu.Unlock()
+		if c != nil {
+			return c, nil
+		}
+		select {
+		case <-ready:
+		case <-timeout:
+			return nil, errors.New("agent worker is not running")
+		case <-ctx.Done():
+			return nil, fmt.Errorf("agent worker is not running: %w", ctx.Err())
+		}
+	}
+}
+
+// Run 执行单次对话
+func (w *AgentWorker) Run(ctx context.Context, groupFolder string, messages []Message) (string, error) {
+	resp, _, err := w.RunStreaming(ctx, groupFolder, messages, nil)
+	return resp, err
+}
+
+// RunWithUsage 执行单次对话并返回token用量
+func (w *AgentWorker) RunWithUsage(ctx context.Context, groupFolder string, messages []Message) (string, Usage, error) {
+	return w.RunStreaming(ctx, groupFolder, messages, nil)
+}
+
+// RunStreaming 在子进程中执行单次对话，onDelta 不为 nil 时使用流式请求
+func (w *AgentWorker) RunStreaming(ctx context.Context, groupFolder string, messages []Message, onDelta func(string)) (string, Usage, error) {
+	c, err := w.connection(ctx)
+	if err != nil {
+		return "", Usage{}, err
+	}
+	w.mu.Lock()
+	w.nextID++
+	req := AgentRequest{GroupFolder: groupFolder, Messages: messages, Request: strconv.Itoa(w.nextID)}
+	w.calls[req.Request] = newToolContext(groupFolder, messages)
+	if onDelta != nil {
+		req.Stream = req.Request
+		w.streams[req.Stream] = onDelta
+	}
+	w.mu.Unlock()
+	defer func() {
+		w.mu.Lock()
+		delete(w.calls, req.Request)
+		delete(w.streams, req.Stream)
+		w.mu.Unlock()
+	}()
+
+	var resp AgentResponse
+	if err := c.InvokeContext(ctx, "agent.run", req, &resp); err != nil {
+		return "", Usage{}, fmt.Errorf("agent worker: %w", err)
+	}
+	var usage Usage
+	if resp.Usage != nil {
+		usage = *resp.Usage
+	}
+	if resp.Error != "" {
+		return "", usage, errors.New(resp.Error)
+	}
+	return resp.Content, usage, nil
+}
+
+// InvalidateMemory 让子进程丢弃群组 CLAUDE.md 缓存；子进程未运行时无需处理
+func (w *AgentWorker) InvalidateMemory(groupFolder string) {
+	w.mu.Lock()
+	c := w.client
+	w.mu.Unlock()
+	if c == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), workerStopTimeout)
+	defer cancel()
+	if err := c.InvokeContext(ctx, "memory.invalidate", map[string]string{"group_folder": groupFolder}, nil); err != nil {
+		slog.Warn("invalidate worker memory", "group", groupFolder, "err", err)
+	}
+}
+
+// onNotify 把子进程推送的回复片段交给对应的调用
+func (w *AgentWorker) onNotify(method string, params json.RawMessage) {
+	if method != workerDeltaMethod {
+		return
+	}
+	var d workerDelta
+	if err := json.Unmarshal(params, &d); err != nil {
+		return
+	}
+	w.mu.Lock()
+	fn := w.streams[d.Stream]
+	w.mu.Unlock()
+	if fn != nil {
+		fn(d.Delta)
+	}
+}
+
+func (w *AgentWorker) listTools(_ context.Context, _ json.RawMessage) (any, error) {
+	specs := make([]workerTool, 0, len(w.tools))
+	for _, t := range w.tools {
+		spec := workerTool{Name: t.Name, Description: t.Description}
+		if t.Parameters != nil {
+			raw, err := json.Marshal(t.Parameters)
+			if err != nil {
+				return nil, fmt.Errorf("tool %s: %w", t.Name, err)
+			}
+			spec.Parameters = raw
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+func (w *AgentWorker) callTool(ctx context.Context, params json.RawMessage) (any, error) {
+	var p workerToolCall
+	if err := BindParams(params, &p); err != nil {
+		return nil, err
+	}
+	// 工具上下文来自父进程发出的请求，不使用子进程提供的任何会话信息
+	w.mu.Lock()
+	tc, ok := w.calls[p.Request]
+	w.mu.Unlock()
+	if !ok {
+		slog.Warn("agent worker called a tool outside a request", "tool", p.Name, "request", p.Request)
+		return nil, &RPCError{Code: RPCUnauthorized, Message: "unknown or finished request " + strconv.Quote(p.Request)}
+	}
+	for _, t := range w.tools {
+		if t.Name == p.Name {
+			out, err := t.Call(ctx, tc, p.Args)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]string{"output": out}, nil
+		}
+	}
+	return nil, InvalidParams("unknown tool %s", p.Name)
+}
+
+// RunWorker 作为 AgentWorker 的子进程运行（nanoclaw worker），父进程关闭连接后返回
+//
+// 以root启动时先切换到 cfg.App.User，之后才读取群组文件和访问LLM。
+func RunWorker(cfg *Config) error {
+	if IsRoot() {
+		if err := DropPrivileges(cfg.App.User); err != nil {
+			return err
+		}
+	}
+	agentConn, toolConn, err := workerConns()
+	if err != nil {
+		return err
+	}
+	if cfg.LLM.APIKey == "" {
+		return errors.New("OPENAI_API_KEY is required")
+	}
+	return ServeWorker(NewAgent(nil), agentConn, toolConn)
+}
+
+// ServeWorker 子进程的主循环：从 toolConn 获取父进程的工具，在 agentConn 上处理请求
+func ServeWorker(agent *Agent, agentConn, toolConn net.Conn) error {
+	tools := NewIPCClientConn(toolConn)
+	defer tools.Close()
+	var specs []workerTool
+	if err := tools.Invoke("tools.list", nil, &specs); err != nil {
+		return fmt.Errorf("list tools: %w", err)
+	}
+	for _, spec := range specs {
+		name := spec.Name
+		t := AgentTool{Name: name, Description: spec.Description}
+		if spec.Parameters != nil {
+			t.Parameters = spec.Parameters
+		}
+		t.Call = func(ctx context.Context, _ ToolContext, args string) (string, error) {
+			var out struct {
+				Output string `json:"output"`
+			}
+			request, _ := ctx.Value(workerRequestKey{}).(string)
+			err := tools.InvokeContext(ctx, "tool.call", workerToolCall{Name: name, Args: args, Request: request}, &out)
+			// 把父进程的错误原样交给模型
+			if rpcErr := (*RPCError)(nil); errors.As(err, &rpcErr) {
+				return "", errors.New(rpcErr.Message)
+			}
+			return out.Output, err
+		}
+		agent.RegisterTools(t)
+	}
+
+	server := NewConnServer()
+	server.SetConcurrency(workerConcurrency)
+	server.Handle("agent.run", func(ctx context.Context, params json.RawMessage) (any, error) {
+		var req AgentRequest
+		if err := BindParams(params, &req); err != nil {
+			return nil, err
+		}
+		ctx = context.WithValue(ctx, workerRequestKey{}, req.Request)
+		var onDelta func(string)
+		if req.Stream != "" {
+			conn := ConnFromContext(ctx)
+			onDelta = func(delta string) {
+				conn.Notify(workerDeltaMethod, workerDelta{Stream: req.Stream, Delta: delta})
+			}
+		}
+		content, usage, err := agent.RunStreaming(ctx, req.GroupFolder, req.Messages, onDelta)
+		resp := AgentResponse{Content: content, Usage: &usage}
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		return resp, nil
+	})
+	server.Handle("memory.invalidate", func(_ context.Context, params json.RawMessage) (any, error) {
+		var p struct {
+			GroupFolder string `json:"group_folder"`
+		}
+		if err := BindParams(params, &p); err != nil {
+			return nil, err
+		}
+		agent.InvalidateMemory(p.GroupFolder)
+		return map[string]bool{"ok": true}, nil
+	})
+	server.ServeConn(agentConn)
+	return nil
+}
@@ -0,0 +1,22 @@
+//go:build !unix
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+func startWorkerProcess(ctx context.Context, path string, args, env []string) (func() error, net.Conn, net.Conn, error) {
+	return nil, nil, nil, errors.New("agent worker not supported on this platform")
+}
+
+func workerConns() (net.Conn, net.Conn, error) {
+	return nil, nil, errors.New("agent worker not supported on this platform")
+}
+
+// DropPrivileges 切换到 username 的uid和主组
+func DropPrivileges(username string) error {
+	return errors.New("dropping privileges not supported on this platform")
+}
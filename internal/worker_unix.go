@@ -0,0 +1,119 @@
+//go:build unix
+
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// startWorkerProcess 启动子进程，两条 socketpair 的一端作为 fd 3（agent.run）和 fd 4（tool.call）传入
+//
+// ctx 取消时关闭连接让子进程自行退出，workerStopTimeout 后仍未退出则强制结束。
+func startWorkerProcess(ctx context.Context, path string, args, env []string) (wait func() error, agentConn, toolConn net.Conn, err error) {
+	agentConn, agentFile, err := socketpair()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	toolConn, toolFile, err := socketpair()
+	if err != nil {
+		agentConn.Close()
+		agentFile.Close()
+		return nil, nil, nil, err
+	}
+
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Env = env
+	cmd.ExtraFiles = []*os.File{agentFile, toolFile}
+	cmd.Stderr = os.Stderr
+	cmd.Cancel = agentConn.Close
+	cmd.WaitDelay = workerStopTimeout
+	err = cmd.Start()
+	agentFile.Close()
+	toolFile.Close()
+	if err != nil {
+		agentConn.Close()
+		toolConn.Close()
+		return nil, nil, nil, fmt.Errorf("start worker: %w", err)
+	}
+	return cmd.Wait, agentConn, toolConn, nil
+}
+
+// socketpair 创建一对相连的Unix Socket，返回父进程使用的连接和传给子进程的文件
+func socketpair() (net.Conn, *os.File, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("socketpair: %w", err)
+	}
+	syscall.CloseOnExec(fds[0])
+	syscall.CloseOnExec(fds[1])
+	parent := os.NewFile(uintptr(fds[0]), "worker-parent")
+	defer parent.Close()
+	conn, err := net.FileConn(parent)
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, nil, err
+	}
+	return conn, os.NewFile(uintptr(fds[1]), "worker-child"), nil
+}
+
+// workerConns 返回父进程通过 fd 3、4 传入的连接
+func workerConns() (agentConn, toolConn net.Conn, err error) {
+	conn := func(fd uintptr, name string) (net.Conn, error) {
+		f := os.NewFile(fd, name)
+		if f == nil {
+			return nil, fmt.Errorf("fd %d is not open", fd)
+		}
+		defer f.Close()
+		c, err := net.FileConn(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s (fd %d): %w", name, fd, err)
+		}
+		return c, nil
+	}
+	if agentConn, err = conn(3, "agent"); err != nil {
+		return nil, nil, err
+	}
+	if toolConn, err = conn(4, "tools"); err != nil {
+		agentConn.Close()
+		return nil, nil, err
+	}
+	return agentConn, toolConn, nil
+}
+
+// DropPrivileges 切换到 username 的uid和主组并清空附加组，之后无法再恢复root权限
+func DropPrivileges(username string) error {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return fmt.Errorf("lookup user %s: %w", username, err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("user %s: bad uid %s", username, u.Uid)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("user %s: bad gid %s", username, u.Gid)
+	}
+	// 先清空附加组和切换组，切换用户之后就没有权限再修改
+	if err := syscall.Setgroups(nil); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid %d: %w", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid %d: %w", uid, err)
+	}
+	if uid != 0 && syscall.Setuid(0) == nil {
+		return errors.New("regained root after dropping privileges")
+	}
+	return nil
+}
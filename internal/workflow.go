@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// 工作流步骤的执行结果（在 TaskRun* 之外）
+const (
+	StepReused = "reused" // 恢复执行时沿用上次成功的输出
+)
+
+// WorkflowStep 工作流中的一个步骤
+//
+// 步骤二选一：Prompt 交给Agent执行，Skill 以 Args 为参数执行技能。
+// Prompt 和 Args 中的 {{name}} 替换为步骤 name 的输出。
+// 只有 DependsOn 中的步骤全部成功后才会执行，否则记为 skipped。
+type WorkflowStep struct {
+	Name      string   `json:"name"`
+	Prompt    string   `json:"prompt,omitempty"`
+	Skill     string   `json:"skill,omitempty"`
+	Args      []string `json:"args,omitempty"`
+	DependsOn []string `json:"depends_on,omitempty"`
+}
+
+// TaskRunStep 工作流执行记录中单个步骤的结果
+type TaskRunStep struct {
+	Name      string
+	Status    string // success/error/skipped/reused
+	StartedAt time.Time
+	EndedAt   time.Time
+	Output    string
+	Error     string
+}
+
+var stepNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// OrderWorkflow 校验工作流并返回执行顺序（依赖在前，其余保持定义顺序）
+func OrderWorkflow(steps []WorkflowStep) ([]WorkflowStep, error) {
+	index := make(map[string]int, len(steps))
+	for i, st := range steps {
+		if !stepNamePattern.MatchString(st.Name) {
+			return nil, fmt.Errorf("invalid step name %q", st.Name)
+		}
+		if _, dup := index[st.Name]; dup {
+			return nil, fmt.Errorf("duplicate step %q", st.Name)
+		}
+		if (st.Prompt == "") == (st.Skill == "") {
+			return nil, fmt.Errorf("step %s: exactly one of prompt and skill is required", st.Name)
+		}
+		index[st.Name] = i
+	}
+	for _, st := range steps {
+		for _, dep := range st.DependsOn {
+			if _, ok := index[dep]; !ok {
+				return nil, fmt.Errorf("step %s: unknown dependency %q", st.Name, dep)
+			}
+		}
+	}
+
+	// 按定义顺序反复取出依赖已满足的步骤
+	done := make(map[string]bool, len(steps))
+	ordered := make([]WorkflowStep, 0, len(steps))
+	for len(ordered) < len(steps) {
+		progressed := false
+		for _, st := range steps {
+			if done[st.Name] || !allDone(st.DependsOn, done) {
+				continue
+			}
+			done[st.Name] = true
+			ordered = append(ordered, st)
+			progressed = true
+		}
+		if !progressed {
+			var cycle []string
+			for _, st := range steps {
+				if !done[st.Name] {
+					cycle = append(cycle, st.Name)
+				}
+			}
+			return nil, fmt.Errorf("dependency cycle among steps %s", strings.Join(cycle, ", "))
+		}
+	}
+	return ordered, nil
+}
+
+func allDone(names []string, done map[string]bool) bool {
+	for _, n := range names {
+		if !done[n] {
+			return false
+		}
+	}
+	return true
+}
+
+// renderStep 将 {{name}} 替换为已完成步骤的输出
+func renderStep(s string, outputs map[string]string) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+	pairs := make([]string, 0, 2*len(outputs))
+	for name, out := range outputs {
+		pairs = append(pairs, "{{"+name+"}}", out)
+	}
+	return strings.NewReplacer(pairs...).Replace(s)
+}
+
+// runWorkflow 按依赖顺序执行任务的步骤，结果记录到 run.Steps
+//
+// resume 为之前的执行记录时，沿用其中成功步骤的输出，只执行其余步骤。
+// 返回末端步骤（没有其他步骤依赖）的输出；任一步骤失败时返回错误。
+func (s *Scheduler) runWorkflow(ctx context.Context, task Task, steps []WorkflowStep, events []Event, resume *TaskRun, run *TaskRun) (string, Usage, error) {
+	ordered, err := OrderWorkflow(steps)
+	if err != nil {
+		return "", Usage{}, err
+	}
+	previous := make(map[string]TaskRunStep)
+	if resume != nil {
+		for _, st := range resume.Steps {
+			previous[st.Name] = st
+		}
+	}
+
+	var (
+		usage    Usage
+		failed   []string
+		outputs  = make(map[string]string)
+		statuses = make(map[string]string)
+		needed   = make(map[string]bool)
+	)
+	for _, st := range ordered {
+		for _, dep := range st.DependsOn {
+			needed[dep] = true
+		}
+	}
+
+	for _, st := range ordered {
+		rs := TaskRunStep{Name: st.Name, StartedAt: s.now()}
+		if prev, ok := previous[st.Name]; ok && (prev.Status == TaskRunSuccess || prev.Status == StepReused) {
+			rs.Status, rs.Output = StepReused, prev.Output
+		} else if blocked := blockedBy(st.DependsOn, statuses); blocked != "" {
+			rs.Status, rs.Error = TaskRunSkipped, fmt.Sprintf("dependency %s did not succeed", blocked)
+		} else {
+			// 触发事件只附加给没有依赖的步骤，后续步骤通过 {{name}} 获取前面的结果
+			evs := events
+			if len(st.DependsOn) > 0 {
+				evs = nil
+			}
+			out, u, err := s.runStep(ctx, task, st, outputs, evs)
+			usage.PromptTokens += u.PromptTokens
+			usage.CompletionTokens += u.CompletionTokens
+			rs.Status, rs.Output = TaskRunSuccess, out
+			if err != nil {
+				rs.Status, rs.Error = TaskRunError, err.Error()
+				failed = append(failed, st.Name)
+				slog.Warn("workflow step failed", "task", task.ID, "step", st.Name, "err", err)
+			}
+		}
+		rs.EndedAt = s.now()
+		statuses[st.Name] = rs.Status
+		if rs.Status == TaskRunSuccess || rs.Status == StepReused {
+			outputs[st.Name] = rs.Output
+		}
+		run.Steps = append(run.Steps, rs)
+		if ctx.Err() != nil {
+			return "", usage, ctx.Err()
+		}
+	}
+
+	if len(failed) > 0 {
+		return "", usage, fmt.Errorf("step %s failed: %s", failed[0], stepError(run.Steps, failed[0]))
+	}
+	var results []string
+	for _, st := range ordered {
+		if !needed[st.Name] {
+			results = append(results, outputs[st.Name])
+		}
+	}
+	return strings.Join(results, "\n\n"), usage, nil
+}
+
+// blockedBy 返回第一个没有成功的依赖
+func blockedBy(deps []string, statuses map[string]string) string {
+	for _, dep := range deps {
+		if st := statuses[dep]; st != TaskRunSuccess && st != StepReused {
+			return dep
+		}
+	}
+	return ""
+}
+
+func stepError(steps []TaskRunStep, name string) string {
+	for _, st := range steps {
+		if st.Name == name {
+			return st.Error
+		}
+	}
+	return ""
+}
+
+// runStep 执行单个步骤：提示交给Agent，技能交给技能注册表
+func (s *Scheduler) runStep(ctx context.Context, task Task, st WorkflowStep, outputs map[string]string, events []Event) (string, Usage, error) {
+	if st.Prompt != "" {
+		task.Prompt = renderStep(st.Prompt, outputs)
+		return s.invoke(ctx, task, events)
+	}
+	if s.skills == nil {
+		return "", Usage{}, errors.New("skills are not available to the scheduler")
+	}
+	args := make([]string, len(st.Args))
+	for i, a := range st.Args {
+		args[i] = renderStep(a, outputs)
+	}
+	out, err := s.skills.Run(ctx, st.Skill, SkillContext{GroupFolder: task.GroupFolder, ChatJID: task.ChatJID, Argv: args})
+	return out, Usage{}, err
+}
@@ -0,0 +1,249 @@
+package internal
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOrderWorkflow(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   []WorkflowStep
+		want    string // 执行顺序，逗号分隔
+		wantErr string
+	}{
+		{"empty", nil, "", ""},
+		{"chain declared backwards", []WorkflowStep{
+			{Name: "post", Prompt: "p", DependsOn: []string{"sum"}},
+			{Name: "sum", Prompt: "s", DependsOn: []string{"fetch"}},
+			{Name: "fetch", Skill: "http"},
+		}, "fetch,sum,post", ""},
+		{"independent keep order", []WorkflowStep{
+			{Name: "b", Prompt: "b"}, {Name: "a", Prompt: "a"}, {Name: "c", Prompt: "c", DependsOn: []string{"a", "b"}},
+		}, "b,a,c", ""},
+		{"duplicate", []WorkflowStep{{Name: "a", Prompt: "x"}, {Name: "a", Prompt: "y"}}, "", "duplicate step"},
+		{"bad name", []WorkflowStep{{Name: "a b", Prompt: "x"}}, "", "invalid step name"},
+		{"both prompt and skill", []WorkflowStep{{Name: "a", Prompt: "x", Skill: "y"}}, "", "exactly one"},
+		{"neither prompt nor skill", []WorkflowStep{{Name: "a"}}, "", "exactly one"},
+		{"unknown dependency", []WorkflowStep{{Name: "a", Prompt: "x", DependsOn: []string{"b"}}}, "", "unknown dependency"},
+		{"cycle", []WorkflowStep{
+			{Name: "a", Prompt: "x", DependsOn: []string{"c"}},
+			{Name: "b", Prompt: "x", DependsOn: []string{"a"}},
+			{Name: "c", Prompt: "x", DependsOn: []string{"b"}},
+			{Name: "d", Prompt: "x"},
+		}, "", "dependency cycle among steps a, b, c"},
+		{"self dependency", []WorkflowStep{{Name: "a", Prompt: "x", DependsOn: []string{"a"}}}, "", "dependency cycle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ordered, err := OrderWorkflow(tt.steps)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, st := range ordered {
+				names = append(names, st.Name)
+			}
+			if got := strings.Join(names, ","); got != tt.want {
+				t.Errorf("order = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDB_TaskSteps(t *testing.T) {
+	db := TestTempDB(t)
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	if err := db.SaveTask(&Task{ID: "wf", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "digest",
+		ScheduleType: "cron", ScheduleValue: "0 9 * * *", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []WorkflowStep{
+		{Name: "fetch", Skill: "http_get", Args: []string{"https://example.com"}},
+		{Name: "sum", Prompt: "Summarize {{fetch}}", DependsOn: []string{"fetch"}},
+	}
+	if err := db.SaveTaskSteps("wf", steps); err != nil {
+		t.Fatal(err)
+	}
+	got, err := db.GetTaskSteps("wf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Args[0] != "https://example.com" || got[1].DependsOn[0] != "fetch" || got[1].Prompt != steps[1].Prompt {
+		t.Errorf("steps = %+v", got)
+	}
+
+	if err := db.SaveTaskSteps("wf", []WorkflowStep{{Name: "a", Prompt: "x", DependsOn: []string{"a"}}}); err == nil {
+		t.Error("cyclic workflow should be rejected")
+	}
+	if err := db.SaveTaskSteps("missing", steps); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("missing task err = %v", err)
+	}
+
+	run := &TaskRun{TaskID: "wf", StartedAt: now, EndedAt: now, Status: TaskRunSuccess,
+		Steps: []TaskRunStep{{Name: "fetch", Status: TaskRunSuccess, StartedAt: now, EndedAt: now, Output: "data"}}}
+	if err := db.SaveTaskRun(run); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := db.GetTaskRun(run.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Steps) != 1 || loaded.Steps[0].Output != "data" {
+		t.Errorf("run steps = %+v", loaded.Steps)
+	}
+	if _, err := db.ResumeTaskRun(run.ID, now); err == nil {
+		t.Error("resuming a successful run should fail")
+	}
+
+	if err := db.SaveTaskSteps("wf", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := db.GetTaskSteps("wf"); len(got) != 0 {
+		t.Errorf("steps after clear = %+v", got)
+	}
+	db.SaveTaskSteps("wf", steps)
+	if err := db.DeleteTask("wf"); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	db.QueryRow(`SELECT (SELECT COUNT(*) FROM task_steps) + (SELECT COUNT(*) FROM task_run_steps)`).Scan(&n)
+	if n != 0 {
+		t.Errorf("%d step rows left after delete", n)
+	}
+}
+
+func TestScheduler_Workflow(t *testing.T) {
+	db := TestTempDB(t)
+	now := time.Date(2024, 1, 1, 9, 0, 0, 0, time.UTC)
+	task := &Task{ID: "digest", GroupFolder: "main", ChatJID: "main@nanoclaw", Prompt: "daily digest",
+		ScheduleType: "once", ScheduleValue: now.Format(time.RFC3339), RetryLimit: 1, CreatedAt: now}
+	if err := db.SaveTask(task); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SaveTaskSteps("digest", []WorkflowStep{
+		{Name: "fetch", Skill: "fetch", Args: []string{"news"}},
+		{Name: "summarize", Prompt: "Summarize: {{fetch}}", DependsOn: []string{"fetch"}},
+		{Name: "post", Prompt: "Digest of {{summarize}}", DependsOn: []string{"summarize"}},
+		{Name: "weather", Prompt: "Weather"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	registry := NewSkillRegistry(db)
+	registry.Register(&Skill{Name: "fetch", LuaScript: `return "data for " .. arg[1]`})
+	var prompts []string
+	failSummary := true
+	runner := runnerFunc(func(_ context.Context, _ string, messages []Message) (string, error) {
+		prompt := messages[len(messages)-1].Content
+		prompts = append(prompts, prompt)
+		switch {
+		case strings.HasPrefix(prompt, "Summarize"):
+			if failSummary {
+				return "", errors.New("llm unavailable")
+			}
+			return "short summary", nil
+		case strings.HasPrefix(prompt, "Digest"):
+			return "digest posted", nil
+		}
+		return "sunny", nil
+	})
+	scheduler := NewScheduler(db, runner)
+	scheduler.SetSkills(registry)
+	scheduler.SetClock(func() time.Time { return now })
+	var posts []string
+	scheduler.SetDeliver(func(_ ChatJID, content string) error {
+		posts = append(posts, content)
+		return nil
+	})
+
+	stepStatus := func(run *TaskRun) string {
+		var parts []string
+		for _, st := range run.Steps {
+			parts = append(parts, st.Name+"="+st.Status)
+		}
+		return strings.Join(parts, ",")
+	}
+	latestRun := func() *TaskRun {
+		runs, err := db.ListTaskRuns("digest", 1, 0)
+		if err != nil || len(runs) == 0 {
+			t.Fatalf("no runs: %v", err)
+		}
+		run, err := db.GetTaskRun(runs[0].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return run
+	}
+
+	// 第一次执行：summarize 失败，post 被跳过，独立的 weather 照常执行；安排重试
+	scheduler.runTask(context.Background(), *task)
+	first := latestRun()
+	if got := stepStatus(first); got != "fetch=success,summarize=error,post=skipped,weather=success" {
+		t.Fatalf("first run steps = %s", got)
+	}
+	if first.Status != TaskRunError || !strings.Contains(first.Error, "step summarize failed") {
+		t.Errorf("first run = %s %q", first.Status, first.Error)
+	}
+	if len(prompts) != 2 || prompts[0] != "Summarize: data for news" {
+		t.Errorf("prompts = %q, want fetch output substituted", prompts)
+	}
+	got, _ := db.GetTask("digest")
+	if got.Status != TaskActive || got.Attempt != 1 || got.ResumeFrom != first.ID {
+		t.Fatalf("after first failure: status=%s attempt=%d resume=%d", got.Status, got.Attempt, got.ResumeFrom)
+	}
+
+	// 重试从失败的步骤继续，仍然失败后放弃
+	prompts = nil
+	scheduler.runTask(context.Background(), *got)
+	second := latestRun()
+	if got := stepStatus(second); !strings.Contains(got, "fetch=reused") || !strings.Contains(got, "weather=reused") ||
+		!strings.Contains(got, "summarize=error") {
+		t.Fatalf("retry steps = %s", got)
+	}
+	if len(prompts) != 1 {
+		t.Errorf("retry prompts = %q, want only summarize", prompts)
+	}
+	got, _ = db.GetTask("digest")
+	if got.Status != TaskFailed || got.ResumeFrom != 0 || len(posts) != 1 {
+		t.Fatalf("after final failure: status=%s resume=%d posts=%q", got.Status, got.ResumeFrom, posts)
+	}
+
+	// 手动恢复：重新激活任务，成功后发送末端步骤的输出
+	failSummary = false
+	resumed, err := db.ResumeTaskRun(second.ID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resumed.Status != TaskActive || resumed.ResumeFrom != second.ID {
+		t.Fatalf("resumed = %s %d", resumed.Status, resumed.ResumeFrom)
+	}
+	scheduler.runTask(context.Background(), *resumed)
+	third := latestRun()
+	if got := stepStatus(third); !strings.Contains(got, "summarize=success") || !strings.Contains(got, "post=success") ||
+		!strings.Contains(got, "fetch=reused") {
+		t.Fatalf("resumed steps = %s", got)
+	}
+	if third.Status != TaskRunSuccess {
+		t.Errorf("resumed run = %s %q", third.Status, third.Error)
+	}
+	got, _ = db.GetTask("digest")
+	if got.Status != TaskCompleted || got.ResumeFrom != 0 {
+		t.Errorf("after resume: status=%s resume=%d", got.Status, got.ResumeFrom)
+	}
+	last := posts[len(posts)-1]
+	if !strings.Contains(last, "digest posted") || !strings.Contains(last, "sunny") || strings.Contains(last, "short summary") {
+		t.Errorf("posted = %q, want outputs of post and weather", last)
+	}
+}
@@ -0,0 +1,9 @@
+// Package skills 内置技能，编译时嵌入二进制
+package skills
+
+import "embed"
+
+// Builtin 内置技能文件系统（根目录为 builtin/）
+//
+//go:embed builtin
+var Builtin embed.FS
@@ -1,35 +1,169 @@
 -- Task management skill
--- Usage: /task create "send daily report" "0 9 * * *"
+-- Usage: /task create [--silent] [--tz Asia/Shanghai] [--retries 3] [--retry-delay 30s]
+--                     [--timeout 5m] [--misfire once|all|skip] "send daily report" cron "0 9 * * *"
+--        /task create [--debounce 30s] [--rate-limit 10] "answer the question" event "keyword:help,帮助"
+--        /task list [status]
+--        /task show|pause|resume|run|delete <id>
+--        /task edit <id> [--prompt text] [--schedule type value] [--loud] [create options]
+
+-- 带值的选项 -> tasks.create 的 opts 字段
+local value_flags = {
+    ["--tz"] = "timezone",
+    ["--retries"] = "retries",
+    ["--retry-delay"] = "retry_delay",
+    ["--timeout"] = "timeout",
+    ["--misfire"] = "misfire",
+    ["--debounce"] = "debounce",
+    ["--rate-limit"] = "rate_limit",
+}
+
+-- 从 args 头部取出选项，返回 opts 或 nil, err
+function parse_opts(args)
+    local opts = {}
+    while args[1] and string.sub(args[1], 1, 2) == "--" do
+        local flag = table.remove(args, 1)
+        if flag == "--silent" then
+            opts.silent = true
+        elseif flag == "--loud" then
+            opts.silent = false
+        elseif flag == "--prompt" then
+            opts.prompt = table.remove(args, 1)
+        elseif flag == "--schedule" then
+            opts.schedule_type = table.remove(args, 1)
+            opts.schedule_value = table.remove(args, 1) or ""
+        elseif value_flags[flag] then
+            opts[value_flags[flag]] = table.remove(args, 1)
+        else
+            return nil, "Unknown option: " .. flag
+        end
+    end
+    for _, key in ipairs({"retries", "rate_limit"}) do
+        if opts[key] then
+            opts[key] = tonumber(opts[key])
+            if not opts[key] then
+                return nil, "--" .. string.gsub(key, "_", "-") .. " must be a number"
+            end
+        end
+    end
+    return opts
+end
 
 function create_task(args)
+    local opts, err = parse_opts(args)
+    if not opts then
+        return "Failed to create task: " .. err
+    end
     local prompt = args[1]
-    local schedule = args[2] or "once"
-    
-    local id = uuid()
-    local sql = string.format(
-        "INSERT INTO tasks (id, group_folder, chat_jid, prompt, schedule_type, schedule_value, status, created_at) VALUES ('%s', '%s', '%s', '%s', 'cron', '%s', 'active', datetime('now'))",
-        id, GROUP_FOLDER, CHAT_JID, prompt, schedule
-    )
-    
-    local err = db:exec(sql)
-    if err then
+    local schedule_type = args[2] or "once"
+    local schedule_value = args[3] or ""
+
+    local id, err = tasks.create(prompt, schedule_type, schedule_value, opts)
+    if not id then
         log("Error creating task: " .. err)
-        return "Failed to create task"
+        return "Failed to create task: " .. err
     end
-    
+
     return "Task created: " .. id
 end
 
-function list_tasks()
-    log("Listing tasks for " .. GROUP_FOLDER)
-    return "Tasks listed (implement query)"
+function list_tasks(status)
+    local lines = {}
+    for _, t in ipairs(tasks.list(status)) do
+        local next_run = t.next_run and time.format(t.next_run) or "-"
+        local status = t.silent and t.status .. ",silent" or t.status
+        local schedule = t.schedule_value
+        if t.timezone ~= "" then
+            next_run = t.next_run and time.format(t.next_run, nil, t.timezone) or "-"
+            schedule = schedule .. " (" .. t.timezone .. ")"
+        end
+        table.insert(lines, string.format("%s [%s] %s %s next=%s: %s",
+            t.id, status, t.schedule_type, schedule, next_run, t.prompt))
+    end
+    if #lines == 0 then
+        return "No tasks"
+    end
+    return table.concat(lines, "\n")
+end
+
+function set_status(id, status)
+    local ok, err
+    if status == "paused" then
+        ok, err = tasks.pause(id)
+    else
+        ok, err = tasks.resume(id)
+    end
+    if not ok then
+        return "Failed: " .. err
+    end
+    return "Task " .. id .. " " .. status
+end
+
+function show_task(id)
+    local t, err = tasks.get(id)
+    if not t then
+        return "Failed: " .. err
+    end
+    local tz = t.timezone ~= "" and t.timezone or nil
+    local lines = {
+        "id:       " .. t.id,
+        "status:   " .. t.status .. (t.silent and " (silent)" or ""),
+        "schedule: " .. t.schedule_type .. " " .. t.schedule_value .. (tz and " (" .. tz .. ")" or ""),
+        "next run: " .. (t.next_run and time.format(t.next_run, nil, tz) or "-"),
+        "last run: " .. (t.last_run and time.format(t.last_run, nil, tz) or "-"),
+        "retries:  " .. t.retries .. ", misfire: " .. (t.misfire ~= "" and t.misfire or "once"),
+        "prompt:   " .. t.prompt,
+    }
+    if t.last_result ~= "" then
+        table.insert(lines, "result:   " .. t.last_result)
+    end
+    return table.concat(lines, "\n")
+end
+
+function edit_task(args)
+    local id = table.remove(args, 1)
+    local opts, err = parse_opts(args)
+    if not opts then
+        return "Failed: " .. err
+    end
+    if #args > 0 then
+        return "Failed: unexpected argument " .. args[1]
+    end
+    local ok, err = tasks.update(id, opts)
+    if not ok then
+        return "Failed: " .. err
+    end
+    return "Task " .. id .. " updated"
+end
+
+-- 对任务执行无参数的操作
+function task_action(fn, id, done)
+    local ok, err = fn(id)
+    if not ok then
+        return "Failed: " .. err
+    end
+    return "Task " .. id .. " " .. done
 end
 
 -- Main entry
 if #arg > 0 then
-    if arg[1] == "create" then
-        return create_task({table.unpack(arg, 2)})
-    elseif arg[1] == "list" then
-        return list_tasks()
+    local cmd, id = arg[1], arg[2]
+    if cmd == "create" then
+        return create_task({unpack(arg, 2)})
+    elseif cmd == "list" then
+        return list_tasks(arg[2])
+    elseif not id then
+        return "Usage: /task " .. cmd .. " <id>"
+    elseif cmd == "show" then
+        return show_task(id)
+    elseif cmd == "edit" then
+        return edit_task({unpack(arg, 2)})
+    elseif cmd == "pause" then
+        return set_status(id, "paused")
+    elseif cmd == "resume" then
+        return set_status(id, "active")
+    elseif cmd == "run" then
+        return task_action(tasks.run, id, "triggered")
+    elseif cmd == "delete" then
+        return task_action(tasks.delete, id, "deleted")
     end
 end